@@ -0,0 +1,297 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// artifactsDirName is where stage artifacts are collected, under the
+// project's .local-ci directory.
+const artifactsDirName = "artifacts"
+
+// artifactDir is the local directory holding a stage's artifacts.
+func artifactDir(root, stage string) string {
+	return filepath.Join(root, ".local-ci", artifactsDirName, stage)
+}
+
+// validateArtifactPatterns rejects patterns that would reach outside the
+// project root.
+func validateArtifactPatterns(patterns []string) error {
+	for _, p := range patterns {
+		clean := path.Clean(filepath.ToSlash(p))
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("artifact pattern %q must be relative to the project root", p)
+		}
+	}
+	return nil
+}
+
+// matchArtifact reports whether rel (slash-separated, relative to the
+// project root) matches pattern. Segments are matched with path.Match, "**"
+// matches any number of segments, and a pattern naming a directory matches
+// everything below it.
+func matchArtifact(pattern, rel string) bool {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	return matchArtifactSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchArtifactSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchArtifactSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return true
+}
+
+// artifactBases returns the literal leading directories of patterns, so
+// collection only walks the parts of the tree that can match.
+func artifactBases(patterns []string) []string {
+	var bases []string
+	for _, p := range patterns {
+		var lit []string
+		for _, seg := range strings.Split(path.Clean(filepath.ToSlash(p)), "/") {
+			if strings.ContainsAny(seg, "*?[") {
+				break
+			}
+			lit = append(lit, seg)
+		}
+		base := "."
+		if len(lit) > 0 {
+			base = path.Join(lit...)
+		}
+		bases = append(bases, base)
+	}
+	bases = dedupeStrings(bases)
+	sort.Strings(bases)
+	return bases
+}
+
+// matchesAnyArtifact reports whether rel matches one of patterns.
+func matchesAnyArtifact(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if matchArtifact(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectLocalArtifacts copies the files under root that match the stage's
+// artifact patterns into its artifact directory, replacing whatever an
+// earlier run left there. It returns the copied paths relative to root.
+func collectLocalArtifacts(root string, stage Stage) ([]string, error) {
+	if len(stage.Artifacts) == 0 {
+		return nil, nil
+	}
+	if err := validateArtifactPatterns(stage.Artifacts); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, base := range artifactBases(stage.Artifacts) {
+		err := filepath.WalkDir(filepath.Join(root, base), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if d.IsDir() {
+				if rel == ".git" || rel == ".local-ci" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() && matchesAnyArtifact(stage.Artifacts, rel) {
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	files = dedupeStrings(files)
+	sort.Strings(files)
+
+	dest := artifactDir(root, stage.Name)
+	if err := os.RemoveAll(dest); err != nil {
+		return nil, err
+	}
+	for _, rel := range files {
+		if err := copyArtifact(filepath.Join(root, filepath.FromSlash(rel)), dest, rel); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func copyArtifact(src, dest, rel string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeArtifact(dest, rel, f, info.Mode().Perm())
+}
+
+// writeArtifact writes one artifact to dest/rel.
+func writeArtifact(dest, rel string, r io.Reader, mode fs.FileMode) error {
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return fmt.Errorf("refusing to write artifact outside %s: %s", dest, rel)
+	}
+	target := filepath.Join(dest, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// FetchArtifacts copies the files in the remote workspace that match the
+// stage's artifact patterns into the local artifact directory (under
+// ArtifactsLabel, when set), as one tar stream over SSH. It returns the
+// fetched paths relative to the project root.
+func (re *RemoteExecutor) FetchArtifacts(ctx context.Context, stage Stage) ([]string, error) {
+	if len(stage.Artifacts) == 0 || re.LocalDir == "" {
+		return nil, nil
+	}
+	if err := validateArtifactPatterns(stage.Artifacts); err != nil {
+		return nil, err
+	}
+	uploader, ok := re.sshClient().(remoteUploader)
+	if !ok {
+		return nil, fmt.Errorf("SSH client cannot stream files")
+	}
+
+	bases := artifactBases(stage.Artifacts)
+	args := make([]string, len(bases))
+	for i, b := range bases {
+		args[i] = escapeShellArg(b)
+	}
+	workDir := escapeShellArg(re.WorkDir)
+	list := fmt.Sprintf("cd %s || exit 1; find %s \\( -name .git -o -name .local-ci \\) -prune -o -type f -print0 2>/dev/null; exit 0",
+		workDir, strings.Join(args, " "))
+	out, err := re.sshExecWithOutput(ctx, list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote artifacts: %w", err)
+	}
+	var files []string
+	for _, name := range strings.Split(out, "\x00") {
+		name = strings.TrimPrefix(name, "./")
+		if name != "" && matchesAnyArtifact(stage.Artifacts, name) {
+			files = append(files, name)
+		}
+	}
+	files = dedupeStrings(files)
+	sort.Strings(files)
+
+	dest := artifactDir(re.LocalDir, stage.Name)
+	if re.ArtifactsLabel != "" {
+		dest = filepath.Join(dest, re.ArtifactsLabel)
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	pr, pw := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+		err := extractArtifacts(pr, dest)
+		if err == nil {
+			// Drain tar's end-of-archive padding so the remote side can finish.
+			io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		extracted <- err
+	}()
+	var stderr bytes.Buffer
+	input := strings.NewReader(strings.Join(files, "\x00") + "\x00")
+	err = uploader.runWithInput(ctx, fmt.Sprintf("cd %s && tar -cf - --null -T -", workDir), input, pw, &stderr)
+	pw.CloseWithError(err)
+	if extractErr := <-extracted; err == nil && extractErr != nil {
+		err = extractErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifacts: %w (stderr: %s)", err, stderr.String())
+	}
+	return files, nil
+}
+
+// extractArtifacts writes the regular files in a tar stream under dest.
+func extractArtifacts(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := writeArtifact(dest, hdr.Name, tr, hdr.FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+	}
+}
+
+// artifactSummary counts collected artifacts per stage, e.g.
+// ".local-ci/artifacts/ (build 1, test 2)".
+func artifactSummary(results []Result) string {
+	var parts []string
+	for _, r := range results {
+		if len(r.Artifacts) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", r.Name, len(r.Artifacts)))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf(".local-ci/%s/ (%s)", artifactsDirName, strings.Join(parts, ", "))
+}
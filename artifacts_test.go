@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchArtifact(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"target/release/foo", "target/release/foo", true},
+		{"target/release/foo", "target/release/foo.d", false},
+		{"coverage/**", "coverage/lcov.info", true},
+		{"coverage/**", "coverage/html/index.html", true},
+		{"coverage", "coverage/html/index.html", true},
+		{"coverage/**", "src/coverage.rs", false},
+		{"**/*.xml", "junit.xml", true},
+		{"**/*.xml", "reports/unit/junit.xml", true},
+		{"reports/*.xml", "reports/unit/junit.xml", false},
+		{"./dist/*.tgz", "dist/pkg-1.0.tgz", true},
+	}
+	for _, tt := range tests {
+		if got := matchArtifact(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchArtifact(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+
+	if got := artifactBases([]string{"coverage/**", "**/*.xml", "target/release/foo", "coverage/html"}); !reflect.DeepEqual(got, []string{".", "coverage", "coverage/html", "target/release/foo"}) {
+		t.Errorf("artifactBases = %v", got)
+	}
+	if err := validateArtifactPatterns([]string{"../secrets"}); err == nil {
+		t.Error("patterns escaping the project root should be rejected")
+	}
+	if err := validateArtifactPatterns([]string{"/etc/passwd"}); err == nil {
+		t.Error("absolute patterns should be rejected")
+	}
+}
+
+func TestRunLocalStageCollectsArtifacts(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"src/main.rs":                        "fn main() {}",
+		".local-ci/artifacts/test/stale.txt": "old",
+	})
+	stage := Stage{
+		Name:      "test",
+		Cmd:       []string{"sh", "-c", "mkdir -p coverage/html && echo lcov > coverage/lcov.info && echo page > coverage/html/index.html && echo '<xml/>' > junit.xml"},
+		Artifacts: []string{"coverage/**", "*.xml", "missing/**"},
+	}
+	result := runLocalStage(context.Background(), stage, root, nil)
+	if result.Status != "pass" {
+		t.Fatalf("stage failed: %v\n%s", result.Error, result.Output)
+	}
+	want := []string{"coverage/html/index.html", "coverage/lcov.info", "junit.xml"}
+	if !reflect.DeepEqual(result.Artifacts, want) {
+		t.Errorf("artifacts = %v, want %v", result.Artifacts, want)
+	}
+	dest := artifactDir(root, "test")
+	if data, _ := os.ReadFile(filepath.Join(dest, "coverage", "lcov.info")); string(data) != "lcov\n" {
+		t.Errorf("lcov.info = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "stale.txt")); !os.IsNotExist(err) {
+		t.Error("artifacts from an earlier run should be replaced")
+	}
+	if got := artifactSummary([]Result{result}); got != ".local-ci/artifacts/ (test 3)" {
+		t.Errorf("artifactSummary = %q", got)
+	}
+
+	stage.Artifacts = []string{"../outside"}
+	if result := runLocalStage(context.Background(), stage, root, nil); result.Status != "fail" || !strings.Contains(result.Error.Error(), "relative to the project root") {
+		t.Errorf("invalid pattern should fail the stage, got %s: %v", result.Status, result.Error)
+	}
+}
+
+func TestRemoteStageFetchesArtifacts(t *testing.T) {
+	re, remote := syncTestExecutor(t)
+	local := t.TempDir()
+	re.LocalDir = local
+	os.MkdirAll(remote, 0o755)
+
+	stage := Stage{
+		Name:      "build",
+		Cmd:       []string{"sh", "-c", "mkdir -p target/release && printf bin > target/release/foo && chmod +x target/release/foo && printf dep > target/release/foo.d"},
+		Artifacts: []string{"target/release/foo"},
+	}
+	result := re.RunStage(context.Background(), stage, nil)
+	if result.Status != "pass" {
+		t.Fatalf("stage failed: %v\n%s", result.Error, result.Output)
+	}
+	if !reflect.DeepEqual(result.Artifacts, []string{"target/release/foo"}) {
+		t.Errorf("artifacts = %v", result.Artifacts)
+	}
+	fetched := filepath.Join(artifactDir(local, "build"), "target", "release", "foo")
+	info, err := os.Stat(fetched)
+	if err != nil {
+		t.Fatalf("artifact not fetched: %v", err)
+	}
+	if info.Mode().Perm()&0o100 == 0 {
+		t.Errorf("fetched binary lost its executable bit: %v", info.Mode())
+	}
+	if data, _ := os.ReadFile(fetched); string(data) != "bin" {
+		t.Errorf("fetched artifact = %q", data)
+	}
+
+	// With a label (as in --matrix), each host gets its own subdirectory.
+	re.ArtifactsLabel = "sparky"
+	if result := re.RunStage(context.Background(), stage, nil); result.Status != "pass" {
+		t.Fatalf("labelled run failed: %v", result.Error)
+	}
+	if _, err := os.Stat(filepath.Join(artifactDir(local, "build"), "sparky", "target", "release", "foo")); err != nil {
+		t.Errorf("labelled artifact missing: %v", err)
+	}
+}
@@ -1,22 +1,66 @@
 package main
 
-import "strings"
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+)
 
-// cacheKeyForStage builds the canonical cache entry value: "<hash>|<command>".
+// cacheKeyForStage builds the canonical cache entry value: "<hash>|<command>",
+// plus "|dir:<dir>" and "|env:<digest of the sorted env>" for stages that set
+// them, "|<image>@<image id>" for stages that run in a container,
+// "|nix:<shell>@<devShell inputs hash>" for stages that run in a devShell and
+// "|tools:<tool>@<version>,..." for the versions of the tools it runs.
 func cacheKeyForStage(stage Stage, hash string) string {
 	if hash == "" {
 		return ""
 	}
-	return hash + "|" + strings.Join(stage.Cmd, " ")
+	key := hash + "|" + strings.Join(stage.Cmd, " ")
+	if stage.Dir != "" {
+		key += "|dir:" + stage.Dir
+	}
+	if len(stage.Env) > 0 {
+		// Digested so values can't break the one-line-per-entry cache file.
+		key += fmt.Sprintf("|env:%x", md5.Sum([]byte(strings.Join(stageEnv(stage), "\x00"))))
+	}
+	if stage.Container != "" {
+		key += "|" + stage.Container + "@" + stage.ImageID
+	}
+	if stage.NixShell != "" {
+		key += "|nix:" + stage.NixShell + "@" + stage.NixKey
+	}
+	if stage.ToolVersions != "" {
+		key += "|tools:" + stage.ToolVersions
+	}
+	return key
 }
 
-// cacheHit reports whether the stage is cached for the given content hash.
-// Legacy entries stored as hash-only (without "|command") still match.
+// cacheEntryName is the cache key for a stage run on target. Local runs
+// (target "") use the bare stage name; remote targets are namespaced as
+// "<stage>@<target>" so a pass on one host never counts on another.
+func cacheEntryName(stage, target string) string {
+	if target == "" {
+		return stage
+	}
+	return stage + "@" + target
+}
+
+// cacheHit reports whether the stage is cached locally for the given content
+// hash. Legacy entries stored as hash-only (without "|command") still match
+// stages keyed on nothing else.
 func cacheHit(cache map[string]string, stage Stage, hash string) bool {
+	return cacheHitOn(cache, stage, hash, "")
+}
+
+// cacheHitOn reports whether the stage is cached on target for hash. A
+// legacy hash-only entry only counts for a local stage whose key has no
+// parts beyond the hash and command: it recorded no dir, env, image, flake
+// lock or tool versions, so it can't vouch for them.
+func cacheHitOn(cache map[string]string, stage Stage, hash, target string) bool {
 	if hash == "" {
 		return false
 	}
-	entry, ok := cache[stage.Name]
+	entry, ok := cache[cacheEntryName(stage.Name, target)]
 	if !ok {
 		return false
 	}
@@ -24,5 +68,32 @@ func cacheHit(cache map[string]string, stage Stage, hash string) bool {
 	if entry == key {
 		return true
 	}
-	return entry == hash
+	return target == "" && entry == hash && key == hash+"|"+strings.Join(stage.Cmd, " ")
+}
+
+// cacheHitAny reports whether the stage is cached on any of targets, or
+// locally when there are none.
+func cacheHitAny(cache map[string]string, stage Stage, hash string, targets []string) bool {
+	if len(targets) == 0 {
+		return cacheHit(cache, stage, hash)
+	}
+	for _, target := range targets {
+		if cacheHitOn(cache, stage, hash, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateStageCache removes a stage's entries for every target and
+// returns how many were removed.
+func invalidateStageCache(cache map[string]string, stage string) int {
+	n := 0
+	for name := range cache {
+		if name == stage || strings.HasPrefix(name, stage+"@") {
+			delete(cache, name)
+			n++
+		}
+	}
+	return n
 }
@@ -11,6 +11,30 @@ func TestCacheKeyForStage(t *testing.T) {
 	}
 }
 
+func TestCacheKeyCoversEnvAndDir(t *testing.T) {
+	stage := Stage{Name: "test", Cmd: []string{"cargo", "test"}, Env: map[string]string{"RUSTFLAGS": "-D warnings"}}
+	cache := map[string]string{"test": cacheKeyForStage(stage, "abc123")}
+	if !cacheHit(cache, stage, "abc123") {
+		t.Fatal("expected cache hit with the same env")
+	}
+
+	changed := stage
+	changed.Env = map[string]string{"RUSTFLAGS": "-C target-cpu=native"}
+	if cacheHit(cache, changed, "abc123") {
+		t.Error("changing env should miss")
+	}
+	added := stage
+	added.Env = map[string]string{"RUSTFLAGS": "-D warnings", "CI": "1"}
+	if cacheHit(cache, added, "abc123") {
+		t.Error("adding an env var should miss")
+	}
+	moved := stage
+	moved.Dir = "crates/core"
+	if cacheHit(cache, moved, "abc123") {
+		t.Error("changing dir should miss")
+	}
+}
+
 func TestCacheHitCanonicalAndLegacy(t *testing.T) {
 	stage := Stage{Name: "fmt", Cmd: []string{"cargo", "fmt"}}
 	cache := map[string]string{
@@ -24,6 +48,17 @@ func TestCacheHitCanonicalAndLegacy(t *testing.T) {
 	if !cacheHit(legacy, stage, "abc123") {
 		t.Fatal("expected legacy hash-only cache hit")
 	}
+
+	// The legacy entry didn't record tool versions or an image, so it
+	// can't prove a stage keyed on them is up to date.
+	for _, keyed := range []Stage{
+		{Name: "fmt", Cmd: []string{"cargo", "fmt"}, ToolVersions: "cargo@1.80.0"},
+		{Name: "fmt", Cmd: []string{"cargo", "fmt"}, Container: "rust:1.80", ImageID: "sha256:1a2b"},
+	} {
+		if cacheHit(legacy, keyed, "abc123") {
+			t.Errorf("legacy entry should not match %+v", keyed)
+		}
+	}
 }
 
 func TestCacheHitMiss(t *testing.T) {
@@ -33,3 +68,37 @@ func TestCacheHitMiss(t *testing.T) {
 		t.Fatal("expected cache miss")
 	}
 }
+
+func TestCacheNamespacedByTarget(t *testing.T) {
+	stage := Stage{Name: "test", Cmd: []string{"cargo", "test"}}
+	key := cacheKeyForStage(stage, "abc123")
+	cache := map[string]string{cacheEntryName("test", "sparky/linux_spark"): key}
+
+	if !cacheHitOn(cache, stage, "abc123", "sparky/linux_spark") {
+		t.Fatal("expected a hit on the host that ran the stage")
+	}
+	if cacheHit(cache, stage, "abc123") {
+		t.Error("a remote pass must not count as cached locally")
+	}
+	if cacheHitOn(cache, stage, "abc123", "macos-mini/macos") {
+		t.Error("a pass on one host must not count on another")
+	}
+	if !cacheHitAny(cache, stage, "abc123", []string{"macos-mini/macos", "sparky/linux_spark"}) {
+		t.Error("expected a hit when any pool target has the stage cached")
+	}
+
+	// Legacy hash-only entries only ever meant local runs.
+	legacy := map[string]string{"test@sparky/linux_spark": "abc123"}
+	if cacheHitOn(legacy, stage, "abc123", "sparky/linux_spark") {
+		t.Error("hash-only entries should not match remote targets")
+	}
+
+	cache["test"] = key
+	cache["testing"] = key
+	if n := invalidateStageCache(cache, "test"); n != 2 {
+		t.Errorf("invalidateStageCache removed %d entries, want 2", n)
+	}
+	if _, ok := cache["testing"]; !ok {
+		t.Error("invalidating test should leave other stages alone")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// conditionEnv is what stage conditions are checked against. The fields
+// are swappable for tests; newConditionEnv fills them from this machine.
+type conditionEnv struct {
+	root      string
+	platform  Platform
+	lookupEnv func(string) (string, bool)
+	hasTool   func(string) bool
+
+	changedOnce sync.Once
+	changed     []string
+	changedOK   bool
+	changedFn   func(root string) ([]string, error)
+}
+
+func newConditionEnv(root string) *conditionEnv {
+	return &conditionEnv{
+		root:      root,
+		platform:  DetectPlatform(),
+		lookupEnv: os.LookupEnv,
+		hasTool:   conditionToolAvailable,
+		changedFn: changedFiles,
+	}
+}
+
+// applyStageConditions records on each stage why it should be skipped, or
+// clears it. Remote hosts have their own platform and tools, so only the
+// env, file and change conditions are checked for them; the workspace they
+// run is a copy of this one.
+func applyStageConditions(stages []Stage, env *conditionEnv, remote bool) {
+	for i := range stages {
+		stages[i].SkipReason = env.check(stages[i], remote)
+	}
+}
+
+// check returns why s's conditions are unmet, or "" when it should run.
+func (e *conditionEnv) check(s Stage, remote bool) string {
+	if len(s.IfPlatform) > 0 && !remote {
+		matched := false
+		for _, want := range s.IfPlatform {
+			if platformMatches(e.platform, want) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Sprintf("if_platform: %s is not %s", e.platform, strings.Join(s.IfPlatform, " or "))
+		}
+	}
+	for _, cond := range s.IfEnv {
+		if reason := e.checkEnv(cond); reason != "" {
+			return "if_env: " + reason
+		}
+	}
+	for _, p := range s.IfFilesExist {
+		matches, _ := filepath.Glob(filepath.Join(e.root, p))
+		if len(matches) == 0 {
+			return fmt.Sprintf("if_files_exist: %s not found", p)
+		}
+	}
+	if !remote {
+		for _, tool := range s.IfTool {
+			if !e.hasTool(tool) {
+				return fmt.Sprintf("if_tool: %s is not installed", tool)
+			}
+		}
+	}
+	if len(s.IfChanged) > 0 {
+		e.changedOnce.Do(func() {
+			files, err := e.changedFn(e.root)
+			e.changed, e.changedOK = files, err == nil
+		})
+		// Without git there is nothing to compare against, so run.
+		if e.changedOK {
+			matched := false
+			for _, f := range e.changed {
+				if matchesAnyArtifact(s.IfChanged, f) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Sprintf("if_changed: no changes to %s", strings.Join(s.IfChanged, ", "))
+			}
+		}
+	}
+	return ""
+}
+
+// checkEnv checks one if_env entry: "VAR" (set and not empty), "VAR=value"
+// or "!VAR" (unset or empty).
+func (e *conditionEnv) checkEnv(cond string) string {
+	if name, ok := strings.CutPrefix(cond, "!"); ok {
+		if v, _ := e.lookupEnv(name); v != "" {
+			return name + " is set"
+		}
+		return ""
+	}
+	if name, want, ok := strings.Cut(cond, "="); ok {
+		if v, _ := e.lookupEnv(name); v != want {
+			return fmt.Sprintf("%s is %q, not %q", name, v, want)
+		}
+		return ""
+	}
+	if v, _ := e.lookupEnv(cond); v == "" {
+		return cond + " is not set"
+	}
+	return ""
+}
+
+// platformMatches reports whether p satisfies an if_platform entry: a
+// DetectPlatform name (macos, nixos, nixos-wsl, generic-linux), "linux" for
+// any Linux, "wsl", or a GOOS such as darwin or windows.
+func platformMatches(p Platform, want string) bool {
+	want = strings.ToLower(strings.TrimSpace(want))
+	if want == string(p) || want == runtime.GOOS {
+		return true
+	}
+	switch want {
+	case "linux":
+		return p == PlatformLinux || p == PlatformGenericLinux || p.IsNixOS()
+	case "darwin":
+		return p == PlatformMacOS
+	case "wsl":
+		return p.IsWSL()
+	}
+	return false
+}
+
+// conditionToolAvailable checks a known tool with its own check command
+// (ToolIsAvailable), and anything else by looking it up in PATH.
+func conditionToolAvailable(name string) bool {
+	if getToolByName(name) != nil {
+		return ToolIsAvailable(name)
+	}
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// changedFiles lists files under root that differ from HEAD (staged,
+// unstaged or untracked) or from the upstream branch, relative to root.
+func changedFiles(root string) ([]string, error) {
+	git := func(args ...string) ([]string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+	}
+	files, err := git("diff", "--name-only", "--relative", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, untracked...)
+	// Commits not pushed yet count too, when the branch has an upstream.
+	if ahead, err := git("diff", "--name-only", "--relative", "@{upstream}...HEAD"); err == nil {
+		files = append(files, ahead...)
+	}
+	var out []string
+	for _, f := range dedupeStrings(files) {
+		if f != "" {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// skipResult is the result of a stage whose conditions are unmet.
+func skipResult(s Stage) Result {
+	return Result{Name: s.Name, Command: strings.Join(s.Cmd, " "), Status: "skip", SkipReason: s.SkipReason}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testConditionEnv(root string, env map[string]string, changed []string) *conditionEnv {
+	return &conditionEnv{
+		root:     root,
+		platform: PlatformGenericLinux,
+		lookupEnv: func(k string) (string, bool) {
+			v, ok := env[k]
+			return v, ok
+		},
+		hasTool: func(name string) bool { return name == "cargo" },
+		changedFn: func(string) ([]string, error) {
+			if changed == nil {
+				return nil, errors.New("not a git checkout")
+			}
+			return changed, nil
+		},
+	}
+}
+
+func TestStageConditions(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "migrations"), 0o755)
+	env := testConditionEnv(root, map[string]string{"CI": "true", "MODE": "release"}, []string{"src/db/schema.sql", "README.md"})
+
+	tests := []struct {
+		name  string
+		stage Stage
+		want  string // substring of the skip reason; "" runs
+	}{
+		{"platform linux", Stage{IfPlatform: []string{"linux"}}, ""},
+		{"platform exact", Stage{IfPlatform: []string{"macos", "generic-linux"}}, ""},
+		{"platform unmet", Stage{IfPlatform: []string{"macos", "nixos"}}, "if_platform: generic-linux is not macos or nixos"},
+		{"env set", Stage{IfEnv: []string{"CI"}}, ""},
+		{"env unset", Stage{IfEnv: []string{"GITHUB_ACTIONS"}}, "if_env: GITHUB_ACTIONS is not set"},
+		{"env value", Stage{IfEnv: []string{"MODE=release"}}, ""},
+		{"env value unmet", Stage{IfEnv: []string{"MODE=debug"}}, `MODE is "release", not "debug"`},
+		{"env negated", Stage{IfEnv: []string{"!CI"}}, "if_env: CI is set"},
+		{"files", Stage{IfFilesExist: []string{"migrations/"}}, ""},
+		{"files glob", Stage{IfFilesExist: []string{"migr*"}}, ""},
+		{"files missing", Stage{IfFilesExist: []string{"Cargo.toml"}}, "if_files_exist: Cargo.toml not found"},
+		{"tool", Stage{IfTool: []string{"cargo"}}, ""},
+		{"tool missing", Stage{IfTool: []string{"cargo-deny"}}, "if_tool: cargo-deny is not installed"},
+		{"changed", Stage{IfChanged: []string{"**/*.sql"}}, ""},
+		{"changed dir", Stage{IfChanged: []string{"src/db"}}, ""},
+		{"unchanged", Stage{IfChanged: []string{"*.go", "web/**"}}, "if_changed: no changes to *.go, web/**"},
+	}
+	for _, tt := range tests {
+		got := env.check(tt.stage, false)
+		if tt.want == "" && got != "" || tt.want != "" && !strings.Contains(got, tt.want) {
+			t.Errorf("%s: reason = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Remote hosts have their own platform and tools.
+	remote := Stage{IfPlatform: []string{"macos"}, IfTool: []string{"cargo-deny"}}
+	if got := env.check(remote, true); got != "" {
+		t.Errorf("remote runs should not check platform or tools: %q", got)
+	}
+
+	// Without git, if_changed can't tell, so the stage runs.
+	noGit := testConditionEnv(root, nil, nil)
+	if got := noGit.check(Stage{IfChanged: []string{"*.go"}}, false); got != "" {
+		t.Errorf("without git = %q", got)
+	}
+}
+
+func TestStageConditionsFromConfig(t *testing.T) {
+	root := t.TempDir()
+	config := `[stages.deny]
+command = ["cargo", "deny", "check"]
+if_tool = "cargo-deny"
+if_env = ["CI", "!SKIP_DENY"]
+if_platform = ["linux", "macos"]
+if_files_exist = "deny.toml"
+if_changed = ["Cargo.lock"]
+`
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(config), 0o644)
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Stages["deny"]
+	if len(s.IfTool) != 1 || len(s.IfEnv) != 2 || len(s.IfPlatform) != 2 || s.IfFilesExist[0] != "deny.toml" || s.IfChanged[0] != "Cargo.lock" {
+		t.Errorf("conditions = %+v", s)
+	}
+}
+
+func TestConditionSkipsAreNotFailures(t *testing.T) {
+	stages := []Stage{
+		{Name: "deny", Cmd: []string{"false"}, Timeout: 10, SkipReason: "if_tool: cargo-deny is not installed", Enabled: true},
+		{Name: "test", Cmd: []string{"echo", "ok"}, Timeout: 10, DependsOn: []string{"deny"}, Enabled: true},
+	}
+	runner := &ParallelRunner{Stages: stages, Concurrency: 2, Cwd: t.TempDir(), NoCache: true, Cache: map[string]string{}, FailFast: true}
+	results := runner.Run()
+	if results[0].Status != "skip" || results[0].SkipReason == "" || results[1].Status != "pass" {
+		t.Fatalf("results = %+v", results)
+	}
+	if rj := toJSONResults(results)[0]; rj.SkipReason != "if_tool: cargo-deny is not installed" {
+		t.Errorf("JSON skip_reason = %q", rj.SkipReason)
+	}
+
+	report := BuildDryRunReport(stages, map[string]string{}, nil, "h", false, nil, nil)
+	if d := report.Stages[0]; d.WouldRun || d.Reason != "condition" || d.SkipReason == "" {
+		t.Errorf("dry-run = %+v", d)
+	}
+	if summary := githubStepSummary(results, 0); !strings.Contains(summary, "skip (if_tool: cargo-deny is not installed)") {
+		t.Errorf("step summary:\n%s", summary)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@t", "-c", "commit.gpgsign=false"}, args...)...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	os.WriteFile(filepath.Join(root, "a.go"), []byte("package a"), 0o644)
+	os.WriteFile(filepath.Join(root, "b.go"), []byte("package b"), 0o644)
+	git("add", ".")
+	git("commit", "-qm", "init")
+
+	os.WriteFile(filepath.Join(root, "a.go"), []byte("package a // edited"), 0o644)
+	os.WriteFile(filepath.Join(root, "new.sql"), nil, 0o644)
+	files, err := changedFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(files, ","); got != "a.go,new.sql" {
+		t.Errorf("changed = %s", got)
+	}
+}
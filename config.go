@@ -28,6 +28,23 @@ type Config struct {
 	Workspace    WorkspaceConfig       `toml:"workspace"`
 	Profiles     map[string]Profile    `toml:"profiles"`
 	Hosts        map[string]RemoteHost `toml:"hosts"`
+	Sync         SyncConfig            `toml:"sync"`
+
+	// Container is the default image for stages that don't set their own
+	// `container`; ContainerEngine is docker, podman or auto (default).
+	Container       string `toml:"container"`
+	ContainerEngine string `toml:"container_engine"`
+
+	// Nix runs stages in the flake's default devShell; NixShell names
+	// another installable, e.g. ".#ci". Stages can set either to override.
+	Nix      bool   `toml:"nix"`
+	NixShell string `toml:"nix_shell"`
+
+	// Sandbox runs every stage under bubblewrap unless it sets its own.
+	Sandbox bool `toml:"sandbox"`
+
+	// Tools pins tool versions, e.g. rustc = "1.80" or bun = ">=1.1".
+	Tools map[string]string `toml:"tools"`
 }
 
 // RemoteHost is a named SSH+tmux target loaded from .local-ci-remote.toml.
@@ -161,6 +178,15 @@ func LoadConfig(root string, remote bool) (*Config, error) {
 			if remoteCfg.SSHDefaults.MacOSUser != "" || remoteCfg.SSHDefaults.LinuxSparkUser != "" || remoteCfg.SSHDefaults.WindowsUser != "" {
 				cfg.SSHDefaults = remoteCfg.SSHDefaults
 			}
+			if remoteCfg.Sync.Timeout > 0 {
+				cfg.Sync.Timeout = remoteCfg.Sync.Timeout
+			}
+			if remoteCfg.Sync.Method != "" {
+				cfg.Sync.Method = remoteCfg.Sync.Method
+			}
+			if remoteCfg.Sync.Git != "" {
+				cfg.Sync.Git = remoteCfg.Sync.Git
+			}
 			if len(remoteCfg.Hosts) > 0 {
 				if cfg.Hosts == nil {
 					cfg.Hosts = make(map[string]RemoteHost, len(remoteCfg.Hosts))
@@ -179,11 +205,28 @@ func LoadConfig(root string, remote bool) (*Config, error) {
 		}
 	}
 
-	// Ensure Name field is set for all stages from the map key
+	// Ensure Name field is set for all stages from the map key, and apply
+	// the config-level container, Nix shell and sandbox to stages that
+	// don't choose their own.
 	for name, stage := range cfg.Stages {
 		stage.Name = name
+		if !stage.containerSet {
+			stage.Container = cfg.Container
+		}
+		if stage.Container != "" {
+			stage.ContainerEngine = cfg.ContainerEngine
+		}
+		if !stage.nixSet {
+			stage.NixShell = cfg.nixShell()
+		}
+		if !stage.sandboxSet {
+			stage.Sandbox = cfg.Sandbox
+		}
 		cfg.Stages[name] = stage
 	}
+	if err := expandStageMatrices(cfg.Stages); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
@@ -319,59 +362,79 @@ func (c *Config) GetTimeout(stageName string) time.Duration {
 	return 30 * time.Second // Safe default
 }
 
-// GetEnabledStages returns the list of enabled stage names in deterministic order
-func (c *Config) GetEnabledStages() []string {
-	// Define default order for common stages to ensure deterministic output
-	order := []string{"fmt", "check", "clippy", "test", "lint", "vet", "types", "build", "audit", "deny", "machete", "taplo"}
-
-	var enabled []string
-	// First add stages in predefined order if they exist and are enabled
-	for _, name := range order {
-		if stage, ok := c.Stages[name]; ok && stage.Enabled {
-			enabled = append(enabled, name)
+// stageOrder is the default order for common stages, so output is
+// deterministic. Other stages follow alphabetically.
+var stageOrder = []string{"fmt", "check", "clippy", "test", "lint", "vet", "types", "build", "audit", "deny", "machete", "taplo"}
+
+// orderStages returns the names of the stages keep accepts, in stageOrder.
+// Matrix instances sort by the stage they came from, then in expansion
+// order.
+func (c *Config) orderStages(keep func(Stage) bool) []string {
+	rank := make(map[string]int, len(stageOrder))
+	for i, name := range stageOrder {
+		rank[name] = i
+	}
+	base := func(name string) string {
+		if of := c.Stages[name].MatrixOf; of != "" {
+			return of
 		}
+		return name
 	}
-
-	// Then add any remaining enabled stages not in the predefined order, sorted alphabetically
-	seen := make(map[string]bool)
-	for _, name := range order {
-		seen[name] = true
+	position := func(name string) int {
+		if r, ok := rank[base(name)]; ok {
+			return r
+		}
+		return len(stageOrder)
 	}
-	var extra []string
+
+	var names []string
 	for name, stage := range c.Stages {
-		if !seen[name] && stage.Enabled {
-			extra = append(extra, name)
+		if keep(stage) {
+			names = append(names, name)
 		}
 	}
-	sort.Strings(extra)
-	enabled = append(enabled, extra...)
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if pa, pb := position(a), position(b); pa != pb {
+			return pa < pb
+		}
+		if ba, bb := base(a), base(b); ba != bb {
+			return ba < bb
+		}
+		return c.Stages[a].matrixIndex < c.Stages[b].matrixIndex
+	})
+	return names
+}
 
-	return enabled
+// GetEnabledStages returns the list of enabled stage names in deterministic order
+func (c *Config) GetEnabledStages() []string {
+	return c.orderStages(func(s Stage) bool { return s.Enabled })
 }
 
 // GetAllStages returns every configured stage name (enabled and disabled) in
 // deterministic order — the same ordering as GetEnabledStages, but without the
 // enabled filter. Used by the --all flag.
 func (c *Config) GetAllStages() []string {
-	order := []string{"fmt", "check", "clippy", "test", "lint", "vet", "types", "build", "audit", "deny", "machete", "taplo"}
+	return c.orderStages(func(Stage) bool { return true })
+}
 
-	var all []string
-	seen := make(map[string]bool)
-	for _, name := range order {
-		if _, ok := c.Stages[name]; ok {
-			all = append(all, name)
-			seen[name] = true
+// SelectStages resolves stage names to stages, silently ignoring unknown
+// names. A matrix stage's name or selector resolves to its matching
+// instances. With no names (or none that match) it returns the enabled
+// stages.
+func (c *Config) SelectStages(names []string) []Stage {
+	var stages []Stage
+	for _, name := range names {
+		for _, n := range c.StagesNamed(name) {
+			stages = append(stages, c.Stages[n])
 		}
 	}
-
-	var extra []string
-	for name := range c.Stages {
-		if !seen[name] {
-			extra = append(extra, name)
+	if len(stages) == 0 {
+		for _, name := range c.GetEnabledStages() {
+			stages = append(stages, c.Stages[name])
 		}
 	}
-	sort.Strings(extra)
-	return append(all, extra...)
+	return stages
 }
 
 // GetRemoteHost looks up a named host preset (loaded from
@@ -447,9 +510,10 @@ func (c *Config) ListRemoteHosts() []struct {
 // ResolvedRemoteTarget is the result of merging a `[hosts.<name>]` preset
 // with command-line overrides. Empty fields mean "use the caller's default".
 type ResolvedRemoteTarget struct {
-	Host      string
-	Session   string
-	RemoteDir string
+	Host        string
+	Session     string
+	RemoteDir   string
+	CacheTarget string // cache namespace: preset name and platform
 }
 
 // ResolveRemoteHost applies a named preset onto flag-derived defaults using
@@ -483,6 +547,7 @@ func (c *Config) ResolveRemoteHost(
 	if !userSetRemoteDir && preset.RemoteDir != "" {
 		out.RemoteDir = preset.RemoteDir
 	}
+	out.CacheTarget = remoteCacheTarget(name, preset.effectivePlatform(name))
 	return out, nil
 }
 
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// containerCacheMount is where the persistent tool cache is mounted inside
+// stage containers.
+const containerCacheMount = "/local-ci-cache"
+
+// containerCacheEnv points common package managers at the persistent cache
+// so dependency downloads survive between container runs. Stage env wins.
+var containerCacheEnv = []string{
+	"HOME=" + containerCacheMount + "/home",
+	"CARGO_HOME=" + containerCacheMount + "/cargo",
+	"npm_config_cache=" + containerCacheMount + "/npm",
+	"BUN_INSTALL_CACHE_DIR=" + containerCacheMount + "/bun",
+	"GOCACHE=" + containerCacheMount + "/go-build",
+	"GOMODCACHE=" + containerCacheMount + "/go-mod",
+	"PIP_CACHE_DIR=" + containerCacheMount + "/pip",
+	"XDG_CACHE_HOME=" + containerCacheMount + "/xdg",
+}
+
+// containerEngine picks the engine for a stage: the configured one, or
+// docker, then podman, whichever is installed.
+func containerEngine(preferred string) (string, error) {
+	if preferred != "" && preferred != "auto" {
+		if _, err := exec.LookPath(preferred); err != nil {
+			return "", fmt.Errorf("container engine %q not found in PATH", preferred)
+		}
+		return preferred, nil
+	}
+	for _, engine := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(engine); err == nil {
+			return engine, nil
+		}
+	}
+	return "", fmt.Errorf("container stages need docker or podman in PATH")
+}
+
+// containerCacheDir is the host directory bind-mounted at
+// containerCacheMount. A host directory rather than a named volume keeps it
+// writable by the mapped user.
+func containerCacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "local-ci", "container")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// containerName is a unique, engine-safe name for one stage run, so a
+// cancelled run can remove its container.
+func containerName(stage string) string {
+	return fmt.Sprintf("local-ci-%s-%d-%d", tmuxWindowName(stage), os.Getpid(), time.Now().UnixNano()%1e9)
+}
+
+// containerRunArgs builds the `<engine> run` arguments for a stage. The
+// project is bind-mounted at its host path, so paths in compiler output
+// still point at real files. Files are written as the invoking user: podman
+// maps it with --userns=keep-id, docker with --user. uid < 0 (Windows)
+// leaves the image's user alone.
+func containerRunArgs(engine, name string, stage Stage, root, cacheDir string, uid, gid int) []string {
+	mountRoot := filepath.ToSlash(root)
+	workDir := mountRoot
+	if stage.Dir != "" {
+		workDir = path.Join(mountRoot, filepath.ToSlash(stage.Dir))
+	}
+	args := []string{"run", "--rm", "--init", "--name", name,
+		"-v", root + ":" + mountRoot,
+		"-v", cacheDir + ":" + containerCacheMount,
+		"-w", workDir,
+	}
+	if uid >= 0 {
+		if filepath.Base(engine) == "podman" {
+			args = append(args, "--userns=keep-id")
+		} else {
+			args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid))
+		}
+	}
+	for _, kv := range containerCacheEnv {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, ok := stage.Env[key]; !ok {
+			args = append(args, "-e", kv)
+		}
+	}
+	for _, kv := range stageEnv(stage) {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, stage.Container)
+	return append(args, stage.Cmd...)
+}
+
+// containerCommand runs the stage in its container. Cancelling ctx removes
+// the container as well as killing the engine client. A stage whose image
+// ID wasn't resolved doesn't run: its cache key couldn't tell the image
+// apart from the next one.
+func containerCommand(ctx context.Context, stage Stage, root string) (*exec.Cmd, error) {
+	if stage.ImageID == "" {
+		return nil, fmt.Errorf("image %s could not be resolved; pull it and run again", stage.Container)
+	}
+	engine, err := containerEngine(stage.ContainerEngine)
+	if err != nil {
+		return nil, err
+	}
+	cacheDir, err := containerCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("container cache directory: %w", err)
+	}
+	name := containerName(stage.Name)
+	cmd := exec.CommandContext(ctx, engine, containerRunArgs(engine, name, stage, root, cacheDir, os.Getuid(), os.Getgid())...)
+	cmd.Dir = root
+	cmd.Cancel = func() error {
+		exec.Command(engine, "rm", "-f", name).Run()
+		return cmd.Process.Kill()
+	}
+	return cmd, nil
+}
+
+// containerImageID returns the local ID (content digest) of image. A missing
+// image is pulled first when pull is set; otherwise its ID is empty.
+func containerImageID(ctx context.Context, engine, image string, pull bool) (string, error) {
+	inspect := func() (string, error) {
+		out, err := exec.CommandContext(ctx, engine, "image", "inspect", "--format", "{{.Id}}", image).Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	id, err := inspect()
+	if (err != nil || id == "") && !pull {
+		return "", nil
+	}
+	if err != nil || id == "" {
+		printf("📦 Pulling %s...\n", image)
+		if out, err := exec.CommandContext(ctx, engine, "pull", image).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to pull %s: %v\n%s", image, err, strings.TrimSpace(string(out)))
+		}
+		if id, err = inspect(); err != nil || id == "" {
+			return "", fmt.Errorf("failed to inspect %s: %v", image, err)
+		}
+	}
+	return id, nil
+}
+
+// resolveContainerImages records each containerized stage's image ID, which
+// becomes part of its cache key: a re-tagged or re-pulled image re-runs the
+// stage. Stages whose image can't be resolved keep an empty ID and fail
+// when they run. Dry runs pass pull=false so nothing is downloaded. IDs
+// are looked up afresh on every run, so a long-lived daemon notices an
+// image re-pulled between runs; stages sharing an image share one lookup.
+func resolveContainerImages(stages []Stage, pull bool) error {
+	ids := make(map[string]string)
+	var errs []string
+	for i := range stages {
+		if stages[i].Container == "" {
+			continue
+		}
+		engine, err := containerEngine(stages[i].ContainerEngine)
+		if err == nil {
+			key := engine + "\x00" + stages[i].Container
+			id, ok := ids[key]
+			if !ok {
+				if id, err = containerImageID(context.Background(), engine, stages[i].Container, pull); err == nil {
+					ids[key] = id
+				}
+			}
+			stages[i].ImageID = id
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", stages[i].Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("container images: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// shortImageID trims an image ID for display: "sha256:1a2b3c…" -> "1a2b3c4d5e6f".
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeEngine installs a docker stand-in that reports a fixed image ID and,
+// for `run`, applies -w and -e and then runs the command on the host.
+func fakeEngine(t *testing.T, name string) {
+	t.Helper()
+	bin := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+image) echo "${FAKE_IMAGE_ID:-sha256:0123456789abcdef0123}"; exit 0 ;;
+pull|rm) exit 0 ;;
+run) shift ;;
+esac
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--rm|--init|--userns=*) shift ;;
+	--name|-v|--user) shift 2 ;;
+	-w) cd "$2"; shift 2 ;;
+	-e) export "$2"; shift 2 ;;
+	*) break ;;
+	esac
+done
+echo "image=$1"; shift
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+}
+
+func TestContainerRunArgs(t *testing.T) {
+	stage := Stage{
+		Name:      "test",
+		Cmd:       []string{"cargo", "test"},
+		Dir:       "crates/core",
+		Env:       map[string]string{"CARGO_HOME": "/opt/cargo", "RUST_LOG": "debug"},
+		Container: "rust:1.80",
+	}
+	args := strings.Join(containerRunArgs("docker", "lci", stage, "/src/app", "/cache", 501, 20), " ")
+	for _, want := range []string{
+		"run --rm --init --name lci",
+		"-v /src/app:/src/app",
+		"-v /cache:/local-ci-cache",
+		"-w /src/app/crates/core",
+		"--user 501:20",
+		"-e HOME=/local-ci-cache/home",
+		"-e CARGO_HOME=/opt/cargo",
+		"-e RUST_LOG=debug",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args missing %q: %s", want, args)
+		}
+	}
+	if strings.Contains(args, "CARGO_HOME=/local-ci-cache") {
+		t.Errorf("stage env should override the cache default: %s", args)
+	}
+	if !strings.HasSuffix(args, "rust:1.80 cargo test") {
+		t.Errorf("image and command should come last: %s", args)
+	}
+
+	podman := strings.Join(containerRunArgs("/usr/bin/podman", "lci", stage, "/src/app", "/cache", 501, 20), " ")
+	if !strings.Contains(podman, "--userns=keep-id") || strings.Contains(podman, "--user ") {
+		t.Errorf("podman should keep the user's ID: %s", podman)
+	}
+	if windows := strings.Join(containerRunArgs("docker", "lci", stage, "/src/app", "/cache", -1, -1), " "); strings.Contains(windows, "--user") {
+		t.Errorf("no uid means no user mapping: %s", windows)
+	}
+}
+
+func TestRunLocalStageInContainer(t *testing.T) {
+	fakeEngine(t, "docker")
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub"), 0o755)
+
+	stages := []Stage{{
+		Name:      "build",
+		Cmd:       []string{"sh", "-c", "pwd; echo cache=$CARGO_HOME; touch out.txt"},
+		Dir:       "sub",
+		Timeout:   10,
+		Container: "rust:1.80",
+	}}
+	if err := resolveContainerImages(stages, true); err != nil {
+		t.Fatal(err)
+	}
+	stage := stages[0]
+	if stage.ImageID != "sha256:0123456789abcdef0123" {
+		t.Fatalf("image ID = %q", stage.ImageID)
+	}
+
+	result := runLocalStage(context.Background(), stage, root, nil)
+	if result.Status != "pass" {
+		t.Fatalf("stage failed: %v\n%s", result.Error, result.Output)
+	}
+	for _, want := range []string{"image=rust:1.80", filepath.Join(root, "sub"), "cache=/local-ci-cache/cargo"} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output missing %q:\n%s", want, result.Output)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "sub", "out.txt")); err != nil {
+		t.Errorf("files written in the container should land in the workspace: %v", err)
+	}
+
+	// The image ID is part of the cache key: a new image misses.
+	key := cacheKeyForStage(stage, "h")
+	if key != "h|sh -c pwd; echo cache=$CARGO_HOME; touch out.txt|dir:sub|rust:1.80@sha256:0123456789abcdef0123" {
+		t.Errorf("cache key = %q", key)
+	}
+	repulled := stage
+	repulled.ImageID = "sha256:fedcba"
+	if cacheHit(map[string]string{"build": key}, repulled, "h") {
+		t.Error("a different image ID should miss the cache")
+	}
+}
+
+func TestResolveContainerImagesPerRun(t *testing.T) {
+	fakeEngine(t, "docker")
+	stages := []Stage{{Name: "build", Container: "rust:1.80"}}
+	if err := resolveContainerImages(stages, false); err != nil || stages[0].ImageID != "sha256:0123456789abcdef0123" {
+		t.Fatalf("image ID = %q, %v", stages[0].ImageID, err)
+	}
+	// A daemon resolves again for each run, so a re-pulled image is seen.
+	t.Setenv("FAKE_IMAGE_ID", "sha256:fedcba")
+	stages[0].ImageID = ""
+	if err := resolveContainerImages(stages, false); err != nil || stages[0].ImageID != "sha256:fedcba" {
+		t.Errorf("image ID after re-pull = %q, %v", stages[0].ImageID, err)
+	}
+}
+
+func TestConfigLevelContainer(t *testing.T) {
+	root := t.TempDir()
+	config := `container = "node:20"
+container_engine = "podman"
+
+[stages.lint]
+command = ["bun", "run", "lint"]
+
+[stages.host]
+command = ["./scripts/host-only.sh"]
+container = ""
+
+[stages.rust]
+command = ["cargo", "test"]
+container = "rust:1.80"
+`
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(config), 0o644)
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cfg.Stages["lint"]; s.Container != "node:20" || s.ContainerEngine != "podman" {
+		t.Errorf("lint = %q via %q", s.Container, s.ContainerEngine)
+	}
+	if s := cfg.Stages["host"]; s.Container != "" {
+		t.Errorf("container = \"\" should opt out, got %q", s.Container)
+	}
+	if s := cfg.Stages["rust"]; s.Container != "rust:1.80" {
+		t.Errorf("rust = %q", s.Container)
+	}
+}
+
+func TestRunLocalStageNeedsImageID(t *testing.T) {
+	fakeEngine(t, "docker")
+	root := t.TempDir()
+	stage := Stage{Name: "build", Cmd: []string{"touch", "ran"}, Timeout: 10, Container: "rust:1.80"}
+	result := runLocalStage(context.Background(), stage, root, nil)
+	if result.Status != "fail" || result.Error == nil || !strings.Contains(result.Error.Error(), "could not be resolved") {
+		t.Fatalf("a stage without an image ID should fail, got %s: %v", result.Status, result.Error)
+	}
+	if _, err := os.Stat(filepath.Join(root, "ran")); !os.IsNotExist(err) {
+		t.Error("the stage should not run without an image ID")
+	}
+}
@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// daemonRequest is a single JSON line sent by a client over the daemon socket.
+type daemonRequest struct {
+	Op      string   `json:"op"` // run | status | cancel | logs | stop
+	Stages  []string `json:"stages,omitempty"`
+	NoCache bool     `json:"no_cache,omitempty"`
+	Follow  bool     `json:"follow,omitempty"` // logs: keep streaming until the run ends
+}
+
+// daemonMessage is a single JSON line sent back by the daemon. The daemon
+// closes the connection once a request has been fully answered.
+type daemonMessage struct {
+	Type   string              `json:"type"` // log | warning | result | done | status | ok | error
+	Stage  string              `json:"stage,omitempty"`
+	Line   string              `json:"line,omitempty"`
+	Result *ResultJSON         `json:"result,omitempty"`
+	Report *PipelineReportJSON `json:"report,omitempty"`
+	Status *daemonStatus       `json:"status,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// daemonStatus describes what the daemon is doing.
+type daemonStatus struct {
+	Root         string              `json:"root"`
+	PID          int                 `json:"pid"`
+	StartedAt    time.Time           `json:"started_at"`
+	Running      bool                `json:"running"`
+	Stages       []string            `json:"stages,omitempty"`
+	CurrentStage string              `json:"current_stage,omitempty"`
+	Queued       int                 `json:"queued"`
+	IndexedFiles int                 `json:"indexed_files"`
+	LastRun      *PipelineReportJSON `json:"last_run,omitempty"`
+}
+
+// daemonSocketPath returns where the daemon for root listens. Unix socket
+// paths are limited to ~104 bytes, so deep checkouts fall back to a per-root
+// name in the temp dir.
+func daemonSocketPath(root string) string {
+	p := filepath.Join(root, ".local-ci", "daemon.sock")
+	if len(p) < 100 {
+		return p
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("local-ci-%x.sock", md5.Sum([]byte(root))))
+}
+
+// Daemon keeps configuration and the file-hash index warm for one workspace
+// and runs pipelines on behalf of clients. Runs are serialized so two
+// requests never fight over shared build directories such as `target/`.
+type Daemon struct {
+	Root    string
+	Verbose bool
+
+	index     *HashIndex
+	startedAt time.Time
+	stop      context.CancelFunc
+
+	runMu sync.Mutex // held for the duration of a run
+
+	mu        sync.Mutex // guards the fields below
+	config    *Config
+	ws        *Workspace
+	running   bool
+	runStages []string
+	current   string
+	queued    int
+	cancelRun context.CancelFunc
+	log       *runLog
+	last      *PipelineReportJSON
+}
+
+// NewDaemon loads the workspace configuration for root.
+func NewDaemon(root string) (*Daemon, error) {
+	d := &Daemon{Root: root, index: NewHashIndex(), log: newRunLog()}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	d.log.finish()
+	return d, nil
+}
+
+func (d *Daemon) reload() error {
+	config, err := LoadConfig(d.Root, false)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	ws, _ := DetectWorkspace(d.Root)
+	d.mu.Lock()
+	d.config = config
+	d.ws = ws
+	d.mu.Unlock()
+	return nil
+}
+
+// Serve listens on the workspace socket until ctx is cancelled or a client
+// sends `stop`.
+func (d *Daemon) Serve(ctx context.Context) error {
+	sock := daemonSocketPath(d.Root)
+	if conn, err := net.DialTimeout("unix", sock, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", sock)
+	}
+	_ = os.Remove(sock) // stale socket from a crashed daemon
+	if err := os.MkdirAll(filepath.Dir(sock), 0o755); err != nil {
+		return fmt.Errorf("failed to create socket dir: %w", err)
+	}
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", sock, err)
+	}
+	defer os.Remove(sock)
+
+	ctx, d.stop = context.WithCancel(ctx)
+	defer d.stop()
+	d.startedAt = time.Now()
+
+	d.mu.Lock()
+	skipDirs := d.config.Cache.SkipDirs
+	d.mu.Unlock()
+	watcher, err := newFSWatcher(d.Root, skipDirs)
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to watch %s: %w", d.Root, err)
+	}
+	defer watcher.Close()
+	go d.watch(ctx, watcher)
+
+	// Prime the index so the first run doesn't pay for a cold walk.
+	d.mu.Lock()
+	config, ws := d.config, d.ws
+	d.mu.Unlock()
+	if _, err := d.index.SourceHash(d.Root, config, ws); err != nil && d.Verbose {
+		warnf("Warning: initial hash failed: %v\n", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	printf("🛰  local-ci daemon listening on %s (%d files indexed)\n", sock, d.index.Len())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go d.handle(ctx, conn)
+	}
+}
+
+// watch invalidates index entries and reloads config as files change.
+func (d *Daemon) watch(ctx context.Context, watcher fsWatcher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path, ok := <-watcher.Events():
+			if !ok {
+				return
+			}
+			d.index.Invalidate(path)
+			switch filepath.Base(path) {
+			case ".local-ci.toml", ".local-ci-remote.toml":
+				if err := d.reload(); err != nil {
+					warnf("Config reload failed: %v\n", err)
+				} else if d.Verbose {
+					printf("🔄 Reloaded %s\n", filepath.Base(path))
+				}
+			}
+		}
+	}
+}
+
+func (d *Daemon) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	out := &daemonEncoder{enc: json.NewEncoder(conn)}
+
+	var req daemonRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		out.send(daemonMessage{Type: "error", Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	switch req.Op {
+	case "status":
+		st := d.status()
+		out.send(daemonMessage{Type: "status", Status: &st})
+	case "run":
+		d.run(ctx, req, out)
+	case "cancel":
+		d.mu.Lock()
+		cancel := d.cancelRun
+		d.mu.Unlock()
+		if cancel == nil {
+			out.send(daemonMessage{Type: "error", Error: "no run in progress"})
+			return
+		}
+		cancel()
+		out.send(daemonMessage{Type: "ok"})
+	case "logs":
+		d.mu.Lock()
+		log := d.log
+		d.mu.Unlock()
+		backlog, ch, unsubscribe := log.subscribe(req.Follow)
+		defer unsubscribe()
+		for _, e := range backlog {
+			if out.send(daemonMessage{Type: "log", Stage: e.stage, Line: e.line}) != nil {
+				return
+			}
+		}
+		for e := range ch {
+			if out.send(daemonMessage{Type: "log", Stage: e.stage, Line: e.line}) != nil {
+				return
+			}
+		}
+	case "stop":
+		out.send(daemonMessage{Type: "ok"})
+		d.stop()
+	default:
+		out.send(daemonMessage{Type: "error", Error: fmt.Sprintf("unknown op %q", req.Op)})
+	}
+}
+
+func (d *Daemon) status() daemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return daemonStatus{
+		Root:         d.Root,
+		PID:          os.Getpid(),
+		StartedAt:    d.startedAt,
+		Running:      d.running,
+		Stages:       d.runStages,
+		CurrentStage: d.current,
+		Queued:       d.queued,
+		IndexedFiles: d.index.Len(),
+		LastRun:      d.last,
+	}
+}
+
+// run executes one pipeline, streaming logs and per-stage results to the
+// requesting client. Concurrent requests queue behind runMu.
+func (d *Daemon) run(ctx context.Context, req daemonRequest, out *daemonEncoder) {
+	d.mu.Lock()
+	d.queued++
+	d.mu.Unlock()
+
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	log := newRunLog()
+
+	d.mu.Lock()
+	d.queued--
+	config, ws := d.config, d.ws
+	stages := config.SelectStages(req.Stages)
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = s.Name
+	}
+	d.running = true
+	d.runStages = names
+	d.cancelRun = cancel
+	d.log = log
+	d.mu.Unlock()
+	// An image that can't be resolved fails its stage when it runs.
+	probes := versionProbes{}
+	_ = resolveStageBackends(stages, d.Root, true, probes)
+	applyStageConditions(stages, newConditionEnv(d.Root), false)
+
+	defer func() {
+		log.finish()
+		d.mu.Lock()
+		d.running = false
+		d.runStages = nil
+		d.current = ""
+		d.cancelRun = nil
+		d.mu.Unlock()
+	}()
+
+	hashes, err := d.index.StageHashes(d.Root, config, ws, stages)
+	if err != nil {
+		out.send(daemonMessage{Type: "error", Error: fmt.Sprintf("hash computation failed: %v", err)})
+		return
+	}
+	cache := map[string]string{}
+	if !req.NoCache {
+		cache, _ = loadCache(d.Root)
+	}
+
+	// The same tool checks as an in-process run, so a missing tool or a
+	// broken [tools] pin fails the run before any stage starts.
+	var pending []Stage
+	for _, s := range stages {
+		if req.NoCache || !cacheHit(cache, s, hashes[s.Name]) {
+			pending = append(pending, s)
+		}
+	}
+	check, err := checkStageTools(pending, d.Root, config.Tools, false, probes)
+	if err != nil {
+		out.send(daemonMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	if warning := check.warning(); warning != "" {
+		out.send(daemonMessage{Type: "warning", Line: warning})
+	}
+	if failure := check.failure("Install them, or rerun with --no-daemon --install-missing."); failure != "" {
+		out.send(daemonMessage{Type: "error", Error: strings.TrimRight(failure, "\n")})
+		return
+	}
+
+	start := time.Now()
+	var results []Result
+	for _, stage := range stages {
+		if ctx.Err() != nil {
+			stage.SkipReason = "run cancelled"
+			results = append(results, skipResult(stage))
+			continue
+		}
+		d.mu.Lock()
+		d.current = stage.Name
+		d.mu.Unlock()
+
+		var result Result
+		if stage.SkipReason != "" {
+			result = skipResult(stage)
+		} else if !req.NoCache && cacheHit(cache, stage, hashes[stage.Name]) {
+			result = Result{Name: stage.Name, Command: strings.Join(stage.Cmd, " "), Status: "pass", CacheHit: true}
+		} else {
+			live := log.writer(stage.Name, func(line string) {
+				if out.send(daemonMessage{Type: "log", Stage: stage.Name, Line: line}) != nil {
+					cancel() // client went away
+				}
+			})
+			result = runLocalStage(ctx, stage, d.Root, live)
+			live.Flush()
+			if result.Status == "pass" && !req.NoCache {
+				cache[stage.Name] = cacheKeyForStage(stage, hashes[stage.Name])
+			}
+		}
+		results = append(results, result)
+		rj := toJSONResults([]Result{result})[0]
+		out.send(daemonMessage{Type: "result", Stage: stage.Name, Result: &rj})
+	}
+
+	if !req.NoCache {
+		saveCache(cache, d.Root)
+	}
+
+	report := PipelineReportJSON{Results: toJSONResults(results), DurationMS: time.Since(start).Milliseconds()}
+	for _, r := range results {
+		switch {
+		case r.SkipReason != "":
+			report.Skipped++
+		case r.Status == "pass":
+			report.Passed++
+		case r.Status == "fail":
+			report.Failed++
+		}
+	}
+	d.mu.Lock()
+	d.last = &report
+	d.mu.Unlock()
+	out.send(daemonMessage{Type: "done", Report: &report})
+}
+
+// daemonEncoder serializes writes to one client connection.
+type daemonEncoder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (e *daemonEncoder) send(m daemonMessage) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(m)
+}
+
+// runLog records the output of the current (or most recent) run so `logs`
+// clients can catch up and then follow along.
+type runLog struct {
+	mu      sync.Mutex
+	entries []logEntry
+	subs    map[chan logEntry]bool
+	done    bool
+}
+
+type logEntry struct {
+	stage string
+	line  string
+}
+
+func newRunLog() *runLog {
+	return &runLog{subs: make(map[chan logEntry]bool)}
+}
+
+func (l *runLog) append(e logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+	for ch := range l.subs {
+		select {
+		case ch <- e:
+		default: // slow follower; drop rather than stall the run
+		}
+	}
+}
+
+// subscribe returns the lines logged so far and, when follow is set and the
+// run is still going, a channel of subsequent lines that closes when the run
+// ends.
+func (l *runLog) subscribe(follow bool) ([]logEntry, <-chan logEntry, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	backlog := append([]logEntry(nil), l.entries...)
+	ch := make(chan logEntry, 256)
+	if !follow || l.done {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	l.subs[ch] = true
+	return backlog, ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.subs[ch] {
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (l *runLog) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	for ch := range l.subs {
+		delete(l.subs, ch)
+		close(ch)
+	}
+}
+
+// writer returns an io.Writer that splits stage output into lines, records
+// them and hands each one to emit.
+func (l *runLog) writer(stage string, emit func(string)) *lineWriter {
+	return &lineWriter{emit: func(line string) {
+		l.append(logEntry{stage: stage, line: line})
+		emit(line)
+	}}
+}
+
+// lineWriter buffers partial writes and emits complete lines.
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexByte(string(w.buf), '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits any trailing partial line.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// daemonCall sends req to the daemon serving root and invokes handle for
+// every message until the daemon closes the connection.
+func daemonCall(root string, req daemonRequest, handle func(daemonMessage) error) error {
+	conn, err := net.DialTimeout("unix", daemonSocketPath(root), time.Second)
+	if err != nil {
+		return fmt.Errorf("no local-ci daemon running for %s (start one with `local-ci daemon`)", root)
+	}
+	defer conn.Close()
+
+	data, _ := json.Marshal(req)
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var m daemonMessage
+		if err := dec.Decode(&m); err != nil {
+			return nil // daemon closed the connection
+		}
+		if m.Type == "error" {
+			return fmt.Errorf("%s", m.Error)
+		}
+		if err := handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+// daemonAvailable reports whether a daemon is serving root.
+func daemonAvailable(root string) bool {
+	conn, err := net.DialTimeout("unix", daemonSocketPath(root), 200*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// daemonRun asks the daemon to run stages and collects their results.
+// Log lines are passed to onLog when it is non-nil.
+func daemonRun(root string, stages []string, noCache bool, onLog func(stage, line string)) (*PipelineReportJSON, error) {
+	var report *PipelineReportJSON
+	err := daemonCall(root, daemonRequest{Op: "run", Stages: stages, NoCache: noCache}, func(m daemonMessage) error {
+		switch m.Type {
+		case "log":
+			if onLog != nil {
+				onLog(m.Stage, m.Line)
+			}
+		case "done":
+			report = m.Report
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, fmt.Errorf("daemon closed the connection before the run finished")
+	}
+	return report, nil
+}
+
+// cmdDaemon implements `local-ci daemon [start|status|run|cancel|logs|stop]`.
+// It returns the process exit code.
+func cmdDaemon(root string, args []string, noCache, verbose, jsonOut bool) int {
+	sub := "start"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "start":
+		d, err := NewDaemon(root)
+		if err != nil {
+			errorf("%v\n", err)
+			return 1
+		}
+		d.Verbose = verbose
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := d.Serve(ctx); err != nil {
+			errorf("Daemon error: %v\n", err)
+			return 1
+		}
+		return 0
+
+	case "status":
+		var st *daemonStatus
+		err := daemonCall(root, daemonRequest{Op: "status"}, func(m daemonMessage) error {
+			st = m.Status
+			return nil
+		})
+		if err != nil || st == nil {
+			errorf("%v\n", err)
+			return 1
+		}
+		if jsonOut {
+			data, _ := json.MarshalIndent(st, "", "  ")
+			fmt.Println(string(data))
+			return 0
+		}
+		printf("🛰  Daemon pid %d serving %s (up %s)\n", st.PID, st.Root, time.Since(st.StartedAt).Round(time.Second))
+		printf("  Indexed files: %d\n", st.IndexedFiles)
+		if st.Running {
+			printf("  Running: %s (current: %s)\n", strings.Join(st.Stages, ", "), st.CurrentStage)
+		} else {
+			printf("  Idle\n")
+		}
+		if st.Queued > 0 {
+			printf("  Queued runs: %d\n", st.Queued)
+		}
+		if st.LastRun != nil {
+			printf("  Last run: %d passed, %d failed in %dms\n", st.LastRun.Passed, st.LastRun.Failed, st.LastRun.DurationMS)
+		}
+		return 0
+
+	case "run":
+		return runViaDaemon(root, args, noCache, verbose, jsonOut)
+
+	case "cancel", "stop":
+		if err := daemonCall(root, daemonRequest{Op: sub}, func(daemonMessage) error { return nil }); err != nil {
+			errorf("%v\n", err)
+			return 1
+		}
+		return 0
+
+	case "logs":
+		follow := false
+		for _, a := range args {
+			if a == "-f" || a == "--follow" {
+				follow = true
+			}
+		}
+		err := daemonCall(root, daemonRequest{Op: "logs", Follow: follow}, func(m daemonMessage) error {
+			fmt.Printf("[%s] %s\n", m.Stage, m.Line)
+			return nil
+		})
+		if err != nil {
+			errorf("%v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	errorf("Unknown daemon command %q (expected start, status, run, cancel, logs or stop)\n", sub)
+	return 1
+}
+
+// daemonRunFlags are the flags a run through the daemon honors. Any other
+// flag needs something the daemon doesn't do, so the run stays in-process.
+var daemonRunFlags = map[string]bool{"no-cache": true, "verbose": true, "json": true, "color": true, "groups": true}
+
+// runViaDaemon runs stages through the daemon serving root, printing
+// results as they stream in, and returns the exit code.
+func runViaDaemon(root string, stages []string, noCache, verbose, jsonOut bool) int {
+	var report *PipelineReportJSON
+	err := daemonCall(root, daemonRequest{Op: "run", Stages: stages, NoCache: noCache}, func(m daemonMessage) error {
+		switch m.Type {
+		case "log":
+			if verbose {
+				printf("[%s] %s\n", m.Stage, m.Line)
+			}
+		case "warning":
+			warnf("%s", m.Line)
+		case "result":
+			r := m.Result
+			switch {
+			case r.CacheHit:
+				printf("✓ %s (cached)\n", r.Name)
+			case r.Status == "pass":
+				printf("✓ %s (%dms)\n", r.Name, r.DurationMS)
+			case r.SkipReason != "":
+				printf("- %s (skipped: %s)\n", r.Name, r.SkipReason)
+			case r.Status == "skip":
+				printf("- %s (skipped)\n", r.Name)
+			default:
+				if !verbose && r.Output != "" {
+					printf("%s\n", r.Output)
+				}
+				printf("✗ %s (failed)\n", r.Name)
+			}
+		case "done":
+			report = m.Report
+		}
+		return nil
+	})
+	if err != nil {
+		errorf("%v\n", err)
+		return 1
+	}
+	if report == nil {
+		errorf("Daemon closed the connection before the run finished\n")
+		return 1
+	}
+	if jsonOut {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(os.Stdout, string(data))
+	}
+	if report.Failed > 0 {
+		errorf("❌ %d/%d stages failed\n", report.Failed, len(report.Results)-report.Skipped)
+		return 1
+	}
+	successf("✅ All %d stage(s) passed in %dms\n", len(report.Results)-report.Skipped, report.DurationMS)
+	return 0
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestDaemon runs a daemon for a temp workspace with the given stages
+// and waits until its socket accepts connections.
+func startTestDaemon(t *testing.T, stagesToml string) string {
+	t.Helper()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "go.mod"), []byte("module x\n"), 0o644)
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(stagesToml), 0o644)
+
+	d, err := NewDaemon(root)
+	if err != nil {
+		t.Fatalf("NewDaemon: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !daemonAvailable(root) {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon did not start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return root
+}
+
+func TestDaemonRunStreamsLogsAndResults(t *testing.T) {
+	root := startTestDaemon(t, `
+[stages.hello]
+command = ["sh", "-c", "echo one; echo two"]
+enabled = true
+`)
+	var lines []string
+	report, err := daemonRun(root, []string{"hello"}, false, func(stage, line string) {
+		lines = append(lines, stage+":"+line)
+	})
+	if err != nil {
+		t.Fatalf("daemonRun: %v", err)
+	}
+	if report.Passed != 1 || report.Failed != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if strings.Join(lines, ",") != "hello:one,hello:two" {
+		t.Fatalf("unexpected streamed logs: %v", lines)
+	}
+
+	// Second run hits the cache the daemon wrote.
+	report, err = daemonRun(root, []string{"hello"}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Results[0].CacheHit {
+		t.Fatal("expected second run to be served from cache")
+	}
+}
+
+func TestDaemonSerializesRuns(t *testing.T) {
+	root := startTestDaemon(t, `
+[stages.lock]
+command = ["sh", "-c", "set -C; echo $$ > held || exit 1; sleep 0.2; rm held"]
+enabled = true
+`)
+	var wg sync.WaitGroup
+	errs := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report, err := daemonRun(root, []string{"lock"}, true, nil)
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			if report.Failed > 0 {
+				errs <- "overlapping runs: " + report.Results[0].Output
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+func TestDaemonStatusAndCancel(t *testing.T) {
+	root := startTestDaemon(t, `
+[stages.slow]
+command = ["sleep", "10"]
+timeout = 30
+enabled = true
+
+[stages.after]
+command = ["true"]
+enabled = true
+`)
+	done := make(chan *PipelineReportJSON, 1)
+	go func() {
+		report, _ := daemonRun(root, []string{"slow", "after"}, true, nil)
+		done <- report
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var st *daemonStatus
+		daemonCall(root, daemonRequest{Op: "status"}, func(m daemonMessage) error {
+			st = m.Status
+			return nil
+		})
+		if st != nil && st.Running && st.CurrentStage == "slow" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("daemon never reported the running stage")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := daemonCall(root, daemonRequest{Op: "cancel"}, func(daemonMessage) error { return nil }); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	select {
+	case report := <-done:
+		if report == nil || report.Passed != 0 {
+			t.Fatalf("cancelled run should not pass: %+v", report)
+		}
+		if after := report.Results[1]; after.Status != "skip" || after.SkipReason != "run cancelled" {
+			t.Errorf("stage after the cancel = %+v", after)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel did not stop the run")
+	}
+}
+
+func TestDaemonLogsReplay(t *testing.T) {
+	root := startTestDaemon(t, `
+[stages.hello]
+command = ["echo", "from-log"]
+enabled = true
+`)
+	if _, err := daemonRun(root, nil, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	daemonCall(root, daemonRequest{Op: "logs"}, func(m daemonMessage) error {
+		got = append(got, m.Line)
+		return nil
+	})
+	if len(got) != 1 || got[0] != "from-log" {
+		t.Fatalf("expected replayed log line, got %v", got)
+	}
+}
+
+func TestDaemonSocketPathFallsBackForLongRoots(t *testing.T) {
+	short := daemonSocketPath("/repo")
+	if short != "/repo/.local-ci/daemon.sock" {
+		t.Fatalf("unexpected short path %s", short)
+	}
+	long := daemonSocketPath("/" + strings.Repeat("x", 120))
+	if len(long) >= 100 || !strings.HasSuffix(long, ".sock") {
+		t.Fatalf("long root should fall back to a short socket path, got %s", long)
+	}
+}
+
+func TestDaemonCallWithoutDaemon(t *testing.T) {
+	if daemonAvailable(t.TempDir()) {
+		t.Fatal("no daemon should be reachable in an empty dir")
+	}
+	if _, err := daemonRun(t.TempDir(), nil, false, nil); err == nil {
+		t.Fatal("expected an error without a daemon")
+	}
+}
+
+func TestRunViaDaemonExitCode(t *testing.T) {
+	root := startTestDaemon(t, `
+[stages.ok]
+command = ["true"]
+enabled = true
+
+[stages.broken]
+command = ["false"]
+enabled = true
+`)
+	if code := runViaDaemon(root, []string{"ok"}, true, false, false); code != 0 {
+		t.Errorf("passing run exit code = %d", code)
+	}
+	if code := runViaDaemon(root, []string{"ok", "broken"}, true, false, false); code != 1 {
+		t.Errorf("failing run exit code = %d", code)
+	}
+	if code := runViaDaemon(t.TempDir(), nil, true, false, false); code != 1 {
+		t.Errorf("run without a daemon exit code = %d", code)
+	}
+}
+
+func TestDaemonRunChecksTools(t *testing.T) {
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "ruff"), []byte("#!/bin/sh\necho ruff 0.4.1\n"), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	root := startTestDaemon(t, `
+[tools]
+ruff = ">=0.5"
+
+[stages.lint]
+command = ["ruff", "check", "."]
+enabled = true
+
+[stages.deploy]
+command = ["local-ci-test-missing-tool"]
+enabled = true
+`)
+	_, err := daemonRun(root, []string{"lint"}, true, nil)
+	if err == nil || !strings.Contains(err.Error(), "Tool versions don't match [tools]") {
+		t.Errorf("mis-pinned tool error = %v", err)
+	}
+	_, err = daemonRun(root, []string{"deploy"}, true, nil)
+	if err == nil || !strings.Contains(err.Error(), "Missing tools") || !strings.Contains(err.Error(), "local-ci-test-missing-tool") {
+		t.Errorf("missing tool error = %v", err)
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// DoctorReport is everything local-ci infers about a project and the
+// machine it runs on, as printed by `local-ci doctor`.
+type DoctorReport struct {
+	Version   string          `json:"version"`
+	Root      string          `json:"root"`
+	Project   DoctorProject   `json:"project"`
+	Workspace DoctorWorkspace `json:"workspace"`
+	Platform  DoctorPlatform  `json:"platform"`
+	Nix       DoctorNix       `json:"nix"`
+	Tools     []DoctorTool    `json:"tools"`
+	Config    DoctorConfig    `json:"config"`
+	Cache     DoctorCache     `json:"cache"`
+	Hook      DoctorHook      `json:"pre_commit_hook"`
+	Problems  []string        `json:"problems"`
+}
+
+// DoctorProject is the detected ProjectType (default stages, cache
+// patterns) and ProjectKind (workspace layout, tool hints), with the file
+// that decided each.
+type DoctorProject struct {
+	Type       ProjectType `json:"type"`
+	TypeReason string      `json:"type_reason"`
+	Kind       ProjectKind `json:"kind"`
+	KindReason string      `json:"kind_reason"`
+}
+
+type DoctorWorkspace struct {
+	Members       []string `json:"members"`
+	Excludes      []string `json:"excludes,omitempty"`       // from the project's own manifest
+	ConfigExclude []string `json:"config_exclude,omitempty"` // [workspace] exclude
+	Single        bool     `json:"single"`
+	Error         string   `json:"error,omitempty"`
+}
+
+type DoctorPlatform struct {
+	Name  Platform `json:"name"`
+	GOOS  string   `json:"goos"`
+	Arch  string   `json:"arch"`
+	NixOS bool     `json:"nixos"`
+	WSL   bool     `json:"wsl"`
+}
+
+type DoctorNix struct {
+	Installed    bool     `json:"installed"`
+	Version      string   `json:"version,omitempty"`
+	Substituters []string `json:"substituters,omitempty"`
+	Missing      []string `json:"missing_caches,omitempty"` // recommended caches not configured
+}
+
+// DoctorTool is a known tool, a tool an enabled stage runs, or a pinned
+// tool. PinOK is nil when the tool isn't pinned.
+type DoctorTool struct {
+	Name      string   `json:"name"`
+	Found     bool     `json:"found"`
+	Version   string   `json:"version,omitempty"`
+	Pin       string   `json:"pin,omitempty"`
+	PinSource string   `json:"pin_source,omitempty"`
+	PinOK     *bool    `json:"pin_ok,omitempty"`
+	Stages    []string `json:"stages,omitempty"` // enabled stages that run it
+	Install   string   `json:"install,omitempty"`
+}
+
+type DoctorConfig struct {
+	Files     []DoctorFile    `json:"files"`
+	Error     string          `json:"error,omitempty"`
+	Effective *DoctorSettings `json:"effective,omitempty"`
+}
+
+type DoctorFile struct {
+	Path   string `json:"path"`
+	Loaded bool   `json:"loaded"`
+}
+
+// DoctorSettings are the merged config values runs use: defaults for the
+// project type, .local-ci.toml and .local-ci-remote.toml.
+type DoctorSettings struct {
+	SkipDirs        []string          `json:"skip_dirs"`
+	IncludePatterns []string          `json:"include_patterns"`
+	Stages          []DoctorStage     `json:"stages"`
+	Profiles        []string          `json:"profiles,omitempty"`
+	Hosts           []string          `json:"hosts,omitempty"`
+	Container       string            `json:"container,omitempty"`
+	NixShell        string            `json:"nix_shell,omitempty"`
+	Sandbox         bool              `json:"sandbox,omitempty"`
+	Tools           map[string]string `json:"tools,omitempty"`
+}
+
+type DoctorStage struct {
+	Name      string   `json:"name"`
+	Command   string   `json:"command"`
+	Enabled   bool     `json:"enabled"`
+	Timeout   int      `json:"timeout"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	Runs      string   `json:"runs,omitempty"` // container, nix shell or sandbox it runs in
+}
+
+type DoctorCache struct {
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	Size    int64  `json:"size_bytes"`
+	Entries int    `json:"entries"`
+}
+
+type DoctorHook struct {
+	Path       string `json:"path,omitempty"`
+	Status     string `json:"status"` // "installed", "other" (a hook without local-ci), "missing", "no_git"
+	Executable bool   `json:"executable,omitempty"`
+}
+
+// cmdDoctor implements `local-ci doctor [--json]`. It exits non-zero when
+// it finds a problem: a config that doesn't load, a tool an enabled stage
+// needs that isn't installed, or a [tools] pin that isn't met.
+func cmdDoctor(root string, args []string, jsonOut bool) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	jsonFlag := fs.Bool("json", jsonOut, "Output JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report := buildDoctorReport(root)
+	if *jsonFlag {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		printDoctorReport(report)
+	}
+	if len(report.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// buildDoctorReport gathers the report for the project at root.
+func buildDoctorReport(root string) DoctorReport {
+	platform := DetectPlatform()
+	r := DoctorReport{
+		Version:  version,
+		Root:     root,
+		Platform: DoctorPlatform{Name: platform, GOOS: runtime.GOOS, Arch: runtime.GOARCH, NixOS: platform.IsNixOS(), WSL: platform.IsWSL()},
+		Problems: []string{},
+	}
+
+	r.Project.Type = DetectProjectType(root)
+	r.Project.TypeReason = projectTypeReason(root, r.Project.Type)
+	r.Project.Kind = DetectProjectKind(root)
+	r.Project.KindReason = projectKindReason(root, r.Project.Kind)
+
+	if ws, err := DetectWorkspace(root); err != nil {
+		r.Workspace.Error = err.Error()
+		r.Problems = append(r.Problems, "workspace: "+err.Error())
+	} else {
+		r.Workspace.Members, r.Workspace.Excludes, r.Workspace.Single = ws.Members, ws.Excludes, ws.IsSingle
+	}
+
+	r.Nix = doctorNix()
+
+	for _, name := range []string{".local-ci.toml", ".local-ci-remote.toml"} {
+		r.Config.Files = append(r.Config.Files, DoctorFile{Path: name, Loaded: fileExistsAt(filepath.Join(root, name))})
+	}
+	cfg, err := LoadConfig(root, true)
+	if err != nil {
+		r.Config.Error = err.Error()
+		r.Problems = append(r.Problems, "config: "+err.Error())
+	} else {
+		r.Workspace.ConfigExclude = cfg.Workspace.Exclude
+		r.Config.Effective = doctorSettings(cfg)
+	}
+
+	var pins []toolPin
+	if cfg != nil {
+		if pins, err = loadToolPins(root, cfg.Tools); err != nil {
+			r.Problems = append(r.Problems, err.Error())
+		}
+	}
+	for _, name := range []string{"rust-toolchain.toml", "rust-toolchain", ".nvmrc", ".tool-versions"} {
+		if fileExistsAt(filepath.Join(root, name)) {
+			r.Config.Files = append(r.Config.Files, DoctorFile{Path: name, Loaded: true})
+		}
+	}
+	r.Tools = doctorTools(cfg, pins, platform, newConditionEnv(root))
+	for _, t := range r.Tools {
+		switch {
+		case !t.Found && len(t.Stages) > 0:
+			r.Problems = append(r.Problems, fmt.Sprintf("%s is not installed (needed by %s)", t.Name, strings.Join(t.Stages, ", ")))
+		case t.PinOK != nil && !*t.PinOK && t.PinSource == "[tools]":
+			r.Problems = append(r.Problems, fmt.Sprintf("%s %s does not satisfy [tools] %s", t.Name, t.Version, t.Pin))
+		}
+	}
+
+	r.Cache = doctorCache(root)
+	r.Hook = doctorHook(root)
+	return r
+}
+
+// projectTypeReason names the file DetectProjectType decided on.
+func projectTypeReason(root string, t ProjectType) string {
+	markers := map[ProjectType][]string{
+		ProjectTypeRust:       {"Cargo.toml"},
+		ProjectTypeTypeScript: {"package.json"},
+		ProjectTypeSwift:      {"Package.swift", "*.xcodeproj", "*.xcworkspace"},
+		ProjectTypePython:     {"pyproject.toml", "setup.py", "requirements.txt"},
+		ProjectTypeGo:         {"go.mod"},
+		ProjectTypeJava:       {"pom.xml", "build.gradle"},
+	}
+	for _, marker := range markers[t] {
+		if matches, _ := filepath.Glob(filepath.Join(root, marker)); len(matches) > 0 {
+			return filepath.Base(matches[0])
+		}
+	}
+	return "no project files found"
+}
+
+// projectKindReason names the files DetectProjectKind decided on.
+func projectKindReason(root string, k ProjectKind) string {
+	switch k {
+	case ProjectKindRust:
+		return "Cargo.toml"
+	case ProjectKindTypeScript:
+		for _, indicator := range []string{"tsconfig.json", "bunfig.toml", "bun.lock", "bun.lockb"} {
+			if fileExistsAt(filepath.Join(root, indicator)) {
+				return "package.json + " + indicator
+			}
+		}
+	case ProjectKindSwift:
+		return projectTypeReason(root, ProjectTypeSwift)
+	}
+	if fileExistsAt(filepath.Join(root, "package.json")) {
+		return "package.json without tsconfig.json, bunfig.toml or bun.lock"
+	}
+	return "no Cargo.toml, package.json or Swift project"
+}
+
+func doctorNix() DoctorNix {
+	var n DoctorNix
+	if out, err := exec.Command("nix", "--version").Output(); err == nil {
+		n.Installed = true
+		n.Version = versionPattern.FindString(string(out))
+	}
+	n.Substituters, _ = GetInstalledCaches()
+	n.Substituters = dedupeStrings(n.Substituters)
+	if n.Installed {
+		for _, c := range DefaultNixCaches {
+			if !cacheListContains(n.Substituters, c.URL) {
+				n.Missing = append(n.Missing, c.URL)
+			}
+		}
+	}
+	return n
+}
+
+func doctorSettings(cfg *Config) *DoctorSettings {
+	s := &DoctorSettings{
+		SkipDirs:        cfg.Cache.SkipDirs,
+		IncludePatterns: cfg.Cache.IncludePatterns,
+		Container:       cfg.Container,
+		NixShell:        cfg.nixShell(),
+		Sandbox:         cfg.Sandbox,
+		Tools:           cfg.Tools,
+	}
+	for _, name := range cfg.GetAllStages() {
+		st := cfg.Stages[name]
+		ds := DoctorStage{Name: name, Command: strings.Join(st.Cmd, " "), Enabled: st.Enabled, Timeout: st.Timeout, DependsOn: st.DependsOn}
+		switch {
+		case st.Container != "":
+			ds.Runs = "container " + st.Container
+		case st.NixShell != "":
+			ds.Runs = "nix " + st.NixShell
+		case st.Sandbox:
+			ds.Runs = "sandbox"
+		}
+		s.Stages = append(s.Stages, ds)
+	}
+	for name := range cfg.Profiles {
+		s.Profiles = append(s.Profiles, name)
+	}
+	sort.Strings(s.Profiles)
+	for _, h := range cfg.ListRemoteHosts() {
+		s.Hosts = append(s.Hosts, h.Name)
+	}
+	return s
+}
+
+// doctorTools reports the tools CheckAllTools knows, the tools enabled
+// stages run directly (not in a container or Nix shell), and pinned tools.
+// Stages whose conditions are unmet would be skipped, so they need nothing,
+// as in preflightStages.
+func doctorTools(cfg *Config, pins []toolPin, platform Platform, conditions *conditionEnv) []DoctorTool {
+	byName := make(map[string]*DoctorTool)
+	get := func(name string) *DoctorTool {
+		t, ok := byName[name]
+		if !ok {
+			t = &DoctorTool{Name: name}
+			byName[name] = t
+		}
+		return t
+	}
+
+	probes := versionProbes{}
+	checks := CheckAllTools()
+	for name, check := range checks {
+		get(name).Found = check.Found
+	}
+	if cfg != nil {
+		for _, name := range cfg.GetEnabledStages() {
+			s := cfg.Stages[name]
+			if s.Container != "" || s.NixShell != "" || conditions.check(s, false) != "" {
+				continue
+			}
+			for _, tool := range stageTools(s) {
+				t := get(tool)
+				t.Stages = append(t.Stages, name)
+			}
+		}
+	}
+	pinned := make(map[string]toolPin, len(pins))
+	for _, p := range pins {
+		pinned[p.Tool] = p
+		get(p.Tool)
+	}
+
+	tools := make([]DoctorTool, 0, len(byName))
+	for name, t := range byName {
+		pin, isPinned := pinned[name]
+		if _, checked := checks[name]; !checked {
+			t.Found = doctorToolFound(name, probes)
+		}
+		if t.Found {
+			t.Version = probes.toolVersion(name, isPinned)
+		} else {
+			t.Install = installCommand(name, platform)
+		}
+		if isPinned {
+			t.Pin, t.PinSource = pin.Constraint, pin.Source
+			c, _ := parseVersionConstraint(pin.Constraint)
+			ok := t.Version != "" && c.matches(t.Version)
+			t.PinOK = &ok
+		}
+		tools = append(tools, *t)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// doctorToolFound looks a tool up by its command: a cargo subcommand
+// tool such as clippy through cargo, anything else on PATH.
+func doctorToolFound(name string, probes versionProbes) bool {
+	command := name
+	if tool := versionTool(name); tool != nil {
+		if tool.Command == "cargo" && len(tool.CheckArgs) > 1 {
+			return probes.toolVersion(name, false) != ""
+		}
+		command = tool.Command
+	}
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+func doctorCache(root string) DoctorCache {
+	c := DoctorCache{Path: filepath.Join(root, ".local-ci-cache")}
+	if info, err := os.Stat(c.Path); err == nil {
+		c.Exists, c.Size = true, info.Size()
+		cache, _ := loadCache(root)
+		c.Entries = len(cache)
+	}
+	return c
+}
+
+// doctorHook finds the pre-commit hook git would run, honoring worktrees
+// and core.hooksPath, and whether it runs local-ci.
+func doctorHook(root string) DoctorHook {
+	cmd := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-path", "hooks/pre-commit")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		if !isFileExists(filepath.Join(root, ".git")) {
+			return DoctorHook{Status: "no_git"}
+		}
+		out = []byte(filepath.Join(root, ".git", "hooks", "pre-commit"))
+	}
+	h := DoctorHook{Path: strings.TrimSpace(string(out)), Status: "missing"}
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return h
+	}
+	h.Executable = info.Mode()&0o111 != 0
+	h.Status = "other"
+	if data, err := os.ReadFile(h.Path); err == nil && strings.Contains(string(data), "local-ci") {
+		h.Status = "installed"
+	}
+	return h
+}
+
+// printDoctorReport prints the report for humans.
+func printDoctorReport(r DoctorReport) {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	orNone := func(list []string) string {
+		if len(list) == 0 {
+			return "none"
+		}
+		return strings.Join(list, ", ")
+	}
+
+	printf("🩺 local-ci v%s doctor for: %s\n\n", r.Version, r.Root)
+
+	printf("Project:\n")
+	printf("  Type: %s (%s)\n", r.Project.Type, r.Project.TypeReason)
+	printf("  Kind: %s (%s)\n\n", r.Project.Kind, r.Project.KindReason)
+
+	printf("Workspace:\n")
+	if r.Workspace.Error != "" {
+		printf("  Error: %s\n\n", r.Workspace.Error)
+	} else {
+		printf("  Members: %s\n", orNone(r.Workspace.Members))
+		printf("  Excludes: %s\n", orNone(r.Workspace.Excludes))
+		printf("  [workspace] exclude: %s\n\n", orNone(r.Workspace.ConfigExclude))
+	}
+
+	printf("Platform: %s (%s/%s, NixOS: %s, WSL: %s)\n\n", r.Platform.Name, r.Platform.GOOS, r.Platform.Arch, yesNo(r.Platform.NixOS), yesNo(r.Platform.WSL))
+
+	printf("Nix:\n")
+	if r.Nix.Installed {
+		printf("  Installed: yes (%s)\n", r.Nix.Version)
+	} else {
+		printf("  Installed: no\n")
+	}
+	printf("  Substituters: %s\n", orNone(r.Nix.Substituters))
+	if len(r.Nix.Missing) > 0 {
+		printf("  Not configured: %s (see Nix Cache Configuration in the README)\n", strings.Join(r.Nix.Missing, ", "))
+	}
+	printf("\n")
+
+	rows := [][]string{{"TOOL", "STATUS", "VERSION", "PIN", "USED BY"}}
+	for _, t := range r.Tools {
+		status := "ok"
+		switch {
+		case !t.Found:
+			status = "missing"
+		case t.PinOK != nil && !*t.PinOK:
+			status = "pin mismatch"
+		}
+		pin := t.Pin
+		if pin != "" {
+			pin += " (" + t.PinSource + ")"
+		}
+		rows = append(rows, []string{t.Name, status, t.Version, pin, strings.Join(t.Stages, ", ")})
+	}
+	printf("Tools:\n%s\n", indent(formatTable(rows), "  "))
+
+	printf("Config:\n")
+	for _, f := range r.Config.Files {
+		state := "not found"
+		if f.Loaded {
+			state = "loaded"
+		}
+		printf("  %s: %s\n", f.Path, state)
+	}
+	if r.Config.Error != "" {
+		printf("  Error: %s\n", r.Config.Error)
+	}
+	if e := r.Config.Effective; e != nil {
+		printf("  Skip dirs: %s\n", orNone(e.SkipDirs))
+		printf("  Include patterns: %s\n", orNone(e.IncludePatterns))
+		if e.Container != "" {
+			printf("  Container: %s\n", e.Container)
+		}
+		if e.NixShell != "" {
+			printf("  Nix shell: %s\n", e.NixShell)
+		}
+		if e.Sandbox {
+			printf("  Sandbox: yes\n")
+		}
+		if len(e.Profiles) > 0 {
+			printf("  Profiles: %s\n", strings.Join(e.Profiles, ", "))
+		}
+		if len(e.Hosts) > 0 {
+			printf("  Remote hosts: %s\n", strings.Join(e.Hosts, ", "))
+		}
+		rows := [][]string{{"STAGE", "ENABLED", "COMMAND", "RUNS IN"}}
+		for _, s := range e.Stages {
+			rows = append(rows, []string{s.Name, yesNo(s.Enabled), s.Command, s.Runs})
+		}
+		printf("  Stages:\n%s", indent(formatTable(rows), "    "))
+	}
+	printf("\n")
+
+	if r.Cache.Exists {
+		printf("Cache: %s (%s, %d entries)\n", r.Cache.Path, formatSize(r.Cache.Size), r.Cache.Entries)
+	} else {
+		printf("Cache: %s (not created yet)\n", r.Cache.Path)
+	}
+
+	switch r.Hook.Status {
+	case "installed":
+		printf("Pre-commit hook: installed (%s)\n", r.Hook.Path)
+		if !r.Hook.Executable {
+			warnf("  ⚠️  the hook is not executable, so git won't run it\n")
+		}
+	case "other":
+		printf("Pre-commit hook: %s doesn't run local-ci\n", r.Hook.Path)
+	case "missing":
+		printf("Pre-commit hook: not installed (local-ci init adds one)\n")
+	default:
+		printf("Pre-commit hook: not a git repository\n")
+	}
+	printf("\n")
+
+	if len(r.Problems) == 0 {
+		successf("✅ No problems found\n")
+		return
+	}
+	errorf("❌ Problems:\n")
+	for _, p := range r.Problems {
+		errorf("  - %s\n", p)
+	}
+}
+
+// indent prefixes every non-empty line of s.
+func indent(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
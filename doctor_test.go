@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildDoctorReport(t *testing.T) {
+	root, bin := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", bin)
+	t.Setenv("HOME", t.TempDir())
+	os.WriteFile(filepath.Join(bin, "cargo"), []byte("#!/bin/sh\n[ \"$1\" = --version ] && echo cargo 1.80.0\n"), 0o755)
+
+	os.WriteFile(filepath.Join(root, "Cargo.toml"), []byte("[workspace]\nmembers = [\"crates/*\"]\nexclude = [\"crates/old\"]\n"), 0o644)
+	for _, dir := range []string{"crates/a", "crates/old", ".git/hooks"} {
+		os.MkdirAll(filepath.Join(root, dir), 0o755)
+	}
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(`[tools]
+rustc = "1.80"
+
+[stages.test]
+command = ["cargo", "test"]
+enabled = true
+
+[stages.deny]
+command = ["cargo", "deny", "check"]
+enabled = false
+
+[stages.release]
+command = ["goreleaser", "release"]
+enabled = true
+if_env = ["LOCAL_CI_DOCTOR_RELEASE"]
+`), 0o644)
+	os.WriteFile(filepath.Join(root, ".local-ci-cache"), []byte("fmt:abc|cargo fmt\ntest:abc|cargo test\n"), 0o644)
+	os.WriteFile(filepath.Join(root, ".git", "hooks", "pre-commit"), []byte("#!/bin/sh\nlocal-ci fmt\n"), 0o644)
+
+	r := buildDoctorReport(root)
+
+	if r.Project.Type != ProjectTypeRust || r.Project.TypeReason != "Cargo.toml" || r.Project.KindReason != "Cargo.toml" {
+		t.Errorf("project = %+v", r.Project)
+	}
+	if r.Workspace.Single || len(r.Workspace.Members) != 2 || len(r.Workspace.Excludes) != 1 {
+		t.Errorf("workspace = %+v", r.Workspace)
+	}
+	if !r.Config.Files[0].Loaded || r.Config.Files[1].Loaded || r.Config.Effective == nil {
+		t.Errorf("config = %+v", r.Config)
+	}
+	if r.Cache.Entries != 2 || !r.Cache.Exists {
+		t.Errorf("cache = %+v", r.Cache)
+	}
+	if r.Hook.Status != "installed" || r.Hook.Executable {
+		t.Errorf("hook = %+v", r.Hook)
+	}
+
+	tools := make(map[string]DoctorTool)
+	for _, tool := range r.Tools {
+		tools[tool.Name] = tool
+	}
+	// The default fmt and clippy stages run cargo too.
+	if c := tools["cargo"]; !c.Found || c.Version != "1.80.0" || strings.Join(c.Stages, ",") != "fmt,clippy,test" {
+		t.Errorf("cargo = %+v", c)
+	}
+	// rustc is pinned and used by the test stage, but not installed.
+	if rc := tools["rustc"]; rc.Found || rc.Pin != "1.80" || rc.PinOK == nil || *rc.PinOK {
+		t.Errorf("rustc = %+v", rc)
+	}
+	// The deny stage is disabled, so cargo-deny is reported but not a problem.
+	if d := tools["cargo-deny"]; d.Found || len(d.Stages) != 0 || d.Install == "" {
+		t.Errorf("cargo-deny = %+v", d)
+	}
+	// The release stage's if_env is unmet, so goreleaser isn't needed.
+	if g := tools["goreleaser"]; len(g.Stages) != 0 {
+		t.Errorf("goreleaser = %+v", g)
+	}
+	want := []string{
+		"clippy is not installed (needed by clippy)",
+		"rustc is not installed (needed by fmt, clippy, test)",
+		"rustfmt is not installed (needed by fmt)",
+	}
+	if got := strings.Join(r.Problems, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"type_reason":"Cargo.toml"`, `"pre_commit_hook":{`, `"effective":{`, `"entries":2`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("JSON missing %s", key)
+		}
+	}
+}
+
+func TestDoctorHookStatus(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	root := t.TempDir()
+	if h := doctorHook(root); h.Status != "no_git" {
+		t.Errorf("no .git: %+v", h)
+	}
+	os.MkdirAll(filepath.Join(root, ".git", "hooks"), 0o755)
+	if h := doctorHook(root); h.Status != "missing" {
+		t.Errorf("no hook: %+v", h)
+	}
+	os.WriteFile(filepath.Join(root, ".git", "hooks", "pre-commit"), []byte("#!/bin/sh\nmake lint\n"), 0o755)
+	if h := doctorHook(root); h.Status != "other" || !h.Executable {
+		t.Errorf("other hook: %+v", h)
+	}
+}
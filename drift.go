@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DriftEntry is one difference between a workflow and the local stages.
+type DriftEntry struct {
+	Stage    string `json:"stage"`
+	Kind     string `json:"kind"` // missing | changed | local_only
+	Workflow string `json:"workflow,omitempty"`
+	Local    string `json:"local,omitempty"`
+}
+
+// DriftReport compares a GitHub Actions workflow with .local-ci.toml.
+type DriftReport struct {
+	Workflow string       `json:"workflow"`
+	InSync   bool         `json:"in_sync"`
+	Entries  []DriftEntry `json:"entries"`
+}
+
+// computeDrift matches imported workflow stages against local stages. A
+// workflow stage is in sync when a local stage with the same name runs the
+// same command in the same directory, or when any local stage runs the same
+// command. Enabled local stages that nothing in the workflow runs are
+// reported too.
+func computeDrift(imported []Stage, local map[string]Stage) []DriftEntry {
+	byCommand := make(map[string]string)
+	for name, s := range local {
+		byCommand[stageSignature(s)] = name
+	}
+
+	matched := make(map[string]bool)
+	entries := []DriftEntry{}
+	for _, s := range imported {
+		sig := stageSignature(s)
+		if ls, ok := local[s.Name]; ok {
+			matched[s.Name] = true
+			if stageSignature(ls) != sig {
+				entries = append(entries, DriftEntry{Stage: s.Name, Kind: "changed", Workflow: sig, Local: stageSignature(ls)})
+			}
+			continue
+		}
+		if other, ok := byCommand[sig]; ok {
+			matched[other] = true
+			continue
+		}
+		entries = append(entries, DriftEntry{Stage: s.Name, Kind: "missing", Workflow: sig})
+	}
+
+	var localOnly []string
+	for name, s := range local {
+		if s.Enabled && !matched[name] {
+			localOnly = append(localOnly, name)
+		}
+	}
+	sort.Strings(localOnly)
+	for _, name := range localOnly {
+		entries = append(entries, DriftEntry{Stage: name, Kind: "local_only", Local: stageSignature(local[name])})
+	}
+	return entries
+}
+
+// stageSignature is the comparable form of what a stage runs.
+func stageSignature(s Stage) string {
+	sig := strings.Join(strings.Fields(strings.Join(s.Cmd, " ")), " ")
+	if s.Dir != "" && s.Dir != "." {
+		sig = "(in " + filepath.ToSlash(s.Dir) + ") " + sig
+	}
+	return sig
+}
+
+// cmdDrift implements `local-ci drift [workflow]`. It exits non-zero when
+// the workflow and the local stages have diverged.
+func cmdDrift(root string, args []string, jsonOut bool) int {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	path, err := findWorkflow(root, name)
+	if err != nil {
+		errorf("%v\n", err)
+		return 2
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errorf("Failed to read %s: %v\n", path, err)
+		return 2
+	}
+	res, err := ImportGitHubWorkflow(data)
+	if err != nil {
+		errorf("%s: %v\n", path, err)
+		return 2
+	}
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		errorf("%v\n", err)
+		return 2
+	}
+
+	rel, _ := filepath.Rel(root, path)
+	report := DriftReport{Workflow: filepath.ToSlash(rel), Entries: computeDrift(res.Stages, cfg.Stages)}
+	report.InSync = len(report.Entries) == 0
+
+	if jsonOut {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else if report.InSync {
+		successf("✅ .local-ci.toml is in sync with %s\n", report.Workflow)
+	} else {
+		printf("Drift between %s and .local-ci.toml:\n\n", report.Workflow)
+		for _, e := range report.Entries {
+			switch e.Kind {
+			case "missing":
+				printf("  + %s (only in workflow)\n      %s\n", e.Stage, e.Workflow)
+			case "changed":
+				printf("  ~ %s\n      workflow: %s\n      local:    %s\n", e.Stage, e.Workflow, e.Local)
+			case "local_only":
+				printf("  - %s (only in .local-ci.toml)\n      %s\n", e.Stage, e.Local)
+			}
+		}
+		printf("\n💡 Run `local-ci import github-actions %s` to add missing stages\n", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+	if !report.InSync {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeDrift(t *testing.T) {
+	imported := []Stage{
+		{Name: "fmt", Cmd: []string{"cargo", "fmt", "--all", "--", "--check"}},
+		{Name: "lint", Cmd: []string{"cargo", "clippy"}},
+		{Name: "test", Cmd: []string{"cargo", "test", "--workspace"}},
+		{Name: "docs", Cmd: []string{"cargo", "doc"}},
+	}
+	local := map[string]Stage{
+		"fmt":    {Name: "fmt", Cmd: []string{"cargo", "fmt", "--all", "--", "--check"}, Enabled: true},
+		"clippy": {Name: "clippy", Cmd: []string{"cargo", "clippy"}, Enabled: true},
+		"test":   {Name: "test", Cmd: []string{"cargo", "test"}, Enabled: true},
+		"audit":  {Name: "audit", Cmd: []string{"cargo", "audit"}, Enabled: true},
+		"deny":   {Name: "deny", Cmd: []string{"cargo", "deny"}, Enabled: false},
+	}
+
+	entries := computeDrift(imported, local)
+	got := make(map[string]string)
+	for _, e := range entries {
+		got[e.Stage] = e.Kind
+	}
+	want := map[string]string{"test": "changed", "docs": "missing", "audit": "local_only"}
+	if len(got) != len(want) {
+		t.Fatalf("drift entries = %+v, want kinds %v", entries, want)
+	}
+	for stage, kind := range want {
+		if got[stage] != kind {
+			t.Errorf("%s: kind %q, want %q", stage, got[stage], kind)
+		}
+	}
+}
+
+func TestComputeDriftComparesDirectory(t *testing.T) {
+	imported := []Stage{{Name: "test", Cmd: []string{"go", "test"}, Dir: "sub"}}
+	local := map[string]Stage{"test": {Name: "test", Cmd: []string{"go", "test"}, Enabled: true}}
+	entries := computeDrift(imported, local)
+	if len(entries) != 1 || entries[0].Kind != "changed" {
+		t.Errorf("a different working directory should count as drift: %+v", entries)
+	}
+}
+
+func TestCmdDriftExitCodes(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, ".github", "workflows")
+	os.MkdirAll(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, "ci.yml"), []byte(`jobs:
+  build:
+    steps:
+      - run: make build
+`), 0o644)
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(`[stages.build]
+command = ["make", "build"]
+enabled = true
+`), 0o644)
+
+	if code := cmdDrift(root, nil, true); code != 0 {
+		t.Errorf("matching config should be in sync, exit %d", code)
+	}
+
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(`[stages.build]
+command = ["make", "all"]
+enabled = true
+`), 0o644)
+	if code := cmdDrift(root, nil, true); code != 1 {
+		t.Errorf("changed command should report drift, exit %d", code)
+	}
+}
@@ -9,37 +9,98 @@ import (
 
 // DryRunStage represents a single stage in dry-run output
 type DryRunStage struct {
-	Name     string `json:"name"`
-	Command  string `json:"command"`
-	WouldRun bool   `json:"would_run"`
-	Reason   string `json:"reason"` // "cached", "hash_changed", "disabled", "no_cache_flag"
+	Name     string              `json:"name"`
+	Command  string              `json:"command"`
+	WouldRun bool                `json:"would_run"`
+	Reason   string              `json:"reason"`            // "cached", "hash_changed", "disabled", "no_cache_flag", "condition"
+	Targets  []DryRunCacheTarget `json:"targets,omitempty"` // per-target cache state for remote runs
+
+	SkipReason string `json:"skip_reason,omitempty"` // the unmet condition, with reason "condition"
+
+	Container string   `json:"container,omitempty"` // image the stage runs in
+	ImageID   string   `json:"image_id,omitempty"`  // empty when the image isn't pulled yet
+	NixShell  string   `json:"nix_shell,omitempty"` // flake devShell the stage runs in
+	NixKey    string   `json:"nix_key,omitempty"`   // devShell inputs hash in the cache key
+	Tools     string   `json:"tools,omitempty"`     // tool@version list in the cache key
+	Sandbox   bool     `json:"sandbox,omitempty"`
+	Outputs   []string `json:"outputs,omitempty"` // paths a sandboxed stage may write
+
+	CPUs   int    `json:"cpus,omitempty"`
+	Memory int64  `json:"memory_bytes,omitempty"`
+	Lock   string `json:"lock,omitempty"`
+
+	resources string // formatted hints for the human report
+}
+
+// DryRunSchedule is the --parallel plan: which stages would start together.
+type DryRunSchedule struct {
+	Concurrency int            `json:"concurrency"`
+	Budget      resourceBudget `json:"budget"`
+	Waves       [][]string     `json:"waves"`
+}
+
+// DryRunCacheTarget is a stage's cache state on one execution target.
+type DryRunCacheTarget struct {
+	Target string `json:"target"` // "local" or a remote cache namespace
+	Cached bool   `json:"cached"`
 }
 
 // DryRunRemote describes a remote SSH+tmux target when --remote is active.
 type DryRunRemote struct {
-	Host       string `json:"host"`
-	Session    string `json:"session"`
-	WorkDir    string `json:"work_dir"`
-	HostPreset string `json:"host_preset,omitempty"`
+	Host        string `json:"host"`
+	Session     string `json:"session"`
+	WorkDir     string `json:"work_dir"`
+	HostPreset  string `json:"host_preset,omitempty"`
+	CacheTarget string `json:"cache_target,omitempty"`
 }
 
 // DryRunReport represents the overall dry-run output
 type DryRunReport struct {
-	Workspace  string        `json:"workspace"`
-	SourceHash string        `json:"source_hash"`
-	Remote     *DryRunRemote `json:"remote,omitempty"`
-	Stages     []DryRunStage `json:"stages"`
+	Workspace  string          `json:"workspace"`
+	SourceHash string          `json:"source_hash"`
+	Remote     *DryRunRemote   `json:"remote,omitempty"`
+	Pool       []DryRunRemote  `json:"pool,omitempty"` // --remote-hosts / --matrix targets
+	Stages     []DryRunStage   `json:"stages"`
+	Schedule   *DryRunSchedule `json:"schedule,omitempty"` // local --parallel runs
 }
 
-// BuildDryRunReport creates a dry-run report for the given stages
-func BuildDryRunReport(stages []Stage, cache map[string]string, stageHashes map[string]string, sourceHash string, noCache bool, remote *DryRunRemote) DryRunReport {
+// AddSchedule plans the stages that would run against slots and budget.
+func (r *DryRunReport) AddSchedule(stages []Stage, slots int, budget resourceBudget) {
+	wouldRun := make(map[string]bool, len(r.Stages))
+	for _, s := range r.Stages {
+		wouldRun[s.Name] = s.WouldRun
+	}
+	var planned []Stage
+	for _, s := range stages {
+		if wouldRun[s.Name] {
+			planned = append(planned, s)
+		}
+	}
+	r.Schedule = &DryRunSchedule{Concurrency: slots, Budget: budget, Waves: planSchedule(planned, slots, budget)}
+}
+
+// BuildDryRunReport creates a dry-run report for the given stages. With
+// remote cache targets, a stage would run unless one of them has it cached,
+// and each stage lists its cache state locally and on every target.
+func BuildDryRunReport(stages []Stage, cache map[string]string, stageHashes map[string]string, sourceHash string, noCache bool, remote *DryRunRemote, targets []string) DryRunReport {
 	workspace, _ := os.Getwd()
 
 	var dryRunStages []DryRunStage
 	for _, stage := range stages {
 		dryRunStage := DryRunStage{
-			Name:    stage.Name,
-			Command: strings.Join(stage.Cmd, " "),
+			Name:      stage.Name,
+			Command:   strings.Join(stage.Cmd, " "),
+			Container: stage.Container,
+			ImageID:   stage.ImageID,
+			NixShell:  stage.NixShell,
+			NixKey:    stage.NixKey,
+			Tools:     stage.ToolVersions,
+			Sandbox:   stage.Sandbox,
+			Outputs:   stage.Outputs,
+			CPUs:      stage.CPUs,
+			Memory:    stage.Memory,
+			Lock:      stage.Lock,
+			resources: formatStageResources(stage),
 		}
 
 		hash := sourceHash
@@ -52,16 +113,26 @@ func BuildDryRunReport(stages []Stage, cache map[string]string, stageHashes map[
 		if !stage.Enabled {
 			dryRunStage.WouldRun = false
 			dryRunStage.Reason = "disabled"
+		} else if stage.SkipReason != "" {
+			dryRunStage.WouldRun = false
+			dryRunStage.Reason = "condition"
+			dryRunStage.SkipReason = stage.SkipReason
 		} else if noCache {
 			dryRunStage.WouldRun = true
 			dryRunStage.Reason = "no_cache_flag"
-		} else if cacheHit(cache, stage, hash) {
+		} else if cacheHitAny(cache, stage, hash, targets) {
 			dryRunStage.WouldRun = false
 			dryRunStage.Reason = "cached"
 		} else {
 			dryRunStage.WouldRun = true
 			dryRunStage.Reason = "hash_changed"
 		}
+		if len(targets) > 0 {
+			dryRunStage.Targets = append(dryRunStage.Targets, DryRunCacheTarget{Target: "local", Cached: cacheHit(cache, stage, hash)})
+			for _, target := range targets {
+				dryRunStage.Targets = append(dryRunStage.Targets, DryRunCacheTarget{Target: target, Cached: cacheHitOn(cache, stage, hash, target)})
+			}
+		}
 
 		dryRunStages = append(dryRunStages, dryRunStage)
 	}
@@ -82,26 +153,70 @@ func PrintDryRunJSON(report DryRunReport) {
 
 // PrintDryRunHuman prints dry-run report in human-readable format
 func PrintDryRunHuman(report DryRunReport) {
-	fmt.Printf("📋 Dry-run report for: %s\n", report.Workspace)
-	fmt.Printf("   Source hash: %s\n", report.SourceHash)
+	printf("📋 Dry-run report for: %s\n", report.Workspace)
+	printf("   Source hash: %s\n", report.SourceHash)
 	if report.Remote != nil {
 		line := fmt.Sprintf("   Remote: %s (session=%s, work_dir=%s)", report.Remote.Host, report.Remote.Session, report.Remote.WorkDir)
 		if report.Remote.HostPreset != "" {
 			line += fmt.Sprintf(" [preset=%s]", report.Remote.HostPreset)
 		}
-		fmt.Println(line)
+		if report.Remote.CacheTarget != "" {
+			line += fmt.Sprintf(" [cache=%s]", report.Remote.CacheTarget)
+		}
+		printf("%s\n", line)
 	}
-	fmt.Println()
+	for _, r := range report.Pool {
+		printf("   Remote target: %s (session=%s, work_dir=%s) [preset=%s] [cache=%s]\n", r.Host, r.Session, r.WorkDir, r.HostPreset, r.CacheTarget)
+	}
+	printf("\n")
 
-	fmt.Println("Stages:")
+	printf("Stages:\n")
 	for _, stage := range report.Stages {
 		status := "✗"
 		if stage.WouldRun {
 			status = "✓"
 		}
-		fmt.Printf("  %s %s\n", status, stage.Name)
-		fmt.Printf("      Command: %s\n", stage.Command)
-		fmt.Printf("      Reason: %s\n", stage.Reason)
+		printf("  %s %s\n", status, stage.Name)
+		printf("      Command: %s\n", stage.Command)
+		if stage.Container != "" {
+			image := "not pulled"
+			if stage.ImageID != "" {
+				image = shortImageID(stage.ImageID)
+			}
+			printf("      Container: %s (%s)\n", stage.Container, image)
+		}
+		if stage.NixShell != "" {
+			printf("      Nix shell: %s (inputs %s)\n", stage.NixShell, stage.NixKey)
+		}
+		if stage.Tools != "" {
+			printf("      Tools: %s\n", strings.ReplaceAll(stage.Tools, ",", ", "))
+		}
+		if stage.Sandbox {
+			outputs := "none"
+			if len(stage.Outputs) > 0 {
+				outputs = strings.Join(stage.Outputs, ", ")
+			}
+			printf("      Sandbox: writable %s\n", outputs)
+		}
+		if stage.resources != "" {
+			printf("      Resources: %s\n", stage.resources)
+		}
+		if stage.SkipReason != "" {
+			printf("      Reason: %s (%s)\n", stage.Reason, stage.SkipReason)
+		} else {
+			printf("      Reason: %s\n", stage.Reason)
+		}
+		if len(stage.Targets) > 0 {
+			parts := make([]string, len(stage.Targets))
+			for i, t := range stage.Targets {
+				state := "miss"
+				if t.Cached {
+					state = "cached"
+				}
+				parts[i] = t.Target + " " + state
+			}
+			printf("      Cache: %s\n", strings.Join(parts, ", "))
+		}
 	}
 
 	wouldRun := 0
@@ -110,5 +225,18 @@ func PrintDryRunHuman(report DryRunReport) {
 			wouldRun++
 		}
 	}
-	fmt.Printf("\n📊 Summary: %d/%d stages would run\n", wouldRun, len(report.Stages))
+	if sched := report.Schedule; sched != nil {
+		limits := []string{fmt.Sprintf("%d at a time", sched.Concurrency)}
+		if sched.Budget.CPUs > 0 {
+			limits = append(limits, fmt.Sprintf("%d cores", sched.Budget.CPUs))
+		}
+		if sched.Budget.Memory > 0 {
+			limits = append(limits, formatSize(sched.Budget.Memory)+" available")
+		}
+		printf("\nPlan (%s):\n", strings.Join(limits, ", "))
+		for i, wave := range sched.Waves {
+			printf("  %d. %s\n", i+1, strings.Join(wave, ", "))
+		}
+	}
+	printf("\n📊 Summary: %d/%d stages would run\n", wouldRun, len(report.Stages))
 }
@@ -15,7 +15,7 @@ func TestBuildDryRunReportAllCached(t *testing.T) {
 		"test": "hash1",
 	}
 
-	report := BuildDryRunReport(stages, cache, nil, "hash1", false, nil)
+	report := BuildDryRunReport(stages, cache, nil, "hash1", false, nil, nil)
 
 	for _, s := range report.Stages {
 		if s.WouldRun {
@@ -38,7 +38,7 @@ func TestBuildDryRunReportHashChanged(t *testing.T) {
 		"test": "oldhash",
 	}
 
-	report := BuildDryRunReport(stages, cache, nil, "newhash", false, nil)
+	report := BuildDryRunReport(stages, cache, nil, "newhash", false, nil, nil)
 
 	for _, s := range report.Stages {
 		if !s.WouldRun {
@@ -59,7 +59,7 @@ func TestBuildDryRunReportNoCache(t *testing.T) {
 		"fmt": "hash1",
 	}
 
-	report := BuildDryRunReport(stages, cache, nil, "hash1", true, nil)
+	report := BuildDryRunReport(stages, cache, nil, "hash1", true, nil, nil)
 
 	if len(report.Stages) != 1 {
 		t.Fatalf("expected 1 stage, got %d", len(report.Stages))
@@ -78,7 +78,7 @@ func TestBuildDryRunReportDisabledStages(t *testing.T) {
 		{Name: "deny", Cmd: []string{"cargo", "deny"}, Enabled: false},
 	}
 
-	report := BuildDryRunReport(stages, nil, nil, "hash1", true, nil)
+	report := BuildDryRunReport(stages, nil, nil, "hash1", true, nil, nil)
 
 	disabledCount := 0
 	for _, s := range report.Stages {
@@ -106,7 +106,7 @@ func TestBuildDryRunReportMixedStates(t *testing.T) {
 		// test not cached
 	}
 
-	report := BuildDryRunReport(stages, cache, nil, "hash1", false, nil)
+	report := BuildDryRunReport(stages, cache, nil, "hash1", false, nil, nil)
 
 	if len(report.Stages) != 3 {
 		t.Errorf("expected 3 stages, got %d", len(report.Stages))
@@ -114,7 +114,7 @@ func TestBuildDryRunReportMixedStates(t *testing.T) {
 }
 
 func TestBuildDryRunReportSourceHash(t *testing.T) {
-	report := BuildDryRunReport(nil, nil, nil, "abc123def", false, nil)
+	report := BuildDryRunReport(nil, nil, nil, "abc123def", false, nil, nil)
 
 	if report.SourceHash != "abc123def" {
 		t.Errorf("expected source hash 'abc123def', got %q", report.SourceHash)
@@ -122,7 +122,7 @@ func TestBuildDryRunReportSourceHash(t *testing.T) {
 }
 
 func TestBuildDryRunReportEmptyStages(t *testing.T) {
-	report := BuildDryRunReport(nil, nil, nil, "hash", false, nil)
+	report := BuildDryRunReport(nil, nil, nil, "hash", false, nil, nil)
 
 	if len(report.Stages) != 0 {
 		t.Errorf("expected 0 stages, got %d", len(report.Stages))
@@ -136,8 +136,35 @@ func TestBuildDryRunReportRemoteTarget(t *testing.T) {
 		WorkDir:    "/tmp/local-ci",
 		HostPreset: "discovery",
 	}
-	report := BuildDryRunReport(nil, nil, nil, "hash", false, remote)
+	report := BuildDryRunReport(nil, nil, nil, "hash", false, remote, nil)
 	if report.Remote == nil || report.Remote.Host != "aivcs@discovery" {
 		t.Fatalf("expected remote target in report: %+v", report.Remote)
 	}
 }
+
+func TestBuildDryRunReportPerTargetCache(t *testing.T) {
+	stages := []Stage{
+		{Name: "fmt", Cmd: []string{"cargo", "fmt"}, Enabled: true},
+		{Name: "test", Cmd: []string{"cargo", "test"}, Enabled: true},
+	}
+	cache := map[string]string{
+		"fmt":                     cacheKeyForStage(stages[0], "hash"),
+		"test@sparky/linux_spark": cacheKeyForStage(stages[1], "hash"),
+	}
+	report := BuildDryRunReport(stages, cache, nil, "hash", false, nil, []string{"sparky/linux_spark"})
+
+	fmtStage, testStage := report.Stages[0], report.Stages[1]
+	if !fmtStage.WouldRun || fmtStage.Reason != "hash_changed" {
+		t.Errorf("fmt is only cached locally, so it should run remotely: %+v", fmtStage)
+	}
+	if testStage.WouldRun || testStage.Reason != "cached" {
+		t.Errorf("test is cached on the target: %+v", testStage)
+	}
+	want := []DryRunCacheTarget{{Target: "local", Cached: false}, {Target: "sparky/linux_spark", Cached: true}}
+	if len(testStage.Targets) != 2 || testStage.Targets[0] != want[0] || testStage.Targets[1] != want[1] {
+		t.Errorf("test targets = %+v, want %+v", testStage.Targets, want)
+	}
+	if local := BuildDryRunReport(stages, cache, nil, "hash", false, nil, nil); local.Stages[0].Targets != nil {
+		t.Error("local dry-runs should not list targets")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultStageTimeout applies to stages that don't set `timeout`.
+const defaultStageTimeout = 30 * time.Second
+
+// runLocalStage executes a stage's command in dir and returns its Result.
+// The run is bounded by the stage timeout and by ctx, so callers such as
+// watch mode can cancel an in-flight stage. When live is non-nil, output is
+// also streamed to it as the command produces it. Cache handling is left to
+// the caller.
+func runLocalStage(ctx context.Context, stage Stage, dir string, live io.Writer) Result {
+	start := time.Now()
+	result := Result{
+		Name:    stage.Name,
+		Command: strings.Join(stage.Cmd, " "),
+		Status:  "fail",
+	}
+
+	if len(stage.Cmd) == 0 {
+		result.Error = fmt.Errorf("no command defined")
+		return result
+	}
+
+	timeout := time.Duration(stage.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultStageTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd, check, err := stageCommand(ctx, stage, dir)
+	if err != nil {
+		result.Duration = time.Since(start)
+		result.Error = err
+		return result
+	}
+	var out bytes.Buffer
+	var sink io.Writer = &out
+	if live != nil {
+		sink = io.MultiWriter(&out, live)
+	}
+	cmd.Stdout = sink
+	cmd.Stderr = sink
+
+	err = cmd.Run()
+	result.Duration = time.Since(start)
+	result.Output = out.String()
+	if check != nil {
+		result.UndeclaredWrites = check()
+	}
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	if len(result.UndeclaredWrites) > 0 {
+		result.Error = undeclaredWritesError(result.UndeclaredWrites)
+		return result
+	}
+	if result.Artifacts, err = collectLocalArtifacts(dir, stage); err != nil {
+		result.Error = fmt.Errorf("failed to collect artifacts: %w", err)
+		return result
+	}
+	result.Status = "pass"
+	return result
+}
+
+// stageCommand builds the command for a stage run from the project root
+// dir: directly in the stage's directory, inside its container, or with its
+// Nix devShell environment. A container takes precedence over a shell.
+// Sandboxed stages are wrapped in bubblewrap; check, when non-nil, runs
+// after the command exits and returns the stage's undeclared writes.
+func stageCommand(ctx context.Context, stage Stage, dir string) (cmd *exec.Cmd, check func() []string, err error) {
+	switch {
+	case stage.Container != "":
+		cmd, err := containerCommand(ctx, stage, dir)
+		return cmd, nil, err
+	case stage.NixShell != "":
+		cmd, err = nixCommand(ctx, stage, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		cmd = exec.CommandContext(ctx, stage.Cmd[0], stage.Cmd[1:]...)
+		cmd.Dir = dir
+		if stage.Dir != "" {
+			cmd.Dir = filepath.Join(dir, stage.Dir)
+		}
+		if len(stage.Env) > 0 {
+			cmd.Env = append(os.Environ(), stageEnv(stage)...)
+		}
+	}
+	if stage.Sandbox {
+		return sandboxCommand(ctx, cmd, stage, dir)
+	}
+	return cmd, nil, nil
+}
+
+// resolveStageBackends fills in the parts of stages' cache keys that live
+// outside the source tree: image IDs, devShell inputs and tool versions.
+// pull=false never downloads an image. probes is shared by the whole run,
+// so each tool is probed once however many stages use it.
+func resolveStageBackends(stages []Stage, root string, pull bool, probes versionProbes) error {
+	// The same inputs that decide when the captured environment is stale.
+	for i := range stages {
+		if stages[i].NixShell != "" {
+			stages[i].NixKey = nixEnvKey(root, stages[i].NixShell)
+		}
+	}
+	resolveStageToolVersions(stages, probes)
+	return resolveContainerImages(stages, pull)
+}
+
+// stageEnv returns the stage's extra environment as sorted KEY=value pairs.
+func stageEnv(stage Stage) []string {
+	env := make([]string, 0, len(stage.Env))
+	for k, v := range stage.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
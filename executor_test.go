@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunLocalStageEnvAndDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stage := Stage{
+		Name:    "env",
+		Cmd:     []string{"sh", "-c", "echo $GREETING; basename $(pwd)"},
+		Timeout: 10,
+		Env:     map[string]string{"GREETING": "hello"},
+		Dir:     "sub",
+	}
+	result := runLocalStage(context.Background(), stage, root, nil)
+	if result.Status != "pass" {
+		t.Fatalf("expected pass, got %s (%v)", result.Status, result.Error)
+	}
+	if got := strings.Fields(result.Output); len(got) != 2 || got[0] != "hello" || got[1] != "sub" {
+		t.Errorf("unexpected output %q", result.Output)
+	}
+}
+
+func TestRunLocalStageCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := runLocalStage(ctx, Stage{Name: "slow", Cmd: []string{"sleep", "5"}}, t.TempDir(), nil)
+	if result.Status != "fail" {
+		t.Errorf("cancelled stage should fail, got %s", result.Status)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exportHeader marks files written by `local-ci export`; only such files
+// are overwritten without --force.
+const exportHeader = "# Generated by `local-ci export %s` from .local-ci.toml. Do not edit;\n# change .local-ci.toml and re-run the export instead.\n"
+
+// exportFile is one generated pipeline file.
+type exportFile struct {
+	Path    string // relative to the project root
+	Content string
+}
+
+// exportPipeline is a set of stages that becomes one workflow: the enabled
+// stages, or the stages of a profile.
+type exportPipeline struct {
+	Name   string // "" for the default pipeline, else the profile name
+	Stages []Stage
+}
+
+// exportPipelines returns the default pipeline followed by one pipeline
+// per profile (sorted by name). depends_on entries outside a pipeline are
+// dropped, since the job they refer to doesn't exist there.
+func exportPipelines(cfg *Config) []exportPipeline {
+	pipelines := []exportPipeline{{Stages: cfg.SelectStages(nil)}}
+
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var stages []Stage
+		for _, s := range cfg.Profiles[name].Stages {
+			for _, n := range cfg.StagesNamed(s) {
+				stages = append(stages, cfg.Stages[n])
+			}
+		}
+		if len(stages) > 0 {
+			pipelines = append(pipelines, exportPipeline{Name: name, Stages: stages})
+		}
+	}
+
+	for i := range pipelines {
+		included := make(map[string]bool)
+		for _, s := range pipelines[i].Stages {
+			included[s.Name] = true
+		}
+		for j, s := range pipelines[i].Stages {
+			var deps []string
+			for _, d := range s.DependsOn {
+				if included[d] {
+					deps = append(deps, d)
+				}
+			}
+			pipelines[i].Stages[j].DependsOn = deps
+		}
+	}
+	return pipelines
+}
+
+// stageScript renders a stage command as shell source. Commands that are
+// already `bash -c <script>` (as produced by import) export their script.
+func stageScript(cmd []string) string {
+	if n := len(cmd); n >= 3 && (cmd[0] == "bash" || cmd[0] == "sh") && cmd[n-2] == "-c" {
+		return cmd[n-1]
+	}
+	return joinShellCommand(cmd)
+}
+
+func timeoutMinutes(seconds int) int {
+	return (seconds + 59) / 60
+}
+
+type ghExportWorkflow struct {
+	Name string                 `yaml:"name"`
+	On   map[string]interface{} `yaml:"on"`
+	Jobs yaml.Node              `yaml:"jobs"`
+}
+
+type ghExportJob struct {
+	Name           string            `yaml:"name,omitempty"`
+	RunsOn         string            `yaml:"runs-on"`
+	Needs          []string          `yaml:"needs,omitempty"`
+	Strategy       *ghExportStrategy `yaml:"strategy,omitempty"`
+	TimeoutMinutes int               `yaml:"timeout-minutes,omitempty"`
+	Env            map[string]string `yaml:"env,omitempty"`
+	Steps          []ghExportStep    `yaml:"steps"`
+}
+
+type ghExportStrategy struct {
+	FailFast bool `yaml:"fail-fast"`
+	Matrix   struct {
+		Include []map[string]string `yaml:"include"`
+	} `yaml:"matrix"`
+}
+
+type ghExportStep struct {
+	Name             string            `yaml:"name,omitempty"`
+	Uses             string            `yaml:"uses,omitempty"`
+	With             map[string]string `yaml:"with,omitempty"`
+	Run              string            `yaml:"run,omitempty"`
+	WorkingDirectory string            `yaml:"working-directory,omitempty"`
+}
+
+// cargoSubcommandTools maps cargo subcommands that aren't part of rustup's
+// toolchain to the crate that provides them.
+var cargoSubcommandTools = map[string]string{
+	"nextest": "cargo-nextest",
+	"deny":    "cargo-deny",
+	"audit":   "cargo-audit",
+	"machete": "cargo-machete",
+}
+
+// githubSetupSteps installs the toolchain for the project type (plus any
+// cargo subcommand the stage runs) so the exported job runs the same
+// command as local-ci.
+func githubSetupSteps(pt ProjectType, s Stage) []ghExportStep {
+	switch pt {
+	case ProjectTypeRust:
+		steps := []ghExportStep{{Uses: "dtolnay/rust-toolchain@stable", With: map[string]string{"components": "rustfmt, clippy"}}}
+		if len(s.Cmd) > 1 && s.Cmd[0] == "cargo" {
+			if tool, ok := cargoSubcommandTools[s.Cmd[1]]; ok {
+				steps = append(steps, ghExportStep{Uses: "taiki-e/install-action@v2", With: map[string]string{"tool": tool}})
+			}
+		}
+		return steps
+	case ProjectTypeGo:
+		return []ghExportStep{{Uses: "actions/setup-go@v5", With: map[string]string{"go-version-file": "go.mod"}}}
+	case ProjectTypeTypeScript:
+		return []ghExportStep{{Uses: "oven-sh/setup-bun@v2"}, {Run: "bun install"}}
+	case ProjectTypePython:
+		return []ghExportStep{{Uses: "actions/setup-python@v5", With: map[string]string{"python-version": "3.x"}}}
+	case ProjectTypeJava:
+		return []ghExportStep{{Uses: "actions/setup-java@v4", With: map[string]string{"distribution": "temurin", "java-version": "21"}}}
+	}
+	return nil
+}
+
+// exportGitHub renders one GitHub Actions workflow per pipeline.
+func exportGitHub(cfg *Config, pt ProjectType) ([]exportFile, error) {
+	var files []exportFile
+	for _, p := range exportPipelines(cfg) {
+		wf := ghExportWorkflow{
+			Name: "local-ci",
+			On: map[string]interface{}{
+				"push":              map[string][]string{"branches": {"main"}},
+				"pull_request":      map[string]interface{}{},
+				"workflow_dispatch": map[string]interface{}{},
+			},
+			Jobs: yaml.Node{Kind: yaml.MappingNode},
+		}
+		path := filepath.Join(".github", "workflows", "local-ci.yml")
+		if p.Name != "" {
+			wf.Name = "local-ci (" + p.Name + ")"
+			path = filepath.Join(".github", "workflows", "local-ci-"+p.Name+".yml")
+		}
+
+		groups, ids := githubJobGroups(p.Stages)
+		for _, g := range groups {
+			job := githubJob(pt, g, ids)
+			var key, val yaml.Node
+			key.SetString(ids[g[0].Name])
+			if err := val.Encode(job); err != nil {
+				return nil, err
+			}
+			wf.Jobs.Content = append(wf.Jobs.Content, &key, &val)
+		}
+
+		content, err := marshalExport(wf, "github")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, exportFile{Path: path, Content: content})
+	}
+	return files, nil
+}
+
+// githubJobInvalid matches what GitHub doesn't allow in a job id, which
+// must match [A-Za-z_][A-Za-z0-9_-]*.
+var githubJobInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// githubJobID turns a stage name into a job id: disallowed characters
+// become "-", and an id that doesn't start with a letter gets a "_".
+func githubJobID(name string) string {
+	id := strings.Trim(githubJobInvalid.ReplaceAllString(name, "-"), "-")
+	if id == "" || !(id[0] == '_' || (id[0] >= 'A' && id[0] <= 'Z') || (id[0] >= 'a' && id[0] <= 'z')) {
+		id = "_" + id
+	}
+	return id
+}
+
+// githubJobGroups groups a pipeline's stages into jobs, in order: a matrix
+// stage's instances share one job, every other stage is its own. ids maps
+// each stage name to its (unique) job id.
+func githubJobGroups(stages []Stage) ([][]Stage, map[string]string) {
+	var groups [][]Stage
+	index := make(map[string]int)
+	ids := make(map[string]string)
+	used := make(map[string]bool)
+	for _, s := range stages {
+		base := s.Name
+		if s.MatrixOf != "" {
+			base = s.MatrixOf
+		}
+		if i, ok := index[base]; ok && s.MatrixOf != "" {
+			groups[i] = append(groups[i], s)
+			ids[s.Name] = ids[groups[i][0].Name]
+			continue
+		}
+		id := githubJobID(base)
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", githubJobID(base), n)
+		}
+		used[id] = true
+		index[base] = len(groups)
+		groups = append(groups, []Stage{s})
+		ids[s.Name] = id
+	}
+	return groups, ids
+}
+
+// githubJob renders one job. A matrix stage becomes a strategy.matrix job
+// whose include entries carry each instance's values, command, dir and
+// env, so GitHub runs the same commands local-ci does.
+func githubJob(pt ProjectType, group []Stage, ids map[string]string) ghExportJob {
+	s := group[0]
+	self := ids[s.Name]
+	var needs []string
+	for _, inst := range group {
+		for _, dep := range inst.DependsOn {
+			if id := ids[dep]; id != "" && id != self {
+				needs = append(needs, id)
+			}
+		}
+	}
+	job := ghExportJob{
+		RunsOn:         "ubuntu-latest",
+		Needs:          dedupeStrings(needs),
+		TimeoutMinutes: timeoutMinutes(s.Timeout),
+		Env:            s.Env,
+	}
+	job.Steps = append(job.Steps, ghExportStep{Uses: "actions/checkout@v4"})
+	job.Steps = append(job.Steps, githubSetupSteps(pt, s)...)
+	if s.MatrixOf == "" {
+		job.Steps = append(job.Steps, ghExportStep{Name: s.Name, Run: stageScript(s.Cmd), WorkingDirectory: s.Dir})
+		return job
+	}
+
+	// Env values that are the same in every instance stay literal.
+	envKeys := make(map[string]bool)
+	for _, inst := range group {
+		for k := range inst.Env {
+			envKeys[k] = true
+		}
+	}
+	job.Env = nil
+	varying := make(map[string]bool)
+	for k := range envKeys {
+		for _, inst := range group {
+			if v, ok := inst.Env[k]; !ok || v != s.Env[k] {
+				varying[k] = true
+			}
+		}
+		if job.Env == nil {
+			job.Env = make(map[string]string)
+		}
+		if varying[k] {
+			job.Env[k] = "${{ matrix.local_ci_env_" + k + " }}"
+		} else {
+			job.Env[k] = s.Env[k]
+		}
+	}
+
+	var axes []string
+	hasDir := false
+	job.Strategy = &ghExportStrategy{}
+	for _, inst := range group {
+		if inst.Timeout > s.Timeout {
+			job.TimeoutMinutes = timeoutMinutes(inst.Timeout)
+		}
+		entry := make(map[string]string, len(inst.MatrixValues)+2)
+		for k, v := range inst.MatrixValues {
+			entry[k] = v
+			axes = append(axes, k)
+		}
+		entry["local_ci_run"] = stageScript(inst.Cmd)
+		if inst.Dir != "" {
+			entry["local_ci_dir"] = inst.Dir
+			hasDir = true
+		}
+		for k := range varying {
+			entry["local_ci_env_"+k] = inst.Env[k]
+		}
+		job.Strategy.Matrix.Include = append(job.Strategy.Matrix.Include, entry)
+	}
+	axes = dedupeStrings(axes)
+	sort.Strings(axes)
+	labels := make([]string, len(axes))
+	for i, k := range axes {
+		labels[i] = k + "=${{ matrix." + k + " }}"
+	}
+	job.Name = s.MatrixOf + "[" + strings.Join(labels, ",") + "]"
+
+	step := ghExportStep{Name: s.MatrixOf, Run: "${{ matrix.local_ci_run }}"}
+	if hasDir {
+		step.WorkingDirectory = "${{ matrix.local_ci_dir || '.' }}"
+	}
+	job.Steps = append(job.Steps, step)
+	return job
+}
+
+type glExportJob struct {
+	Stage     string            `yaml:"stage"`
+	Needs     []string          `yaml:"needs"`
+	Timeout   string            `yaml:"timeout,omitempty"`
+	Variables map[string]string `yaml:"variables,omitempty"`
+	Script    []string          `yaml:"script"`
+}
+
+// gitlabReserved are top-level .gitlab-ci.yml keywords that can't be job names.
+var gitlabReserved = map[string]bool{
+	"default": true, "include": true, "stages": true, "variables": true, "workflow": true,
+	"image": true, "services": true, "cache": true, "before_script": true, "after_script": true,
+}
+
+func gitlabJobName(name string) string {
+	if gitlabReserved[name] {
+		return "stage-" + name
+	}
+	return name
+}
+
+func gitlabImage(pt ProjectType) string {
+	switch pt {
+	case ProjectTypeRust:
+		return "rust:latest"
+	case ProjectTypeGo:
+		return "golang:latest"
+	case ProjectTypeTypeScript:
+		return "oven/bun:latest"
+	case ProjectTypePython:
+		return "python:3"
+	case ProjectTypeJava:
+		return "eclipse-temurin:21"
+	}
+	return ""
+}
+
+// exportGitLab renders a GitLab CI pipeline per pipeline. Jobs share one
+// stage and are ordered by `needs`, so the DAG matches depends_on exactly.
+func exportGitLab(cfg *Config, pt ProjectType) ([]exportFile, error) {
+	var files []exportFile
+	for _, p := range exportPipelines(cfg) {
+		doc := yaml.Node{Kind: yaml.MappingNode}
+		addKey := func(name string, v interface{}) error {
+			var key, val yaml.Node
+			key.SetString(name)
+			if err := val.Encode(v); err != nil {
+				return err
+			}
+			doc.Content = append(doc.Content, &key, &val)
+			return nil
+		}
+		if err := addKey("stages", []string{"ci"}); err != nil {
+			return nil, err
+		}
+		if image := gitlabImage(pt); image != "" {
+			if err := addKey("default", map[string]string{"image": image}); err != nil {
+				return nil, err
+			}
+		}
+		for _, s := range p.Stages {
+			job := glExportJob{Stage: "ci", Needs: []string{}, Variables: s.Env}
+			for _, d := range s.DependsOn {
+				job.Needs = append(job.Needs, gitlabJobName(d))
+			}
+			if s.Timeout > 0 {
+				job.Timeout = fmt.Sprintf("%d minutes", timeoutMinutes(s.Timeout))
+			}
+			if s.Dir != "" {
+				job.Script = append(job.Script, "cd "+escapeShellArg(s.Dir))
+			}
+			job.Script = append(job.Script, stageScript(s.Cmd))
+			if err := addKey(gitlabJobName(s.Name), job); err != nil {
+				return nil, err
+			}
+		}
+
+		path := ".gitlab-ci.yml"
+		if p.Name != "" {
+			path = filepath.Join(".gitlab", "local-ci-"+p.Name+".yml")
+		}
+		content, err := marshalExport(&doc, "gitlab")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, exportFile{Path: path, Content: content})
+	}
+	return files, nil
+}
+
+func marshalExport(v interface{}, target string) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, exportHeader, target)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// cmdExport implements `local-ci export github|gitlab`.
+func cmdExport(root string, args []string) int {
+	if len(args) == 0 || (args[0] != "github" && args[0] != "gitlab") {
+		errorf("Usage: local-ci export github|gitlab [--stdout] [--force]\n")
+		return 2
+	}
+	target := args[0]
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	toStdout := fs.Bool("stdout", false, "Print the generated pipelines instead of writing them")
+	force := fs.Bool("force", false, "Overwrite files that were not generated by local-ci export")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		errorf("%v\n", err)
+		return 1
+	}
+	pt := DetectProjectType(root)
+	var files []exportFile
+	if target == "github" {
+		files, err = exportGitHub(cfg, pt)
+	} else {
+		files, err = exportGitLab(cfg, pt)
+	}
+	if err != nil {
+		errorf("Export failed: %v\n", err)
+		return 1
+	}
+
+	if *toStdout {
+		for i, f := range files {
+			if i > 0 {
+				fmt.Println("---")
+			}
+			fmt.Printf("# %s\n%s", filepath.ToSlash(f.Path), f.Content)
+		}
+		return 0
+	}
+
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		if existing, err := os.ReadFile(path); err == nil && !*force && !strings.HasPrefix(string(existing), "# Generated by `local-ci export") {
+			errorf("%s exists and was not generated by local-ci; use --force to overwrite\n", f.Path)
+			return 1
+		}
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			errorf("Failed to create %s: %v\n", filepath.Dir(f.Path), err)
+			return 1
+		}
+		if err := os.WriteFile(path, []byte(f.Content), 0644); err != nil {
+			errorf("Failed to write %s: %v\n", f.Path, err)
+			return 1
+		}
+		successf("✅ Wrote %s\n", filepath.ToSlash(f.Path))
+	}
+	if target == "gitlab" && len(files) > 1 {
+		printf("💡 Profile pipelines live under .gitlab/; point a pipeline's CI/CD configuration file at one, or `include:` it\n")
+	}
+	return 0
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func exportTestConfig() *Config {
+	return &Config{
+		Stages: map[string]Stage{
+			"fmt":   {Name: "fmt", Cmd: []string{"cargo", "fmt", "--check"}, Timeout: 120, Enabled: true},
+			"test":  {Name: "test", Cmd: []string{"cargo", "nextest", "run"}, Timeout: 1200, Enabled: true, DependsOn: []string{"fmt"}},
+			"core":  {Name: "core", Cmd: []string{"bash", "-eo", "pipefail", "-c", "make\nmake check"}, Timeout: 90, Enabled: true, Dir: "crates/core", Env: map[string]string{"RUST_LOG": "debug"}},
+			"audit": {Name: "audit", Cmd: []string{"cargo", "audit"}, Enabled: false},
+		},
+		Profiles: map[string]Profile{
+			"quick": {Stages: []string{"test"}},
+		},
+	}
+}
+
+func TestExportGitHub(t *testing.T) {
+	files, err := exportGitHub(exportTestConfig(), ProjectTypeRust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != filepath.Join(".github", "workflows", "local-ci.yml") || files[1].Path != filepath.Join(".github", "workflows", "local-ci-quick.yml") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if !strings.HasPrefix(files[0].Content, "# Generated by `local-ci export github`") {
+		t.Error("missing generated-file header")
+	}
+
+	var wf struct {
+		Jobs map[string]struct {
+			Needs          []string          `yaml:"needs"`
+			TimeoutMinutes int               `yaml:"timeout-minutes"`
+			Env            map[string]string `yaml:"env"`
+			Steps          []ghExportStep    `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal([]byte(files[0].Content), &wf); err != nil {
+		t.Fatalf("workflow does not parse: %v\n%s", err, files[0].Content)
+	}
+	if len(wf.Jobs) != 3 {
+		t.Fatalf("expected 3 jobs (disabled audit excluded), got %d", len(wf.Jobs))
+	}
+	test := wf.Jobs["test"]
+	if !reflect.DeepEqual(test.Needs, []string{"fmt"}) || test.TimeoutMinutes != 20 {
+		t.Errorf("unexpected test job: %+v", test)
+	}
+	last := test.Steps[len(test.Steps)-1]
+	if last.Run != "cargo nextest run" {
+		t.Errorf("test job runs %q", last.Run)
+	}
+	foundInstall := false
+	for _, step := range test.Steps {
+		if step.Uses == "taiki-e/install-action@v2" && step.With["tool"] == "cargo-nextest" {
+			foundInstall = true
+		}
+	}
+	if !foundInstall {
+		t.Errorf("nextest should be installed for the test job: %+v", test.Steps)
+	}
+
+	core := wf.Jobs["core"]
+	coreStep := core.Steps[len(core.Steps)-1]
+	if coreStep.Run != "make\nmake check" || coreStep.WorkingDirectory != "crates/core" || core.Env["RUST_LOG"] != "debug" || core.TimeoutMinutes != 2 {
+		t.Errorf("unexpected core job: %+v", core)
+	}
+
+	// The profile workflow only has its own stages, and drops needs on
+	// stages it doesn't include.
+	var quick struct {
+		Jobs map[string]struct {
+			Needs []string `yaml:"needs"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal([]byte(files[1].Content), &quick); err != nil {
+		t.Fatal(err)
+	}
+	if len(quick.Jobs) != 1 || len(quick.Jobs["test"].Needs) != 0 {
+		t.Errorf("unexpected profile workflow: %+v", quick.Jobs)
+	}
+}
+
+func TestExportGitHubMatrixStage(t *testing.T) {
+	cfg := loadMatrixConfig(t, `[stages.test]
+command = ["cargo", "test", "--features={matrix.features}"]
+env = { TOOLCHAIN = "{matrix.toolchain}", RUST_LOG = "debug" }
+matrix = { features = ["serde", "full"], toolchain = ["stable", "nightly"] }
+enabled = true
+
+[stages.report]
+command = ["true"]
+depends_on = ["test"]
+enabled = true
+
+[profiles.quick]
+stages = ["test[toolchain=stable]"]
+`)
+	files, err := exportGitHub(cfg, ProjectTypeRust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wf struct {
+		Jobs map[string]struct {
+			Name     string            `yaml:"name"`
+			Needs    []string          `yaml:"needs"`
+			Env      map[string]string `yaml:"env"`
+			Strategy struct {
+				FailFast bool `yaml:"fail-fast"`
+				Matrix   struct {
+					Include []map[string]string `yaml:"include"`
+				} `yaml:"matrix"`
+			} `yaml:"strategy"`
+			Steps []ghExportStep `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal([]byte(files[0].Content), &wf); err != nil {
+		t.Fatalf("workflow does not parse: %v\n%s", err, files[0].Content)
+	}
+	if len(wf.Jobs) != 2 {
+		t.Fatalf("expected the matrix stage as one job plus report, got %d:\n%s", len(wf.Jobs), files[0].Content)
+	}
+	test, ok := wf.Jobs["test"]
+	if !ok {
+		t.Fatalf("matrix job should be keyed by its stage name:\n%s", files[0].Content)
+	}
+	if test.Name != "test[features=${{ matrix.features }},toolchain=${{ matrix.toolchain }}]" || test.Strategy.FailFast {
+		t.Errorf("unexpected matrix job header: %+v", test)
+	}
+	if len(test.Strategy.Matrix.Include) != 4 {
+		t.Fatalf("expected 4 matrix entries, got %v", test.Strategy.Matrix.Include)
+	}
+	entry := test.Strategy.Matrix.Include[0]
+	if entry["features"] != "serde" || entry["local_ci_run"] != "cargo test --features=serde" || entry["local_ci_env_TOOLCHAIN"] != "stable" {
+		t.Errorf("unexpected matrix entry: %v", entry)
+	}
+	if test.Env["RUST_LOG"] != "debug" || test.Env["TOOLCHAIN"] != "${{ matrix.local_ci_env_TOOLCHAIN }}" {
+		t.Errorf("unexpected matrix job env: %v", test.Env)
+	}
+	if last := test.Steps[len(test.Steps)-1]; last.Run != "${{ matrix.local_ci_run }}" {
+		t.Errorf("matrix job runs %q", last.Run)
+	}
+	if report := wf.Jobs["report"]; !reflect.DeepEqual(report.Needs, []string{"test"}) {
+		t.Errorf("report should need the matrix job once, needs %v", report.Needs)
+	}
+
+	// A profile selecting some instances exports just those.
+	if len(files) != 2 {
+		t.Fatalf("expected the quick profile workflow, got %+v", files)
+	}
+	wf.Jobs = nil
+	if err := yaml.Unmarshal([]byte(files[1].Content), &wf); err != nil {
+		t.Fatal(err)
+	}
+	if got := wf.Jobs["test"].Strategy.Matrix.Include; len(got) != 2 {
+		t.Errorf("quick profile should have the two stable instances, got %v", got)
+	}
+}
+
+func TestGitHubJobID(t *testing.T) {
+	for name, want := range map[string]string{
+		"test":            "test",
+		"lint:go":         "lint-go",
+		"test[os=linux]":  "test-os-linux",
+		"2fast":           "_2fast",
+		"build_release-1": "build_release-1",
+	} {
+		if got := githubJobID(name); got != want {
+			t.Errorf("githubJobID(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExportGitLab(t *testing.T) {
+	cfg := exportTestConfig()
+	cfg.Stages["default"] = Stage{Name: "default", Cmd: []string{"make"}, Enabled: true, DependsOn: []string{"fmt"}}
+	files, err := exportGitLab(cfg, ProjectTypeRust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Path != ".gitlab-ci.yml" || files[1].Path != filepath.Join(".gitlab", "local-ci-quick.yml") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(files[0].Content), &doc); err != nil {
+		t.Fatalf("pipeline does not parse: %v", err)
+	}
+	if doc["default"].(map[string]interface{})["image"] != "rust:latest" {
+		t.Errorf("expected the rust image as default, got %v", doc["default"])
+	}
+	if _, ok := doc["stage-default"]; !ok {
+		t.Error("a stage named after a reserved keyword should be renamed")
+	}
+	core := doc["core"].(map[string]interface{})
+	if script := core["script"].([]interface{}); len(script) != 2 || script[0] != "cd crates/core" || script[1] != "make\nmake check" {
+		t.Errorf("unexpected core script: %v", script)
+	}
+	if core["timeout"] != "2 minutes" {
+		t.Errorf("unexpected timeout: %v", core["timeout"])
+	}
+	fmtJob := doc["fmt"].(map[string]interface{})
+	if needs := fmtJob["needs"].([]interface{}); len(needs) != 0 {
+		t.Errorf("fmt should start immediately, needs %v", needs)
+	}
+}
+
+func TestStageScript(t *testing.T) {
+	if got := stageScript([]string{"cargo", "test", "--features", "a b"}); got != "cargo test --features 'a b'" {
+		t.Errorf("stageScript argv = %q", got)
+	}
+	if got := stageScript([]string{"sh", "-c", "echo hi | wc -c"}); got != "echo hi | wc -c" {
+		t.Errorf("stageScript shell = %q", got)
+	}
+}
+
+func TestCmdExportRefusesToOverwriteHandWrittenFiles(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte("[stages.build]\ncommand = [\"make\"]\nenabled = true\n"), 0o644)
+	os.WriteFile(filepath.Join(root, ".gitlab-ci.yml"), []byte("build:\n  script: [make]\n"), 0o644)
+
+	if code := cmdExport(root, []string{"gitlab"}); code == 0 {
+		t.Fatal("export should refuse to overwrite a hand-written pipeline")
+	}
+	if code := cmdExport(root, []string{"gitlab", "--force"}); code != 0 {
+		t.Fatalf("--force export failed with %d", code)
+	}
+	// A generated file can be regenerated without --force.
+	if code := cmdExport(root, []string{"gitlab"}); code != 0 {
+		t.Fatalf("re-export failed with %d", code)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, ".gitlab-ci.yml"))
+	if !strings.Contains(string(data), "- make") {
+		t.Errorf("unexpected pipeline:\n%s", data)
+	}
+}
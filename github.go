@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAnnotationsPerStage caps annotations so one noisy stage can't bury the
+// rest; GitHub itself only shows the first few per step.
+const maxAnnotationsPerStage = 10
+
+// githubActions reports whether we're running inside a GitHub Actions job.
+func githubActions(getenv func(string) string) bool {
+	return getenv("GITHUB_ACTIONS") == "true"
+}
+
+// annotation is a source location extracted from a failing stage's output.
+type annotation struct {
+	Level   string // error | warning
+	File    string
+	Line    int
+	Col     int
+	Message string
+}
+
+var (
+	// rustc / clippy: "error[E0308]: mismatched types" followed by
+	// "  --> src/main.rs:10:5"
+	rustHeaderRe = regexp.MustCompile(`^(error|warning)(\[\w+\])?: (.+)$`)
+	rustArrowRe  = regexp.MustCompile(`^\s*--> ([^:\s]+):(\d+):(\d+)`)
+	// Rust test panics: "thread 'x' panicked at src/lib.rs:10:5:"
+	rustPanicRe = regexp.MustCompile(`panicked at ([^:\s]+):(\d+):(\d+)`)
+	// tsc: "src/a.ts(3,7): error TS2322: ..."
+	tscRe = regexp.MustCompile(`^([^\s(]+)\((\d+),(\d+)\): (error|warning) (.+)$`)
+	// go vet, gcc, eslint -f unix, pytest, go test: "file:line[:col]: message"
+	fileLineRe = regexp.MustCompile(`^\s*([^\s:]+\.\w+):(\d+)(?::(\d+))?:\s*(.+)$`)
+)
+
+// parseAnnotations extracts file/line locations from a failed stage's output.
+// Only paths that exist under root are kept, so URLs and tool-internal
+// paths don't turn into bogus annotations. Paths are reported relative to
+// root, as GitHub expects.
+func parseAnnotations(root, output string) []annotation {
+	var result []annotation
+	seen := make(map[string]bool)
+	add := func(a annotation) {
+		rel, ok := annotationPath(root, a.File)
+		if !ok {
+			return
+		}
+		a.File = rel
+		key := fmt.Sprintf("%s:%d:%d", a.File, a.Line, a.Col)
+		if seen[key] || len(result) >= maxAnnotationsPerStage {
+			return
+		}
+		seen[key] = true
+		result = append(result, a)
+	}
+
+	var pendingLevel, pendingMsg string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := rustHeaderRe.FindStringSubmatch(line); m != nil {
+			pendingLevel, pendingMsg = m[1], m[3]
+			continue
+		}
+		if m := rustArrowRe.FindStringSubmatch(line); m != nil {
+			if pendingMsg != "" {
+				add(annotation{Level: pendingLevel, File: m[1], Line: atoi(m[2]), Col: atoi(m[3]), Message: pendingMsg})
+				pendingMsg = ""
+			}
+			continue
+		}
+		if m := rustPanicRe.FindStringSubmatch(line); m != nil {
+			add(annotation{Level: "error", File: m[1], Line: atoi(m[2]), Col: atoi(m[3]), Message: strings.TrimSpace(line)})
+			continue
+		}
+		if m := tscRe.FindStringSubmatch(line); m != nil {
+			add(annotation{Level: m[4], File: m[1], Line: atoi(m[2]), Col: atoi(m[3]), Message: m[5]})
+			continue
+		}
+		if m := fileLineRe.FindStringSubmatch(line); m != nil {
+			add(annotation{Level: "error", File: m[1], Line: atoi(m[2]), Col: atoi(m[3]), Message: m[4]})
+		}
+	}
+	return result
+}
+
+func annotationPath(root, file string) (string, bool) {
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// escapeWorkflowData escapes a workflow command message.
+func escapeWorkflowData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeWorkflowProperty escapes a workflow command property value.
+func escapeWorkflowProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// workflowCommand formats an annotation as a GitHub workflow command.
+func (a annotation) workflowCommand(stage string) string {
+	props := []string{"file=" + escapeWorkflowProperty(a.File), "line=" + strconv.Itoa(a.Line)}
+	if a.Col > 0 {
+		props = append(props, "col="+strconv.Itoa(a.Col))
+	}
+	props = append(props, "title="+escapeWorkflowProperty("local-ci "+stage))
+	return fmt.Sprintf("::%s %s::%s", a.Level, strings.Join(props, ","), escapeWorkflowData(a.Message))
+}
+
+// githubStepSummary renders the run as a Markdown job summary.
+func githubStepSummary(results []Result, total time.Duration) string {
+	var b strings.Builder
+	b.WriteString("## local-ci\n\n")
+	b.WriteString("| Stage | Status | Duration | Cache |\n")
+	b.WriteString("|-------|--------|----------|-------|\n")
+	passed, ran := 0, 0
+	for _, r := range results {
+		// Stages skipped by their conditions don't count toward the total.
+		if r.SkipReason == "" {
+			ran++
+		}
+		status := "❌ fail"
+		switch {
+		case r.Status == "pass":
+			passed++
+			status = "✅ pass"
+		case r.SkipReason != "":
+			status = "⏭ skip (" + r.SkipReason + ")"
+		case r.Status == "skip":
+			status = "⏭ skip"
+		}
+		cache := "miss"
+		if r.CacheHit {
+			cache = "hit"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %dms | %s |\n", r.Name, status, r.Duration.Milliseconds(), cache)
+	}
+	fmt.Fprintf(&b, "\n**%d/%d passed** in %dms\n", passed, ran, total.Milliseconds())
+	return b.String()
+}
+
+func appendToFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(content)
+	return err
+}
+
+// reportToGitHub publishes the run to GitHub Actions: error annotations for
+// failing stages, a Markdown job summary, pass/fail step outputs, and (on a
+// cold cache) a hint for persisting local-ci's state with actions/cache.
+func reportToGitHub(root string, results []Result, total time.Duration, coldCache bool) {
+	passed, failed, cached, ran := 0, 0, 0, 0
+	for _, r := range results {
+		if r.SkipReason == "" {
+			ran++
+		}
+		switch {
+		case r.Status == "pass" && r.CacheHit:
+			passed++
+			cached++
+		case r.Status == "pass":
+			passed++
+		case r.Status == "fail":
+			failed++
+		}
+		if r.Status != "fail" {
+			continue
+		}
+		for _, a := range parseAnnotations(root, r.Output) {
+			printf("%s\n", a.workflowCommand(r.Name))
+		}
+	}
+
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		if err := appendToFile(path, githubStepSummary(results, total)); err != nil {
+			warnf("Warning: failed to write job summary: %v\n", err)
+		}
+	}
+
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" {
+		outputs := fmt.Sprintf("passed=%d\nfailed=%d\ncached=%d\ntotal=%d\ncache-file=.local-ci-cache\ncache-dir=.local-ci\n",
+			passed, failed, cached, ran)
+		if err := appendToFile(path, outputs); err != nil {
+			warnf("Warning: failed to write step outputs: %v\n", err)
+		}
+	}
+
+	if coldCache {
+		printf("::notice title=local-ci cache::%s\n", escapeWorkflowData(
+			"No local-ci cache was found. Persist it between runs with actions/cache, "+
+				"paths: .local-ci-cache and .local-ci/, key: local-ci-${{ runner.os }}-${{ github.sha }}, "+
+				"restore-keys: local-ci-${{ runner.os }}-"))
+	}
+}
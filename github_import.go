@@ -0,0 +1,673 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// importedStageTimeout is used for jobs without `timeout-minutes`. GitHub's
+// own default (6h) is far too generous for a local run.
+const importedStageTimeout = 600
+
+// ghWorkflow is the subset of a GitHub Actions workflow file local-ci
+// understands. Jobs are kept as a raw node so their file order survives.
+type ghWorkflow struct {
+	Name     string            `yaml:"name"`
+	Env      map[string]string `yaml:"env"`
+	Defaults ghDefaults        `yaml:"defaults"`
+	Jobs     yaml.Node         `yaml:"jobs"`
+}
+
+type ghDefaults struct {
+	Run struct {
+		WorkingDirectory string `yaml:"working-directory"`
+		Shell            string `yaml:"shell"`
+	} `yaml:"run"`
+}
+
+type ghJob struct {
+	Name           string            `yaml:"name"`
+	Needs          ghStringList      `yaml:"needs"`
+	RunsOn         yaml.Node         `yaml:"runs-on"`
+	If             string            `yaml:"if"`
+	Uses           string            `yaml:"uses"`
+	Env            map[string]string `yaml:"env"`
+	Defaults       ghDefaults        `yaml:"defaults"`
+	TimeoutMinutes int               `yaml:"timeout-minutes"`
+	Strategy       struct {
+		Matrix yaml.Node `yaml:"matrix"`
+	} `yaml:"strategy"`
+	Steps []ghStep `yaml:"steps"`
+}
+
+type ghStep struct {
+	Name             string            `yaml:"name"`
+	Uses             string            `yaml:"uses"`
+	Run              string            `yaml:"run"`
+	Shell            string            `yaml:"shell"`
+	If               string            `yaml:"if"`
+	Env              map[string]string `yaml:"env"`
+	WorkingDirectory string            `yaml:"working-directory"`
+}
+
+// ghStringList accepts either a single string or a list (as in `needs:`).
+type ghStringList []string
+
+func (l *ghStringList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*l = []string{n.Value}
+		return nil
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// ImportResult is the outcome of translating a workflow into stages.
+type ImportResult struct {
+	Stages   []Stage  // in workflow order
+	Warnings []string // things that could not be translated faithfully
+}
+
+var ghExprRe = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+
+// ImportGitHubWorkflow translates a workflow's jobs into stages: each job's
+// `run:` steps become one stage command, `needs` becomes depends_on, and a
+// simple `strategy.matrix` expands into one stage per combination.
+func ImportGitHubWorkflow(data []byte) (*ImportResult, error) {
+	var wf ghWorkflow
+	if err := yaml.Unmarshal(data, &wf); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow: %w", err)
+	}
+	if wf.Jobs.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("workflow has no jobs")
+	}
+
+	res := &ImportResult{}
+	note := func(format string, args ...interface{}) {
+		res.Warnings = append(res.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	type jobStages struct {
+		needs  []string
+		stages []string
+	}
+	jobs := make(map[string]*jobStages)
+	var order []string
+
+	for i := 0; i+1 < len(wf.Jobs.Content); i += 2 {
+		id := wf.Jobs.Content[i].Value
+		var job ghJob
+		if err := wf.Jobs.Content[i+1].Decode(&job); err != nil {
+			return nil, fmt.Errorf("job %s: %w", id, err)
+		}
+		js := &jobStages{needs: job.Needs}
+		jobs[id] = js
+		order = append(order, id)
+
+		if job.Uses != "" {
+			note("job %s: reusable workflow `uses: %s` can't be run locally; skipped", id, job.Uses)
+			continue
+		}
+		if job.If != "" {
+			note("job %s: condition `if: %s` ignored", id, job.If)
+		}
+
+		combos, matrixWarnings := expandGHMatrix(&job.Strategy.Matrix)
+		for _, w := range matrixWarnings {
+			note("job %s: %s", id, w)
+		}
+		if len(combos) > 1 && strings.Contains(nodeText(&job.RunsOn), "matrix.") {
+			note("job %s: runs-on varies by matrix; every variant will run on this machine", id)
+		}
+
+		for _, combo := range combos {
+			stage, ok := buildGHStage(id, job, wf, combo, note)
+			if !ok {
+				continue
+			}
+			if combo.name != "" {
+				stage.Name = id + "-" + combo.name
+			}
+			js.stages = append(js.stages, stage.Name)
+			res.Stages = append(res.Stages, stage)
+		}
+		if len(js.stages) == 0 && job.Uses == "" {
+			note("job %s: no run steps to import", id)
+		}
+	}
+
+	// Resolve `needs` to stage names. A needed job that produced no stage
+	// (e.g. only `uses:` steps) passes its own dependencies through.
+	var resolve func(id string, seen map[string]bool) []string
+	resolve = func(id string, seen map[string]bool) []string {
+		js, ok := jobs[id]
+		if !ok || seen[id] {
+			return nil
+		}
+		seen[id] = true
+		if len(js.stages) > 0 {
+			return js.stages
+		}
+		var deps []string
+		for _, n := range js.needs {
+			deps = append(deps, resolve(n, seen)...)
+		}
+		return deps
+	}
+	stageJob := make(map[string]string)
+	for _, id := range order {
+		for _, name := range jobs[id].stages {
+			stageJob[name] = id
+		}
+	}
+	for i := range res.Stages {
+		js := jobs[stageJob[res.Stages[i].Name]]
+		var deps []string
+		for _, n := range js.needs {
+			if _, ok := jobs[n]; !ok {
+				note("job %s: needs unknown job %s", stageJob[res.Stages[i].Name], n)
+				continue
+			}
+			deps = append(deps, resolve(n, map[string]bool{})...)
+		}
+		res.Stages[i].DependsOn = dedupeStrings(deps)
+	}
+	// Matrix variants repeat the same notes.
+	res.Warnings = dedupeStrings(res.Warnings)
+
+	return res, nil
+}
+
+// ghMatrixCombo is one combination of matrix values.
+type ghMatrixCombo struct {
+	name   string            // suffix for the stage name, empty without a matrix
+	values map[string]string // matrix key → value
+}
+
+// expandGHMatrix expands a simple matrix (keys mapping to scalar lists,
+// plus `exclude`) into its combinations. Anything dynamic is reported and
+// the job is imported once without matrix values.
+func expandGHMatrix(n *yaml.Node) ([]ghMatrixCombo, []string) {
+	single := []ghMatrixCombo{{values: map[string]string{}}}
+	if n.Kind == 0 {
+		return single, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return single, []string{fmt.Sprintf("dynamic matrix %q not supported; imported without matrix values", nodeText(n))}
+	}
+
+	var warnings []string
+	var keys []string
+	axes := make(map[string][]string)
+	var excludes []map[string]string
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i].Value, n.Content[i+1]
+		switch key {
+		case "exclude":
+			var list []map[string]string
+			if err := val.Decode(&list); err != nil {
+				warnings = append(warnings, "matrix exclude entries must be simple key/value maps; ignored")
+				continue
+			}
+			excludes = list
+		case "include":
+			warnings = append(warnings, "matrix include entries are not imported")
+		default:
+			var values []string
+			if val.Kind != yaml.SequenceNode || val.Decode(&values) != nil {
+				warnings = append(warnings, fmt.Sprintf("matrix key %s is not a list of scalars; ignored", key))
+				continue
+			}
+			keys = append(keys, key)
+			axes[key] = values
+		}
+	}
+	if len(keys) == 0 {
+		return single, warnings
+	}
+
+	combos := []map[string]string{{}}
+	for _, key := range keys {
+		var next []map[string]string
+		for _, c := range combos {
+			for _, v := range axes[key] {
+				m := make(map[string]string, len(c)+1)
+				for k, cv := range c {
+					m[k] = cv
+				}
+				m[key] = v
+				next = append(next, m)
+			}
+		}
+		combos = next
+	}
+
+	var result []ghMatrixCombo
+	for _, c := range combos {
+		if matrixExcluded(c, excludes) {
+			continue
+		}
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = c[k]
+		}
+		result = append(result, ghMatrixCombo{name: slugify(strings.Join(parts, "-")), values: c})
+	}
+	return result, warnings
+}
+
+func matrixExcluded(combo map[string]string, excludes []map[string]string) bool {
+	for _, ex := range excludes {
+		match := len(ex) > 0
+		for k, v := range ex {
+			if combo[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGHStage turns one job (for one matrix combination) into a stage.
+func buildGHStage(id string, job ghJob, wf ghWorkflow, combo ghMatrixCombo, note func(string, ...interface{})) (Stage, bool) {
+	subst := func(s string) string {
+		return substituteGHExpressions(s, combo.values, func(expr string) {
+			note("job %s: expression ${{ %s }} has no local equivalent", id, expr)
+		})
+	}
+
+	env := make(map[string]string)
+	for k, v := range wf.Env {
+		env[k] = subst(v)
+	}
+	for k, v := range job.Env {
+		env[k] = subst(v)
+	}
+
+	dir := job.Defaults.Run.WorkingDirectory
+	if dir == "" {
+		dir = wf.Defaults.Run.WorkingDirectory
+	}
+	shell := job.Defaults.Run.Shell
+	if shell == "" {
+		shell = wf.Defaults.Run.Shell
+	}
+
+	var parts []string
+	for i, step := range job.Steps {
+		label := step.Name
+		if label == "" {
+			label = fmt.Sprintf("step %d", i+1)
+		}
+		if step.Uses != "" {
+			note("job %s: %s: `uses: %s` %s", id, label, step.Uses, usesHint(step.Uses))
+			continue
+		}
+		if step.Run == "" {
+			continue
+		}
+		stepShell := step.Shell
+		if stepShell == "" {
+			stepShell = shell
+		}
+		if stepShell != "" && stepShell != "bash" && stepShell != "sh" {
+			note("job %s: %s: shell %q not supported; skipped", id, label, stepShell)
+			continue
+		}
+		if step.If != "" {
+			note("job %s: %s: condition `if: %s` ignored", id, label, step.If)
+		}
+
+		run := strings.TrimRight(subst(step.Run), "\n")
+		if len(step.Env) > 0 || step.WorkingDirectory != "" {
+			var b strings.Builder
+			b.WriteString("(\n")
+			if step.WorkingDirectory != "" {
+				fmt.Fprintf(&b, "cd %s\n", escapeShellArg(subst(step.WorkingDirectory)))
+			}
+			for _, k := range sortedKeys(step.Env) {
+				fmt.Fprintf(&b, "export %s=%s\n", k, escapeShellArg(subst(step.Env[k])))
+			}
+			b.WriteString(run)
+			b.WriteString("\n)")
+			run = b.String()
+		}
+		parts = append(parts, run)
+	}
+	if len(parts) == 0 {
+		return Stage{}, false
+	}
+
+	timeout := importedStageTimeout
+	if job.TimeoutMinutes > 0 {
+		timeout = job.TimeoutMinutes * 60
+	}
+	stage := Stage{
+		Name:    id,
+		Cmd:     scriptCommand(strings.Join(parts, "\n")),
+		Timeout: timeout,
+		Enabled: true,
+		Dir:     dir,
+	}
+	if len(env) > 0 {
+		stage.Env = env
+	}
+	return stage, true
+}
+
+// substituteGHExpressions replaces ${{ matrix.x }} with its value and
+// ${{ env.X }} with $X; anything else is left in place and reported.
+func substituteGHExpressions(s string, matrix map[string]string, unsupported func(string)) string {
+	return ghExprRe.ReplaceAllStringFunc(s, func(m string) string {
+		expr := ghExprRe.FindStringSubmatch(m)[1]
+		switch {
+		case strings.HasPrefix(expr, "matrix."):
+			if v, ok := matrix[strings.TrimPrefix(expr, "matrix.")]; ok {
+				return v
+			}
+		case strings.HasPrefix(expr, "env."):
+			return "$" + strings.TrimPrefix(expr, "env.")
+		}
+		unsupported(expr)
+		return m
+	})
+}
+
+// scriptCommand returns argv for a shell script. A single simple command
+// (no shell syntax) is split into words so the stage reads naturally;
+// anything else runs under bash with the same flags GitHub uses.
+func scriptCommand(script string) []string {
+	if !strings.ContainsAny(script, "\n|&;<>()$`\\\"'*?[]#~=%{}") {
+		if fields := strings.Fields(script); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"bash", "-eo", "pipefail", "-c", script}
+}
+
+func usesHint(uses string) string {
+	action := uses
+	if i := strings.Index(action, "@"); i >= 0 {
+		action = action[:i]
+	}
+	switch {
+	case action == "actions/checkout", action == "actions/cache",
+		strings.HasPrefix(action, "actions/upload-artifact"), strings.HasPrefix(action, "actions/download-artifact"):
+		return "is not needed locally; skipped"
+	case strings.Contains(action, "setup-") || strings.Contains(action, "toolchain"):
+		return "sets up a toolchain; make sure it is installed locally; skipped"
+	default:
+		return "can't be translated; skipped"
+	}
+}
+
+func nodeText(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode {
+		return n.Value
+	}
+	out, _ := yaml.Marshal(n)
+	return strings.TrimSpace(string(out))
+}
+
+var slugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slugify(s string) string {
+	return strings.Trim(slugRe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dedupeStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	var out []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// findWorkflow resolves a workflow argument (a path, or a name under
+// .github/workflows with or without extension). With no argument the
+// repository must have exactly one workflow.
+func findWorkflow(root, name string) (string, error) {
+	dir := filepath.Join(root, ".github", "workflows")
+	if name == "" {
+		var found []string
+		for _, pattern := range []string{"*.yml", "*.yaml"} {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			found = append(found, matches...)
+		}
+		sort.Strings(found)
+		switch len(found) {
+		case 0:
+			return "", fmt.Errorf("no workflows found in %s", dir)
+		case 1:
+			return found[0], nil
+		default:
+			names := make([]string, len(found))
+			for i, f := range found {
+				names[i] = filepath.Base(f)
+			}
+			return "", fmt.Errorf("multiple workflows found (%s); pass one by name", strings.Join(names, ", "))
+		}
+	}
+
+	candidates := []string{name, filepath.Join(dir, name), filepath.Join(dir, name+".yml"), filepath.Join(dir, name+".yaml")}
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("workflow %q not found in %s", name, dir)
+}
+
+// formatStageTOML renders a stage as a [stages.<name>] table.
+func formatStageTOML(s Stage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[stages.%s]\n", tomlKey(s.Name))
+	fmt.Fprintf(&b, "command = %s\n", tomlStringList(s.Cmd))
+	if len(s.FixCmd) > 0 {
+		fmt.Fprintf(&b, "fix_command = %s\n", tomlStringList(s.FixCmd))
+	}
+	if s.Timeout > 0 {
+		fmt.Fprintf(&b, "timeout = %d\n", s.Timeout)
+	}
+	fmt.Fprintf(&b, "enabled = %t\n", s.Enabled)
+	if len(s.DependsOn) > 0 {
+		fmt.Fprintf(&b, "depends_on = %s\n", tomlStringList(s.DependsOn))
+	}
+	if len(s.Watch) > 0 {
+		fmt.Fprintf(&b, "watch = %s\n", tomlStringList(s.Watch))
+	}
+	if s.Dir != "" {
+		fmt.Fprintf(&b, "dir = %s\n", strconv.Quote(s.Dir))
+	}
+	if len(s.Env) > 0 {
+		fmt.Fprintf(&b, "\n[stages.%s.env]\n", tomlKey(s.Name))
+		for _, k := range sortedKeys(s.Env) {
+			fmt.Fprintf(&b, "%s = %s\n", tomlKey(k), strconv.Quote(s.Env[k]))
+		}
+	}
+	return b.String()
+}
+
+var bareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if bareKeyRe.MatchString(k) {
+		return k
+	}
+	return strconv.Quote(k)
+}
+
+func tomlStringList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// cmdImport implements `local-ci import github-actions [workflow]`.
+func cmdImport(root string, args []string) int {
+	if len(args) == 0 || args[0] != "github-actions" {
+		errorf("Usage: local-ci import github-actions [workflow] [--stdout]\n")
+		return 2
+	}
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	toStdout := fs.Bool("stdout", false, "Print the generated stages instead of writing .local-ci.toml")
+	var positional []string
+	rest := args[1:]
+	for len(rest) > 0 {
+		if err := fs.Parse(rest); err != nil {
+			return 2
+		}
+		rest = fs.Args()
+		if len(rest) > 0 {
+			positional = append(positional, rest[0])
+			rest = rest[1:]
+		}
+	}
+	name := ""
+	if len(positional) > 0 {
+		name = positional[0]
+	}
+
+	path, err := findWorkflow(root, name)
+	if err != nil {
+		errorf("%v\n", err)
+		return 1
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errorf("Failed to read %s: %v\n", path, err)
+		return 1
+	}
+	res, err := ImportGitHubWorkflow(data)
+	if err != nil {
+		errorf("%s: %v\n", path, err)
+		return 1
+	}
+	rel, _ := filepath.Rel(root, path)
+
+	for _, w := range res.Warnings {
+		warnf("⚠️  %s\n", w)
+	}
+
+	if *toStdout {
+		for i, s := range res.Stages {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(formatStageTOML(s))
+		}
+		return 0
+	}
+
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		errorf("%v\n", err)
+		return 1
+	}
+	stages, skipped := mergeImportedStages(res.Stages, cfg.Stages)
+	for _, msg := range skipped {
+		printf("↷ %s\n", msg)
+	}
+	if len(stages) == 0 {
+		successf("✅ .local-ci.toml already covers %s\n", rel)
+		return 0
+	}
+
+	configPath := filepath.Join(root, ".local-ci.toml")
+	existing, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		errorf("Failed to read .local-ci.toml: %v\n", err)
+		return 1
+	}
+	var b strings.Builder
+	b.Write(existing)
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\n# Imported from %s by `local-ci import github-actions`\n", filepath.ToSlash(rel))
+	for _, s := range stages {
+		b.WriteString("\n")
+		b.WriteString(formatStageTOML(s))
+	}
+	if _, err := toml.Decode(b.String(), &struct{}{}); err != nil {
+		errorf("Generated config does not parse (%v); nothing written\n", err)
+		return 1
+	}
+	if err := os.WriteFile(configPath, []byte(b.String()), 0644); err != nil {
+		errorf("Failed to write .local-ci.toml: %v\n", err)
+		return 1
+	}
+	successf("✅ Imported %d stage(s) from %s into .local-ci.toml\n", len(stages), rel)
+	return 0
+}
+
+// mergeImportedStages drops imported stages that an existing stage already
+// covers — same name, or same command under another name — and rewrites
+// depends_on to point at the existing stage in the latter case.
+func mergeImportedStages(imported []Stage, existing map[string]Stage) ([]Stage, []string) {
+	byCommand := make(map[string]string)
+	for name, s := range existing {
+		if len(s.Cmd) > 0 {
+			byCommand[strings.Join(s.Cmd, " ")] = name
+		}
+	}
+
+	rename := make(map[string]string)
+	var skipped []string
+	var kept []Stage
+	for _, s := range imported {
+		if _, ok := existing[s.Name]; ok {
+			skipped = append(skipped, fmt.Sprintf("%s: a stage with this name already exists; skipped", s.Name))
+			continue
+		}
+		if other, ok := byCommand[strings.Join(s.Cmd, " ")]; ok {
+			rename[s.Name] = other
+			skipped = append(skipped, fmt.Sprintf("%s: same command as existing stage %s; skipped", s.Name, other))
+			continue
+		}
+		kept = append(kept, s)
+	}
+	for i := range kept {
+		deps := make([]string, len(kept[i].DependsOn))
+		for j, d := range kept[i].DependsOn {
+			if r, ok := rename[d]; ok {
+				d = r
+			}
+			deps[j] = d
+		}
+		kept[i].DependsOn = dedupeStrings(deps)
+	}
+	return kept, skipped
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+const sampleWorkflow = `name: CI
+on: [push, pull_request]
+env:
+  CARGO_TERM_COLOR: always
+jobs:
+  fmt:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: dtolnay/rust-toolchain@stable
+      - run: cargo fmt --all -- --check
+  clippy:
+    needs: fmt
+    runs-on: ubuntu-latest
+    timeout-minutes: 15
+    steps:
+      - uses: actions/checkout@v4
+      - name: Lint
+        run: |
+          cargo clippy --workspace -- -D warnings
+          cargo doc --no-deps
+  test:
+    needs: [fmt, clippy]
+    runs-on: ${{ matrix.os }}
+    strategy:
+      matrix:
+        os: [ubuntu-latest, macos-latest]
+        features: [default, serde]
+        exclude:
+          - os: macos-latest
+            features: serde
+    env:
+      RUST_BACKTRACE: 1
+    steps:
+      - uses: actions/checkout@v4
+      - run: cargo test --features ${{ matrix.features }}
+        working-directory: crates/core
+        env:
+          TOKEN: ${{ secrets.TOKEN }}
+`
+
+func TestImportGitHubWorkflow(t *testing.T) {
+	res, err := ImportGitHubWorkflow([]byte(sampleWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range res.Stages {
+		names = append(names, s.Name)
+	}
+	want := []string{"fmt", "clippy", "test-ubuntu-latest-default", "test-ubuntu-latest-serde", "test-macos-latest-default"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("stage names = %v, want %v", names, want)
+	}
+
+	fmtStage := res.Stages[0]
+	if !reflect.DeepEqual(fmtStage.Cmd, []string{"cargo", "fmt", "--all", "--", "--check"}) {
+		t.Errorf("simple run step should be split into words, got %q", fmtStage.Cmd)
+	}
+	if fmtStage.Env["CARGO_TERM_COLOR"] != "always" || fmtStage.Timeout != importedStageTimeout || !fmtStage.Enabled {
+		t.Errorf("unexpected fmt stage: %+v", fmtStage)
+	}
+
+	clippy := res.Stages[1]
+	if clippy.Cmd[0] != "bash" || !strings.Contains(clippy.Cmd[len(clippy.Cmd)-1], "cargo doc --no-deps") {
+		t.Errorf("multi-line run should go through bash, got %q", clippy.Cmd)
+	}
+	if clippy.Timeout != 900 || !reflect.DeepEqual(clippy.DependsOn, []string{"fmt"}) {
+		t.Errorf("unexpected clippy stage: %+v", clippy)
+	}
+
+	test := res.Stages[3]
+	script := test.Cmd[len(test.Cmd)-1]
+	if !strings.Contains(script, "cargo test --features serde") || !strings.Contains(script, "cd crates/core") {
+		t.Errorf("matrix values and working-directory not applied: %q", script)
+	}
+	if !reflect.DeepEqual(test.DependsOn, []string{"fmt", "clippy"}) {
+		t.Errorf("test depends_on = %v", test.DependsOn)
+	}
+	if test.Env["RUST_BACKTRACE"] != "1" {
+		t.Errorf("job env not imported: %v", test.Env)
+	}
+
+	warnings := strings.Join(res.Warnings, "\n")
+	for _, want := range []string{
+		"actions/checkout@v4` is not needed locally",
+		"dtolnay/rust-toolchain@stable` sets up a toolchain",
+		"runs-on varies by matrix",
+		"${{ secrets.TOKEN }} has no local equivalent",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("missing warning %q in:\n%s", want, warnings)
+		}
+	}
+	if strings.Count(warnings, "secrets.TOKEN") != 1 {
+		t.Errorf("matrix variants should not repeat warnings:\n%s", warnings)
+	}
+}
+
+func TestImportNeedsPassesThroughSkippedJobs(t *testing.T) {
+	wf := `jobs:
+  build:
+    steps:
+      - run: make
+  publish:
+    needs: build
+    steps:
+      - uses: actions/upload-artifact@v4
+  deploy:
+    needs: publish
+    steps:
+      - run: make deploy
+`
+	res, err := ImportGitHubWorkflow([]byte(wf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Stages) != 2 {
+		t.Fatalf("expected 2 stages, got %+v", res.Stages)
+	}
+	if !reflect.DeepEqual(res.Stages[1].DependsOn, []string{"build"}) {
+		t.Errorf("deploy should inherit publish's dependency on build, got %v", res.Stages[1].DependsOn)
+	}
+}
+
+func TestImportUnsupportedShell(t *testing.T) {
+	wf := `jobs:
+  win:
+    steps:
+      - run: Get-ChildItem
+        shell: pwsh
+`
+	res, err := ImportGitHubWorkflow([]byte(wf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Stages) != 0 {
+		t.Errorf("pwsh step should not produce a stage: %+v", res.Stages)
+	}
+	if !strings.Contains(strings.Join(res.Warnings, "\n"), `shell "pwsh" not supported`) {
+		t.Errorf("expected a shell warning, got %v", res.Warnings)
+	}
+}
+
+func TestFormatStageTOMLRoundTrip(t *testing.T) {
+	stage := Stage{
+		Name:      "test-linux",
+		Cmd:       []string{"bash", "-eo", "pipefail", "-c", "echo \"hi\"\ncargo test"},
+		Timeout:   600,
+		Enabled:   true,
+		DependsOn: []string{"fmt"},
+		Dir:       "crates/core",
+		Env:       map[string]string{"RUST_LOG": "debug"},
+	}
+	var cfg struct {
+		Stages map[string]Stage `toml:"stages"`
+	}
+	if _, err := toml.Decode(formatStageTOML(stage), &cfg); err != nil {
+		t.Fatalf("generated TOML does not parse: %v\n%s", err, formatStageTOML(stage))
+	}
+	got := cfg.Stages["test-linux"]
+	got.Name = stage.Name
+	if !reflect.DeepEqual(got, stage) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got, stage)
+	}
+}
+
+func TestFindWorkflow(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, ".github", "workflows")
+	os.MkdirAll(dir, 0o755)
+	if _, err := findWorkflow(root, ""); err == nil {
+		t.Error("expected an error with no workflows")
+	}
+	os.WriteFile(filepath.Join(dir, "ci.yml"), []byte(sampleWorkflow), 0o644)
+	if p, err := findWorkflow(root, ""); err != nil || filepath.Base(p) != "ci.yml" {
+		t.Errorf("single workflow should be picked automatically: %s %v", p, err)
+	}
+	os.WriteFile(filepath.Join(dir, "release.yaml"), []byte(sampleWorkflow), 0o644)
+	if _, err := findWorkflow(root, ""); err == nil || !strings.Contains(err.Error(), "ci.yml, release.yaml") {
+		t.Errorf("expected an ambiguity error listing workflows, got %v", err)
+	}
+	if p, err := findWorkflow(root, "release"); err != nil || filepath.Base(p) != "release.yaml" {
+		t.Errorf("name lookup failed: %s %v", p, err)
+	}
+}
+
+func TestCmdImportAppendsNewStages(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, ".github", "workflows")
+	os.MkdirAll(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, "ci.yml"), []byte(`jobs:
+  lint:
+    steps:
+      - run: cargo clippy --workspace --all-targets -- -D warnings
+  docs:
+    needs: lint
+    steps:
+      - run: cargo doc --no-deps
+`), 0o644)
+	os.WriteFile(filepath.Join(root, "Cargo.toml"), []byte("[package]\nname = \"x\"\n"), 0o644)
+
+	if code := cmdImport(root, []string{"github-actions", "ci"}); code != 0 {
+		t.Fatalf("import exited %d", code)
+	}
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Stages["lint"]; ok {
+		t.Error("lint runs the same command as the default clippy stage and should be skipped")
+	}
+	docs, ok := cfg.Stages["docs"]
+	if !ok {
+		t.Fatal("docs stage was not imported")
+	}
+	if !reflect.DeepEqual(docs.DependsOn, []string{"clippy"}) {
+		t.Errorf("docs should depend on the existing clippy stage, got %v", docs.DependsOn)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseAnnotationsRustClippy(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "src/main.rs")
+	out := `error[E0308]: mismatched types
+  --> src/main.rs:10:5
+   |
+10 |     "x"
+warning: unused variable: ` + "`y`" + `
+  --> src/main.rs:12:9
+thread 'tests::it_works' panicked at src/main.rs:20:5:
+assertion failed`
+
+	got := parseAnnotations(root, out)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 annotations, got %d: %+v", len(got), got)
+	}
+	if got[0].Level != "error" || got[0].File != "src/main.rs" || got[0].Line != 10 || got[0].Col != 5 || got[0].Message != "mismatched types" {
+		t.Errorf("unexpected rustc annotation: %+v", got[0])
+	}
+	if got[1].Level != "warning" || got[1].Line != 12 {
+		t.Errorf("unexpected clippy warning: %+v", got[1])
+	}
+	if got[2].Line != 20 || !strings.Contains(got[2].Message, "panicked") {
+		t.Errorf("unexpected panic annotation: %+v", got[2])
+	}
+}
+
+func TestParseAnnotationsGenericFormats(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "pkg/a.go", "pkg/a_test.go", "src/a.ts")
+	out := strings.Join([]string{
+		"pkg/a.go:3:2: undefined: foo",
+		"    a_test.go:12: expected 1, got 2",
+		"    pkg/a_test.go:14: expected 3, got 4",
+		"src/a.ts(3,7): error TS2322: Type 'string' is not assignable",
+		"see https://example.com:443: docs",
+		"missing.go:1:1: not in tree",
+	}, "\n")
+
+	got := parseAnnotations(root, out)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 annotations, got %d: %+v", len(got), got)
+	}
+	if got[0].File != "pkg/a.go" || got[0].Line != 3 || got[0].Col != 2 {
+		t.Errorf("unexpected go vet annotation: %+v", got[0])
+	}
+	if got[1].File != "pkg/a_test.go" || got[1].Line != 14 || got[1].Col != 0 {
+		t.Errorf("unexpected go test annotation: %+v", got[1])
+	}
+	if got[2].File != "src/a.ts" || got[2].Message != "TS2322: Type 'string' is not assignable" {
+		t.Errorf("unexpected tsc annotation: %+v", got[2])
+	}
+}
+
+func TestParseAnnotationsCapped(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a.go")
+	var lines []string
+	for i := 1; i <= 25; i++ {
+		lines = append(lines, "a.go:"+strconv.Itoa(i)+":1: bad")
+	}
+	if got := parseAnnotations(root, strings.Join(lines, "\n")); len(got) != maxAnnotationsPerStage {
+		t.Errorf("expected %d annotations, got %d", maxAnnotationsPerStage, len(got))
+	}
+}
+
+func TestWorkflowCommandEscaping(t *testing.T) {
+	a := annotation{Level: "error", File: "src/a,b.rs", Line: 3, Col: 1, Message: "100% wrong\nsecond line"}
+	got := a.workflowCommand("clippy")
+	want := "::error file=src/a%2Cb.rs,line=3,col=1,title=local-ci clippy::100%25 wrong%0Asecond line"
+	if got != want {
+		t.Errorf("workflowCommand =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGithubStepSummary(t *testing.T) {
+	results := []Result{
+		{Name: "fmt", Status: "pass", CacheHit: true},
+		{Name: "test", Status: "fail", Duration: 1500 * time.Millisecond},
+		{Name: "deploy", Status: "skip", SkipReason: "branch is not main"},
+	}
+	got := githubStepSummary(results, 2*time.Second)
+	for _, want := range []string{
+		"| Stage | Status | Duration | Cache |",
+		"| `fmt` | ✅ pass | 0ms | hit |",
+		"| `test` | ❌ fail | 1500ms | miss |",
+		"| `deploy` | ⏭ skip (branch is not main) | 0ms | miss |",
+		// Skipped stages didn't run, so they aren't in the total.
+		"**1/2 passed** in 2000ms",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestReportToGitHubWritesSummaryAndOutputs(t *testing.T) {
+	dir := t.TempDir()
+	summary := filepath.Join(dir, "summary.md")
+	outputs := filepath.Join(dir, "output")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+	t.Setenv("GITHUB_OUTPUT", outputs)
+
+	results := []Result{
+		{Name: "fmt", Status: "pass", CacheHit: true},
+		{Name: "clippy", Status: "pass"},
+		{Name: "test", Status: "fail"},
+		// Skipped by its conditions, so not part of the total.
+		{Name: "deploy", Status: "skip", SkipReason: "branch is not main"},
+	}
+	reportToGitHub(dir, results, time.Second, false)
+
+	data, err := os.ReadFile(summary)
+	if err != nil || !strings.Contains(string(data), "**2/3 passed**") {
+		t.Errorf("job summary not written: %q (%v)", data, err)
+	}
+	data, err = os.ReadFile(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"passed=2\n", "failed=1\n", "cached=1\n", "total=3\n", "cache-file=.local-ci-cache\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("step outputs missing %q:\n%s", want, data)
+		}
+	}
+}
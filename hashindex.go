@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HashIndex memoizes per-file content digests, and the tree hashes they add
+// up to, so repeated hash computations only re-read files that changed. The
+// CLI uses a throwaway index (or none); the daemon keeps one warm for its
+// whole lifetime and invalidates entries from filesystem notifications.
+type HashIndex struct {
+	mu    sync.Mutex
+	files map[string]indexEntry // absolute path → digest
+	trees map[string]string     // digest of a tree's file digests → its source hash
+}
+
+// maxIndexedTrees bounds the tree hashes an index keeps; each edit adds one.
+const maxIndexedTrees = 64
+
+type indexEntry struct {
+	size    int64
+	modTime time.Time
+	sum     [md5.Size]byte
+}
+
+// NewHashIndex returns an empty index.
+func NewHashIndex() *HashIndex {
+	return &HashIndex{files: make(map[string]indexEntry), trees: make(map[string]string)}
+}
+
+// Invalidate drops the cached digest for path (and, for a directory,
+// everything beneath it).
+func (ix *HashIndex) Invalidate(path string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	delete(ix.files, path)
+	prefix := path + string(filepath.Separator)
+	for p := range ix.files {
+		if len(p) > len(prefix) && p[:len(prefix)] == prefix {
+			delete(ix.files, p)
+		}
+	}
+}
+
+// Len reports how many file digests are currently cached.
+func (ix *HashIndex) Len() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.files)
+}
+
+// SourceHash is computeSourceHash backed by the index.
+func (ix *HashIndex) SourceHash(root string, config *Config, ws *Workspace) (string, error) {
+	return hashTree(root, config, ws, config.Cache.IncludePatterns, ix)
+}
+
+// StageHashes is computeStageHashes backed by the index.
+func (ix *HashIndex) StageHashes(root string, config *Config, ws *Workspace, stages []Stage) (map[string]string, error) {
+	result := make(map[string]string, len(stages))
+	for _, stage := range stages {
+		patterns := stage.Watch
+		if len(patterns) == 0 {
+			patterns = config.Cache.IncludePatterns
+		}
+		hash, err := hashTree(root, config, ws, patterns, ix)
+		if err != nil {
+			return nil, err
+		}
+		result[stage.Name] = hash
+	}
+	return result, nil
+}
+
+// fileSum returns the MD5 of the file at path, served from the index when
+// the file's size and mtime still match the cached entry.
+func (ix *HashIndex) fileSum(path string, d fs.DirEntry) ([md5.Size]byte, error) {
+	if ix == nil {
+		return md5File(path)
+	}
+	info, err := d.Info()
+	if err != nil {
+		return [md5.Size]byte{}, err
+	}
+
+	ix.mu.Lock()
+	entry, ok := ix.files[path]
+	ix.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.sum, nil
+	}
+
+	sum, err := md5File(path)
+	if err != nil {
+		return sum, err
+	}
+	ix.mu.Lock()
+	ix.files[path] = indexEntry{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	ix.mu.Unlock()
+	return sum, nil
+}
+
+func md5File(path string) ([md5.Size]byte, error) {
+	var sum [md5.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// treeHash is the source hash of files: the MD5 of their contents, in
+// order. Unreadable files are skipped.
+func treeHash(files []string) string {
+	h := md5.New()
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		io.Copy(h, f)
+		f.Close()
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// hashTree walks root and hashes the content of every file whose name
+// matches patterns, honoring the configured skip dirs and workspace
+// excludes. ix may be nil, in which case every file is read. With an index,
+// the hash is reused while every file's digest is unchanged, so a warm
+// daemon only stats the tree.
+func hashTree(root string, config *Config, ws *Workspace, patterns []string, ix *HashIndex) (string, error) {
+	var files []string
+	digests := md5.New()
+
+	// Build skip set from config
+	skipDirs := skipDirSet(config.Cache.SkipDirs)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories in config
+		if d.IsDir() {
+			if skipDirs[d.Name()] || d.Name() == ".local-ci" {
+				return filepath.SkipDir
+			}
+		}
+
+		// Skip excluded workspace members
+		if ws != nil && !ws.IsSingle {
+			relPath, err := filepath.Rel(root, path)
+			if err == nil && ws.IsExcluded(relPath) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		// Hash files matching the patterns
+		if !d.IsDir() && matchesPatterns(d.Name(), patterns) {
+			files = append(files, path)
+			if ix != nil {
+				sum, err := ix.fileSum(path, d)
+				if err != nil {
+					return nil // Skip unreadable files
+				}
+				digests.Write(sum[:])
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+	if ix == nil {
+		return treeHash(files), nil
+	}
+
+	key := fmt.Sprintf("%x", digests.Sum(nil))
+	ix.mu.Lock()
+	hash, ok := ix.trees[key]
+	ix.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+	hash = treeHash(files)
+	ix.mu.Lock()
+	if len(ix.trees) >= maxIndexedTrees {
+		ix.trees = make(map[string]string)
+	}
+	ix.trees[key] = hash
+	ix.mu.Unlock()
+	return hash, nil
+}
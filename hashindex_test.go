@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashIndexMatchesComputeSourceHash(t *testing.T) {
+	dir := createRustWorkspace(t)
+	config := &Config{Cache: CacheConfig{
+		SkipDirs:        []string{"target"},
+		IncludePatterns: []string{"*.rs", "*.toml"},
+	}}
+
+	want, err := computeSourceHash(dir, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := NewHashIndex()
+	for i := 0; i < 2; i++ {
+		got, err := ix.SourceHash(dir, config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("pass %d: indexed hash %s != computeSourceHash %s", i, got, want)
+		}
+	}
+	if ix.Len() == 0 {
+		t.Fatal("expected index to cache file digests")
+	}
+}
+
+func TestHashIndexStageHashesMatch(t *testing.T) {
+	dir := createRustWorkspace(t)
+	config := &Config{Cache: CacheConfig{
+		SkipDirs:        []string{"target"},
+		IncludePatterns: []string{"*.rs", "*.toml", "*.lock"},
+	}}
+	stages := []Stage{
+		{Name: "fmt", Watch: []string{"*.rs"}},
+		{Name: "deny", Watch: []string{"Cargo.lock", "deny.toml"}},
+		{Name: "all"},
+	}
+	want, err := computeStageHashes(dir, config, nil, stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewHashIndex().StageHashes(dir, config, nil, stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, h := range want {
+		if got[name] != h {
+			t.Errorf("stage %s: indexed %s != %s", name, got[name], h)
+		}
+	}
+}
+
+func TestHashIndexInvalidate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.rs")
+	os.WriteFile(path, []byte("aaaa"), 0o644)
+	config := &Config{Cache: CacheConfig{IncludePatterns: []string{"*.rs"}}}
+
+	ix := NewHashIndex()
+	before, _ := ix.SourceHash(dir, config, nil)
+
+	// Same size, same mtime: only an explicit invalidation (as the daemon's
+	// watcher does) should make the index re-read the file.
+	info, _ := os.Stat(path)
+	os.WriteFile(path, []byte("bbbb"), 0o644)
+	os.Chtimes(path, time.Now(), info.ModTime())
+
+	if stale, _ := ix.SourceHash(dir, config, nil); stale != before {
+		t.Fatal("expected index to serve the memoized digest")
+	}
+	ix.Invalidate(path)
+	after, _ := ix.SourceHash(dir, config, nil)
+	if after == before {
+		t.Fatal("expected hash to change after invalidation")
+	}
+	if fresh, _ := computeSourceHash(dir, config, nil); fresh != after {
+		t.Fatalf("invalidated index hash %s != fresh hash %s", after, fresh)
+	}
+}
+
+func TestHashIndexInvalidateDirectory(t *testing.T) {
+	ix := NewHashIndex()
+	ix.files["/w/src/a.rs"] = indexEntry{}
+	ix.files["/w/src/b.rs"] = indexEntry{}
+	ix.files["/w/srcx/c.rs"] = indexEntry{}
+	ix.Invalidate("/w/src")
+	if ix.Len() != 1 {
+		t.Fatalf("expected only the sibling dir entry to survive, have %d", ix.Len())
+	}
+}
+
+func TestSourceHashMatchesBaselineScheme(t *testing.T) {
+	// Existing .local-ci-cache entries were keyed on the MD5 of the matching
+	// files' contents in walk order; the hash must stay that way.
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0o755)
+	os.WriteFile(filepath.Join(dir, "Cargo.toml"), []byte("[package]\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "src", "lib.rs"), []byte("pub fn x() {}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "src", "main.rs"), []byte("fn main() {}\n"), 0o644)
+	config := &Config{Cache: CacheConfig{IncludePatterns: []string{"*.rs", "*.toml"}}}
+
+	want := fmt.Sprintf("%x", md5.Sum([]byte("[package]\npub fn x() {}\nfn main() {}\n")))
+	if got, _ := computeSourceHash(dir, config, nil); got != want {
+		t.Errorf("computeSourceHash = %s, want %s", got, want)
+	}
+	if got, _ := NewHashIndex().SourceHash(dir, config, nil); got != want {
+		t.Errorf("indexed SourceHash = %s, want %s", got, want)
+	}
+}
@@ -37,7 +37,7 @@ func getPreCommitHookTemplate(root string) string {
 
 set -e
 
-# Run local-ci with fast checks
+# Run local-ci with fast checks (through the daemon when one is running)
 if ! local-ci ` + stagesCmd + `; then
   echo ""
   echo "❌ Pre-commit checks failed. Fix the issues above and try again."
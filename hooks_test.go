@@ -27,6 +27,10 @@ func TestCreatePreCommitHookNewRepo(t *testing.T) {
 	if !strings.Contains(content, "local-ci") {
 		t.Error("Hook should contain local-ci invocation")
 	}
+	// Plain local-ci picks up a running daemon itself.
+	if strings.Contains(content, "daemon run") {
+		t.Error("Hook should call plain local-ci, not local-ci daemon run")
+	}
 	if !strings.HasPrefix(content, "#!/bin/bash") {
 		t.Error("Hook should start with bash shebang")
 	}
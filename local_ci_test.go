@@ -399,6 +399,29 @@ func TestSaveLoadCache(t *testing.T) {
 	}
 }
 
+func TestCacheNamesWithColonsRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	cache := map[string]string{
+		"t[v=a]":                            "h1|cargo test",
+		"t[v=b:c]":                          "h2|sh -c echo a:b",
+		"test@host:2222":                    "h3|make",
+		"pct[v=50%3A]":                      "h4|true",
+		cacheEntryName("x", "ci@sparky:22"): "h5|true",
+	}
+	if err := saveCache(cache, tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := loadCache(tmpDir)
+	if len(loaded) != len(cache) {
+		t.Fatalf("loaded %d entries, want %d: %v", len(loaded), len(cache), loaded)
+	}
+	for name, key := range cache {
+		if loaded[name] != key {
+			t.Errorf("%q = %q, want %q", name, loaded[name], key)
+		}
+	}
+}
+
 func TestCacheConsistency(t *testing.T) {
 	tmpDir := createTestWorkspace(t)
 	defer os.RemoveAll(tmpDir)
@@ -11,6 +11,12 @@
 //	local-ci                Run default stages for detected project type
 //	local-ci fmt clippy     Run specific stages
 //	local-ci init           Initialize .local-ci.toml in current project
+//	local-ci watch          Re-run affected stages on file change
+//	local-ci daemon         Serve runs from a warm background process
+//	local-ci import         Import stages from a GitHub Actions workflow
+//	local-ci drift          Compare a workflow with .local-ci.toml
+//	local-ci export         Generate GitHub Actions / GitLab CI pipelines
+//	local-ci doctor         Report what local-ci detects about the project
 //	local-ci --no-cache     Disable caching, force all stages
 //	local-ci --fix          Auto-fix issues
 //	local-ci --verbose      Show detailed output
@@ -27,18 +33,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/md5"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -54,8 +60,43 @@ type Stage struct {
 	Check     bool     // true if this is a --check command
 	Timeout   int      // in seconds
 	Enabled   bool
-	DependsOn []string `toml:"depends_on"` // stage names this stage depends on
-	Watch     []string // file patterns this stage cares about (for granular caching)
+	DependsOn []string          `toml:"depends_on"` // stage names this stage depends on
+	Watch     []string          // file patterns this stage cares about (for granular caching)
+	Env       map[string]string // extra environment variables for the command
+	Dir       string            // working directory, relative to the project root
+	Artifacts []string          // output paths/globs collected into .local-ci/artifacts/<stage>/
+
+	Container       string // image to run the command in via docker/podman; empty runs it directly
+	ContainerEngine string // docker, podman or auto (from the config-level container_engine)
+	ImageID         string // resolved image digest, part of the cache key
+	containerSet    bool   // `container` was given for this stage, even if empty
+
+	NixShell string // flake installable whose devShell the command runs in; empty runs it directly
+	NixKey   string // hash of the installable, flake.lock and top-level .nix files, part of the cache key
+	nixSet   bool   // `nix` or `nix_shell` was given for this stage
+
+	ToolVersions string // resolved "tool@version,..." of the tools it runs, part of the cache key
+
+	Sandbox    bool     // run under bubblewrap: read-only project, private /tmp
+	Outputs    []string // paths a sandboxed stage may write: project-relative, ~/ or absolute
+	NoNetwork  bool     // `network = false`: no network inside the sandbox
+	sandboxSet bool     // `sandbox` was given for this stage
+
+	CPUs   int    // cores the stage keeps busy, for --parallel admission (default 1)
+	Memory int64  // peak memory in bytes, for --parallel admission
+	Lock   string // exclusive group: stages sharing a lock never run together
+
+	Matrix       *stageMatrix      // `matrix`: expanded into one stage per combination on load
+	MatrixOf     string            // for a matrix instance, the stage it was expanded from
+	MatrixValues map[string]string // for a matrix instance, its {matrix.*} values
+	matrixIndex  int               // position among its stage's instances
+
+	IfPlatform   []string // run only on these platforms (DetectPlatform names, linux, wsl or a GOOS)
+	IfEnv        []string // run only when these are set: VAR, VAR=value or !VAR
+	IfFilesExist []string // run only when these paths or globs exist in the project
+	IfTool       []string // run only when these tools are installed
+	IfChanged    []string // run only when changed files match these globs
+	SkipReason   string   // why the conditions above are unmet, once checked
 }
 
 func (s *Stage) UnmarshalTOML(data interface{}) error {
@@ -80,6 +121,14 @@ func (s *Stage) UnmarshalTOML(data interface{}) error {
 		return nil
 	}
 
+	// Helper to extract a string or a list of strings
+	getStrings := func(key string) []string {
+		if str, ok := m[key].(string); ok {
+			return []string{str}
+		}
+		return getStringSlice(key)
+	}
+
 	// Helper to extract bool
 	getBool := func(key string) bool {
 		if val, exists := m[key]; exists {
@@ -136,29 +185,89 @@ func (s *Stage) UnmarshalTOML(data interface{}) error {
 	}
 	s.DependsOn = getStringSlice("depends_on")
 	s.Watch = getStringSlice("watch")
+	if env, ok := m["env"].(map[string]interface{}); ok {
+		s.Env = make(map[string]string, len(env))
+		for k, v := range env {
+			s.Env[k] = fmt.Sprint(v)
+		}
+	}
+	s.Dir = getString("dir")
+	s.Artifacts = getStringSlice("artifacts")
+	_, s.containerSet = m["container"]
+	s.Container = getString("container")
+	if sandbox, ok := m["sandbox"].(bool); ok {
+		s.Sandbox, s.sandboxSet = sandbox, true
+	}
+	s.Outputs = getStringSlice("outputs")
+	if network, ok := m["network"].(bool); ok {
+		s.NoNetwork = !network
+	}
+	s.CPUs = getInt("cpus")
+	s.Lock = getString("lock")
+	switch mem := m["memory"].(type) {
+	case string:
+		size, err := parseMemorySize(mem)
+		if err != nil {
+			return err
+		}
+		s.Memory = size
+	case int64:
+		s.Memory = mem << 20
+	}
+	s.IfPlatform = getStrings("if_platform")
+	s.IfEnv = getStrings("if_env")
+	s.IfFilesExist = getStrings("if_files_exist")
+	s.IfTool = getStrings("if_tool")
+	s.IfChanged = getStrings("if_changed")
+	if raw, ok := m["matrix"].(map[string]interface{}); ok {
+		matrix, err := parseStageMatrix(raw)
+		if err != nil {
+			return err
+		}
+		s.Matrix = matrix
+	}
+	if shell, ok := m["nix_shell"].(string); ok {
+		s.NixShell, s.nixSet = shell, true
+	} else if nix, ok := m["nix"].(bool); ok {
+		s.nixSet = true
+		if nix {
+			s.NixShell = "."
+		}
+	}
 
 	return nil
 }
 
 type Result struct {
-	Name     string
-	Command  string
-	Status   string
-	Duration time.Duration
-	Output   string
-	CacheHit bool
-	Error    error
+	Name      string
+	Command   string
+	Status    string
+	Duration  time.Duration
+	Output    string
+	CacheHit  bool
+	Error     error
+	Host      string   // remote host that ran the stage, if any
+	Artifacts []string // collected artifact paths, relative to the project root
+
+	UndeclaredWrites []string // sandboxed writes outside outputs, relative to the project root
+	SkipReason       string   // unmet stage condition, for skips that aren't failures
+	CacheTarget      string   // cache namespace of the remote that ran the stage, if any
 }
 
 // ResultJSON is the JSON-serializable form of Result.
 type ResultJSON struct {
-	Name       string `json:"name"`
-	Command    string `json:"command"`
-	Status     string `json:"status"`
-	DurationMS int64  `json:"duration_ms"`
-	CacheHit   bool   `json:"cache_hit"`
-	Output     string `json:"output,omitempty"`
-	Error      string `json:"error,omitempty"`
+	Name       string   `json:"name"`
+	Command    string   `json:"command"`
+	Status     string   `json:"status"`
+	DurationMS int64    `json:"duration_ms"`
+	CacheHit   bool     `json:"cache_hit"`
+	Output     string   `json:"output,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Host       string   `json:"host,omitempty"`
+	Artifacts  []string `json:"artifacts,omitempty"`
+
+	UndeclaredWrites []string `json:"undeclared_writes,omitempty"`
+	SkipReason       string   `json:"skip_reason,omitempty"`
 }
 
 // PipelineReportJSON is the JSON-serializable execution report of the pipeline.
@@ -166,6 +275,7 @@ type PipelineReportJSON struct {
 	Results    []ResultJSON `json:"results"`
 	Passed     int          `json:"passed"`
 	Failed     int          `json:"failed"`
+	Skipped    int          `json:"skipped,omitempty"` // stages whose conditions were unmet, or that a cancel stopped before they started
 	DurationMS int64        `json:"duration_ms"`
 }
 
@@ -180,6 +290,11 @@ func toJSONResults(results []Result) []ResultJSON {
 			DurationMS: r.Duration.Milliseconds(),
 			CacheHit:   r.CacheHit,
 			Output:     strings.TrimSpace(r.Output),
+			Host:       r.Host,
+			Artifacts:  r.Artifacts,
+
+			UndeclaredWrites: r.UndeclaredWrites,
+			SkipReason:       r.SkipReason,
 		}
 		if r.Error != nil {
 			jr.Error = r.Error.Error()
@@ -200,13 +315,24 @@ func main() {
 		flagAll             = flag.Bool("all", false, "Run all stages including disabled ones")
 		flagRemote          = flag.String("remote", "", "Run remotely on specified SSH host (e.g., user@host)")
 		flagRemoteHost      = flag.String("remote-host", "", "Run remotely using a named preset from .local-ci-remote.toml (`[hosts.<name>]`)")
+		flagRemoteHosts     = flag.String("remote-hosts", "", "Spread stages across several `[hosts.*]` presets (comma-separated), syncing each once")
+		flagMatrix          = flag.String("matrix", "", "Run the pipeline on every listed `[hosts.*]` preset (comma-separated) and print a stage × host grid")
 		flagSession         = flag.String("session", "onion", "tmux session name for remote execution")
+		flagTmux            = flag.Bool("tmux", false, "Run remote stages inside the tmux session (attachable) instead of as direct SSH commands")
 		flagRemoteTimeout   = flag.Int("remote-timeout", 30, "SSH operation timeout in seconds")
+		flagSyncTimeout     = flag.Int("sync-timeout", 0, "Workspace sync timeout in seconds (default: [sync] timeout, or 120)")
 		flagRemoteDir       = flag.String("remote-dir", "", "Remote working directory (defaults to /tmp/<basename>)")
+		flagSSH             = flag.String("ssh", "native", "SSH client for remote runs: native (built-in, one shared connection) or openssh (the ssh binary)")
+		flagNoProbe         = flag.Bool("no-probe", false, "Skip the remote readiness check before remote runs")
 		flagProfile         = flag.String("profile", "", "Use a named profile from config")
 		flagDryRun          = flag.Bool("dry-run", false, "Show what would run without executing")
 		flagParallel        = flag.Int("parallel", 0, "Number of parallel jobs (0 = auto)")
 		flagFailFast        = flag.Bool("fail-fast", false, "Stop on first failure")
+		flagTUI             = flag.Bool("tui", false, "Interactive terminal UI (falls back to plain output when not a terminal)")
+		flagColor           = flag.String("color", "auto", "Colorize output: auto, always or never (auto honors NO_COLOR and CLICOLOR_FORCE)")
+		flagGroups          = flag.Bool("groups", false, "Emit ::group:: log markers (automatic under GitHub Actions)")
+		flagInstallMissing  = flag.Bool("install-missing", false, "Install tools that selected stages need but can't find, before running")
+		flagNoDaemon        = flag.Bool("no-daemon", false, "Run in this process even when a local-ci daemon is serving the workspace")
 	)
 	flagJSON = flag.Bool("json", false, "Output in JSON format")
 
@@ -215,7 +341,14 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Supports: Rust, Python, TypeScript/Bun, Go, Java, and custom projects\n\n")
 		fmt.Fprintf(os.Stderr, "Usage: local-ci [flags] [stages...]\n\n")
 		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  init      Initialize .local-ci.toml for detected project type\n\n")
+		fmt.Fprintf(os.Stderr, "  init      Initialize .local-ci.toml for detected project type\n")
+		fmt.Fprintf(os.Stderr, "  watch     Re-run affected stages whenever watched files change\n")
+		fmt.Fprintf(os.Stderr, "  daemon    Keep a warm hash index and serve runs over a Unix socket\n")
+		fmt.Fprintf(os.Stderr, "            (daemon status|run|cancel|logs [-f]|stop talk to a running daemon)\n")
+		fmt.Fprintf(os.Stderr, "  import    Import stages from a workflow (import github-actions [workflow] [--stdout])\n")
+		fmt.Fprintf(os.Stderr, "  drift     Report where a GitHub Actions workflow and .local-ci.toml diverge\n")
+		fmt.Fprintf(os.Stderr, "  export    Generate hosted CI from .local-ci.toml (export github|gitlab [--stdout] [--force])\n")
+		fmt.Fprintf(os.Stderr, "  doctor    Report the detected project, platform, tools, config, cache and hook (doctor [--json])\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  local-ci              Run enabled stages for your project\n")
 		fmt.Fprintf(os.Stderr, "  local-ci test         Run only the test stage\n")
@@ -228,6 +361,13 @@ func main() {
 	}
 	flag.Parse()
 
+	if err := configureOutput(*flagColor, *flagGroups); err != nil {
+		fatalf("%v", err)
+	}
+	if *flagSSH != "native" && *flagSSH != "openssh" {
+		fatalf("invalid --ssh %q (want native or openssh)", *flagSSH)
+	}
+
 	if *flagVersion {
 		fmt.Printf("local-ci v%s\n", version)
 		return
@@ -240,21 +380,52 @@ func main() {
 
 	// Handle subcommands
 	args := flag.Args()
+	stageArgs := args
+	watchMode := false
 	if len(args) > 0 {
 		if args[0] == "init" {
 			cmdInit(cwd)
 			return
 		} else if args[0] == "serve" {
-			if err := cmdServe(cwd); err != nil {
+			if err := cmdServe(cwd, args[1:]); err != nil {
 				fatalf("MCP server error: %v", err)
 			}
 			return
+		} else if args[0] == "daemon" {
+			os.Exit(cmdDaemon(cwd, args[1:], *flagNoCache, *flagVerbose, *flagJSON))
+		} else if args[0] == "import" {
+			os.Exit(cmdImport(cwd, args[1:]))
+		} else if args[0] == "export" {
+			os.Exit(cmdExport(cwd, args[1:]))
+		} else if args[0] == "drift" {
+			os.Exit(cmdDrift(cwd, args[1:], *flagJSON))
+		} else if args[0] == "remote" {
+			os.Exit(cmdRemote(cwd, args[1:], *flagJSON))
+		} else if args[0] == "doctor" {
+			os.Exit(cmdDoctor(cwd, args[1:], *flagJSON))
+		} else if args[0] == "watch" {
+			watchMode = true
+			stageArgs = args[1:]
+		}
+	}
+
+	// A plain run goes through the daemon when one is serving the workspace,
+	// so it queues behind (rather than races) hook and agent runs and reuses
+	// the warm hash index.
+	useDaemon := !watchMode && !*flagNoDaemon
+	flag.Visit(func(f *flag.Flag) {
+		if !daemonRunFlags[f.Name] {
+			useDaemon = false
 		}
+	})
+	if useDaemon && daemonAvailable(cwd) {
+		printf("🛰  Running through the local-ci daemon (--no-daemon to run here)\n")
+		os.Exit(runViaDaemon(cwd, stageArgs, *flagNoCache, *flagVerbose, *flagJSON))
 	}
 
 	// Load configuration. Pull the remote overlay whenever remote mode or
 	// preset listing is requested — `--remote-host` resolves [hosts.*].
-	needRemoteCfg := *flagRemote != "" || *flagRemoteHost != "" || *flagListRemoteHosts
+	needRemoteCfg := *flagRemote != "" || *flagRemoteHost != "" || *flagRemoteHosts != "" || *flagMatrix != "" || *flagListRemoteHosts
 	config, err := LoadConfig(cwd, needRemoteCfg)
 	if err != nil {
 		fatalf("Failed to load config: %v", err)
@@ -286,17 +457,18 @@ func main() {
 	// values. Explicit CLI flags always win over preset fields, so e.g.
 	// `--remote-host aivcs2 --session experiment` reuses the preset's host
 	// but overrides its session for this one run.
+	userSetSession := false
+	userSetRemoteDir := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "session":
+			userSetSession = true
+		case "remote-dir":
+			userSetRemoteDir = true
+		}
+	})
+	var presetCacheTarget string
 	if *flagRemoteHost != "" {
-		userSetSession := false
-		userSetRemoteDir := false
-		flag.Visit(func(f *flag.Flag) {
-			switch f.Name {
-			case "session":
-				userSetSession = true
-			case "remote-dir":
-				userSetRemoteDir = true
-			}
-		})
 		resolved, err := config.ResolveRemoteHost(
 			*flagRemoteHost,
 			*flagRemote, *flagSession, *flagRemoteDir,
@@ -308,6 +480,7 @@ func main() {
 		*flagRemote = resolved.Host
 		*flagSession = resolved.Session
 		*flagRemoteDir = resolved.RemoteDir
+		presetCacheTarget = resolved.CacheTarget
 		if *flagVerbose {
 			printf("📍 Using host preset %q → %s\n", *flagRemoteHost, *flagRemote)
 		}
@@ -318,6 +491,47 @@ func main() {
 		*flagRemote = NormalizeSSHHost(*flagRemote, remotePlatformMacOS, config.SSHDefaults)
 	}
 
+	// Remote targets: one for --remote/--remote-host, or one per preset
+	// for a --remote-hosts pool or a --matrix run.
+	var remotes []*RemoteExecutor
+	var remotePresets []string
+	remoteTimeout := time.Duration(*flagRemoteTimeout) * time.Second
+	if *flagRemoteHosts != "" && *flagMatrix != "" {
+		fatalf("--remote-hosts and --matrix cannot be combined")
+	}
+	if presets := *flagRemoteHosts + *flagMatrix; presets != "" {
+		if *flagRemote != "" || *flagRemoteHost != "" {
+			fatalf("--remote-hosts and --matrix cannot be combined with --remote or --remote-host")
+		}
+		for _, name := range splitHostList(presets) {
+			resolved, err := config.ResolveRemoteHost(name, "", *flagSession, *flagRemoteDir, userSetSession, userSetRemoteDir)
+			if err != nil {
+				fatalf("%v", err)
+			}
+			re := NewRemoteExecutor(resolved.Host, resolved.Session, remoteWorkDir(resolved.RemoteDir, cwd), remoteTimeout, *flagVerbose)
+			re.CacheTarget = resolved.CacheTarget
+			remotes = append(remotes, re)
+			remotePresets = append(remotePresets, name)
+		}
+	} else if *flagRemote != "" {
+		re := NewRemoteExecutor(*flagRemote, *flagSession, remoteWorkDir(*flagRemoteDir, cwd), remoteTimeout, *flagVerbose)
+		re.CacheTarget = presetCacheTarget
+		remotes = append(remotes, re)
+	}
+	for _, re := range remotes {
+		re.OpenSSH = *flagSSH == "openssh"
+		re.Tmux = *flagTmux
+		re.LocalDir = cwd
+	}
+	if len(remotes) > 0 {
+		if err := config.Sync.Validate(); err != nil {
+			fatalf("%v", err)
+		}
+		if *flagSyncTimeout > 0 {
+			config.Sync.Timeout = *flagSyncTimeout
+		}
+	}
+
 	// Apply profile if specified
 	if *flagProfile != "" {
 		profile, ok := config.Profiles[*flagProfile]
@@ -345,9 +559,10 @@ func main() {
 
 		// Enable only stages from the profile
 		for _, stageName := range profile.Stages {
-			if stage, ok := config.Stages[stageName]; ok {
+			for _, name := range config.StagesNamed(stageName) {
+				stage := config.Stages[name]
 				stage.Enabled = true
-				config.Stages[stageName] = stage
+				config.Stages[name] = stage
 			}
 		}
 	}
@@ -371,27 +586,16 @@ func main() {
 		return
 	}
 
-	// Build stage list from config
+	// Build stage list from config. If no stages are specified, use the
+	// enabled defaults.
 	stageMap := config.Stages
-	var stages []Stage
-	for _, name := range flag.Args() {
-		if stage, ok := stageMap[name]; ok {
-			stages = append(stages, stage)
-		}
-	}
-
-	// If no stages specified, use enabled defaults
-	if len(stages) == 0 {
-		for _, name := range config.GetEnabledStages() {
-			stages = append(stages, stageMap[name])
-		}
-	}
+	stages := config.SelectStages(stageArgs)
 
 	// If --all, run every configured stage including disabled ones.
 	// (Disabled stages are never added above, so rebuild the list from all
 	// configured stages and force them enabled.)
 	if *flagAll {
-		if len(flag.Args()) == 0 {
+		if len(stageArgs) == 0 {
 			stages = stages[:0]
 			for _, name := range config.GetAllStages() {
 				stage := stageMap[name]
@@ -406,6 +610,35 @@ func main() {
 		}
 	}
 
+	// Container image IDs, devShell inputs and tool versions are part of
+	// the cache key, so resolve them before any cache lookups. Remote hosts run stage
+	// commands directly.
+	probes := versionProbes{}
+	if len(remotes) == 0 {
+		if err := resolveStageBackends(stages, cwd, !*flagDryRun, probes); err != nil {
+			warnf("Warning: %v\n", err)
+		}
+	} else {
+		for _, stage := range stages {
+			if stage.Container != "" {
+				warnf("Warning: %s: container %q is ignored on remote hosts\n", stage.Name, stage.Container)
+			}
+			if stage.NixShell != "" {
+				warnf("Warning: %s: nix_shell %q is ignored on remote hosts\n", stage.Name, stage.NixShell)
+			}
+			if stage.Sandbox {
+				warnf("Warning: %s: sandbox is ignored on remote hosts\n", stage.Name)
+			}
+			if len(stage.IfPlatform) > 0 || len(stage.IfTool) > 0 {
+				warnf("Warning: %s: if_platform and if_tool are not checked on remote hosts\n", stage.Name)
+			}
+		}
+	}
+	applyStageConditions(stages, newConditionEnv(cwd), len(remotes) > 0)
+
+	// The TUI applies fix commands itself so fix mode can be toggled live
+	tuiStages := append([]Stage(nil), stages...)
+
 	// If --fix, modify fmt stage
 	if *flagFix {
 		for i := range stages {
@@ -432,28 +665,61 @@ func main() {
 	if cache == nil {
 		cache = make(map[string]string)
 	}
+	coldCache := len(cache) == 0
 
 	stageHashes, stageHashErr := computeStageHashes(cwd, config, ws, stages)
 	if stageHashErr != nil && *flagVerbose {
 		warnf("Warning: per-stage hash computation failed: %v\n", stageHashErr)
 	}
 
+	if watchMode {
+		if len(remotes) > 0 {
+			fatalf("watch mode runs stages locally; --remote is not supported")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		w := &watchSession{
+			Root:    cwd,
+			Config:  config,
+			Ws:      ws,
+			Stages:  stages,
+			Cache:   cache,
+			NoCache: *flagNoCache,
+			Verbose: *flagVerbose,
+		}
+		if err := w.Run(ctx); err != nil {
+			fatalf("Watch failed: %v", err)
+		}
+		return
+	}
+
 	// Handle dry-run mode
 	if *flagDryRun {
 		var remote *DryRunRemote
-		if *flagRemote != "" {
-			workDir := *flagRemoteDir
-			if workDir == "" {
-				workDir = filepath.Join("/tmp", filepath.Base(cwd))
-			}
+		var targets []string
+		for _, re := range remotes {
+			targets = append(targets, re.cacheTarget())
+		}
+		if len(remotes) == 1 {
 			remote = &DryRunRemote{
-				Host:       *flagRemote,
-				Session:    *flagSession,
-				WorkDir:    workDir,
-				HostPreset: *flagRemoteHost,
+				Host:        remotes[0].Host,
+				Session:     remotes[0].Session,
+				WorkDir:     remotes[0].WorkDir,
+				HostPreset:  *flagRemoteHost,
+				CacheTarget: remotes[0].cacheTarget(),
 			}
 		}
-		report := BuildDryRunReport(stages, cache, stageHashes, sourceHash, *flagNoCache, remote)
+		// Matrix runs always execute, so the cache only informs the report.
+		report := BuildDryRunReport(stages, cache, stageHashes, sourceHash, *flagNoCache || *flagMatrix != "", remote, targets)
+		if len(remotePresets) > 0 {
+			report.Remote = nil
+			for i, re := range remotes {
+				report.Pool = append(report.Pool, DryRunRemote{Host: re.Host, Session: re.Session, WorkDir: re.WorkDir, HostPreset: remotePresets[i], CacheTarget: re.cacheTarget()})
+			}
+		}
+		if *flagParallel > 0 && len(remotes) == 0 {
+			report.AddSchedule(stages, *flagParallel, localResourceBudget())
+		}
 		if *flagJSON {
 			PrintDryRunJSON(report)
 		} else {
@@ -462,15 +728,134 @@ func main() {
 		return
 	}
 
+	// Check that every remote host has the tools the run needs before
+	// syncing anything. Passing probes are cached for a few minutes.
+	if len(remotes) > 0 && !*flagNoProbe {
+		tools := remoteToolRequirements(stages, *flagTmux, config.Sync.Git)
+		if err := ensureRemotesReady(cwd, remotes, tools); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	// Likewise, resolve every program the local stages that will run need,
+	// so a missing tool fails up front instead of mid-pipeline.
+	if len(remotes) == 0 {
+		var pending []Stage
+		for _, s := range stages {
+			hash, ok := stageHashes[s.Name]
+			if !ok {
+				hash = sourceHash
+			}
+			if *flagNoCache || !cacheHit(cache, s, hash) {
+				pending = append(pending, s)
+			}
+		}
+		check, err := checkStageTools(pending, cwd, config.Tools, *flagInstallMissing, probes)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if warning := check.warning(); warning != "" {
+			warnf("%s", warning)
+		}
+		hint := "Install them, or rerun with --install-missing."
+		if *flagInstallMissing {
+			hint = ""
+		}
+		if failure := check.failure(hint); failure != "" {
+			errorf("%s", failure)
+			os.Exit(1)
+		}
+	}
+
 	// Run stages
 	var results []Result
 	start := time.Now()
 
-	// Use parallel runner if requested
-	if *flagParallel > 0 {
-		if *flagRemote != "" {
-			fatalf("Cannot use --parallel and --remote together; run remote stages sequentially")
+	useTUI := *flagTUI
+	if useTUI && (*flagJSON || len(remotes) > 0 || !tuiSupported()) {
+		warnf("--tui needs a local run on an interactive terminal; using plain output\n")
+		useTUI = false
+	}
+
+	if useTUI {
+		app := newTUIApp(tuiStages, cwd, cache, sourceHash, stageHashes, *flagNoCache, *flagFix)
+		results, err = app.Run(*flagParallel, *flagFailFast)
+		if err != nil {
+			fatalf("TUI failed: %v", err)
+		}
+	} else if *flagMatrix != "" {
+		// Host matrix: the whole pipeline on every host, concurrently.
+		var targets []matrixTarget
+		for i, re := range remotes {
+			preset, _ := config.GetRemoteHost(remotePresets[i])
+			re.ArtifactsLabel = remotePresets[i]
+			targets = append(targets, matrixTarget{Name: remotePresets[i], Platform: preset.effectivePlatform(remotePresets[i]), Exec: re})
+		}
+
+		printf("🔄 Synchronizing local workspace to %d remote host(s)...\n", len(remotes))
+		syncCtx, syncCancel := context.WithTimeout(context.Background(), config.Sync.TimeoutDuration())
+		err := syncRemoteHosts(syncCtx, remotes, cwd, config.syncOptions(nil))
+		syncCancel()
+		if err != nil {
+			fatalf("Failed to sync workspace to remote: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+		err = ensureRemoteSessions(ctx, remotes)
+		cancel()
+		if err != nil {
+			fatalf("Failed to initialize remote tmux session: %v", err)
+		}
+
+		printf("🚀 Running local CI pipeline on %s...\n\n", strings.Join(remotePresets, ", "))
+		matrix := runMatrix(targets, stages, *flagParallel, *flagFailFast, *flagVerbose)
+		for _, re := range remotes {
+			re.Close()
 		}
+		os.Exit(printMatrixReport(matrix, *flagJSON))
+	} else if len(remotes) > 1 || (len(remotes) == 1 && *flagParallel > 0) {
+		// Remote parallel execution: each host runs --parallel stages at a
+		// time (one per host without it), each on its own SSH channel or
+		// tmux window. Dependencies are resolved across the whole pool.
+		pool := newRemotePool(remotes, *flagParallel)
+		pool.Verbose = *flagVerbose
+
+		printf("🔄 Synchronizing local workspace to %d remote host(s)...\n", len(remotes))
+		syncCtx, syncCancel := context.WithTimeout(context.Background(), config.Sync.TimeoutDuration())
+		err := pool.Sync(syncCtx, cwd, config.syncOptions(nil))
+		syncCancel()
+		if err != nil {
+			fatalf("Failed to sync workspace to remote: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+		err = pool.EnsureSessions(ctx)
+		cancel()
+		if err != nil {
+			fatalf("Failed to initialize remote tmux session: %v", err)
+		}
+
+		hostNames := make([]string, len(remotes))
+		for i, re := range remotes {
+			hostNames[i] = re.Host
+		}
+		printf("🚀 Running local CI pipeline remotely on %s (%d at a time)...\n\n", strings.Join(hostNames, ", "), pool.Concurrency())
+		runner := &ParallelRunner{
+			Stages:       stages,
+			Concurrency:  pool.Concurrency(),
+			Cwd:          cwd,
+			NoCache:      *flagNoCache,
+			Cache:        cache,
+			SourceHash:   sourceHash,
+			StageHashes:  stageHashes,
+			Verbose:      *flagVerbose,
+			JSON:         *flagJSON,
+			FailFast:     *flagFailFast,
+			Execute:      pool.Execute,
+			CacheTargets: remoteCacheTargets(remotes),
+		}
+		results = runner.Run()
+		pool.Close()
+	} else if *flagParallel > 0 {
+		// Use parallel runner if requested
 		runner := &ParallelRunner{
 			Stages:      stages,
 			Concurrency: *flagParallel,
@@ -482,36 +867,47 @@ func main() {
 			Verbose:     *flagVerbose,
 			JSON:        *flagJSON,
 			FailFast:    *flagFailFast,
+			Budget:      localResourceBudget(),
 		}
 		results = runner.Run()
-	} else if *flagRemote != "" {
-		// Remote sequential execution via SSH+tmux
-		workDir := *flagRemoteDir
-		if workDir == "" {
-			workDir = filepath.Join("/tmp", filepath.Base(cwd))
-		}
-		re := NewRemoteExecutor(*flagRemote, *flagSession, workDir, time.Duration(*flagRemoteTimeout)*time.Second, *flagVerbose)
+	} else if len(remotes) == 1 {
+		// Remote sequential execution via SSH (optionally inside tmux)
+		re := remotes[0]
 
 		// Sync local workspace to remote
 		printf("🔄 Synchronizing local workspace to remote...\n")
-		syncCtx, syncCancel := context.WithTimeout(context.Background(), 2*time.Minute)
-		if err := re.SyncWorkspace(syncCtx, cwd, config.Cache.SkipDirs); err != nil {
+		syncCtx, syncCancel := context.WithTimeout(context.Background(), config.Sync.TimeoutDuration())
+		if err := re.SyncWorkspace(syncCtx, cwd, config.syncOptions(nil)); err != nil {
 			syncCancel()
 			fatalf("Failed to sync workspace to remote: %v", err)
 		}
 		syncCancel()
 
-		printf("🚀 Running local CI pipeline remotely on %s...\n\n", *flagRemote)
+		printf("🚀 Running local CI pipeline remotely on %s...\n\n", re.Host)
 
 		// Ensure remote session exists
-		ctx, cancel := context.WithTimeout(context.Background(), re.Timeout)
-		if err := re.EnsureRemoteSession(ctx); err != nil {
+		if re.Tmux {
+			ctx, cancel := context.WithTimeout(context.Background(), re.Timeout)
+			if err := re.EnsureRemoteSession(ctx); err != nil {
+				cancel()
+				fatalf("Failed to initialize remote tmux session: %v", err)
+			}
 			cancel()
-			fatalf("Failed to initialize remote tmux session: %v", err)
+			printf("📋 Attach with: ssh -t %s tmux attach -t %s (logs in %s)\n\n", re.Host, re.Session, re.LogDir())
+		}
+
+		// Stream remote output as it arrives; JSON mode keeps it in the report.
+		var live io.Writer
+		if !*flagJSON {
+			live = os.Stdout
 		}
-		cancel()
 
 		for _, stage := range stages {
+			if stage.SkipReason != "" {
+				printf("- %s (skipped: %s)\n", stage.Name, stage.SkipReason)
+				results = append(results, skipResult(stage))
+				continue
+			}
 			stageStart := time.Now()
 
 			// Compute per-stage hash for granular caching
@@ -531,8 +927,8 @@ func main() {
 				}
 			}
 
-			// Check local cache
-			if !*flagNoCache && cacheHit(cache, stage, stageHash) {
+			// Check the cache entry for this host
+			if !*flagNoCache && cacheHitOn(cache, stage, stageHash, re.cacheTarget()) {
 				if *flagVerbose {
 					printf("✓ %s (cached)\n", stage.Name)
 				}
@@ -546,10 +942,10 @@ func main() {
 			}
 
 			// Print stage header
-			printf("::group::%s\n", stage.Name)
+			groupStart(stage.Name)
 			if len(stage.Cmd) == 0 {
 				printf("Error: Stage has no command defined\n")
-				printf("::endgroup::\n")
+				groupEnd()
 				printf("✗ %s (failed)\n", stage.Name)
 				results = append(results, Result{
 					Name:     stage.Name,
@@ -568,40 +964,42 @@ func main() {
 			}
 
 			// Run stage remotely
-			result := re.ExecuteStage(stage)
+			result := re.RunStage(context.Background(), stage, live)
 			duration := time.Since(stageStart)
 			result.Duration = duration // set actual local time spent
 
 			if result.Status == "fail" {
-				if result.Output != "" {
+				if live == nil && result.Output != "" {
 					printf("%s\n", result.Output)
-				} else if result.Error != nil {
+				}
+				if result.Error != nil {
 					printf("Error: %v\n", result.Error)
 				}
-				printf("::endgroup::\n")
+				groupEnd()
 				printf("✗ %s (failed)\n", stage.Name)
 				results = append(results, result)
 				if *flagFailFast {
 					break
 				}
 			} else {
-				if *flagVerbose && result.Output != "" {
-					printf("%s\n", result.Output)
-				}
-				printf("::endgroup::\n")
+				groupEnd()
 				printf("✓ %s (%dms)\n", stage.Name, result.Duration.Milliseconds())
 				results = append(results, result)
 				// Update cache
-				cache[stage.Name] = cacheKeyForStage(stage, stageHash)
+				cache[cacheEntryName(stage.Name, re.cacheTarget())] = cacheKeyForStage(stage, stageHash)
 			}
 		}
+		re.Close()
 	} else {
 		// Sequential execution
 		printf("🚀 Running local CI pipeline...\n\n")
 
 		for _, stage := range stages {
-			stageStart := time.Now()
-
+			if stage.SkipReason != "" {
+				printf("- %s (skipped: %s)\n", stage.Name, stage.SkipReason)
+				results = append(results, skipResult(stage))
+				continue
+			}
 			// Compute per-stage hash for granular caching
 			stageHash := sourceHash
 			if len(stage.Watch) > 0 {
@@ -634,10 +1032,10 @@ func main() {
 			}
 
 			// Print stage header
-			printf("::group::%s\n", stage.Name)
+			groupStart(stage.Name)
 			if len(stage.Cmd) == 0 {
 				printf("Error: Stage has no command defined\n")
-				printf("::endgroup::\n")
+				groupEnd()
 				printf("✗ %s (failed)\n", stage.Name)
 				results = append(results, Result{
 					Name:     stage.Name,
@@ -657,45 +1055,20 @@ func main() {
 			}
 
 			// Run stage with timeout
-			timeout := time.Duration(stage.Timeout) * time.Second
-			if timeout == 0 {
-				timeout = 30 * time.Second
-			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			cmd := exec.CommandContext(ctx, stage.Cmd[0], stage.Cmd[1:]...)
-			var out bytes.Buffer
-			cmd.Stdout = &out
-			cmd.Stderr = &out
-			cmd.Dir = cwd
+			result := runLocalStage(context.Background(), stage, cwd, nil)
 
-			err := cmd.Run()
-			cancel()
-			duration := time.Since(stageStart)
-
-			if err != nil {
-				printf("%s\n", out.String()) // Show output even if not verbose
-				printf("::endgroup::\n")
+			if result.Status != "pass" {
+				printf("%s\n", result.Output) // Show output even if not verbose
+				groupEnd()
 				printf("✗ %s (failed)\n", stage.Name)
-				results = append(results, Result{
-					Name:     stage.Name,
-					Status:   "fail",
-					Duration: duration,
-					Error:    err,
-					Output:   out.String(),
-				})
+				results = append(results, result)
 			} else {
 				if *flagVerbose {
-					printf("%s\n", out.String())
+					printf("%s\n", result.Output)
 				}
-				printf("::endgroup::\n")
-				printf("✓ %s (%dms)\n", stage.Name, duration.Milliseconds())
-				results = append(results, Result{
-					Name:     stage.Name,
-					Status:   "pass",
-					Duration: duration,
-					Output:   out.String(),
-				})
+				groupEnd()
+				printf("✓ %s (%dms)\n", stage.Name, result.Duration.Milliseconds())
+				results = append(results, result)
 				// Update cache with per-stage hash
 				cache[stage.Name] = cacheKeyForStage(stage, stageHash)
 			}
@@ -714,10 +1087,13 @@ func main() {
 	failCount := 0
 	cachedCount := 0
 	executedCount := 0
+	var skipped []Result
 	totalTime := time.Duration(0)
 
 	for _, r := range results {
-		if r.Status == "pass" {
+		if r.SkipReason != "" {
+			skipped = append(skipped, r)
+		} else if r.Status == "pass" {
 			passCount++
 			if r.CacheHit {
 				cachedCount++
@@ -731,11 +1107,13 @@ func main() {
 		}
 	}
 
-	// Summary line
+	// Summary line. Stages skipped by their conditions didn't run, so they
+	// are left out of the fractions.
+	ran := len(results) - len(skipped)
 	if failCount == 0 {
-		successf("✅ All %d stage(s) passed in %dms\n", len(results), totalDuration.Milliseconds())
+		successf("✅ All %d stage(s) passed in %dms\n", ran, totalDuration.Milliseconds())
 	} else {
-		errorf("❌ %d/%d stages failed\n", failCount, len(results))
+		errorf("❌ %d/%d stages failed\n", failCount, ran)
 	}
 
 	// Statistics
@@ -746,13 +1124,29 @@ func main() {
 		printf("  Failed: %d\n", failCount)
 	}
 	if cachedCount > 0 {
-		printf("  Cached: %d (%.0f%%)\n", cachedCount, float64(cachedCount)*100/float64(len(results)))
+		printf("  Cached: %d (%.0f%%)\n", cachedCount, float64(cachedCount)*100/float64(ran))
 	}
 	if executedCount > 0 {
 		printf("  Executed: %d\n", executedCount)
 	}
+	if len(skipped) > 0 {
+		printf("  Skipped: %d\n", len(skipped))
+		for _, r := range skipped {
+			printf("    %s: %s\n", r.Name, r.SkipReason)
+		}
+	}
+	if hosts := hostSummary(results); hosts != "" {
+		printf("  Hosts: %s\n", hosts)
+	}
+	if artifacts := artifactSummary(results); artifacts != "" {
+		printf("  Artifacts: %s\n", artifacts)
+	}
 	printf("  Total time: %dms\n", totalDuration.Milliseconds())
 
+	if githubActions(os.Getenv) {
+		reportToGitHub(cwd, results, totalDuration, coldCache && !*flagNoCache)
+	}
+
 	// Show missing tools (optional)
 	missingTools := GetMissingToolsWithHints(DetectProjectKind(cwd))
 	if len(missingTools) > 0 {
@@ -764,6 +1158,7 @@ func main() {
 			Results:    toJSONResults(results),
 			Passed:     passCount,
 			Failed:     failCount,
+			Skipped:    len(skipped),
 			DurationMS: totalDuration.Milliseconds(),
 		}
 		data, err := json.MarshalIndent(report, "", "  ")
@@ -811,117 +1206,19 @@ func computeStageHashes(root string, config *Config, ws *Workspace, stages []Sta
 	return result, nil
 }
 
-// computeSourceHash computes MD5 hash of Rust source files
+// computeSourceHash computes the MD5 hash of every file matching the cache
+// include patterns.
 func computeSourceHash(root string, config *Config, ws *Workspace) (string, error) {
-	h := md5.New()
-
-	// Build skip set from config
-	skipDirs := make(map[string]bool)
-	for _, dir := range config.Cache.SkipDirs {
-		skipDirs[dir] = true
-	}
-
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories in config
-		if d.IsDir() {
-			if skipDirs[d.Name()] {
-				return filepath.SkipDir
-			}
-		}
-
-		// Skip excluded workspace members
-		if ws != nil && !ws.IsSingle {
-			relPath, err := filepath.Rel(root, path)
-			if err == nil && ws.IsExcluded(relPath) {
-				if d.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-		}
-
-		// Hash files matching include patterns
-		if !d.IsDir() {
-			if matchesPatterns(d.Name(), config.Cache.IncludePatterns) {
-				data, err := os.ReadFile(path)
-				if err != nil {
-					return nil // Skip unreadable files
-				}
-				h.Write(data)
-			}
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	return hashTree(root, config, ws, config.Cache.IncludePatterns, nil)
 }
 
 // computeStageHash computes MD5 hash for a specific stage based on its watch patterns
 func computeStageHash(stage Stage, root string, config *Config, ws *Workspace) (string, error) {
-	h := md5.New()
-
 	// If no watch patterns, use global hash
 	if len(stage.Watch) == 0 {
 		return computeSourceHash(root, config, ws)
 	}
-
-	// Build skip set from config
-	skipDirs := make(map[string]bool)
-	for _, dir := range config.Cache.SkipDirs {
-		skipDirs[dir] = true
-	}
-
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories in config
-		if d.IsDir() {
-			if skipDirs[d.Name()] {
-				return filepath.SkipDir
-			}
-		}
-
-		// Skip excluded workspace members
-		if ws != nil && !ws.IsSingle {
-			relPath, err := filepath.Rel(root, path)
-			if err == nil && ws.IsExcluded(relPath) {
-				if d.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-		}
-
-		// Hash files matching watch patterns
-		if !d.IsDir() {
-			if matchesPatterns(d.Name(), stage.Watch) {
-				data, err := os.ReadFile(path)
-				if err != nil {
-					return nil // Skip unreadable files
-				}
-				h.Write(data)
-			}
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	return hashTree(root, config, ws, stage.Watch, nil)
 }
 
 // loadCache loads the cache from .local-ci-cache
@@ -934,20 +1231,29 @@ func loadCache(root string) (map[string]string, error) {
 		return cache, nil // Cache doesn't exist, return empty
 	}
 
-	// Simple format: stage:hash\n
+	// Simple format: stage:hash\n, with ":" escaped in the stage name
 	for _, line := range strings.Split(string(data), "\n") {
 		if line == "" {
 			continue
 		}
 		parts := strings.SplitN(line, ":", 2)
 		if len(parts) == 2 {
-			cache[parts[0]] = parts[1]
+			cache[cacheNameDecoder.Replace(parts[0])] = parts[1]
 		}
 	}
 
 	return cache, nil
 }
 
+// Cache entry names can contain ":" (matrix values such as rust:1.80, or a
+// host:port target), so it is percent-encoded in the file. The key side
+// holds a command and can't be split on either, so the first ":" is the
+// separator. Names written before the encoding contain neither "%" nor ":".
+var (
+	cacheNameEncoder = strings.NewReplacer("%", "%25", ":", "%3A", "\n", "%0A")
+	cacheNameDecoder = strings.NewReplacer("%25", "%", "%3A", ":", "%0A", "\n")
+)
+
 // saveCache saves the cache to .local-ci-cache
 func saveCache(cache map[string]string, root string) error {
 	keys := make([]string, 0, len(cache))
@@ -958,7 +1264,7 @@ func saveCache(cache map[string]string, root string) error {
 
 	var lines []string
 	for _, stage := range keys {
-		lines = append(lines, fmt.Sprintf("%s:%s", stage, cache[stage]))
+		lines = append(lines, fmt.Sprintf("%s:%s", cacheNameEncoder.Replace(stage), cache[stage]))
 	}
 
 	cachePath := filepath.Join(root, ".local-ci-cache")
@@ -997,6 +1303,7 @@ func cmdInit(root string) {
 	// Update .gitignore
 	gitignorePath := filepath.Join(root, ".gitignore")
 	updateGitignore(gitignorePath, ".local-ci-cache")
+	updateGitignore(gitignorePath, ".local-ci/")
 	successf("✅ Updated .gitignore\n")
 
 	// Try to create pre-commit hook if .git exists
@@ -1042,27 +1349,21 @@ func updateGitignore(path string, entry string) error {
 
 // Printing helpers
 func printf(format string, args ...interface{}) {
-	if flagJSON != nil && *flagJSON {
-		fmt.Fprintf(os.Stderr, format, args...)
-	} else {
-		fmt.Fprintf(os.Stdout, format, args...)
-	}
+	w, _ := humanStream()
+	fmt.Fprint(w, decorate(fmt.Sprintf(format, args...), "", false))
 }
 
 func successf(format string, args ...interface{}) {
-	if flagJSON != nil && *flagJSON {
-		fmt.Fprintf(os.Stderr, "\033[32m"+format+"\033[0m", args...)
-	} else {
-		fmt.Fprintf(os.Stdout, "\033[32m"+format+"\033[0m", args...)
-	}
+	w, color := humanStream()
+	fmt.Fprint(w, decorate(fmt.Sprintf(format, args...), "32", color))
 }
 
 func errorf(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "\033[31m"+format+"\033[0m", args...)
+	fmt.Fprint(os.Stderr, decorate(fmt.Sprintf(format, args...), "31", output.colorStderr))
 }
 
 func warnf(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "\033[33m"+format+"\033[0m", args...)
+	fmt.Fprint(os.Stderr, decorate(fmt.Sprintf(format, args...), "33", output.colorStderr))
 }
 
 func fatalf(format string, args ...interface{}) {
@@ -1084,8 +1385,10 @@ func validateStageCommands(stages []Stage) error {
 		if len(s.Cmd) == 0 {
 			return fmt.Errorf("stage %q has empty command", s.Name)
 		}
-		if err := requireCommand(s.Cmd[0]); err != nil {
-			return fmt.Errorf("stage %q requires %q: %w", s.Name, s.Cmd[0], err)
+		for _, program := range stagePrograms(s) {
+			if err := requireCommand(program); err != nil {
+				return fmt.Errorf("stage %q requires %q: %w", s.Name, program, err)
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// matrixTarget is one column of a --matrix run: a host preset and the
+// executor that runs the pipeline there.
+type matrixTarget struct {
+	Name     string // preset name from .local-ci-remote.toml
+	Platform string
+	Exec     *RemoteExecutor
+}
+
+// MatrixReport holds a stage × host grid of results.
+type MatrixReport struct {
+	Targets  []matrixTarget
+	Stages   []string
+	Results  map[string]map[string]Result // stage -> target name -> result
+	Duration time.Duration
+}
+
+// Failed counts failing cells.
+func (m *MatrixReport) Failed() int {
+	n := 0
+	for _, row := range m.Results {
+		for _, r := range row {
+			if r.Status != "pass" {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// runMatrix runs stages on every target concurrently. Within a host, up to
+// perHost stages run at once and depends_on is honored; a failure on one
+// host never stops the others.
+func runMatrix(targets []matrixTarget, stages []Stage, perHost int, failFast, verbose bool) *MatrixReport {
+	start := time.Now()
+	report := &MatrixReport{
+		Targets: targets,
+		Results: make(map[string]map[string]Result, len(stages)),
+	}
+	for _, s := range stages {
+		report.Stages = append(report.Stages, s.Name)
+		report.Results[s.Name] = make(map[string]Result, len(targets))
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target matrixTarget) {
+			defer wg.Done()
+			pool := newRemotePool([]*RemoteExecutor{target.Exec}, perHost)
+			pool.Verbose = verbose
+			runner := &ParallelRunner{
+				Stages:      stages,
+				Concurrency: pool.Concurrency(),
+				NoCache:     true,
+				Cache:       map[string]string{},
+				FailFast:    failFast,
+				Execute:     pool.Execute,
+			}
+			results := runner.Run()
+			mu.Lock()
+			defer mu.Unlock()
+			for _, r := range results {
+				report.Results[r.Name][target.Name] = r
+			}
+		}(target)
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	return report
+}
+
+// matrixCell renders one grid cell, e.g. "✓ 1.2s" or "✗ 3.4s".
+func matrixCell(r Result, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	switch r.Status {
+	case "pass":
+		return fmt.Sprintf("✓ %.1fs", r.Duration.Seconds())
+	case "skip":
+		return "skipped"
+	default:
+		return fmt.Sprintf("✗ %.1fs", r.Duration.Seconds())
+	}
+}
+
+// FormatGrid renders the stage × host table.
+func (m *MatrixReport) FormatGrid() string {
+	header := []string{"stage"}
+	for _, t := range m.Targets {
+		col := t.Name
+		if t.Platform != "" {
+			col += " (" + t.Platform + ")"
+		}
+		header = append(header, col)
+	}
+	rows := [][]string{header}
+	for _, stage := range m.Stages {
+		row := []string{stage}
+		for _, t := range m.Targets {
+			r, ok := m.Results[stage][t.Name]
+			row = append(row, matrixCell(r, ok))
+		}
+		rows = append(rows, row)
+	}
+	return formatTable(rows)
+}
+
+// MatrixHostJSON describes one matrix column.
+type MatrixHostJSON struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Platform string `json:"platform,omitempty"`
+}
+
+// MatrixStageJSON is one matrix row, keyed by host preset name.
+type MatrixStageJSON struct {
+	Name    string                `json:"name"`
+	Results map[string]ResultJSON `json:"results"`
+}
+
+// MatrixReportJSON is the JSON form of a --matrix run.
+type MatrixReportJSON struct {
+	Hosts      []MatrixHostJSON  `json:"hosts"`
+	Stages     []MatrixStageJSON `json:"stages"`
+	Passed     int               `json:"passed"`
+	Failed     int               `json:"failed"`
+	DurationMS int64             `json:"duration_ms"`
+}
+
+// JSON converts the report for --json output.
+func (m *MatrixReport) JSON() MatrixReportJSON {
+	out := MatrixReportJSON{DurationMS: m.Duration.Milliseconds()}
+	for _, t := range m.Targets {
+		out.Hosts = append(out.Hosts, MatrixHostJSON{Name: t.Name, Host: t.Exec.Host, Platform: t.Platform})
+	}
+	for _, stage := range m.Stages {
+		row := MatrixStageJSON{Name: stage, Results: make(map[string]ResultJSON)}
+		for _, t := range m.Targets {
+			r, ok := m.Results[stage][t.Name]
+			if !ok {
+				continue
+			}
+			row.Results[t.Name] = toJSONResults([]Result{r})[0]
+			if r.Status == "pass" {
+				out.Passed++
+			} else {
+				out.Failed++
+			}
+		}
+		out.Stages = append(out.Stages, row)
+	}
+	return out
+}
+
+// printMatrixReport prints the grid (or JSON) and returns the exit code.
+func printMatrixReport(m *MatrixReport, asJSON bool) int {
+	if asJSON {
+		data, _ := json.MarshalIndent(m.JSON(), "", "  ")
+		fmt.Println(string(data))
+	} else {
+		printf("\n%s\n", m.FormatGrid())
+		if failed := m.Failed(); failed == 0 {
+			successf("✅ All stages passed on %d host(s) in %dms\n", len(m.Targets), m.Duration.Milliseconds())
+		} else {
+			errorf("❌ %d stage run(s) failed across %d host(s)\n", failed, len(m.Targets))
+		}
+	}
+	if m.Failed() > 0 {
+		return 1
+	}
+	return 0
+}
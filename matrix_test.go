@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMatrixGrid(t *testing.T) {
+	home, pub := sshTestHome(t)
+	mac := startTestSSHServer(t, pub)
+	linux := startTestSSHServer(t, pub)
+	trustHost(t, home, mac)
+	trustHost(t, home, linux)
+
+	// The remote dir decides which "platform" a stage fails on.
+	macDir, linuxDir := t.TempDir(), t.TempDir()
+	targets := []matrixTarget{
+		{Name: "macos-mini", Platform: "macos", Exec: NewRemoteExecutor("ci@"+mac.addr, "onion", macDir, 5*time.Second, false)},
+		{Name: "sparky", Platform: "linux_spark", Exec: NewRemoteExecutor("ci@"+linux.addr, "onion", linuxDir, 5*time.Second, false)},
+	}
+	defer targets[0].Exec.Close()
+	defer targets[1].Exec.Close()
+
+	stages := []Stage{
+		{Name: "fmt", Cmd: []string{"true"}, Timeout: 10},
+		{Name: "test", Cmd: []string{"sh", "-c", "case $PWD in " + macDir + ") exit 1;; esac"}, Timeout: 10, DependsOn: []string{"fmt"}},
+	}
+	report := runMatrix(targets, stages, 1, false, false)
+
+	if report.Failed() != 1 {
+		t.Fatalf("expected one failing cell, got %d: %+v", report.Failed(), report.Results)
+	}
+	if report.Results["test"]["macos-mini"].Status != "fail" || report.Results["test"]["sparky"].Status != "pass" {
+		t.Errorf("unexpected test row: %+v", report.Results["test"])
+	}
+
+	grid := report.FormatGrid()
+	lines := strings.Split(strings.TrimSpace(grid), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "stage") || !strings.Contains(lines[0], "macos-mini (macos)") || !strings.Contains(lines[0], "sparky (linux_spark)") {
+		t.Fatalf("unexpected grid:\n%s", grid)
+	}
+	if !strings.HasPrefix(lines[2], "test") || strings.Index(lines[2], "✗") > strings.Index(lines[2], "✓") {
+		t.Errorf("test row should fail on macOS and pass on Linux:\n%s", grid)
+	}
+
+	data, err := json.Marshal(report.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded MatrixReportJSON
+	json.Unmarshal(data, &decoded)
+	if decoded.Passed != 3 || decoded.Failed != 1 || len(decoded.Hosts) != 2 || decoded.Stages[1].Results["sparky"].Host != "ci@"+linux.addr {
+		t.Errorf("unexpected JSON report: %s", data)
+	}
+	if code := printMatrixReport(report, true); code != 1 {
+		t.Errorf("failing matrix should exit 1, got %d", code)
+	}
+}
+
+func TestMatrixCell(t *testing.T) {
+	if got := matrixCell(Result{}, false); got != "-" {
+		t.Errorf("missing cell = %q", got)
+	}
+	if got := matrixCell(Result{Status: "pass", Duration: 1500 * time.Millisecond}, true); got != "✓ 1.5s" {
+		t.Errorf("pass cell = %q", got)
+	}
+	if got := matrixCell(Result{Status: "skip"}, true); got != "skipped" {
+		t.Errorf("skip cell = %q", got)
+	}
+}
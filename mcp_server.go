@@ -3,11 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"os"
-	"os/exec"
 	"strings"
-	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -15,12 +13,18 @@ import (
 
 // mcpContext holds shared state for MCP tool handlers.
 type mcpContext struct {
-	root   string
-	config *Config
-	ws     *Workspace
+	root    string
+	config  *Config
+	ws      *Workspace
+	sandbox bool // run every stage under bubblewrap (serve --sandbox)
 }
 
-func cmdServe(root string) error {
+func cmdServe(root string, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	sandbox := fs.Bool("sandbox", false, "Run every stage in the bubblewrap sandbox, whatever .local-ci.toml says")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 	config, err := LoadConfig(root, false)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
@@ -28,7 +32,7 @@ func cmdServe(root string) error {
 
 	ws, _ := DetectWorkspace(root)
 
-	ctx := &mcpContext{root: root, config: config, ws: ws}
+	ctx := &mcpContext{root: root, config: config, ws: ws, sandbox: *sandbox}
 
 	s := server.NewMCPServer(
 		"local-ci",
@@ -91,17 +95,42 @@ func (mc *mcpContext) handleRunStage(ctx context.Context, req mcp.CallToolReques
 	}
 	stage.Name = name
 
-	result := mc.executeStage(ctx, stage)
+	if report, ok := mc.runViaDaemon([]string{name}); ok && len(report.Results) == 1 {
+		data, _ := json.Marshal(report.Results[0])
+		return mcp.NewToolResultText(string(data)), nil
+	}
+
+	result := mc.executeStage(ctx, stage, versionProbes{})
 	return mc.resultToMCP(result), nil
 }
 
+// runViaDaemon hands the run to a `local-ci daemon` serving this workspace,
+// if there is one, so agent-triggered runs queue behind (rather than race)
+// runs from the CLI and hooks.
+func (mc *mcpContext) runViaDaemon(stages []string) (*PipelineReportJSON, bool) {
+	// The daemon runs stages as configured; a forced sandbox runs here.
+	if mc.sandbox || !daemonAvailable(mc.root) {
+		return nil, false
+	}
+	report, err := daemonRun(mc.root, stages, false, nil)
+	if err != nil {
+		return nil, false
+	}
+	return report, true
+}
+
 func (mc *mcpContext) handleRunAll(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	enabledNames := mc.config.GetEnabledStages()
+	if report, ok := mc.runViaDaemon(enabledNames); ok {
+		data, _ := json.Marshal(report.Results)
+		return mcp.NewToolResultText(string(data)), nil
+	}
 	var results []Result
+	probes := versionProbes{}
 	for _, name := range enabledNames {
 		stage := mc.config.Stages[name]
 		stage.Name = name
-		r := mc.executeStage(ctx, stage)
+		r := mc.executeStage(ctx, stage, probes)
 		results = append(results, r)
 	}
 	return mc.resultsToMCP(results), nil
@@ -188,11 +217,10 @@ func (mc *mcpContext) handleInvalidate(_ context.Context, req mcp.CallToolReques
 	}
 
 	cache, _ := loadCache(mc.root)
-	if _, exists := cache[name]; !exists {
+	if invalidateStageCache(cache, name) == 0 {
 		data, _ := json.Marshal(invalidateResp{Stage: name, Status: "no_cache_entry"})
 		return mcp.NewToolResultText(string(data)), nil
 	}
-	delete(cache, name)
 	if err := saveCache(cache, mc.root); err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("failed to save cache: %v", err)), nil
 	}
@@ -239,8 +267,9 @@ func (mc *mcpContext) handleGetWorkspace(_ context.Context, _ mcp.CallToolReques
 	return mcp.NewToolResultText(string(data)), nil
 }
 
-// executeStage runs a single stage locally and returns the result.
-func (mc *mcpContext) executeStage(parent context.Context, stage Stage) Result {
+// executeStage runs a single stage locally and returns the result. probes
+// is shared by the stages of one tool call.
+func (mc *mcpContext) executeStage(parent context.Context, stage Stage, probes versionProbes) Result {
 	cmdStr := strings.Join(stage.Cmd, " ")
 
 	if len(stage.Cmd) == 0 {
@@ -252,6 +281,36 @@ func (mc *mcpContext) executeStage(parent context.Context, stage Stage) Result {
 		}
 	}
 
+	if mc.sandbox {
+		// bubblewrap can't confine a container engine, which would mount
+		// the project writable and give the stage the network.
+		if stage.Container != "" {
+			return Result{
+				Name:    stage.Name,
+				Command: cmdStr,
+				Status:  "fail",
+				Error:   fmt.Errorf("stage runs in container %q, which serve --sandbox can't confine; run it with local-ci instead", stage.Container),
+			}
+		}
+		stage.Sandbox = true
+	}
+	resolved := []Stage{stage}
+	// Without its image ID the stage's cache key would outlive an image
+	// change, so a stage whose image can't be resolved doesn't run.
+	if err := resolveStageBackends(resolved, mc.root, true, probes); err != nil {
+		return Result{
+			Name:    stage.Name,
+			Command: cmdStr,
+			Status:  "fail",
+			Error:   err,
+		}
+	}
+	applyStageConditions(resolved, newConditionEnv(mc.root), false)
+	stage = resolved[0]
+	if stage.SkipReason != "" {
+		return skipResult(stage)
+	}
+
 	hash, _ := mc.stageHash(stage)
 	cache, _ := loadCache(mc.root)
 	if cacheHit(cache, stage, hash) {
@@ -264,33 +323,8 @@ func (mc *mcpContext) executeStage(parent context.Context, stage Stage) Result {
 		}
 	}
 
-	// Execute
-	timeout := time.Duration(stage.Timeout) * time.Second
-	if timeout == 0 {
-		timeout = 30 * time.Second
-	}
-	ctx, cancel := context.WithTimeout(parent, timeout)
-	defer cancel()
-
-	start := time.Now()
-	cmd := exec.CommandContext(ctx, stage.Cmd[0], stage.Cmd[1:]...)
-	cmd.Dir = mc.root
-	cmd.Env = os.Environ()
-	output, err := cmd.CombinedOutput()
-	elapsed := time.Since(start)
-
-	result := Result{
-		Name:     stage.Name,
-		Command:  cmdStr,
-		Output:   string(output),
-		Duration: elapsed,
-	}
-
-	if err != nil {
-		result.Status = "fail"
-		result.Error = err
-	} else {
-		result.Status = "pass"
+	result := runLocalStage(parent, stage, mc.root, nil)
+	if result.Status == "pass" {
 		cache[stage.Name] = cacheKeyForStage(stage, hash)
 		_ = saveCache(cache, mc.root)
 	}
@@ -299,37 +333,11 @@ func (mc *mcpContext) executeStage(parent context.Context, stage Stage) Result {
 }
 
 func (mc *mcpContext) resultToMCP(r Result) *mcp.CallToolResult {
-	rj := ResultJSON{
-		Name:       r.Name,
-		Command:    r.Command,
-		Status:     r.Status,
-		DurationMS: r.Duration.Milliseconds(),
-		CacheHit:   r.CacheHit,
-		Output:     r.Output,
-	}
-	if r.Error != nil {
-		rj.Error = r.Error.Error()
-	}
-	data, _ := json.Marshal(rj)
+	data, _ := json.Marshal(toJSONResults([]Result{r})[0])
 	return mcp.NewToolResultText(string(data))
 }
 
 func (mc *mcpContext) resultsToMCP(results []Result) *mcp.CallToolResult {
-	var rjs []ResultJSON
-	for _, r := range results {
-		rj := ResultJSON{
-			Name:       r.Name,
-			Command:    r.Command,
-			Status:     r.Status,
-			DurationMS: r.Duration.Milliseconds(),
-			CacheHit:   r.CacheHit,
-			Output:     r.Output,
-		}
-		if r.Error != nil {
-			rj.Error = r.Error.Error()
-		}
-		rjs = append(rjs, rj)
-	}
-	data, _ := json.Marshal(rjs)
+	data, _ := json.Marshal(toJSONResults(results))
 	return mcp.NewToolResultText(string(data))
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -90,7 +91,7 @@ func TestHandleGetStages_CacheHitAfterRun(t *testing.T) {
 	// Run the stage to populate cache
 	stage := mc.config.Stages["echo"]
 	stage.Name = "echo"
-	mc.executeStage(context.Background(), stage)
+	mc.executeStage(context.Background(), stage, versionProbes{})
 
 	result, err := mc.handleGetStages(context.Background(), mcp.CallToolRequest{})
 	if err != nil {
@@ -170,7 +171,7 @@ func TestHandleGetStaleStages_NoneAfterRun(t *testing.T) {
 
 	stage := mc.config.Stages["echo"]
 	stage.Name = "echo"
-	mc.executeStage(context.Background(), stage)
+	mc.executeStage(context.Background(), stage, versionProbes{})
 
 	result, _ := mc.handleGetStaleStages(context.Background(), mcp.CallToolRequest{})
 	var stale []struct {
@@ -336,7 +337,7 @@ func TestHandleInvalidate_ClearsCache(t *testing.T) {
 	// Run to populate cache
 	stage := mc.config.Stages["echo"]
 	stage.Name = "echo"
-	mc.executeStage(context.Background(), stage)
+	mc.executeStage(context.Background(), stage, versionProbes{})
 
 	// Verify cache hit
 	req := makeCallToolRequest(map[string]interface{}{"name": "echo"})
@@ -483,7 +484,7 @@ func TestExecuteStage_PassingCommand(t *testing.T) {
 	mc := newTestMCPContext(t, map[string]Stage{})
 	stage := Stage{Name: "echo", Cmd: []string{"echo", "hello world"}, Timeout: 10}
 
-	r := mc.executeStage(context.Background(), stage)
+	r := mc.executeStage(context.Background(), stage, versionProbes{})
 	if r.Status != "pass" {
 		t.Errorf("expected pass, got %q", r.Status)
 	}
@@ -496,7 +497,7 @@ func TestExecuteStage_RecordsDuration(t *testing.T) {
 	mc := newTestMCPContext(t, map[string]Stage{})
 	stage := Stage{Name: "sleep", Cmd: []string{"sleep", "0.05"}, Timeout: 10}
 
-	r := mc.executeStage(context.Background(), stage)
+	r := mc.executeStage(context.Background(), stage, versionProbes{})
 	if r.Duration == 0 {
 		t.Error("expected non-zero duration for executed stage")
 	}
@@ -509,7 +510,7 @@ func TestExecuteStage_FailingCommand(t *testing.T) {
 	mc := newTestMCPContext(t, map[string]Stage{})
 	stage := Stage{Name: "fail", Cmd: []string{"false"}, Timeout: 10}
 
-	r := mc.executeStage(context.Background(), stage)
+	r := mc.executeStage(context.Background(), stage, versionProbes{})
 	if r.Status != "fail" {
 		t.Errorf("expected fail, got %q", r.Status)
 	}
@@ -522,12 +523,12 @@ func TestExecuteStage_CacheOnSuccess(t *testing.T) {
 	mc := newTestMCPContext(t, map[string]Stage{})
 	stage := Stage{Name: "echo", Cmd: []string{"echo", "cache me"}, Timeout: 10}
 
-	r1 := mc.executeStage(context.Background(), stage)
+	r1 := mc.executeStage(context.Background(), stage, versionProbes{})
 	if r1.CacheHit {
 		t.Error("first run should not be cache hit")
 	}
 
-	r2 := mc.executeStage(context.Background(), stage)
+	r2 := mc.executeStage(context.Background(), stage, versionProbes{})
 	if !r2.CacheHit {
 		t.Error("second run should be cache hit")
 	}
@@ -540,14 +541,79 @@ func TestExecuteStage_NoCacheOnFailure(t *testing.T) {
 	mc := newTestMCPContext(t, map[string]Stage{})
 	stage := Stage{Name: "fail", Cmd: []string{"false"}, Timeout: 10}
 
-	mc.executeStage(context.Background(), stage)
-	r2 := mc.executeStage(context.Background(), stage)
+	mc.executeStage(context.Background(), stage, versionProbes{})
+	r2 := mc.executeStage(context.Background(), stage, versionProbes{})
 
 	if r2.CacheHit {
 		t.Error("failed commands should not be cached")
 	}
 }
 
+func TestExecuteStage_CollectsArtifacts(t *testing.T) {
+	stage := Stage{
+		Name:      "report",
+		Cmd:       []string{"sh", "-c", "echo '<xml/>' > junit.xml"},
+		Timeout:   10,
+		Enabled:   true,
+		Artifacts: []string{"*.xml"},
+	}
+	mc := newTestMCPContext(t, map[string]Stage{"report": stage})
+
+	r := mc.executeStage(context.Background(), stage, versionProbes{})
+	if r.Status != "pass" {
+		t.Fatalf("stage failed: %v\n%s", r.Error, r.Output)
+	}
+	if len(r.Artifacts) != 1 || r.Artifacts[0] != "junit.xml" {
+		t.Errorf("expected artifacts [junit.xml], got %v", r.Artifacts)
+	}
+	if _, err := os.Stat(filepath.Join(artifactDir(mc.root, "report"), "junit.xml")); err != nil {
+		t.Errorf("artifact not collected: %v", err)
+	}
+}
+
+func TestExecuteStage_FailsWhenImageCannotBeResolved(t *testing.T) {
+	stage := Stage{
+		Name:            "lint",
+		Cmd:             []string{"sh", "-c", "touch ran"},
+		Timeout:         10,
+		Enabled:         true,
+		Container:       "node:20",
+		ContainerEngine: "docker",
+	}
+	mc := newTestMCPContext(t, map[string]Stage{"lint": stage})
+	t.Setenv("PATH", t.TempDir())
+
+	r := mc.executeStage(context.Background(), stage, versionProbes{})
+	if r.Status != "fail" || r.Error == nil || !strings.Contains(r.Error.Error(), "container images") {
+		t.Fatalf("expected an image resolution failure, got %s: %v", r.Status, r.Error)
+	}
+	if _, err := os.Stat(filepath.Join(mc.root, "ran")); !os.IsNotExist(err) {
+		t.Error("the stage should not run without its image ID")
+	}
+}
+
+func TestExecuteStage_SandboxRefusesContainerStages(t *testing.T) {
+	fakeEngine(t, "docker")
+	stage := Stage{
+		Name:            "lint",
+		Cmd:             []string{"sh", "-c", "touch escaped"},
+		Timeout:         10,
+		Enabled:         true,
+		Container:       "node:20",
+		ContainerEngine: "docker",
+	}
+	mc := newTestMCPContext(t, map[string]Stage{"lint": stage})
+	mc.sandbox = true
+
+	r := mc.executeStage(context.Background(), stage, versionProbes{})
+	if r.Status != "fail" || r.Error == nil || !strings.Contains(r.Error.Error(), "serve --sandbox") {
+		t.Fatalf("expected the container stage to be refused, got %s: %v", r.Status, r.Error)
+	}
+	if _, err := os.Stat(filepath.Join(mc.root, "escaped")); !os.IsNotExist(err) {
+		t.Error("a container stage under serve --sandbox wrote to the repo")
+	}
+}
+
 // --- resultToMCP / resultsToMCP tests ---
 
 func TestResultToMCP_IncludesAllFields(t *testing.T) {
@@ -555,8 +621,10 @@ func TestResultToMCP_IncludesAllFields(t *testing.T) {
 	r := Result{
 		Name:    "test",
 		Command: "echo test",
-		Status:  "pass",
+		Status:  "fail",
 		Output:  "test output",
+
+		UndeclaredWrites: []string{"target/"},
 	}
 
 	result := mc.resultToMCP(r)
@@ -567,12 +635,15 @@ func TestResultToMCP_IncludesAllFields(t *testing.T) {
 	if rj.Name != "test" {
 		t.Errorf("expected name 'test', got %q", rj.Name)
 	}
-	if rj.Status != "pass" {
-		t.Errorf("expected status 'pass', got %q", rj.Status)
+	if rj.Status != "fail" {
+		t.Errorf("expected status 'fail', got %q", rj.Status)
 	}
 	if rj.Output != "test output" {
 		t.Errorf("expected output 'test output', got %q", rj.Output)
 	}
+	if len(rj.UndeclaredWrites) != 1 || rj.UndeclaredWrites[0] != "target/" {
+		t.Errorf("expected undeclared writes [target/], got %v", rj.UndeclaredWrites)
+	}
 }
 
 func TestResultsToMCP_MultipleResults(t *testing.T) {
@@ -580,6 +651,7 @@ func TestResultsToMCP_MultipleResults(t *testing.T) {
 	results := []Result{
 		{Name: "a", Status: "pass"},
 		{Name: "b", Status: "fail"},
+		{Name: "c", Status: "skip", SkipReason: "platform is not darwin"},
 	}
 
 	result := mc.resultsToMCP(results)
@@ -587,7 +659,10 @@ func TestResultsToMCP_MultipleResults(t *testing.T) {
 	text := result.Content[0].(mcp.TextContent).Text
 	json.Unmarshal([]byte(text), &rjs)
 
-	if len(rjs) != 2 {
-		t.Fatalf("expected 2 results, got %d", len(rjs))
+	if len(rjs) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(rjs))
+	}
+	if rjs[2].SkipReason != "platform is not darwin" {
+		t.Errorf("expected the skip reason to be reported, got %q", rjs[2].SkipReason)
 	}
 }
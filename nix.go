@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// nixEnvDir holds captured devShell environments under .local-ci/.
+const nixEnvDir = "nix-env"
+
+// nixIgnoredVars are devShell variables that describe the Nix build sandbox
+// rather than the shell; `nix develop` leaves the caller's values alone too.
+var nixIgnoredVars = map[string]bool{
+	"BASHOPTS": true, "HOME": true, "NIX_BUILD_TOP": true, "NIX_ENFORCE_PURITY": true,
+	"NIX_LOG_FD": true, "NIX_REMOTE": true, "PPID": true, "SHELL": true, "SHELLOPTS": true,
+	"SSL_CERT_FILE": true, "TEMP": true, "TEMPDIR": true, "TERM": true, "TMP": true,
+	"TMPDIR": true, "TZ": true, "UID": true,
+}
+
+// nixShell returns the installable for the config-level `nix`/`nix_shell`
+// settings: nix_shell when set, "." (the default devShell) for nix = true.
+func (c *Config) nixShell() string {
+	if c.NixShell != "" {
+		return c.NixShell
+	}
+	if c.Nix {
+		return "."
+	}
+	return ""
+}
+
+// nixEnvKey identifies a captured environment: the installable plus the
+// content of flake.lock and the top-level .nix files that define shells.
+func nixEnvKey(root, installable string) string {
+	h := sha256.New()
+	h.Write([]byte(installable + "\x00"))
+	files, _ := filepath.Glob(filepath.Join(root, "*.nix"))
+	files = append(files, filepath.Join(root, "flake.lock"))
+	sort.Strings(files)
+	for _, f := range files {
+		if data, err := os.ReadFile(f); err == nil {
+			h.Write([]byte(filepath.Base(f) + "\x00"))
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+// nixDevEnv is the part of `nix print-dev-env --json` local-ci uses.
+type nixDevEnv struct {
+	Variables map[string]struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"variables"`
+}
+
+// environ overlays the devShell's exported variables on base. The shell's
+// PATH comes first, followed by the caller's, as with `nix develop`.
+func (e *nixDevEnv) environ(base []string) []string {
+	vars := make(map[string]string)
+	for name, v := range e.Variables {
+		if v.Type != "exported" || nixIgnoredVars[name] {
+			continue
+		}
+		var s string
+		if json.Unmarshal(v.Value, &s) == nil {
+			vars[name] = s
+		}
+	}
+	env := make([]string, 0, len(base)+len(vars))
+	for _, kv := range base {
+		name, value, _ := strings.Cut(kv, "=")
+		if shell, ok := vars[name]; ok {
+			if name == "PATH" && value != "" {
+				shell += string(os.PathListSeparator) + value
+			}
+			kv = name + "=" + shell
+			delete(vars, name)
+		}
+		env = append(env, kv)
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return env
+}
+
+var (
+	nixEnvMu    sync.Mutex // guards nixEnvCache and nixEnvLocks
+	nixEnvCache = make(map[string]*nixDevEnv)
+	nixEnvLocks = make(map[string]*sync.Mutex)
+)
+
+// nixEnvLock returns the lock for one environment key, so stages sharing a
+// devShell evaluate it once while other shells are captured in parallel.
+func nixEnvLock(key string) *sync.Mutex {
+	nixEnvMu.Lock()
+	defer nixEnvMu.Unlock()
+	l, ok := nixEnvLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		nixEnvLocks[key] = l
+	}
+	return l
+}
+
+func cachedNixEnv(key string) (*nixDevEnv, bool) {
+	nixEnvMu.Lock()
+	defer nixEnvMu.Unlock()
+	env, ok := nixEnvCache[key]
+	return env, ok
+}
+
+func storeNixEnv(key string, env *nixDevEnv) {
+	nixEnvMu.Lock()
+	nixEnvCache[key] = env
+	nixEnvMu.Unlock()
+}
+
+// storePathsExist reports whether the store paths on the devShell's PATH
+// are still present. The saved environment only names them; if they were
+// garbage-collected anyway (say, the profile was deleted), it is stale.
+func (e *nixDevEnv) storePathsExist() bool {
+	v, ok := e.Variables["PATH"]
+	if !ok {
+		return true
+	}
+	var path string
+	if json.Unmarshal(v.Value, &path) != nil {
+		return true
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if !strings.HasPrefix(dir, "/nix/store/") {
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// captureNixEnv evaluates the devShell once and reuses it: in memory for
+// the rest of the process and in .local-ci/nix-env/ until flake.lock or a
+// top-level .nix file changes. Evaluating a shell takes seconds; reading it
+// back takes microseconds. The shell is recorded in a profile next to the
+// saved environment, which makes it a GC root: `nix-collect-garbage` keeps
+// the store paths the environment points at.
+func captureNixEnv(ctx context.Context, root, installable string) (*nixDevEnv, error) {
+	key := nixEnvKey(root, installable)
+	lock := nixEnvLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	if env, ok := cachedNixEnv(key); ok {
+		return env, nil
+	}
+
+	dir := filepath.Join(root, ".local-ci", nixEnvDir)
+	path := filepath.Join(dir, key+".json")
+	env := &nixDevEnv{}
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, env) == nil && env.storePathsExist() {
+		storeNixEnv(key, env)
+		return env, nil
+	}
+	env = &nixDevEnv{}
+
+	args := []string{"print-dev-env", "--json", installable}
+	if err := os.MkdirAll(dir, 0o755); err == nil {
+		args = append(args, "--profile", filepath.Join(dir, key+".profile"))
+	}
+	cmd := exec.CommandContext(ctx, "nix", args...)
+	cmd.Dir = root
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nix print-dev-env %s: %v: %s", installable, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, fmt.Errorf("nix print-dev-env %s: %w", installable, err)
+	}
+	os.WriteFile(path, data, 0o644)
+	storeNixEnv(key, env)
+	return env, nil
+}
+
+// nixCommand runs the stage with its devShell's environment. If the
+// environment can't be captured, it falls back to `nix develop --command`,
+// which reports the evaluation error in the stage output.
+func nixCommand(ctx context.Context, stage Stage, root string) (*exec.Cmd, error) {
+	dir := root
+	if stage.Dir != "" {
+		dir = filepath.Join(root, stage.Dir)
+	}
+	env, err := captureNixEnv(ctx, root, stage.NixShell)
+	if err != nil {
+		if _, lookErr := exec.LookPath("nix"); lookErr != nil {
+			return nil, fmt.Errorf("stage needs nix (nix_shell %q), but nix is not in PATH", stage.NixShell)
+		}
+		args := append([]string{"develop", nixInstallable(root, stage.NixShell), "--command"}, stage.Cmd...)
+		cmd := exec.CommandContext(ctx, "nix", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), stageEnv(stage)...)
+		return cmd, nil
+	}
+
+	environ := append(env.environ(os.Environ()), stageEnv(stage)...)
+	name := stage.Cmd[0]
+	if !strings.ContainsRune(name, filepath.Separator) {
+		// exec resolves names against our own PATH; use the shell's.
+		for _, kv := range environ {
+			if p, ok := strings.CutPrefix(kv, "PATH="); ok {
+				if found := lookPathIn(name, p); found != "" {
+					name = found
+				}
+			}
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, stage.Cmd[1:]...)
+	cmd.Args[0] = stage.Cmd[0]
+	cmd.Dir = dir
+	cmd.Env = environ
+	return cmd, nil
+}
+
+// nixInstallable anchors a relative flake reference such as ".#ci" at root,
+// so it resolves the same from a stage's dir.
+func nixInstallable(root, installable string) string {
+	ref, attr, hasAttr := strings.Cut(installable, "#")
+	if ref == "." || strings.HasPrefix(ref, "./") {
+		ref = filepath.Join(root, ref)
+	}
+	if hasAttr {
+		ref += "#" + attr
+	}
+	return ref
+}
+
+// lookPathIn finds an executable named file in the PATH-style list dirs.
+func lookPathIn(file, dirs string) string {
+	for _, dir := range filepath.SplitList(dirs) {
+		if dir == "" {
+			continue
+		}
+		p := filepath.Join(dir, file)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() && info.Mode()&0o111 != 0 {
+			return p
+		}
+	}
+	return ""
+}
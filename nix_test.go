@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNix installs a nix stand-in whose print-dev-env reports a devShell
+// with its own bin directory on PATH, and counts evaluations in a file.
+func fakeNix(t *testing.T) (calls string) {
+	t.Helper()
+	bin, shellBin := t.TempDir(), t.TempDir()
+	calls = filepath.Join(bin, "calls")
+	os.WriteFile(filepath.Join(shellBin, "devtool"), []byte("#!/bin/sh\necho \"devtool $GREETING from $HOME\"\n"), 0o755)
+	script := `#!/bin/sh
+echo "$*" >> ` + calls + `
+cat <<EOF
+{"variables": {
+  "PATH": {"type": "exported", "value": "` + shellBin + `"},
+  "GREETING": {"type": "exported", "value": "hello"},
+  "HOME": {"type": "exported", "value": "/homeless-shelter"},
+  "buildPhase": {"type": "var", "value": "make"}
+}}
+EOF
+`
+	os.WriteFile(filepath.Join(bin, "nix"), []byte(script), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	nixEnvMu.Lock()
+	nixEnvCache = make(map[string]*nixDevEnv)
+	nixEnvMu.Unlock()
+	return calls
+}
+
+func TestRunLocalStageInNixShell(t *testing.T) {
+	calls := fakeNix(t)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "flake.nix"), []byte("{ }"), 0o644)
+	os.WriteFile(filepath.Join(root, "flake.lock"), []byte(`{"version": 7}`), 0o644)
+
+	stages := []Stage{
+		{Name: "a", Cmd: []string{"devtool"}, Timeout: 10, NixShell: ".#ci"},
+		{Name: "b", Cmd: []string{"sh", "-c", "echo $GREETING; command -v git >/dev/null && echo host-path"}, Timeout: 10, NixShell: ".#ci"},
+	}
+	if err := resolveStageBackends(stages, root, false, versionProbes{}); err != nil {
+		t.Fatal(err)
+	}
+	if stages[0].NixKey == "" || stages[0].NixKey != nixEnvKey(root, ".#ci") {
+		t.Fatalf("nix key = %q", stages[0].NixKey)
+	}
+
+	a := runLocalStage(context.Background(), stages[0], root, nil)
+	if a.Status != "pass" || !strings.HasPrefix(a.Output, "devtool hello from ") || strings.Contains(a.Output, "homeless-shelter") {
+		t.Fatalf("a = %s %v %q", a.Status, a.Error, a.Output)
+	}
+	b := runLocalStage(context.Background(), stages[1], root, nil)
+	if b.Status != "pass" || b.Output != "hello\nhost-path\n" {
+		t.Fatalf("b = %s %v %q", b.Status, b.Error, b.Output)
+	}
+
+	// The shell was evaluated once, recorded in a profile as a GC root and
+	// saved for the next process.
+	envDir := filepath.Join(root, ".local-ci", nixEnvDir)
+	profile := filepath.Join(envDir, nixEnvKey(root, ".#ci")+".profile")
+	data, _ := os.ReadFile(calls)
+	if got := strings.TrimSpace(string(data)); got != "print-dev-env --json .#ci --profile "+profile {
+		t.Errorf("nix calls = %q", got)
+	}
+	nixEnvCache = make(map[string]*nixDevEnv)
+	if r := runLocalStage(context.Background(), stages[0], root, nil); r.Status != "pass" {
+		t.Fatal(r.Error)
+	}
+	if data, _ := os.ReadFile(calls); strings.Count(string(data), "\n") != 1 {
+		t.Errorf("captured env should be reused from disk, calls:\n%s", data)
+	}
+
+	// A saved env whose store paths were collected is captured again.
+	saved := filepath.Join(envDir, nixEnvKey(root, ".#ci")+".json")
+	os.WriteFile(saved, []byte(`{"variables": {"PATH": {"type": "exported", "value": "/nix/store/local-ci-test-collected/bin"}}}`), 0o644)
+	nixEnvCache = make(map[string]*nixDevEnv)
+	if r := runLocalStage(context.Background(), stages[0], root, nil); r.Status != "pass" {
+		t.Fatal(r.Error)
+	}
+	if data, _ := os.ReadFile(calls); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("stale env should be re-captured, calls:\n%s", data)
+	}
+
+	// Bumping flake.lock changes the cache key.
+	key := cacheKeyForStage(stages[0], "h")
+	os.WriteFile(filepath.Join(root, "flake.lock"), []byte(`{"version": 7, "bumped": true}`), 0o644)
+	resolveStageBackends(stages, root, false, versionProbes{})
+	if cacheHit(map[string]string{"a": key}, stages[0], "h") {
+		t.Error("a flake.lock change should miss the cache")
+	}
+
+	// So does editing the devShell itself.
+	key = cacheKeyForStage(stages[0], "h")
+	os.WriteFile(filepath.Join(root, "flake.nix"), []byte("{ packages = [ ripgrep ]; }"), 0o644)
+	resolveStageBackends(stages, root, false, versionProbes{})
+	if cacheHit(map[string]string{"a": key}, stages[0], "h") {
+		t.Error("a flake.nix change should miss the cache")
+	}
+}
+
+func TestCaptureNixEnvLocksPerShell(t *testing.T) {
+	fakeNix(t)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "flake.lock"), []byte(`{"version": 7}`), 0o644)
+
+	// A capture in progress for one shell doesn't hold up another.
+	busy := nixEnvLock(nixEnvKey(root, ".#ci"))
+	busy.Lock()
+	defer busy.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := captureNixEnv(context.Background(), root, ".#lint")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("capturing .#lint waited on the .#ci capture")
+	}
+}
+
+func TestNixInstallable(t *testing.T) {
+	tests := map[string]string{
+		".":              "/src/app",
+		".#ci":           "/src/app#ci",
+		"./nix#rust":     "/src/app/nix#rust",
+		"github:o/r#dev": "github:o/r#dev",
+	}
+	for in, want := range tests {
+		if got := nixInstallable("/src/app", in); got != want {
+			t.Errorf("nixInstallable(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConfigLevelNix(t *testing.T) {
+	root := t.TempDir()
+	config := `nix = true
+
+[stages.test]
+command = ["go", "test", "./..."]
+
+[stages.lint]
+command = ["golangci-lint", "run"]
+nix_shell = ".#lint"
+
+[stages.host]
+command = ["true"]
+nix = false
+`
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(config), 0o644)
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := cfg.Stages["test"].NixShell + " " + cfg.Stages["lint"].NixShell + " [" + cfg.Stages["host"].NixShell + "]"
+	if got != ". .#lint []" {
+		t.Errorf("nix shells = %s", got)
+	}
+}
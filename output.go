@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// outputSettings controls how the printing helpers decorate human output.
+// It is resolved once from flags and the environment by configureOutput;
+// the zero-configuration default matches a UTF-8 color terminal.
+type outputSettings struct {
+	colorStdout bool
+	colorStderr bool
+	unicode     bool // emoji and box glyphs; ASCII stand-ins otherwise
+	groups      bool // emit ::group::/::endgroup:: log folding markers
+}
+
+var output = outputSettings{colorStdout: true, colorStderr: true, unicode: true}
+
+// configureOutput resolves the output settings for this process from the
+// --color mode, the --groups flag and the environment.
+func configureOutput(colorMode string, groups bool) error {
+	stdoutColor, err := colorEnabled(colorMode, term.IsTerminal(int(os.Stdout.Fd())), os.Getenv)
+	if err != nil {
+		return err
+	}
+	stderrColor, _ := colorEnabled(colorMode, term.IsTerminal(int(os.Stderr.Fd())), os.Getenv)
+	output = outputSettings{
+		colorStdout: stdoutColor,
+		colorStderr: stderrColor,
+		unicode:     unicodeEnabled(os.Getenv),
+		groups:      groupsEnabled(groups, os.Getenv),
+	}
+	return nil
+}
+
+// colorEnabled decides whether to emit ANSI colors on a stream. In auto mode
+// NO_COLOR (https://no-color.org) wins, then CLICOLOR_FORCE, then TERM=dumb,
+// and finally whether the stream is a terminal.
+func colorEnabled(mode string, isTTY bool, getenv func(string) string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "", "auto":
+	default:
+		return false, fmt.Errorf("invalid --color %q (want auto, always or never)", mode)
+	}
+	if getenv("NO_COLOR") != "" {
+		return false, nil
+	}
+	if v := getenv("CLICOLOR_FORCE"); v != "" && v != "0" {
+		return true, nil
+	}
+	if getenv("TERM") == "dumb" {
+		return false, nil
+	}
+	return isTTY, nil
+}
+
+// unicodeEnabled reports whether emoji glyphs are safe to print: not on a
+// dumb terminal, and not when the locale explicitly selects a non-UTF-8
+// charset. An unset locale is assumed to be UTF-8.
+func unicodeEnabled(getenv func(string) string) bool {
+	if getenv("TERM") == "dumb" {
+		return false
+	}
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := getenv(key); v != "" {
+			v = strings.ToLower(v)
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	return true
+}
+
+// groupsEnabled reports whether to emit GitHub Actions log groups.
+func groupsEnabled(requested bool, getenv func(string) string) bool {
+	return requested || getenv("GITHUB_ACTIONS") == "true"
+}
+
+// asciiGlyphs maps the glyphs used in human output to ASCII stand-ins.
+var asciiGlyphs = strings.NewReplacer(
+	"✅", "[OK]",
+	"❌", "[FAIL]",
+	"✓", "ok",
+	"✗", "FAIL",
+	"⚠️", "WARNING:",
+	"💡", "hint:",
+	"🔄", "~",
+	"🚀", ">>",
+	"📊", "*",
+	"📦", "*",
+	"📋", "*",
+	"📍", "*",
+	"🛰", "*",
+	"👀", "*",
+	"🩺", "*",
+	"↷", "-",
+	"▶", ">",
+	"▸", ">",
+	"─", "-",
+	"·", ".",
+	"⏹", "[stopped]",
+	"→", "->",
+	"—", "-",
+)
+
+// decorate applies the output settings to text bound for a stream with the
+// given color setting; ansi is the SGR color code, or "" for none.
+func decorate(text, ansi string, color bool) string {
+	if !output.unicode {
+		text = asciiGlyphs.Replace(text)
+	}
+	if color && ansi != "" {
+		text = "\033[" + ansi + "m" + text + "\033[0m"
+	}
+	return text
+}
+
+// humanStream is where progress output goes: stdout normally, stderr when
+// --json owns stdout.
+func humanStream() (io.Writer, bool) {
+	if flagJSON != nil && *flagJSON {
+		return os.Stderr, output.colorStderr
+	}
+	return os.Stdout, output.colorStdout
+}
+
+// groupStart opens a collapsible log group for stage output when running
+// under GitHub Actions (or with --groups).
+func groupStart(name string) {
+	if output.groups {
+		printf("::group::%s\n", name)
+	}
+}
+
+func groupEnd() {
+	if output.groups {
+		printf("::endgroup::\n")
+	}
+}
+
+// formatTable renders rows as left-aligned columns separated by two spaces.
+// The first row is typically the header.
+func formatTable(rows [][]string) string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := len([]rune(cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	var b strings.Builder
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(row)-1 {
+				cell += strings.Repeat(" ", widths[i]-len([]rune(cell))+2)
+			}
+			b.WriteString(cell)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
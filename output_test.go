@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func envMap(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestColorEnabled(t *testing.T) {
+	cases := []struct {
+		name string
+		mode string
+		tty  bool
+		env  map[string]string
+		want bool
+	}{
+		{"auto tty", "auto", true, nil, true},
+		{"auto pipe", "auto", false, nil, false},
+		{"no color wins", "auto", true, map[string]string{"NO_COLOR": "1", "CLICOLOR_FORCE": "1"}, false},
+		{"force on pipe", "auto", false, map[string]string{"CLICOLOR_FORCE": "1"}, true},
+		{"force zero ignored", "auto", false, map[string]string{"CLICOLOR_FORCE": "0"}, false},
+		{"dumb terminal", "auto", true, map[string]string{"TERM": "dumb"}, false},
+		{"always", "always", false, map[string]string{"NO_COLOR": "1"}, true},
+		{"never", "never", true, map[string]string{"CLICOLOR_FORCE": "1"}, false},
+		{"empty mode is auto", "", true, nil, true},
+	}
+	for _, tc := range cases {
+		got, err := colorEnabled(tc.mode, tc.tty, envMap(tc.env))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: colorEnabled = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := colorEnabled("sometimes", true, envMap(nil)); err == nil {
+		t.Error("expected an error for an invalid --color value")
+	}
+}
+
+func TestUnicodeEnabled(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want bool
+	}{
+		{nil, true},
+		{map[string]string{"LANG": "en_US.UTF-8"}, true},
+		{map[string]string{"LANG": "C.utf8"}, true},
+		{map[string]string{"LANG": "C"}, false},
+		{map[string]string{"LC_ALL": "POSIX", "LANG": "en_US.UTF-8"}, false},
+		{map[string]string{"TERM": "dumb", "LANG": "en_US.UTF-8"}, false},
+	}
+	for _, tc := range cases {
+		if got := unicodeEnabled(envMap(tc.env)); got != tc.want {
+			t.Errorf("unicodeEnabled(%v) = %v, want %v", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestGroupsEnabled(t *testing.T) {
+	if groupsEnabled(false, envMap(nil)) {
+		t.Error("groups should be off outside GitHub Actions")
+	}
+	if !groupsEnabled(false, envMap(map[string]string{"GITHUB_ACTIONS": "true"})) {
+		t.Error("groups should be on under GitHub Actions")
+	}
+	if !groupsEnabled(true, envMap(nil)) {
+		t.Error("--groups should force groups on")
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	saved := output
+	defer func() { output = saved }()
+
+	output = outputSettings{unicode: true}
+	if got := decorate("✓ fmt", "32", true); got != "\033[32m✓ fmt\033[0m" {
+		t.Errorf("colored output = %q", got)
+	}
+	if got := decorate("✓ fmt", "32", false); got != "✓ fmt" {
+		t.Errorf("uncolored output = %q", got)
+	}
+
+	output = outputSettings{unicode: false}
+	got := decorate("✅ All stages passed — ❌ none failed", "", false)
+	if strings.ContainsAny(got, "✅❌—") {
+		t.Errorf("ASCII mode left non-ASCII glyphs: %q", got)
+	}
+	if got != "[OK] All stages passed - [FAIL] none failed" {
+		t.Errorf("ASCII output = %q", got)
+	}
+}
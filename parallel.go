@@ -1,10 +1,7 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"os/exec"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -23,6 +20,21 @@ type ParallelRunner struct {
 	Verbose     bool
 	JSON        bool
 	FailFast    bool
+
+	// CacheTargets are the cache namespaces of the remote hosts stages may
+	// run on; a stage counts as cached when any of them has it. A pass is
+	// recorded under the result's CacheTarget. Nil means local runs.
+	CacheTargets []string
+
+	// Execute, when set, runs a stage that missed the cache in place of
+	// plain local execution (used by the TUI for live output and per-stage
+	// cancellation).
+	Execute func(stage Stage) Result
+
+	// Budget caps the cores and memory that running stages declare
+	// (`cpus`, `memory`). Zero fields are unlimited. `lock` groups are
+	// always exclusive.
+	Budget resourceBudget
 }
 
 // Run executes all stages concurrently with dependency management
@@ -31,7 +43,7 @@ func (r *ParallelRunner) Run() []Result {
 		r.Concurrency = runtime.NumCPU()
 	}
 
-	sem := make(chan struct{}, r.Concurrency)
+	pool := newResourcePool(r.Concurrency, r.Budget)
 	completed := make(map[string]bool)
 	var mu sync.Mutex
 	var failed atomic.Bool
@@ -94,20 +106,29 @@ func (r *ParallelRunner) Run() []Result {
 				return
 			}
 
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			if r.FailFast && failed.Load() {
-				skip()
-				return
+			// Skips and cache hits don't need a slot; everything else waits
+			// until its resources and lock are free.
+			result, cached := r.cachedResult(s)
+			if s.SkipReason != "" {
+				result = skipResult(s)
+			} else if !cached {
+				release := pool.acquire(s)
+				if r.FailFast && failed.Load() {
+					release()
+					skip()
+					return
+				}
+				result = r.run(s)
+				release()
 			}
-
-			result := r.executeStage(s)
-			if result.Status != "pass" {
+			switch {
+			case result.SkipReason != "":
+				// Unmet conditions aren't failures.
+			case result.Status != "pass":
 				failed.Store(true)
-			} else if !result.CacheHit {
+			case !result.CacheHit:
 				mu.Lock()
-				r.Cache[s.Name] = cacheKeyForStage(s, r.stageHash(s))
+				r.Cache[cacheEntryName(s.Name, result.CacheTarget)] = cacheKeyForStage(s, r.stageHash(s))
 				mu.Unlock()
 			}
 			resultChan <- result
@@ -145,60 +166,23 @@ func (r *ParallelRunner) stageHash(stage Stage) string {
 	return r.SourceHash
 }
 
-// executeStage runs a single stage
-func (r *ParallelRunner) executeStage(stage Stage) Result {
-	stageStart := time.Now()
-	hash := r.stageHash(stage)
-
-	if !r.NoCache && cacheHit(r.Cache, stage, hash) {
+// cachedResult returns the cache-hit result for a stage, if it has one.
+func (r *ParallelRunner) cachedResult(stage Stage) (Result, bool) {
+	if !r.NoCache && cacheHitAny(r.Cache, stage, r.stageHash(stage), r.CacheTargets) {
 		return Result{
 			Name:     stage.Name,
 			Status:   "pass",
 			CacheHit: true,
 			Duration: 0,
-		}
-	}
-
-	if len(stage.Cmd) == 0 {
-		return Result{
-			Name:     stage.Name,
-			Status:   "fail",
-			Duration: time.Since(stageStart),
-			Error:    fmt.Errorf("no command defined"),
-		}
-	}
-
-	timeout := time.Duration(stage.Timeout) * time.Second
-	if timeout == 0 {
-		timeout = 30 * time.Second
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, stage.Cmd[0], stage.Cmd[1:]...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	cmd.Dir = r.Cwd
-
-	err := cmd.Run()
-	duration := time.Since(stageStart)
-
-	if err != nil {
-		return Result{
-			Name:     stage.Name,
-			Status:   "fail",
-			Duration: duration,
-			Error:    err,
-			Output:   out.String(),
-		}
+		}, true
 	}
+	return Result{}, false
+}
 
-	return Result{
-		Name:     stage.Name,
-		Status:   "pass",
-		Duration: duration,
-		Output:   out.String(),
+// run executes a stage that missed the cache.
+func (r *ParallelRunner) run(stage Stage) Result {
+	if r.Execute != nil {
+		return r.Execute(stage)
 	}
+	return runLocalStage(context.Background(), stage, r.Cwd, nil)
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// cargoBuiltins are cargo subcommands that ship with cargo itself. Any
+// other subcommand runs a cargo-<name> binary from PATH.
+var cargoBuiltins = map[string]bool{
+	"add": true, "b": true, "bench": true, "build": true, "c": true, "check": true,
+	"clean": true, "config": true, "d": true, "doc": true, "fetch": true, "fix": true,
+	"generate-lockfile": true, "help": true, "info": true, "init": true, "install": true,
+	"locate-project": true, "login": true, "logout": true, "metadata": true, "new": true,
+	"owner": true, "package": true, "pkgid": true, "publish": true, "r": true,
+	"remove": true, "report": true, "rm": true, "run": true, "rustc": true,
+	"rustdoc": true, "search": true, "t": true, "test": true, "tree": true,
+	"uninstall": true, "update": true, "vendor": true, "verify-project": true,
+	"version": true, "yank": true,
+}
+
+// rustupComponents are cargo subcommands installed with rustup.
+var rustupComponents = map[string]string{
+	"cargo-fmt":    "rustfmt",
+	"cargo-clippy": "clippy",
+}
+
+// missingTool is a program that stages about to run need but can't find.
+type missingTool struct {
+	Program string   // e.g. "cargo-deny"
+	Stages  []string // stages that need it
+	Install string   // command that installs it here; empty when unknown
+}
+
+// stagePrograms returns the programs a stage's command needs: the command
+// itself and, for `cargo <sub>` with a subcommand that isn't built in,
+// cargo-<sub>.
+func stagePrograms(s Stage) []string {
+	if len(s.Cmd) == 0 {
+		return nil
+	}
+	programs := []string{s.Cmd[0]}
+	if filepath.Base(s.Cmd[0]) == "cargo" {
+		for _, arg := range s.Cmd[1:] {
+			// Skip toolchain overrides (+nightly) and flags (--locked).
+			if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+				continue
+			}
+			if !cargoBuiltins[arg] {
+				programs = append(programs, "cargo-"+arg)
+			}
+			break
+		}
+	}
+	return programs
+}
+
+// findProgram reports whether a stage can start program: a path relative
+// to the stage's directory, or a name on its PATH (the stage's own `env`
+// PATH when it sets one).
+func findProgram(program string, s Stage, root string) bool {
+	if strings.ContainsRune(program, '/') || strings.ContainsRune(program, filepath.Separator) {
+		if !filepath.IsAbs(program) {
+			program = filepath.Join(root, s.Dir, program)
+		}
+		info, err := os.Stat(program)
+		return err == nil && !info.IsDir() && (runtime.GOOS == "windows" || info.Mode()&0o111 != 0)
+	}
+	if path, ok := s.Env["PATH"]; ok {
+		return lookPathIn(program, path) != ""
+	}
+	_, err := exec.LookPath(program)
+	return err == nil
+}
+
+// preflightStages resolves every program the stages need, before anything
+// runs. Stages in a container or Nix shell get their tools from there, and
+// stages that are skipped need none.
+func preflightStages(stages []Stage, root string, platform Platform) []missingTool {
+	byProgram := make(map[string]*missingTool)
+	var order []string
+	for _, s := range stages {
+		if s.Container != "" || s.NixShell != "" || s.SkipReason != "" {
+			continue
+		}
+		for _, program := range stagePrograms(s) {
+			if findProgram(program, s, root) {
+				continue
+			}
+			m, ok := byProgram[program]
+			if !ok {
+				m = &missingTool{Program: program, Install: installCommand(program, platform)}
+				byProgram[program] = m
+				order = append(order, program)
+			}
+			m.Stages = append(m.Stages, s.Name)
+		}
+	}
+	sort.Strings(order)
+	missing := make([]missingTool, len(order))
+	for i, program := range order {
+		missing[i] = *byProgram[program]
+	}
+	return missing
+}
+
+// toolForProgram finds the known tool that provides program. An unknown
+// cargo-<name> is assumed to be the crate of the same name.
+func toolForProgram(program string) *Tool {
+	for _, list := range [][]Tool{cargoTools, systemTools, bunTools} {
+		for _, tool := range list {
+			if tool.Name == program || (tool.Command == program && tool.Command != "cargo") {
+				return &tool
+			}
+		}
+	}
+	if component, ok := rustupComponents[program]; ok {
+		return &Tool{Name: program, Command: program, InstallCmd: "rustup component add " + component, ToolType: "rustup", NixPackage: component}
+	}
+	if strings.HasPrefix(program, "cargo-") {
+		return &Tool{Name: program, Command: program, InstallCmd: "cargo install " + program, ToolType: "cargo"}
+	}
+	return nil
+}
+
+// installCommand is the single command that installs program on this
+// platform: nix profile on NixOS, otherwise the tool's InstallCmd line for
+// this OS. Empty when there is none.
+func installCommand(program string, platform Platform) string {
+	tool := toolForProgram(program)
+	if tool == nil {
+		return ""
+	}
+	if platform.IsNixOS() {
+		return "nix profile install nixpkgs#" + tool.nixPackage()
+	}
+	for _, line := range strings.Split(tool.InstallCmd, "\n") {
+		cmd, note, _ := strings.Cut(line, "#")
+		cmd, note = strings.TrimSpace(cmd), strings.ToLower(strings.TrimSpace(note))
+		if cmd == "" {
+			continue
+		}
+		switch {
+		case note == "":
+			return cmd
+		case note == "macos" && platform == PlatformMacOS:
+			return cmd
+		case note == "ubuntu" && platformMatches(platform, "linux"):
+			return cmd
+		}
+	}
+	return ""
+}
+
+// formatMissingTools renders the preflight table.
+func formatMissingTools(missing []missingTool) string {
+	rows := [][]string{{"TOOL", "NEEDED BY", "INSTALL"}}
+	for _, m := range missing {
+		install := m.Install
+		if install == "" {
+			install = "(install it manually)"
+		}
+		rows = append(rows, []string{m.Program, strings.Join(m.Stages, ", "), install})
+	}
+	return formatTable(rows)
+}
+
+// installMissingTools runs the install command of each missing tool,
+// streaming its output, and returns the tools still missing afterwards.
+func installMissingTools(ctx context.Context, missing []missingTool, stages []Stage, root string, platform Platform) []missingTool {
+	out, _ := humanStream()
+	for _, m := range missing {
+		if m.Install == "" {
+			continue
+		}
+		printf("📦 Installing %s: %s\n", m.Program, m.Install)
+		cmd := exec.CommandContext(ctx, "sh", "-c", m.Install)
+		cmd.Stdout, cmd.Stderr = out, out
+		if err := cmd.Run(); err != nil {
+			warnf("Installing %s failed: %v\n", m.Program, err)
+		}
+	}
+	return preflightStages(stages, root, platform)
+}
+
+// stageToolCheck is the outcome of checkStageTools.
+type stageToolCheck struct {
+	Missing  []missingTool
+	Enforced []toolMismatch // [tools] pins that don't hold: the run can't start
+	Advisory []toolMismatch // version-file pins that don't hold: only a warning
+}
+
+// checkStageTools is what every local run checks before its stages start:
+// each program the pending stages need must resolve (installing missing
+// ones first when install is set), and pinned tools must match their pins.
+// probes holds the versions already probed this run.
+func checkStageTools(pending []Stage, root string, tools map[string]string, install bool, probes versionProbes) (stageToolCheck, error) {
+	var c stageToolCheck
+	platform := DetectPlatform()
+	c.Missing = preflightStages(pending, root, platform)
+	if len(c.Missing) > 0 && install {
+		c.Missing = installMissingTools(context.Background(), c.Missing, pending, root, platform)
+		// Tools that were missing may be installed now.
+		for name, v := range probes {
+			if v == "" {
+				delete(probes, name)
+			}
+		}
+	}
+	pins, err := loadToolPins(root, tools)
+	if err != nil {
+		return c, err
+	}
+	for _, m := range checkToolPins(pins, pending, probes) {
+		if m.Pin.enforced() {
+			c.Enforced = append(c.Enforced, m)
+		} else {
+			c.Advisory = append(c.Advisory, m)
+		}
+	}
+	return c, nil
+}
+
+// failure explains why the run can't start, or is "" when it can. hint
+// follows the missing tools table.
+func (c stageToolCheck) failure(hint string) string {
+	var b strings.Builder
+	if len(c.Missing) > 0 {
+		fmt.Fprintf(&b, "❌ Missing tools:\n\n%s\n", formatMissingTools(c.Missing))
+		if hint != "" {
+			b.WriteString(hint + "\n")
+		}
+	}
+	if len(c.Enforced) > 0 {
+		fmt.Fprintf(&b, "❌ Tool versions don't match [tools]:\n\n%s\n", formatToolMismatches(c.Enforced))
+	}
+	return b.String()
+}
+
+// warning reports pins from version files that don't hold, or "".
+func (c stageToolCheck) warning() string {
+	if len(c.Advisory) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("⚠️  Tool versions differ from version files:\n\n%s\n", formatToolMismatches(c.Advisory))
+}
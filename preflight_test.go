@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStagePrograms(t *testing.T) {
+	tests := map[string]string{
+		"cargo test --workspace":      "cargo",
+		"cargo deny check":            "cargo cargo-deny",
+		"cargo +nightly fmt --check":  "cargo cargo-fmt",
+		"cargo --locked nextest run":  "cargo cargo-nextest",
+		"bun test":                    "bun",
+		"./scripts/check.sh --strict": "./scripts/check.sh",
+	}
+	for cmd, want := range tests {
+		got := strings.Join(stagePrograms(Stage{Cmd: strings.Fields(cmd)}), " ")
+		if got != want {
+			t.Errorf("stagePrograms(%q) = %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestInstallCommand(t *testing.T) {
+	tests := []struct {
+		program  string
+		platform Platform
+		want     string
+	}{
+		{"cargo-deny", PlatformGenericLinux, "cargo install cargo-deny"},
+		{"cargo-deny", PlatformNixOS, "nix profile install nixpkgs#cargo-deny"},
+		{"protoc", PlatformMacOS, "brew install protobuf"},
+		{"protoc", PlatformGenericLinux, "sudo apt install protobuf-compiler"},
+		{"protoc", PlatformNixOS, "nix profile install nixpkgs#protobuf"},
+		{"cargo-fmt", PlatformMacOS, "rustup component add rustfmt"},
+		{"cargo-hack", PlatformMacOS, "cargo install cargo-hack"},
+		{"some-internal-tool", PlatformMacOS, ""},
+	}
+	for _, tt := range tests {
+		if got := installCommand(tt.program, tt.platform); got != tt.want {
+			t.Errorf("installCommand(%s, %s) = %q, want %q", tt.program, tt.platform, got, tt.want)
+		}
+	}
+}
+
+func TestPreflightStages(t *testing.T) {
+	root := t.TempDir()
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "cargo"), []byte("#!/bin/sh\n"), 0o755)
+	os.MkdirAll(filepath.Join(root, "scripts"), 0o755)
+	os.WriteFile(filepath.Join(root, "scripts", "ok.sh"), []byte("#!/bin/sh\n"), 0o755)
+	t.Setenv("PATH", bin)
+
+	stages := []Stage{
+		{Name: "test", Cmd: []string{"cargo", "test"}},
+		{Name: "deny", Cmd: []string{"cargo", "deny", "check"}},
+		{Name: "audit", Cmd: []string{"cargo", "deny", "check", "advisories"}},
+		{Name: "lint", Cmd: []string{"golangci-lint", "run"}},
+		{Name: "script", Cmd: []string{"./scripts/ok.sh"}},
+		{Name: "gone", Cmd: []string{"./scripts/gone.sh"}},
+		{Name: "boxed", Cmd: []string{"golangci-lint", "run"}, Container: "golangci/golangci-lint"},
+		{Name: "skipped", Cmd: []string{"golangci-lint", "run"}, SkipReason: "if_env: CI is not set"},
+		{Name: "own-path", Cmd: []string{"cargo", "test"}, Env: map[string]string{"PATH": t.TempDir()}},
+	}
+	missing := preflightStages(stages, root, PlatformGenericLinux)
+	var got []string
+	for _, m := range missing {
+		got = append(got, m.Program+"="+strings.Join(m.Stages, "+"))
+	}
+	want := "./scripts/gone.sh=gone cargo=own-path cargo-deny=deny+audit golangci-lint=lint"
+	if strings.Join(got, " ") != want {
+		t.Errorf("missing = %s\nwant      %s", strings.Join(got, " "), want)
+	}
+
+	table := formatMissingTools(missing)
+	for _, line := range []string{"cargo-deny", "deny, audit", "cargo install cargo-deny", "(install it manually)"} {
+		if !strings.Contains(table, line) {
+			t.Errorf("table missing %q:\n%s", line, table)
+		}
+	}
+}
+
+func TestInstallMissingTools(t *testing.T) {
+	root, bin := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"/bin"+string(os.PathListSeparator)+"/usr/bin")
+	stages := []Stage{{Name: "lint", Cmd: []string{"fake-linter"}}, {Name: "other", Cmd: []string{"fake-other"}}}
+
+	missing := preflightStages(stages, root, PlatformGenericLinux)
+	if len(missing) != 2 {
+		t.Fatalf("missing = %+v", missing)
+	}
+	missing[0].Install = "printf '#!/bin/sh\\n' > " + filepath.Join(bin, "fake-linter") + " && chmod +x " + filepath.Join(bin, "fake-linter")
+	left := installMissingTools(context.Background(), missing, stages, root, PlatformGenericLinux)
+	if len(left) != 1 || left[0].Program != "fake-other" {
+		t.Errorf("still missing = %+v", left)
+	}
+}
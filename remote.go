@@ -3,30 +3,51 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 // remoteSSH abstracts SSH execution so RemoteExecutor can be unit-tested
-// without a live host. When nil on RemoteExecutor, execSSH is used.
+// without a live host. When nil on RemoteExecutor, nativeSSH (or execSSH
+// with OpenSSH set) is used.
 type remoteSSH interface {
 	execWithOutput(ctx context.Context, cmd string) (string, error)
 }
 
+// remoteStreamer is implemented by SSH clients that can run a command with
+// its output streamed as it is produced. The returned error carries the
+// command's exit status (see remoteExitStatus).
+type remoteStreamer interface {
+	run(ctx context.Context, cmd string, stdout, stderr io.Writer) error
+}
+
+// remoteUploader is implemented by SSH clients that can feed stdin to a
+// remote command (used to stream the workspace during sync).
+type remoteUploader interface {
+	runWithInput(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
 type execSSH struct {
 	host           string
 	connectTimeout time.Duration
 }
 
-func (e execSSH) execWithOutput(ctx context.Context, cmd string) (string, error) {
+func (e execSSH) command(ctx context.Context, cmd string) *exec.Cmd {
 	timeoutSec := int(e.connectTimeout.Seconds())
 	if timeoutSec < 1 {
 		timeoutSec = 10
 	}
-	sshCmd := exec.CommandContext(
+	return exec.CommandContext(
 		ctx,
 		"ssh",
 		"-o",
@@ -34,12 +55,23 @@ func (e execSSH) execWithOutput(ctx context.Context, cmd string) (string, error)
 		e.host,
 		cmd,
 	)
+}
 
-	var stdout, stderr bytes.Buffer
-	sshCmd.Stdout = &stdout
-	sshCmd.Stderr = &stderr
+func (e execSSH) run(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	return e.runWithInput(ctx, cmd, nil, stdout, stderr)
+}
 
-	if err := sshCmd.Run(); err != nil {
+func (e execSSH) runWithInput(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	sshCmd := e.command(ctx, cmd)
+	sshCmd.Stdin = stdin
+	sshCmd.Stdout = stdout
+	sshCmd.Stderr = stderr
+	return sshCmd.Run()
+}
+
+func (e execSSH) execWithOutput(ctx context.Context, cmd string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := e.run(ctx, cmd, &stdout, &stderr); err != nil {
 		if benignSSHFailure(cmd, stdout.String(), stderr.String()) {
 			return "", nil
 		}
@@ -69,14 +101,57 @@ func benignSSHFailure(cmd, stdout, stderr string) bool {
 		(stderr == "" && stdout == "")
 }
 
-// RemoteExecutor handles execution of stages on a remote machine via SSH+tmux
+// remoteExitStatus extracts the remote command's exit status from a
+// streamed run's error. It reports false for connection and session errors.
+func remoteExitStatus(err error) (int, bool) {
+	var sshErr *ssh.ExitError
+	if errors.As(err, &sshErr) {
+		return sshErr.ExitStatus(), true
+	}
+	// The ssh binary reserves 255 for its own failures.
+	var execErr *exec.ExitError
+	if errors.As(err, &execErr) && execErr.ExitCode() != 255 && execErr.ExitCode() >= 0 {
+		return execErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// RemoteExecutor handles execution of stages on a remote machine. Stages run
+// as direct SSH commands; with Tmux set they run inside a tmux session
+// instead, so a developer can attach to them, with output teed to a
+// per-stage log file.
 type RemoteExecutor struct {
 	Host    string        // SSH host (e.g., "aivcs@100.90.209.9")
 	Session string        // tmux session name (e.g., "onion")
+	Tmux    bool          // Run stages in the tmux session instead of direct exec
 	WorkDir string        // Remote working directory
 	Timeout time.Duration // SSH operation timeout
 	Verbose bool          // Show detailed output
-	ssh     remoteSSH     // test hook; defaults to execSSH
+	OpenSSH bool          // Shell out to the ssh binary instead of the built-in client
+
+	LocalDir       string // local project root that receives stage artifacts; empty skips fetching
+	ArtifactsLabel string // subdirectory of each stage's artifact directory (per-host in --matrix)
+	CacheTarget    string // cache namespace, e.g. "sparky/linux_spark"; defaults to Host
+
+	mu  sync.Mutex
+	ssh remoteSSH // test hook; defaults to nativeSSH
+}
+
+// cacheTarget is the namespace this host's results are cached under.
+func (re *RemoteExecutor) cacheTarget() string {
+	if re.CacheTarget != "" {
+		return re.CacheTarget
+	}
+	return re.Host
+}
+
+// remoteCacheTarget names the cache namespace for a host preset: the preset
+// name qualified by its platform, e.g. "sparky/linux_spark".
+func remoteCacheTarget(preset, platform string) string {
+	if platform == "" {
+		return preset
+	}
+	return preset + "/" + platform
 }
 
 // NewRemoteExecutor creates a new remote executor
@@ -96,11 +171,29 @@ func NewRemoteExecutor(host, session, workDir string, timeout time.Duration, ver
 	}
 }
 
+// sshClient returns the executor's SSH client, creating it on first use so
+// every command in the run shares one connection.
 func (re *RemoteExecutor) sshClient() remoteSSH {
-	if re.ssh != nil {
-		return re.ssh
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if re.ssh == nil {
+		if re.OpenSSH {
+			re.ssh = execSSH{host: re.Host, connectTimeout: re.Timeout}
+		} else {
+			re.ssh = newNativeSSH(re.Host, re.Timeout)
+		}
+	}
+	return re.ssh
+}
+
+// Close releases the shared SSH connection, if any.
+func (re *RemoteExecutor) Close() error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if c, ok := re.ssh.(io.Closer); ok {
+		return c.Close()
 	}
-	return execSSH{host: re.Host, connectTimeout: re.Timeout}
+	return nil
 }
 
 // joinShellCommand quotes argv for safe remote shell execution.
@@ -115,23 +208,82 @@ func joinShellCommand(parts []string) string {
 	return strings.Join(quoted, " ")
 }
 
-// buildRemoteStageCommand wraps a stage command with cd + exit-code sentinel capture.
-func buildRemoteStageCommand(workDir string, cmd []string, sentinelFile string) string {
+// buildRemoteStageCommand wraps a stage command for the tmux session: it
+// runs in workDir with output teed to logFile, and the exit status lands in
+// sentinelFile only once the log is complete.
+func buildRemoteStageCommand(workDir string, cmd []string, sentinelFile, logFile string) string {
 	return fmt.Sprintf(
-		"cd %s && %s; echo $? > %s",
+		"{ cd %s && %s; echo $? > %s.tmp; } 2>&1 | tee %s; mv -f %s.tmp %s",
 		escapeShellArg(workDir),
 		joinShellCommand(cmd),
 		sentinelFile,
+		escapeShellArg(logFile),
+		sentinelFile,
+		sentinelFile,
+	)
+}
+
+// followLogCommand streams logFile until sentinelFile appears.
+func followLogCommand(logFile, sentinelFile string) string {
+	log := escapeShellArg(logFile)
+	return fmt.Sprintf(
+		"until [ -f %s ] || [ -f %s ]; do sleep 0.1; done; tail -n +1 -f %s & t=$!; until [ -f %s ]; do sleep 0.2; done; sleep 0.3; kill $t",
+		log, sentinelFile, log, sentinelFile,
 	)
 }
 
+// LogDir is where tmux-mode stage logs are kept on the remote host.
+func (re *RemoteExecutor) LogDir() string {
+	return "/tmp/local-ci-" + re.Session
+}
+
+// stageLogPath is the remote log file for a tmux-mode stage.
+func (re *RemoteExecutor) stageLogPath(name string) string {
+	return path.Join(re.LogDir(), name+".log")
+}
+
+// remoteWorkDir is the remote workspace: dir when set, otherwise
+// /tmp/<basename of the local workspace>.
+func remoteWorkDir(dir, localDir string) string {
+	if dir != "" {
+		return dir
+	}
+	return path.Join("/tmp", filepath.Base(localDir))
+}
+
+// remoteStageDir is the stage's working directory on the remote host.
+func remoteStageDir(workDir string, stage Stage) string {
+	if stage.Dir == "" {
+		return workDir
+	}
+	return path.Join(workDir, filepath.ToSlash(stage.Dir))
+}
+
+// remoteStageCmd prefixes the stage command with `env KEY=value ...` when
+// the stage sets extra environment variables.
+func remoteStageCmd(stage Stage) []string {
+	if len(stage.Env) == 0 {
+		return stage.Cmd
+	}
+	cmd := append([]string{"env"}, stageEnv(stage)...)
+	return append(cmd, stage.Cmd...)
+}
+
 // ExecuteStage runs a single stage on the remote machine
 func (re *RemoteExecutor) ExecuteStage(stage Stage) Result {
+	return re.RunStage(context.Background(), stage, nil)
+}
+
+// RunStage runs a stage remotely and returns its full output and real exit
+// status. When live is non-nil, output is also streamed to it as the stage
+// produces it. Clients that can't stream always go through tmux.
+func (re *RemoteExecutor) RunStage(ctx context.Context, stage Stage, live io.Writer) Result {
 	start := time.Now()
 	result := Result{
 		Name:    stage.Name,
 		Command: strings.Join(stage.Cmd, " "),
 		Status:  "fail",
+		Host:    re.Host,
 	}
 
 	if len(stage.Cmd) == 0 {
@@ -140,60 +292,122 @@ func (re *RemoteExecutor) ExecuteStage(stage Stage) Result {
 		return result
 	}
 
-	sentinelFile := fmt.Sprintf("/tmp/kc_exit_%s_%d", stage.Name, time.Now().UnixNano())
-	remoteCmd := buildRemoteStageCommand(re.WorkDir, stage.Cmd, sentinelFile)
-
 	stageTimeout := time.Duration(stage.Timeout) * time.Second
 	if stageTimeout <= 0 {
 		stageTimeout = 10 * time.Minute
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), stageTimeout)
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout)
 	defer cancel()
 
-	if err := re.sendToSession(ctx, remoteCmd); err != nil {
+	var exitCode int
+	var err error
+	streamer, canStream := re.sshClient().(remoteStreamer)
+	if re.Tmux || !canStream {
+		result.Output, exitCode, err = re.runInSession(ctx, stage, streamer, live)
+	} else {
+		result.Output, exitCode, err = re.runDirect(ctx, stage, streamer, live)
+	}
+	result.Duration = time.Since(start)
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Error = fmt.Errorf("timed out after %s", stageTimeout)
+	case err != nil:
 		result.Error = err
-		result.Duration = time.Since(start)
 		if re.Verbose {
 			warnf("Remote execution failed: %v", err)
 		}
-		return result
+	case exitCode != 0:
+		result.Error = fmt.Errorf("exit code %d", exitCode)
+	default:
+		result.Status = "pass"
+		// Artifacts are fetched outside the stage timeout.
+		if result.Artifacts, err = re.FetchArtifacts(parent, stage); err != nil {
+			result.Status = "fail"
+			result.Error = err
+		}
+	}
+	return result
+}
+
+// runDirect runs the stage as its own SSH command. stderr is merged into
+// stdout on the host so the log keeps the order the output was produced in.
+func (re *RemoteExecutor) runDirect(ctx context.Context, stage Stage, streamer remoteStreamer, live io.Writer) (string, int, error) {
+	cmd := fmt.Sprintf("exec 2>&1; cd %s && %s", escapeShellArg(remoteStageDir(re.WorkDir, stage)), joinShellCommand(remoteStageCmd(stage)))
+
+	var out bytes.Buffer
+	var sink io.Writer = &out
+	if live != nil {
+		sink = io.MultiWriter(&out, live)
+	}
+	sink = &lockedWriter{w: sink}
+	err := streamer.run(ctx, cmd, sink, sink)
+	if err == nil {
+		return out.String(), 0, nil
+	}
+	if code, ok := remoteExitStatus(err); ok {
+		return out.String(), code, nil
+	}
+	return out.String(), -1, err
+}
+
+// runInSession types the stage into the tmux session, waits for its exit
+// status and reads back the complete log.
+func (re *RemoteExecutor) runInSession(ctx context.Context, stage Stage, streamer remoteStreamer, live io.Writer) (string, int, error) {
+	sentinelFile := fmt.Sprintf("/tmp/kc_exit_%s_%d", tmuxWindowName(stage.Name), time.Now().UnixNano())
+	logFile := re.stageLogPath(stage.Name)
+	prepare := fmt.Sprintf("mkdir -p %s && rm -f %s", escapeShellArg(re.LogDir()), escapeShellArg(logFile))
+	if err := re.sshExec(ctx, prepare); err != nil {
+		return "", -1, fmt.Errorf("failed to prepare stage log: %w", err)
+	}
+
+	remoteCmd := buildRemoteStageCommand(remoteStageDir(re.WorkDir, stage), remoteStageCmd(stage), sentinelFile, logFile)
+	if err := re.sendToSession(ctx, tmuxWindowName(stage.Name), remoteCmd); err != nil {
+		return "", -1, err
+	}
+
+	if streamer != nil && live != nil {
+		// Returns once the sentinel exists; pollExitCode then reads it.
+		_ = streamer.run(ctx, followLogCommand(logFile, sentinelFile), live, io.Discard)
 	}
 
 	exitCode, err := re.pollExitCode(ctx, sentinelFile)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to get exit code: %w", err)
-		result.Duration = time.Since(start)
-		return result
+		return "", -1, fmt.Errorf("failed to get exit code: %w", err)
 	}
 
-	output, err := re.captureSessionOutput(ctx)
+	output, err := re.sshExecWithOutput(ctx, "cat "+escapeShellArg(logFile))
 	if err != nil {
-		result.Error = fmt.Errorf("failed to capture output: %w", err)
-		result.Duration = time.Since(start)
-		return result
+		return "", -1, fmt.Errorf("failed to read stage log: %w", err)
 	}
-
 	_ = re.cleanupSentinel(sentinelFile)
+	return output, exitCode, nil
+}
 
-	result.Output = output
-	result.Duration = time.Since(start)
-
-	if exitCode == 0 {
-		result.Status = "pass"
-	} else {
-		result.Status = "fail"
-		result.Error = fmt.Errorf("exit code %d", exitCode)
-	}
+// lockedWriter serializes writes from a command's stdout and stderr, which
+// the SSH client copies on separate goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
 
-	return result
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
 }
 
-// sendToSession dispatches a command into a tmux session without waiting for completion.
-func (re *RemoteExecutor) sendToSession(ctx context.Context, cmd string) error {
+// sendToSession dispatches a command into its own window of the tmux session
+// without waiting for completion. A window per stage keeps concurrent stages
+// from typing into the same shell; a window left over from an earlier run of
+// the same stage is replaced.
+func (re *RemoteExecutor) sendToSession(ctx context.Context, window, cmd string) error {
 	initCmd := fmt.Sprintf(
-		"tmux new-session -d -s %s -c %s 'sleep 999999' 2>/dev/null; true",
+		"tmux new-session -d -s %s -c %s 'sleep 999999' \\; %s 2>/dev/null; true",
 		escapeShellArg(re.Session),
 		escapeShellArg(re.WorkDir),
+		tagSessionCommand(re.Session),
 	)
 
 	if err := re.sshExec(ctx, initCmd); err != nil {
@@ -202,9 +416,23 @@ func (re *RemoteExecutor) sendToSession(ctx context.Context, cmd string) error {
 		}
 	}
 
+	target := re.Session + ":=" + window
+	windowCmd := fmt.Sprintf(
+		"tmux kill-window -t %s 2>/dev/null; tmux new-window -d -P -F '#{window_id}' -t %s -n %s -c %s",
+		escapeShellArg(target),
+		escapeShellArg(re.Session+":"),
+		escapeShellArg(window),
+		escapeShellArg(re.WorkDir),
+	)
+	if id, err := re.sshExecWithOutput(ctx, windowCmd); err != nil {
+		return fmt.Errorf("failed to open tmux window: %w", err)
+	} else if id = strings.TrimSpace(id); id != "" {
+		target = id
+	}
+
 	sendCmd := fmt.Sprintf(
 		"tmux send-keys -t %s '%s' Enter",
-		escapeShellArg(re.Session),
+		escapeShellArg(target),
 		escapeForTmux(cmd),
 	)
 
@@ -215,28 +443,20 @@ func (re *RemoteExecutor) sendToSession(ctx context.Context, cmd string) error {
 	return nil
 }
 
-// captureSessionOutput reads the current tmux pane after a stage completes.
-func (re *RemoteExecutor) captureSessionOutput(ctx context.Context) (string, error) {
-	captureCmd := fmt.Sprintf("tmux capture-pane -t %s -p", escapeShellArg(re.Session))
-	output, err := re.sshExecWithOutput(ctx, captureCmd)
-	if err != nil {
-		return "", fmt.Errorf("failed to capture output: %w", err)
-	}
-	return output, nil
+// tmuxWindowName maps a stage name onto characters tmux targets accept.
+func tmuxWindowName(stage string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, stage)
 }
 
-// pollExitCode polls the remote sentinel file for the exit code
+// pollExitCode polls the remote sentinel file for the exit code until it
+// appears or ctx (bounded by the stage timeout) is done.
 func (re *RemoteExecutor) pollExitCode(ctx context.Context, sentinelFile string) (int, error) {
-	maxRetries := 300
-	retries := 0
-
-	for retries < maxRetries {
-		select {
-		case <-ctx.Done():
-			return -1, ctx.Err()
-		default:
-		}
-
+	for {
 		catCmd := fmt.Sprintf("cat %s 2>/dev/null", sentinelFile)
 		output, err := re.sshExecWithOutput(ctx, catCmd)
 		if err == nil && output != "" {
@@ -246,11 +466,12 @@ func (re *RemoteExecutor) pollExitCode(ctx context.Context, sentinelFile string)
 			}
 		}
 
-		time.Sleep(100 * time.Millisecond)
-		retries++
+		select {
+		case <-ctx.Done():
+			return -1, fmt.Errorf("timeout waiting for exit code from %s: %w", sentinelFile, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
 	}
-
-	return -1, fmt.Errorf("timeout waiting for exit code from %s", sentinelFile)
 }
 
 // cleanupSentinel removes the sentinel file
@@ -271,9 +492,20 @@ func (re *RemoteExecutor) sshExecWithOutput(ctx context.Context, cmd string) (st
 	return re.sshClient().execWithOutput(ctx, cmd)
 }
 
-// escapeShellArg safely escapes a shell argument
+// escapeShellArg safely escapes a shell argument. A leading "~" or "~/"
+// still means the home directory, as it would unquoted, so remote_dir =
+// "~/src/app" lands in $HOME rather than in a directory named "~".
 func escapeShellArg(arg string) string {
-	if !strings.ContainsAny(arg, " \t\n'\"\\$`") {
+	if arg == "~" {
+		return `"$HOME"`
+	}
+	if rest, ok := strings.CutPrefix(arg, "~/"); ok {
+		if rest == "" {
+			return `"$HOME"/`
+		}
+		return `"$HOME"/` + escapeShellArg(rest)
+	}
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`;|&<>()*?[]{}#~!") {
 		return arg
 	}
 	return "'" + strings.ReplaceAll(arg, "'", "'\\''") + "'"
@@ -287,57 +519,42 @@ func escapeForTmux(cmd string) string {
 // EnsureRemoteSession creates or attaches to a remote tmux session
 func (re *RemoteExecutor) EnsureRemoteSession(ctx context.Context) error {
 	cmd := fmt.Sprintf(
-		"tmux new-session -d -s %s -c %s 'sleep 999999' 2>/dev/null || true",
+		"tmux new-session -d -s %s -c %s 'sleep 999999' \\; %s 2>/dev/null || true",
 		escapeShellArg(re.Session),
 		escapeShellArg(re.WorkDir),
+		tagSessionCommand(re.Session),
 	)
 	return re.sshExec(ctx, cmd)
 }
 
-// KillRemoteSession kills the remote tmux session
-func (re *RemoteExecutor) KillRemoteSession(ctx context.Context) error {
-	cmd := fmt.Sprintf("tmux kill-session -t %s 2>/dev/null || true", escapeShellArg(re.Session))
-	return re.sshExec(ctx, cmd)
-}
-
-// TestSSHConnection tests if SSH connection works
-func (re *RemoteExecutor) TestSSHConnection(ctx context.Context) error {
-	return re.sshExec(ctx, "echo 'SSH connection OK'")
+// tagSessionCommand is the tmux command, chained onto new-session, that marks
+// a session as created by local-ci so `local-ci remote ls|clean` can tell it
+// apart from the user's own sessions.
+func tagSessionCommand(session string) string {
+	return "set-option -t " + escapeShellArg(session) + " @local-ci 1"
 }
 
-// SyncWorkspace uses rsync to synchronize local directory to remote WorkDir
-func (re *RemoteExecutor) SyncWorkspace(ctx context.Context, localDir string, skipDirs []string) error {
-	mkdirCmd := fmt.Sprintf("mkdir -p %s", escapeShellArg(re.WorkDir))
-	if err := re.sshExec(ctx, mkdirCmd); err != nil {
-		return fmt.Errorf("failed to create remote work directory: %w", err)
-	}
-
-	rsyncArgs := []string{"-az", "--delete"}
-	rsyncArgs = append(rsyncArgs, "--exclude", ".git", "--exclude", ".local-ci-cache")
-
-	for _, dir := range skipDirs {
-		if dir != "" && dir != ".git" {
-			rsyncArgs = append(rsyncArgs, "--exclude", dir)
-		}
-	}
-
-	src := localDir
-	if !strings.HasSuffix(src, "/") {
-		src += "/"
-	}
-	dest := fmt.Sprintf("%s:%s", re.Host, re.WorkDir)
-	rsyncArgs = append(rsyncArgs, src, dest)
-
-	if re.Verbose {
-		printf("Syncing workspace to remote: rsync %s\n", strings.Join(rsyncArgs, " "))
+// KillRemoteSession kills the remote tmux session if local-ci created it
+// (it carries the @local-ci tag), and reports whether it did. A session of
+// the same name that the user made is left alone.
+func (re *RemoteExecutor) KillRemoteSession(ctx context.Context) (bool, error) {
+	out, err := re.sshExecWithOutput(ctx, killTaggedSessionCommand(re.Session))
+	if err != nil {
+		return false, err
 	}
+	return strings.TrimSpace(out) == "killed", nil
+}
 
-	cmd := exec.CommandContext(ctx, "rsync", rsyncArgs...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("rsync failed: %w (stderr: %s)", err, stderr.String())
-	}
+// killTaggedSessionCommand kills session only when it carries the @local-ci
+// tag, printing "killed" if it did.
+func killTaggedSessionCommand(session string) string {
+	s := escapeShellArg("=" + session)
+	return fmt.Sprintf(
+		"if [ \"$(tmux display-message -p -t %s '#{@local-ci}' 2>/dev/null)\" = 1 ] && tmux kill-session -t %s 2>/dev/null; then echo killed; fi",
+		s, s)
+}
 
-	return nil
+// TestSSHConnection tests if SSH connection works
+func (re *RemoteExecutor) TestSSHConnection(ctx context.Context) error {
+	return re.sshExec(ctx, "echo 'SSH connection OK'")
 }
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const remoteUsage = `Usage: local-ci remote <command> [--remote-host name[,name...]] [--remote user@host] [--session name]
+
+Commands:
+  doctor [stages...]           Check hosts can run the selected stages
+  ls                           List local-ci sessions, workdirs, logs and exit sentinels
+  attach                       Attach to the host's tmux session (needs the ssh binary)
+  logs [-f] [stage]            Print (or follow) a tmux-mode stage log; lists logs without a stage
+  clean [--older-than 72h]     Remove sessions, workdirs, logs and sentinels idle that long
+  kill                         Kill one host's local-ci tmux session and remove its logs
+`
+
+// remoteCmdFlags are the target flags shared by `local-ci remote` subcommands.
+type remoteCmdFlags struct {
+	fs        *flag.FlagSet
+	remote    *string
+	presets   *string
+	session   *string
+	remoteDir *string
+	timeout   *int
+	ssh       *string
+	tmux      *bool
+	json      *bool
+}
+
+func newRemoteCmdFlags(name string, jsonOut bool) (*flag.FlagSet, *remoteCmdFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	f := &remoteCmdFlags{
+		fs:        fs,
+		remote:    fs.String("remote", "", "SSH host (user@host); defaults to every preset in .local-ci-remote.toml"),
+		presets:   fs.String("remote-host", "", "Comma-separated host presets from .local-ci-remote.toml"),
+		session:   fs.String("session", "onion", "tmux session name"),
+		remoteDir: fs.String("remote-dir", "", "Remote working directory (defaults to /tmp/<basename>)"),
+		timeout:   fs.Int("remote-timeout", 30, "SSH timeout in seconds"),
+		ssh:       fs.String("ssh", "native", "SSH client: native or openssh"),
+		tmux:      fs.Bool("tmux", false, "Check for the tmux execution mode"),
+		json:      fs.Bool("json", jsonOut, "Output JSON"),
+	}
+	return fs, f
+}
+
+// targets resolves the hosts a subcommand acts on: --remote, the named
+// presets, or every configured preset. Names label each host in output.
+func (f *remoteCmdFlags) targets(cfg *Config, root string) ([]*RemoteExecutor, []string, error) {
+	timeout := time.Duration(*f.timeout) * time.Second
+	var remotes []*RemoteExecutor
+	var names []string
+	if *f.remote != "" {
+		host := NormalizeSSHHost(*f.remote, remotePlatformMacOS, cfg.SSHDefaults)
+		remotes = append(remotes, NewRemoteExecutor(host, *f.session, remoteWorkDir(*f.remoteDir, root), timeout, false))
+		names = append(names, host)
+	} else {
+		presets := splitHostList(*f.presets)
+		if len(presets) == 0 {
+			for _, h := range cfg.ListRemoteHosts() {
+				presets = append(presets, h.Name)
+			}
+		}
+		if len(presets) == 0 {
+			return nil, nil, fmt.Errorf("no remote hosts: pass --remote or --remote-host, or add [hosts.<name>] to .local-ci-remote.toml")
+		}
+		set := make(map[string]bool)
+		f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+		for _, name := range presets {
+			resolved, err := cfg.ResolveRemoteHost(name, "", *f.session, *f.remoteDir, set["session"], set["remote-dir"])
+			if err != nil {
+				return nil, nil, err
+			}
+			re := NewRemoteExecutor(resolved.Host, resolved.Session, remoteWorkDir(resolved.RemoteDir, root), timeout, false)
+			re.CacheTarget = resolved.CacheTarget
+			remotes = append(remotes, re)
+			names = append(names, name)
+		}
+	}
+	for _, re := range remotes {
+		re.OpenSSH = *f.ssh == "openssh"
+		re.Tmux = *f.tmux
+	}
+	return remotes, names, nil
+}
+
+// parseTargets parses args, loads the config and resolves the target hosts.
+// The caller closes the executors. A non-zero code means the command is done.
+func (f *remoteCmdFlags) parseTargets(root string, args []string) (*Config, []*RemoteExecutor, []string, int) {
+	if err := f.fs.Parse(args); err != nil {
+		return nil, nil, nil, 2
+	}
+	cfg, err := LoadConfig(root, true)
+	if err != nil {
+		errorf("Failed to load config: %v\n", err)
+		return nil, nil, nil, 2
+	}
+	remotes, names, err := f.targets(cfg, root)
+	if err != nil {
+		errorf("%v\n", err)
+		return nil, nil, nil, 2
+	}
+	return cfg, remotes, names, 0
+}
+
+// singleTarget is parseTargets for commands that act on exactly one host.
+func (f *remoteCmdFlags) singleTarget(root string, args []string) (*RemoteExecutor, int) {
+	_, remotes, names, code := f.parseTargets(root, args)
+	if code != 0 {
+		return nil, code
+	}
+	if len(remotes) > 1 {
+		closeRemotes(remotes)
+		errorf("%d hosts configured (%s): pick one with --remote-host or --remote\n", len(names), strings.Join(names, ", "))
+		return nil, 2
+	}
+	return remotes[0], 0
+}
+
+func closeRemotes(remotes []*RemoteExecutor) {
+	for _, re := range remotes {
+		re.Close()
+	}
+}
+
+// cmdRemote implements `local-ci remote <subcommand>`.
+func cmdRemote(root string, args []string, jsonOut bool) int {
+	if len(args) == 0 {
+		errorf(remoteUsage)
+		return 2
+	}
+	switch args[0] {
+	case "doctor":
+		return cmdRemoteDoctor(root, args[1:], jsonOut)
+	case "ls":
+		return cmdRemoteLs(root, args[1:], jsonOut)
+	case "attach":
+		return cmdRemoteAttach(root, args[1:])
+	case "logs":
+		return cmdRemoteLogs(root, args[1:])
+	case "clean":
+		return cmdRemoteClean(root, args[1:], jsonOut)
+	case "kill":
+		return cmdRemoteKill(root, args[1:])
+	default:
+		errorf("Unknown remote command %q\n%s", args[0], remoteUsage)
+		return 2
+	}
+}
+
+// RemoteDoctorJSON is the JSON form of `local-ci remote doctor`.
+type RemoteDoctorJSON struct {
+	Hosts []*remoteProbe `json:"hosts"`
+	Ready bool           `json:"ready"`
+}
+
+// cmdRemoteDoctor probes each host and prints a readiness table. It exits
+// non-zero when any host can't run the selected stages.
+func cmdRemoteDoctor(root string, args []string, jsonOut bool) int {
+	fs, f := newRemoteCmdFlags("remote doctor", jsonOut)
+	cfg, remotes, names, code := f.parseTargets(root, args)
+	if code != 0 {
+		return code
+	}
+	defer closeRemotes(remotes)
+
+	tools := remoteToolRequirements(cfg.SelectStages(fs.Args()), *f.tmux, cfg.Sync.Git)
+	probes := probeRemotes(context.Background(), remotes, names, tools)
+
+	// A fresh doctor run also refreshes the cache the pre-run probe uses.
+	cache := loadProbeCache(root)
+	ready := true
+	for i, p := range probes {
+		cache[remotes[i].cacheTarget()] = p
+		ready = ready && p.Ready()
+	}
+	saveProbeCache(root, cache)
+
+	if *f.json {
+		data, _ := json.MarshalIndent(RemoteDoctorJSON{Hosts: probes, Ready: ready}, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		printf("%s\n", formatProbeTable(probes))
+		printProbeProblems(probes)
+		if ready {
+			successf("✅ %d host(s) ready\n", len(probes))
+		} else {
+			errorf("❌ Not every host is ready\n")
+		}
+	}
+	if !ready {
+		return 1
+	}
+	return 0
+}
+
+// RemoteLsJSON is the JSON form of `local-ci remote ls` and `remote clean`.
+type RemoteLsJSON struct {
+	Hosts []*remoteInventory `json:"hosts"`
+}
+
+// cmdRemoteLs lists what local-ci has left on each host.
+func cmdRemoteLs(root string, args []string, jsonOut bool) int {
+	_, f := newRemoteCmdFlags("remote ls", jsonOut)
+	_, remotes, names, code := f.parseTargets(root, args)
+	if code != 0 {
+		return code
+	}
+	defer closeRemotes(remotes)
+
+	invs := inventoryRemotes(context.Background(), remotes, names)
+	if *f.json {
+		data, _ := json.MarshalIndent(RemoteLsJSON{Hosts: invs}, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for i, inv := range invs {
+			if i > 0 {
+				printf("\n")
+			}
+			printf("%s", formatInventory(inv))
+		}
+	}
+	for _, inv := range invs {
+		if inv.Error != "" {
+			return 1
+		}
+	}
+	return 0
+}
+
+// cmdRemoteAttach attaches the terminal to the host's tmux session.
+func cmdRemoteAttach(root string, args []string) int {
+	_, f := newRemoteCmdFlags("remote attach", false)
+	re, code := f.singleTarget(root, args)
+	if code != 0 {
+		return code
+	}
+	re.Close()
+	if err := attachCommand(re).Run(); err != nil {
+		errorf("Failed to attach to %s on %s: %v\n", re.Session, re.Host, err)
+		return 1
+	}
+	return 0
+}
+
+// cmdRemoteLogs prints or follows a tmux-mode stage log until interrupted.
+func cmdRemoteLogs(root string, args []string) int {
+	fs, f := newRemoteCmdFlags("remote logs", false)
+	follow := fs.Bool("f", false, "Follow the log as it grows")
+	re, code := f.singleTarget(root, args)
+	if code != 0 {
+		return code
+	}
+	defer re.Close()
+	if fs.NArg() > 1 {
+		errorf("remote logs takes at most one stage\n")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cmd := stageLogCommand(re, fs.Arg(0), *follow)
+	var err error
+	if streamer, ok := re.sshClient().(remoteStreamer); ok {
+		err = streamer.run(ctx, cmd, os.Stdout, os.Stderr)
+	} else {
+		var out string
+		out, err = re.sshExecWithOutput(ctx, cmd)
+		fmt.Print(out)
+	}
+	if err != nil && ctx.Err() == nil {
+		errorf("No logs for %q in %s on %s: %v\n", fs.Arg(0), re.LogDir(), re.Host, err)
+		return 1
+	}
+	return 0
+}
+
+// cmdRemoteClean removes sessions, workdirs, logs and exit sentinels that
+// have been idle longer than --older-than. Attached sessions are kept.
+func cmdRemoteClean(root string, args []string, jsonOut bool) int {
+	fs, f := newRemoteCmdFlags("remote clean", jsonOut)
+	olderThan := fs.Duration("older-than", defaultRemoteCleanAge, "Only remove what has been idle at least this long")
+	dryRun := fs.Bool("dry-run", false, "Show what would be removed without removing it")
+	_, remotes, names, code := f.parseTargets(root, args)
+	if code != 0 {
+		return code
+	}
+	defer closeRemotes(remotes)
+
+	ctx := context.Background()
+	invs := inventoryRemotes(ctx, remotes, names)
+	status := 0
+	for i, inv := range invs {
+		if inv.Error != "" {
+			status = 1
+			continue
+		}
+		inv.Entries = staleRemoteEntries(inv.Entries, *olderThan)
+		if *dryRun || len(inv.Entries) == 0 {
+			continue
+		}
+		re := remotes[i]
+		cctx, cancel := context.WithTimeout(ctx, re.Timeout)
+		if err := re.sshExec(cctx, removeRemoteCommand(inv.Entries)); err != nil {
+			inv.Error = err.Error()
+			status = 1
+		}
+		cancel()
+	}
+
+	if *f.json {
+		data, _ := json.MarshalIndent(RemoteLsJSON{Hosts: invs}, "", "  ")
+		fmt.Println(string(data))
+		return status
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	for i, inv := range invs {
+		if i > 0 {
+			printf("\n")
+		}
+		if inv.Error != "" || len(inv.Entries) == 0 {
+			printf("%s", formatInventory(inv))
+			continue
+		}
+		printf("%s (%s): %s %d item(s) idle for %s or more\n", inv.Name, inv.Host, verb, len(inv.Entries), formatAge(*olderThan))
+		for _, e := range inv.Entries {
+			printf("  %-8s  %s  (%s)\n", e.Kind, e.Name, formatAge(e.age()))
+		}
+	}
+	return status
+}
+
+// cmdRemoteKill kills one host's tmux session, if local-ci created it, and
+// removes its stage logs.
+func cmdRemoteKill(root string, args []string) int {
+	_, f := newRemoteCmdFlags("remote kill", false)
+	re, code := f.singleTarget(root, args)
+	if code != 0 {
+		return code
+	}
+	defer re.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), re.Timeout)
+	defer cancel()
+	killed, err := re.KillRemoteSession(ctx)
+	if err == nil {
+		err = re.sshExec(ctx, "rm -rf -- "+escapeShellArg(re.LogDir()))
+	}
+	if err != nil {
+		errorf("❌ %s: %v\n", re.Host, err)
+		return 1
+	}
+	if killed {
+		successf("✅ %s: killed session %s and removed %s\n", re.Host, re.Session, re.LogDir())
+	} else {
+		successf("✅ %s: no local-ci session %s; removed %s\n", re.Host, re.Session, re.LogDir())
+	}
+	return 0
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// remoteProbeTTL is how long a passing probe is trusted before the next
+	// remote run probes the host again.
+	remoteProbeTTL = 5 * time.Minute
+	// remoteProbeFile caches probes under .local-ci/.
+	remoteProbeFile = "remote-probe.json"
+	// lowDiskBytes is the free space below which doctor warns.
+	lowDiskBytes = 2 << 30
+)
+
+// remoteTool is a command a remote run needs, and the stages that use it.
+type remoteTool struct {
+	Name     string
+	Required bool
+	Stages   []string
+}
+
+// remoteToolRequirements lists the commands a remote run depends on: tar
+// for workspace sync, rsync (faster sync), tmux (required with --tmux), git
+// when [sync] ships git metadata, and each stage's program. Programs given
+// as a path are skipped since they arrive with the workspace.
+func remoteToolRequirements(stages []Stage, tmux bool, gitMode string) []remoteTool {
+	tools := []remoteTool{
+		{Name: "tar", Required: true},
+		{Name: "rsync"},
+		{Name: "tmux", Required: tmux},
+	}
+	if gitMode != "" && gitMode != "none" {
+		tools = append(tools, remoteTool{Name: "git", Required: true})
+	}
+	index := make(map[string]int, len(tools))
+	for i, t := range tools {
+		index[t.Name] = i
+	}
+	for _, s := range stages {
+		if len(s.Cmd) == 0 || strings.Contains(s.Cmd[0], "/") {
+			continue
+		}
+		name := s.Cmd[0]
+		i, ok := index[name]
+		if !ok {
+			i = len(tools)
+			index[name] = i
+			tools = append(tools, remoteTool{Name: name})
+		}
+		tools[i].Required = true
+		tools[i].Stages = append(tools[i].Stages, s.Name)
+	}
+	return tools
+}
+
+// toolProbe is one tool's state on a host.
+type toolProbe struct {
+	Name     string   `json:"name"`
+	Found    bool     `json:"found"`
+	Version  string   `json:"version,omitempty"` // first line of its version output
+	Required bool     `json:"required"`
+	Stages   []string `json:"stages,omitempty"`
+}
+
+// remoteProbe is what a health check learned about one host.
+type remoteProbe struct {
+	Name      string      `json:"name"` // preset name, or the host for --remote
+	Host      string      `json:"host"`
+	WorkDir   string      `json:"work_dir"`
+	Reachable bool        `json:"reachable"`
+	Error     string      `json:"error,omitempty"`
+	LatencyMS int64       `json:"latency_ms,omitempty"`
+	OS        string      `json:"os,omitempty"`
+	Arch      string      `json:"arch,omitempty"`
+	CPUs      int         `json:"cpus,omitempty"`
+	Load      []float64   `json:"load,omitempty"` // 1, 5 and 15 minute averages
+	DiskFree  int64       `json:"disk_free_bytes,omitempty"`
+	Tools     []toolProbe `json:"tools,omitempty"`
+	Errors    []string    `json:"errors,omitempty"`   // problems that block a run
+	Warnings  []string    `json:"warnings,omitempty"` // problems worth knowing about
+	CheckedAt time.Time   `json:"checked_at"`
+}
+
+// Ready reports whether the host can run the pipeline.
+func (p *remoteProbe) Ready() bool {
+	return p.Reachable && len(p.Errors) == 0
+}
+
+// remoteProbeScript prints the host's vitals and, for each tool, either
+// "tool+NAME=<first version line>" or "tool-NAME".
+func remoteProbeScript(workDir string, tools []remoteTool) string {
+	var b strings.Builder
+	b.WriteString("echo os=$(uname -s); echo arch=$(uname -m); ")
+	b.WriteString("echo cpus=$(getconf _NPROCESSORS_ONLN 2>/dev/null || sysctl -n hw.ncpu 2>/dev/null); ")
+	b.WriteString("echo uptime=$(uptime); ")
+	// remote_dir may not exist before the first sync; measure its closest
+	// existing parent instead.
+	fmt.Fprintf(&b, `d=%s; while [ ! -d "$d" ]; do d=$(dirname "$d"); done; echo df=$(df -Pk "$d" | tail -n 1); `, escapeShellArg(workDir))
+	for _, t := range tools {
+		versionFlag := "--version"
+		if t.Name == "tmux" {
+			versionFlag = "-V"
+		}
+		q := escapeShellArg(t.Name)
+		fmt.Fprintf(&b, "if command -v %s >/dev/null 2>&1; then echo %s$(%s %s 2>&1 </dev/null | head -n 1); else echo %s; fi; ",
+			q, escapeShellArg("tool+"+t.Name+"="), q, versionFlag, escapeShellArg("tool-"+t.Name))
+	}
+	return b.String()
+}
+
+var loadAvgPattern = regexp.MustCompile(`load averages?: ([0-9.]+),? ([0-9.]+),? ([0-9.]+)`)
+
+// parseRemoteProbe fills p from remoteProbeScript output.
+func parseRemoteProbe(p *remoteProbe, out string, tools []remoteTool) {
+	found := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "tool+"):
+			name, version, _ := strings.Cut(strings.TrimPrefix(line, "tool+"), "=")
+			found[name] = version
+		case strings.HasPrefix(line, "os="):
+			p.OS = strings.TrimPrefix(line, "os=")
+		case strings.HasPrefix(line, "arch="):
+			p.Arch = strings.TrimPrefix(line, "arch=")
+		case strings.HasPrefix(line, "cpus="):
+			p.CPUs, _ = strconv.Atoi(strings.TrimPrefix(line, "cpus="))
+		case strings.HasPrefix(line, "uptime="):
+			if m := loadAvgPattern.FindStringSubmatch(line); m != nil {
+				for _, v := range m[1:] {
+					f, _ := strconv.ParseFloat(v, 64)
+					p.Load = append(p.Load, f)
+				}
+			}
+		case strings.HasPrefix(line, "df="):
+			// Filesystem 1024-blocks Used Available Capacity Mounted-on
+			if fields := strings.Fields(strings.TrimPrefix(line, "df=")); len(fields) >= 4 {
+				if kb, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+					p.DiskFree = kb * 1024
+				}
+			}
+		}
+	}
+	p.Tools = p.Tools[:0]
+	for _, t := range tools {
+		version, ok := found[t.Name]
+		p.Tools = append(p.Tools, toolProbe{Name: t.Name, Found: ok, Version: version, Required: t.Required, Stages: t.Stages})
+	}
+}
+
+// assess records the problems found by a probe.
+func (p *remoteProbe) assess() {
+	p.Errors, p.Warnings = nil, nil
+	if !p.Reachable {
+		p.Errors = append(p.Errors, "unreachable: "+p.Error)
+		return
+	}
+	for _, t := range p.Tools {
+		if t.Found || !t.Required {
+			continue
+		}
+		msg := t.Name + " not found"
+		if len(t.Stages) > 0 {
+			msg += " (needed by " + strings.Join(t.Stages, ", ") + ")"
+		}
+		p.Errors = append(p.Errors, msg)
+	}
+	if p.DiskFree > 0 && p.DiskFree < lowDiskBytes {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("only %s free for %s", formatSize(p.DiskFree), p.WorkDir))
+	}
+	if len(p.Load) > 0 && p.CPUs > 0 && p.Load[0] > 2*float64(p.CPUs) {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("load %.2f on %d CPUs", p.Load[0], p.CPUs))
+	}
+}
+
+// probeRemote checks SSH connectivity, then collects the host's vitals and
+// tool versions in a single round trip.
+func probeRemote(ctx context.Context, re *RemoteExecutor, name string, tools []remoteTool) *remoteProbe {
+	p := &remoteProbe{Name: name, Host: re.Host, WorkDir: re.WorkDir, CheckedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(ctx, re.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := re.TestSSHConnection(ctx); err != nil {
+		p.Error = err.Error()
+		p.assess()
+		return p
+	}
+	p.Reachable = true
+	p.LatencyMS = time.Since(start).Milliseconds()
+
+	out, err := re.sshExecWithOutput(ctx, remoteProbeScript(re.WorkDir, tools))
+	if err != nil {
+		p.Reachable = false
+		p.Error = err.Error()
+		p.assess()
+		return p
+	}
+	parseRemoteProbe(p, out, tools)
+	p.assess()
+	return p
+}
+
+// probeRemotes probes every host concurrently; names label each host.
+func probeRemotes(ctx context.Context, remotes []*RemoteExecutor, names []string, tools []remoteTool) []*remoteProbe {
+	probes := make([]*remoteProbe, len(remotes))
+	var wg sync.WaitGroup
+	for i, re := range remotes {
+		wg.Add(1)
+		go func(i int, re *RemoteExecutor) {
+			defer wg.Done()
+			probes[i] = probeRemote(ctx, re, names[i], tools)
+		}(i, re)
+	}
+	wg.Wait()
+	return probes
+}
+
+// shortVersion pulls the version number out of a tool's version line, e.g.
+// "tmux 3.4" -> "3.4".
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+[0-9A-Za-z.+-]*`)
+
+func shortVersion(line string) string {
+	if v := versionPattern.FindString(line); v != "" {
+		return v
+	}
+	return line
+}
+
+// formatProbeTable renders probes as a check × host readiness table.
+func formatProbeTable(probes []*remoteProbe) string {
+	header := []string{"check"}
+	for _, p := range probes {
+		header = append(header, p.Name)
+	}
+	rows := [][]string{header}
+	row := func(label string, cell func(p *remoteProbe) string) {
+		r := []string{label}
+		for _, p := range probes {
+			if !p.Reachable {
+				r = append(r, "-")
+				continue
+			}
+			r = append(r, cell(p))
+		}
+		rows = append(rows, r)
+	}
+
+	ssh := []string{"ssh"}
+	for _, p := range probes {
+		if p.Reachable {
+			ssh = append(ssh, fmt.Sprintf("✓ %dms", p.LatencyMS))
+		} else {
+			ssh = append(ssh, "✗ unreachable")
+		}
+	}
+	rows = append(rows, ssh)
+	row("os/arch", func(p *remoteProbe) string { return p.OS + "/" + p.Arch })
+	row("cpus/load", func(p *remoteProbe) string {
+		if len(p.Load) == 0 {
+			return fmt.Sprintf("%d", p.CPUs)
+		}
+		return fmt.Sprintf("%d / %.2f", p.CPUs, p.Load[0])
+	})
+	row("disk free", func(p *remoteProbe) string {
+		if p.DiskFree == 0 {
+			return "?"
+		}
+		return formatSize(p.DiskFree)
+	})
+
+	var toolNames []string
+	seen := make(map[string]bool)
+	for _, p := range probes {
+		for _, t := range p.Tools {
+			if !seen[t.Name] {
+				seen[t.Name] = true
+				toolNames = append(toolNames, t.Name)
+			}
+		}
+	}
+	for _, name := range toolNames {
+		row(name, func(p *remoteProbe) string {
+			for _, t := range p.Tools {
+				if t.Name != name {
+					continue
+				}
+				switch {
+				case t.Found:
+					return "✓ " + shortVersion(t.Version)
+				case t.Required:
+					return "✗ missing"
+				default:
+					return "- missing"
+				}
+			}
+			return ""
+		})
+	}
+
+	ready := []string{"ready"}
+	for _, p := range probes {
+		if p.Ready() {
+			ready = append(ready, "✓")
+		} else {
+			ready = append(ready, "✗")
+		}
+	}
+	rows = append(rows, ready)
+	return formatTable(rows)
+}
+
+// printProbeProblems lists each host's errors and warnings.
+func printProbeProblems(probes []*remoteProbe) {
+	for _, p := range probes {
+		for _, e := range p.Errors {
+			errorf("  %s: %s\n", p.Name, e)
+		}
+		for _, w := range p.Warnings {
+			warnf("  %s: %s\n", p.Name, w)
+		}
+	}
+}
+
+// loadProbeCache reads cached probes keyed by cache target.
+func loadProbeCache(root string) map[string]*remoteProbe {
+	cache := make(map[string]*remoteProbe)
+	data, err := os.ReadFile(filepath.Join(root, ".local-ci", remoteProbeFile))
+	if err != nil {
+		return cache
+	}
+	json.Unmarshal(data, &cache)
+	return cache
+}
+
+func saveProbeCache(root string, cache map[string]*remoteProbe) error {
+	dir := filepath.Join(root, ".local-ci")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, remoteProbeFile), data, 0o644)
+}
+
+// probeCovers reports whether a cached probe is fresh, passed, and checked
+// every tool the run needs.
+func probeCovers(p *remoteProbe, re *RemoteExecutor, tools []remoteTool, now time.Time) bool {
+	if p == nil || !p.Ready() || p.Host != re.Host || p.WorkDir != re.WorkDir || now.Sub(p.CheckedAt) > remoteProbeTTL {
+		return false
+	}
+	checked := make(map[string]toolProbe, len(p.Tools))
+	for _, t := range p.Tools {
+		checked[t.Name] = t
+	}
+	for _, t := range tools {
+		c, ok := checked[t.Name]
+		if !ok || (t.Required && !c.Found) {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureRemotesReady probes hosts that have no fresh passing probe and
+// fails when any of them can't run the pipeline. Passing probes are cached
+// for remoteProbeTTL so back-to-back runs don't pay for the check.
+func ensureRemotesReady(root string, remotes []*RemoteExecutor, tools []remoteTool) error {
+	cache := loadProbeCache(root)
+	now := time.Now()
+	var stale []*RemoteExecutor
+	var names []string
+	for _, re := range remotes {
+		if !probeCovers(cache[re.cacheTarget()], re, tools, now) {
+			stale = append(stale, re)
+			names = append(names, re.cacheTarget())
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	probes := probeRemotes(context.Background(), stale, names, tools)
+	var notReady []string
+	for i, p := range probes {
+		cache[names[i]] = p
+		if !p.Ready() {
+			notReady = append(notReady, p.Name)
+		}
+	}
+	printProbeProblems(probes)
+	saveProbeCache(root, cache)
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		return fmt.Errorf("remote host(s) not ready: %s (see `local-ci remote doctor`, or pass --no-probe to skip the check)", strings.Join(notReady, ", "))
+	}
+	return nil
+}
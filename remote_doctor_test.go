@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteToolRequirements(t *testing.T) {
+	stages := []Stage{
+		{Name: "clippy", Cmd: []string{"cargo", "clippy"}},
+		{Name: "test", Cmd: []string{"cargo", "test"}},
+		{Name: "script", Cmd: []string{"./scripts/check.sh"}},
+		{Name: "sessions", Cmd: []string{"tmux", "ls"}},
+	}
+	tools := remoteToolRequirements(stages, false, "shallow")
+	byName := make(map[string]remoteTool)
+	var names []string
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "tar,rsync,tmux,git,cargo" {
+		t.Fatalf("tools = %s", got)
+	}
+	if !byName["tar"].Required || byName["rsync"].Required || !byName["git"].Required {
+		t.Errorf("tar and git (for [sync] git) are required, rsync is optional: %+v", tools)
+	}
+	if c := byName["cargo"]; !c.Required || strings.Join(c.Stages, ",") != "clippy,test" {
+		t.Errorf("cargo = %+v", c)
+	}
+	if tm := byName["tmux"]; !tm.Required || strings.Join(tm.Stages, ",") != "sessions" {
+		t.Errorf("a stage running tmux makes it required: %+v", tm)
+	}
+	if remoteToolRequirements(nil, false, "")[2].Required {
+		t.Error("tmux is optional without --tmux")
+	}
+}
+
+func TestParseRemoteProbe(t *testing.T) {
+	tools := []remoteTool{{Name: "tmux"}, {Name: "cargo", Required: true, Stages: []string{"test"}}, {Name: "bun", Required: true, Stages: []string{"lint"}}}
+	out := strings.Join([]string{
+		"os=Darwin",
+		"arch=arm64",
+		"cpus=10",
+		"uptime=10:01 up 3 days, 2 users, load averages: 1.52 1.40 1.31",
+		"df=/dev/disk3s5 971350180 420000000 1048576 50% /System/Volumes/Data",
+		"tool+tmux=tmux 3.4",
+		"tool+cargo=cargo 1.82.0 (8f40fc59f 2024-08-21)",
+		"tool-bun",
+	}, "\n")
+	p := &remoteProbe{Name: "macos-mini", Reachable: true, WorkDir: "/tmp/app"}
+	parseRemoteProbe(p, out, tools)
+	p.assess()
+
+	if p.OS != "Darwin" || p.Arch != "arm64" || p.CPUs != 10 {
+		t.Errorf("system = %s/%s %d CPUs", p.OS, p.Arch, p.CPUs)
+	}
+	if len(p.Load) != 3 || p.Load[0] != 1.52 {
+		t.Errorf("load = %v", p.Load)
+	}
+	if p.DiskFree != 1<<30 {
+		t.Errorf("disk free = %d", p.DiskFree)
+	}
+	if p.Ready() || len(p.Errors) != 1 || p.Errors[0] != "bun not found (needed by lint)" {
+		t.Errorf("errors = %v", p.Errors)
+	}
+	if len(p.Warnings) != 1 || !strings.Contains(p.Warnings[0], "1.0 GB free") {
+		t.Errorf("warnings = %v", p.Warnings)
+	}
+
+	// Linux uptime uses "load average:" with commas.
+	linux := &remoteProbe{}
+	parseRemoteProbe(linux, "uptime=12:00:01 up 5 days, load average: 0.52, 0.40, 0.31", nil)
+	if len(linux.Load) != 3 || linux.Load[2] != 0.31 {
+		t.Errorf("linux load = %v", linux.Load)
+	}
+
+	table := formatProbeTable([]*remoteProbe{p, {Name: "sparky", Error: "dial tcp: timeout"}})
+	for _, want := range []string{"macos-mini", "sparky", "Darwin/arm64", "✓ 3.4", "✓ 1.82.0", "✗ missing", "✗ unreachable"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("table missing %q:\n%s", want, table)
+		}
+	}
+}
+
+func TestProbeRemoteAndCache(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+	root := t.TempDir()
+	newExec := func() *RemoteExecutor {
+		re := NewRemoteExecutor("ci@"+server.addr, "onion", root+"/not/yet/synced", 5*time.Second, false)
+		t.Cleanup(func() { re.Close() })
+		return re
+	}
+
+	tools := remoteToolRequirements([]Stage{{Name: "test", Cmd: []string{"sh", "-c", "true"}}}, false, "")
+	p := probeRemote(context.Background(), newExec(), "local", tools)
+	if !p.Reachable || p.OS == "" || p.Arch == "" || p.DiskFree == 0 {
+		t.Fatalf("probe = %+v", p)
+	}
+	var sh *toolProbe
+	for i := range p.Tools {
+		if p.Tools[i].Name == "sh" {
+			sh = &p.Tools[i]
+		}
+	}
+	if sh == nil || !sh.Found {
+		t.Fatalf("sh should be found: %+v", p.Tools)
+	}
+
+	// A passing probe is cached, so the next run doesn't connect at all.
+	if err := ensureRemotesReady(root, []*RemoteExecutor{newExec()}, tools); err != nil {
+		t.Fatal(err)
+	}
+	before := server.conns.Load()
+	if err := ensureRemotesReady(root, []*RemoteExecutor{newExec()}, tools); err != nil {
+		t.Fatal(err)
+	}
+	if server.conns.Load() != before {
+		t.Error("a fresh passing probe should be reused")
+	}
+
+	// A stage needing a tool the cached probe never checked forces a probe,
+	// and a missing required tool fails the run.
+	missing := append(tools, remoteTool{Name: "definitely-not-installed-xyz", Required: true, Stages: []string{"lint"}})
+	err := ensureRemotesReady(root, []*RemoteExecutor{newExec()}, missing)
+	if err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("expected a readiness error, got %v", err)
+	}
+	if server.conns.Load() == before {
+		t.Error("new tool requirements should trigger a fresh probe")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRemoteCleanAge is how long a session, workdir or log must have been
+// idle before `local-ci remote clean` removes it.
+const defaultRemoteCleanAge = 72 * time.Hour
+
+// Kinds of leftovers a remote run can leave on a host.
+const (
+	remoteKindSession  = "session"
+	remoteKindWorkDir  = "workdir"
+	remoteKindLogs     = "logs"
+	remoteKindSentinel = "sentinel"
+)
+
+// remoteEntry is one thing local-ci left on a remote host.
+type remoteEntry struct {
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Modified   time.Time `json:"modified"`
+	AgeSeconds int64     `json:"age_seconds"`
+	Attached   bool      `json:"attached,omitempty"`
+}
+
+func (e remoteEntry) age() time.Duration {
+	return time.Duration(e.AgeSeconds) * time.Second
+}
+
+// remoteInventory lists what local-ci left on one host.
+type remoteInventory struct {
+	Name    string        `json:"name"`
+	Host    string        `json:"host"`
+	Error   string        `json:"error,omitempty"`
+	Entries []remoteEntry `json:"entries"`
+}
+
+// remoteInventoryScript prints one tab-separated line per tmux session, sync
+// workdir (any directory holding a .local-ci-manifest, under /tmp or at
+// workDir), stage log directory and exit sentinel. Ages are measured against
+// the host's clock, printed first, so clock skew doesn't matter.
+func remoteInventoryScript(workDir string) string {
+	var b strings.Builder
+	b.WriteString("echo now=$(date +%s); ")
+	b.WriteString(`mt() { stat -c %Y "$1" 2>/dev/null || stat -f %m "$1" 2>/dev/null; }; `)
+	fmt.Fprintf(&b, "tmux list-sessions -F %s 2>/dev/null; ",
+		escapeShellArg("session\t#{session_name}\t#{session_activity}\t#{session_attached}\t#{@local-ci}"))
+	fmt.Fprintf(&b, `for m in /tmp/*/%s %s; do [ -f "$m" ] && printf 'workdir\t%%s\t%%s\n' "${m%%/*}" "$(mt "$m")"; done; `,
+		syncManifestName, escapeShellArg(path.Join(workDir, syncManifestName)))
+	b.WriteString(`for d in /tmp/local-ci-*; do [ -d "$d" ] && printf 'logs\t%s\t%s\n' "$d" "$(mt "$d")"; done; `)
+	b.WriteString(`for f in /tmp/kc_exit_*; do [ -f "$f" ] && printf 'sentinel\t%s\t%s\n' "$f" "$(mt "$f")"; done; `)
+	b.WriteString("true")
+	return b.String()
+}
+
+// parseRemoteInventory turns remoteInventoryScript output into entries.
+// Only tmux sessions local-ci created are kept: those tagged @local-ci, or,
+// for sessions made before tagging, those with a stage log directory.
+func parseRemoteInventory(out string) []remoteEntry {
+	var now int64
+	var sessions, entries []remoteEntry
+	tagged := make(map[string]bool)
+	seen := make(map[string]bool)
+	logDirs := make(map[string]bool)
+	stamp := func(s string) time.Time {
+		n, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		return time.Unix(n, 0)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(line, "now="); ok {
+			now, _ = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		switch {
+		case fields[0] == remoteKindSession && len(fields) >= 5:
+			attached, _ := strconv.Atoi(fields[3])
+			sessions = append(sessions, remoteEntry{Kind: remoteKindSession, Name: fields[1], Modified: stamp(fields[2]), Attached: attached > 0})
+			tagged[fields[1]] = fields[4] == "1"
+		case len(fields) == 3 && (fields[0] == remoteKindWorkDir || fields[0] == remoteKindLogs || fields[0] == remoteKindSentinel):
+			key := fields[0] + "\t" + fields[1]
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			entries = append(entries, remoteEntry{Kind: fields[0], Name: fields[1], Modified: stamp(fields[2])})
+			if fields[0] == remoteKindLogs {
+				logDirs[fields[1]] = true
+			}
+		}
+	}
+
+	var result []remoteEntry
+	for _, s := range sessions {
+		if tagged[s.Name] || logDirs["/tmp/local-ci-"+s.Name] {
+			result = append(result, s)
+		}
+	}
+	result = append(result, entries...)
+	for i := range result {
+		if now > 0 && !result[i].Modified.IsZero() {
+			result[i].AgeSeconds = max(now-result[i].Modified.Unix(), 0)
+		}
+	}
+	return result
+}
+
+// inventoryRemote lists local-ci's leftovers on one host.
+func inventoryRemote(ctx context.Context, re *RemoteExecutor, name string) *remoteInventory {
+	inv := &remoteInventory{Name: name, Host: re.Host}
+	ctx, cancel := context.WithTimeout(ctx, re.Timeout)
+	defer cancel()
+	out, err := re.sshExecWithOutput(ctx, remoteInventoryScript(re.WorkDir))
+	if err != nil {
+		inv.Error = err.Error()
+		return inv
+	}
+	inv.Entries = parseRemoteInventory(out)
+	return inv
+}
+
+// inventoryRemotes inventories every host concurrently.
+func inventoryRemotes(ctx context.Context, remotes []*RemoteExecutor, names []string) []*remoteInventory {
+	invs := make([]*remoteInventory, len(remotes))
+	var wg sync.WaitGroup
+	for i, re := range remotes {
+		wg.Add(1)
+		go func(i int, re *RemoteExecutor) {
+			defer wg.Done()
+			invs[i] = inventoryRemote(ctx, re, names[i])
+		}(i, re)
+	}
+	wg.Wait()
+	return invs
+}
+
+// staleRemoteEntries picks the entries idle for at least olderThan. Sessions
+// someone is attached to are never stale.
+func staleRemoteEntries(entries []remoteEntry, olderThan time.Duration) []remoteEntry {
+	var stale []remoteEntry
+	for _, e := range entries {
+		if e.Attached || e.age() < olderThan {
+			continue
+		}
+		if e.Kind != remoteKindSession && !safeRemotePath(e.Name) {
+			continue
+		}
+		stale = append(stale, e)
+	}
+	return stale
+}
+
+// safeRemotePath guards rm -rf: only clean absolute paths at least two
+// levels deep (never "/" or "/tmp" itself).
+func safeRemotePath(p string) bool {
+	return path.IsAbs(p) && path.Clean(p) == p && strings.Count(p, "/") >= 2
+}
+
+// removeRemoteCommand kills the sessions and deletes the paths in entries.
+func removeRemoteCommand(entries []remoteEntry) string {
+	var cmds, paths []string
+	for _, e := range entries {
+		if e.Kind == remoteKindSession {
+			cmds = append(cmds, fmt.Sprintf("tmux kill-session -t %s 2>/dev/null", escapeShellArg("="+e.Name)))
+		} else if safeRemotePath(e.Name) {
+			paths = append(paths, escapeShellArg(e.Name))
+		}
+	}
+	if len(paths) > 0 {
+		cmds = append(cmds, "rm -rf -- "+strings.Join(paths, " "))
+	}
+	cmds = append(cmds, "true")
+	return strings.Join(cmds, "; ")
+}
+
+// formatAge renders a duration coarsely: "45s", "12m", "5h", "3d".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+// formatInventory renders one host's entries, sessions first.
+func formatInventory(inv *remoteInventory) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n", inv.Name, inv.Host)
+	if inv.Error != "" {
+		fmt.Fprintf(&b, "  ✗ unreachable: %s\n", inv.Error)
+		return b.String()
+	}
+	if len(inv.Entries) == 0 {
+		b.WriteString("  nothing left behind\n")
+		return b.String()
+	}
+	entries := append([]remoteEntry(nil), inv.Entries...)
+	order := map[string]int{remoteKindSession: 0, remoteKindWorkDir: 1, remoteKindLogs: 2, remoteKindSentinel: 3}
+	sort.SliceStable(entries, func(i, j int) bool { return order[entries[i].Kind] < order[entries[j].Kind] })
+	rows := [][]string{{"  kind", "name", "idle"}}
+	for _, e := range entries {
+		name := e.Name
+		if e.Attached {
+			name += " (attached)"
+		}
+		rows = append(rows, []string{"  " + e.Kind, name, formatAge(e.age())})
+	}
+	b.WriteString(formatTable(rows))
+	return b.String()
+}
+
+// attachCommand builds the interactive ssh command that attaches to the
+// executor's tmux session. It always uses the ssh binary, which owns the
+// terminal.
+func attachCommand(re *RemoteExecutor) *exec.Cmd {
+	args := []string{"-t"}
+	host, port := systemSSHTarget(re.Host)
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host, "tmux attach -t "+escapeShellArg("="+re.Session))
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd
+}
+
+// systemSSHTarget splits a "user@host:port" target into the destination
+// and port the ssh binary takes separately. port is "" without one.
+func systemSSHTarget(target string) (host, port string) {
+	user, addr, hasUser := strings.Cut(target, "@")
+	if !hasUser {
+		addr, user = user, ""
+	}
+	h, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return target, ""
+	}
+	if user != "" {
+		return user + "@" + h, port
+	}
+	return h, port
+}
+
+// stageLogCommand prints a tmux-mode stage log, following it when follow is
+// set. Without a stage it lists the session's logs, newest first.
+func stageLogCommand(re *RemoteExecutor, stage string, follow bool) string {
+	if stage == "" {
+		return "ls -1t " + escapeShellArg(re.LogDir())
+	}
+	log := escapeShellArg(re.stageLogPath(stage))
+	if follow {
+		return "tail -n +1 -f " + log
+	}
+	return "cat " + log
+}
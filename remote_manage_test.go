@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRemoteInventory(t *testing.T) {
+	out := strings.Join([]string{
+		"now=1000000",
+		"session\tonion\t999000\t0\t1",
+		"session\tlegacy\t990000\t1\t",
+		"session\tmine\t999990\t0\t",
+		"workdir\t/tmp/app\t900000",
+		"workdir\t/tmp/app\t900000",
+		"logs\t/tmp/local-ci-legacy\t995000",
+		"sentinel\t/tmp/kc_exit_test_123\t999940",
+		"",
+	}, "\n")
+	entries := parseRemoteInventory(out)
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Kind+":"+e.Name+":"+formatAge(e.age()))
+	}
+	want := "session:onion:16m,session:legacy:2h,workdir:/tmp/app:27h,logs:/tmp/local-ci-legacy:1h,sentinel:/tmp/kc_exit_test_123:1m"
+	if strings.Join(got, ",") != want {
+		t.Errorf("entries = %s\nwant      %s", strings.Join(got, ","), want)
+	}
+	if !entries[1].Attached || entries[0].Attached {
+		t.Errorf("attached flags = %+v", entries[:2])
+	}
+}
+
+func TestStaleRemoteEntries(t *testing.T) {
+	entries := []remoteEntry{
+		{Kind: remoteKindSession, Name: "onion", AgeSeconds: 100 * 3600},
+		{Kind: remoteKindSession, Name: "busy", AgeSeconds: 100 * 3600, Attached: true},
+		{Kind: remoteKindWorkDir, Name: "/tmp/app", AgeSeconds: 80 * 3600},
+		{Kind: remoteKindWorkDir, Name: "/tmp/fresh", AgeSeconds: 3600},
+		{Kind: remoteKindWorkDir, Name: "/tmp", AgeSeconds: 80 * 3600},
+		{Kind: remoteKindSentinel, Name: "/tmp/kc_exit_t_1", AgeSeconds: 73 * 3600},
+	}
+	stale := staleRemoteEntries(entries, defaultRemoteCleanAge)
+	var names []string
+	for _, e := range stale {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, ","); got != "onion,/tmp/app,/tmp/kc_exit_t_1" {
+		t.Fatalf("stale = %s", got)
+	}
+	cmd := removeRemoteCommand(stale)
+	if !strings.Contains(cmd, "tmux kill-session -t =onion") || !strings.Contains(cmd, "rm -rf -- /tmp/app /tmp/kc_exit_t_1") {
+		t.Errorf("command = %s", cmd)
+	}
+}
+
+func TestAttachCommand(t *testing.T) {
+	re := NewRemoteExecutor("ci@10.0.0.5:2222", "onion", "/tmp/app", time.Second, false)
+	got := strings.Join(attachCommand(re).Args, " ")
+	if got != "ssh -t -p 2222 ci@10.0.0.5 tmux attach -t =onion" {
+		t.Errorf("args = %s", got)
+	}
+	re = NewRemoteExecutor("ci@mac-mini", "my session", "/tmp/app", time.Second, false)
+	if got := strings.Join(attachCommand(re).Args, " "); got != "ssh -t ci@mac-mini tmux attach -t '=my session'" {
+		t.Errorf("args = %s", got)
+	}
+}
+
+func TestRemoteInventoryAndClean(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	// Stand in for tmux: one tagged session, and a record of kill-session.
+	bin := t.TempDir()
+	killed := filepath.Join(bin, "killed")
+	fakeTmux := "#!/bin/sh\ncase \"$1\" in\n" +
+		"list-sessions) printf 'session\\tlci-inv\\t1\\t0\\t1\\n' ;;\n" +
+		"kill-session) echo \"$3\" >> " + killed + " ;;\n" +
+		"esac\n"
+	os.WriteFile(filepath.Join(bin, "tmux"), []byte(fakeTmux), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	workDir := filepath.Join(t.TempDir(), "app")
+	os.MkdirAll(filepath.Join(workDir, "target"), 0o755)
+	manifest := filepath.Join(workDir, syncManifestName)
+	os.WriteFile(manifest, []byte("{}"), 0o644)
+	old := time.Now().Add(-100 * time.Hour)
+	os.Chtimes(manifest, old, old)
+
+	re := NewRemoteExecutor("ci@"+server.addr, "lci-inv", workDir, 5*time.Second, false)
+	defer re.Close()
+	inv := inventoryRemote(context.Background(), re, "local")
+	if inv.Error != "" {
+		t.Fatal(inv.Error)
+	}
+
+	// Only act on what this test created; /tmp on the test machine is shared.
+	var mine []remoteEntry
+	for _, e := range inv.Entries {
+		if e.Name == workDir || e.Name == "lci-inv" {
+			mine = append(mine, e)
+		}
+	}
+	if len(mine) != 2 {
+		t.Fatalf("expected the session and workdir, got %+v", inv.Entries)
+	}
+	if mine[1].Kind != remoteKindWorkDir || mine[1].age() < 99*time.Hour {
+		t.Errorf("workdir age should come from the manifest: %+v", mine[1])
+	}
+	if !strings.Contains(formatInventory(inv), workDir) {
+		t.Errorf("listing should show the workdir:\n%s", formatInventory(inv))
+	}
+
+	stale := staleRemoteEntries(mine, defaultRemoteCleanAge)
+	if len(stale) != 2 {
+		t.Fatalf("stale = %+v", stale)
+	}
+	if err := re.sshExec(context.Background(), removeRemoteCommand(stale)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("stale workdir should be removed: %v", err)
+	}
+	if data, _ := os.ReadFile(killed); strings.TrimSpace(string(data)) != "=lci-inv" {
+		t.Errorf("kill-session targets = %q", data)
+	}
+}
+
+func TestKillRemoteSessionOnlyKillsTaggedSessions(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	// Stand in for tmux: lci-mine is tagged, onion is the user's own.
+	bin := t.TempDir()
+	killed := filepath.Join(bin, "killed")
+	fakeTmux := "#!/bin/sh\ncase \"$1\" in\n" +
+		"display-message) [ \"$4\" = =lci-mine ] && echo 1 ;;\n" +
+		"kill-session) echo \"$3\" >> " + killed + " ;;\n" +
+		"esac\nexit 0\n"
+	os.WriteFile(filepath.Join(bin, "tmux"), []byte(fakeTmux), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for session, want := range map[string]bool{"onion": false, "lci-mine": true} {
+		re := NewRemoteExecutor("ci@"+server.addr, session, "/tmp/app", 5*time.Second, false)
+		got, err := re.KillRemoteSession(context.Background())
+		re.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("KillRemoteSession(%s) = %v, want %v", session, got, want)
+		}
+	}
+	if data, _ := os.ReadFile(killed); strings.TrimSpace(string(data)) != "=lci-mine" {
+		t.Errorf("kill-session targets = %q", data)
+	}
+}
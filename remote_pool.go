@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// remotePool spreads stages across one or more remote hosts. Each host runs
+// up to slots stages at once, each on its own SSH channel (or tmux window),
+// so ParallelRunner can drive remote execution the same way it drives local
+// execution: Execute is plugged in as ParallelRunner.Execute and
+// dependencies are still resolved by the runner.
+//
+// A stage with depends_on runs on the host that ran its first dependency,
+// since the files that dependency built only exist in that host's copy of
+// the workspace. Dependencies that were cache hits ran on no host this run
+// and don't pin anything.
+type remotePool struct {
+	hosts   []*RemoteExecutor
+	slots   int
+	Verbose bool
+
+	mu    sync.Mutex
+	freed *sync.Cond
+	busy  map[*RemoteExecutor]int
+	ranOn map[string]*RemoteExecutor // stage name -> host that ran it
+	next  int                        // where the search for a free host starts
+
+	reportMu sync.Mutex // keeps each stage's report together
+}
+
+func newRemotePool(hosts []*RemoteExecutor, slots int) *remotePool {
+	if slots < 1 {
+		slots = 1
+	}
+	p := &remotePool{
+		hosts: hosts,
+		slots: slots,
+		busy:  make(map[*RemoteExecutor]int, len(hosts)),
+		ranOn: make(map[string]*RemoteExecutor),
+	}
+	p.freed = sync.NewCond(&p.mu)
+	return p
+}
+
+// Concurrency is the total number of stages the pool runs at once.
+func (p *remotePool) Concurrency() int {
+	return len(p.hosts) * p.slots
+}
+
+// Execute runs stage on a free slot: on its first dependency's host when it
+// has one, otherwise on the next free host.
+func (p *remotePool) Execute(stage Stage) Result {
+	re := p.acquire(stage)
+	defer p.release(re)
+
+	result := re.RunStage(context.Background(), stage, nil)
+	result.CacheTarget = re.cacheTarget()
+	p.report(result)
+	return result
+}
+
+// acquire waits for a slot for stage and takes it. Unpinned stages start
+// the search after the last host picked, so the first stages to start land
+// on different machines.
+func (p *remotePool) acquire(stage Stage) *RemoteExecutor {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var pinned *RemoteExecutor
+	for _, dep := range stage.DependsOn {
+		if re, ok := p.ranOn[dep]; ok {
+			pinned = re
+			break
+		}
+	}
+	for {
+		if pinned != nil {
+			if p.busy[pinned] < p.slots {
+				p.busy[pinned]++
+				p.ranOn[stage.Name] = pinned
+				return pinned
+			}
+		} else {
+			for i := range p.hosts {
+				idx := (p.next + i) % len(p.hosts)
+				if re := p.hosts[idx]; p.busy[re] < p.slots {
+					p.next = idx + 1
+					p.busy[re]++
+					p.ranOn[stage.Name] = re
+					return re
+				}
+			}
+		}
+		p.freed.Wait()
+	}
+}
+
+func (p *remotePool) release(re *RemoteExecutor) {
+	p.mu.Lock()
+	p.busy[re]--
+	p.mu.Unlock()
+	p.freed.Broadcast()
+}
+
+// report prints a finished stage. Output is buffered per stage rather than
+// streamed, since concurrent stages would interleave.
+func (p *remotePool) report(result Result) {
+	p.reportMu.Lock()
+	defer p.reportMu.Unlock()
+	if result.Status == "pass" {
+		if p.Verbose && result.Output != "" {
+			groupStart(result.Name)
+			printf("%s\n", strings.TrimRight(result.Output, "\n"))
+			groupEnd()
+		}
+		printf("✓ %s on %s (%dms)\n", result.Name, result.Host, result.Duration.Milliseconds())
+		return
+	}
+	groupStart(result.Name)
+	if result.Output != "" {
+		printf("%s\n", strings.TrimRight(result.Output, "\n"))
+	}
+	if result.Error != nil {
+		printf("Error: %v\n", result.Error)
+	}
+	groupEnd()
+	printf("✗ %s on %s (failed)\n", result.Name, result.Host)
+}
+
+// Sync pushes the workspace to every host concurrently, once per host.
+func (p *remotePool) Sync(ctx context.Context, localDir string, opts SyncOptions) error {
+	return syncRemoteHosts(ctx, p.hosts, localDir, opts)
+}
+
+func syncRemoteHosts(ctx context.Context, hosts []*RemoteExecutor, localDir string, opts SyncOptions) error {
+	if opts.Index == nil {
+		opts.Index = NewHashIndex()
+	}
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, re := range hosts {
+		wg.Add(1)
+		go func(i int, re *RemoteExecutor) {
+			defer wg.Done()
+			if err := re.SyncWorkspace(ctx, localDir, opts); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", re.Host, err)
+			}
+		}(i, re)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// EnsureSessions creates the tmux session on every host that runs in tmux mode.
+func (p *remotePool) EnsureSessions(ctx context.Context) error {
+	return ensureRemoteSessions(ctx, p.hosts)
+}
+
+func ensureRemoteSessions(ctx context.Context, hosts []*RemoteExecutor) error {
+	for _, re := range hosts {
+		if !re.Tmux {
+			continue
+		}
+		if err := re.EnsureRemoteSession(ctx); err != nil {
+			return fmt.Errorf("%s: %w", re.Host, err)
+		}
+	}
+	return nil
+}
+
+// Close releases every host's SSH connection.
+func (p *remotePool) Close() {
+	for _, re := range p.hosts {
+		re.Close()
+	}
+}
+
+// hostSummary counts executed stages per host, e.g. "uranus 3, sparky 2".
+func hostSummary(results []Result) string {
+	counts := make(map[string]int)
+	for _, r := range results {
+		if r.Host != "" {
+			counts[r.Host]++
+		}
+	}
+	hosts := make([]string, 0, len(counts))
+	for h := range counts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	parts := make([]string, len(hosts))
+	for i, h := range hosts {
+		parts[i] = fmt.Sprintf("%s %d", h, counts[h])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// splitHostList parses a comma-separated --remote-hosts value.
+func splitHostList(s string) []string {
+	var out []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return dedupeStrings(out)
+}
+
+// remoteCacheTargets lists the hosts' cache namespaces, for
+// ParallelRunner.CacheTargets.
+func remoteCacheTargets(hosts []*RemoteExecutor) []string {
+	targets := make([]string, 0, len(hosts))
+	for _, re := range hosts {
+		targets = append(targets, re.cacheTarget())
+	}
+	return dedupeStrings(targets)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemotePoolSpreadsStagesAcrossHosts(t *testing.T) {
+	home, pub := sshTestHome(t)
+	hostA := startTestSSHServer(t, pub)
+	hostB := startTestSSHServer(t, pub)
+	trustHost(t, home, hostA)
+	trustHost(t, home, hostB)
+
+	a := NewRemoteExecutor("ci@"+hostA.addr, "onion", t.TempDir(), 5*time.Second, false)
+	b := NewRemoteExecutor("ci@"+hostB.addr, "onion", t.TempDir(), 5*time.Second, false)
+	pool := newRemotePool([]*RemoteExecutor{a, b}, 0)
+	defer pool.Close()
+	if pool.Concurrency() != 2 {
+		t.Fatalf("expected one slot per host, got %d", pool.Concurrency())
+	}
+
+	sleep := []string{"sh", "-c", "sleep 0.3; echo done"}
+	runner := &ParallelRunner{
+		Stages: []Stage{
+			{Name: "build", Cmd: sleep, Timeout: 10},
+			{Name: "lint", Cmd: sleep, Timeout: 10},
+			{Name: "test", Cmd: []string{"sh", "-c", "exit 1"}, Timeout: 10, DependsOn: []string{"build", "lint"}},
+		},
+		Concurrency: pool.Concurrency(),
+		NoCache:     true,
+		Cache:       map[string]string{},
+		Execute:     pool.Execute,
+	}
+	start := time.Now()
+	results := runner.Run()
+	if len(results) != 3 || results[0].Status != "pass" || results[1].Status != "pass" || results[2].Status != "fail" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if results[0].Host == results[1].Host {
+		t.Errorf("independent stages should run on different hosts, both ran on %s", results[0].Host)
+	}
+	if results[2].Host != results[0].Host {
+		t.Errorf("test should run where its first dependency built, ran on %s instead of %s", results[2].Host, results[0].Host)
+	}
+	if results[0].Output != "done\n" {
+		t.Errorf("expected stage output, got %q", results[0].Output)
+	}
+	if elapsed := time.Since(start); elapsed > 550*time.Millisecond {
+		t.Errorf("build and lint should overlap, pipeline took %s", elapsed)
+	}
+	// Each host keeps one connection however many stages it runs.
+	if hostA.conns.Load() != 1 || hostB.conns.Load() != 1 {
+		t.Errorf("expected one connection per host, got %d and %d", hostA.conns.Load(), hostB.conns.Load())
+	}
+	if got := hostSummary(results); !strings.Contains(got, "ci@"+hostA.addr) || !strings.Contains(got, "ci@"+hostB.addr) {
+		t.Errorf("hostSummary = %q", got)
+	}
+}
+
+func TestRemotePoolParallelOnOneHost(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	re := NewRemoteExecutor("ci@"+server.addr, "onion", t.TempDir(), 5*time.Second, false)
+	pool := newRemotePool([]*RemoteExecutor{re}, 3)
+	defer pool.Close()
+
+	var stages []Stage
+	for _, name := range []string{"a", "b", "c"} {
+		stages = append(stages, Stage{Name: name, Cmd: []string{"sleep", "0.4"}, Timeout: 10})
+	}
+	runner := &ParallelRunner{Stages: stages, Concurrency: pool.Concurrency(), NoCache: true, Cache: map[string]string{}, Execute: pool.Execute}
+	start := time.Now()
+	for _, r := range runner.Run() {
+		if r.Status != "pass" {
+			t.Fatalf("stage %s failed: %v", r.Name, r.Error)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stages should share the host concurrently, took %s", elapsed)
+	}
+	if server.conns.Load() != 1 {
+		t.Errorf("concurrent stages should multiplex one connection, got %d", server.conns.Load())
+	}
+}
+
+func TestTmuxModeUsesWindowPerStage(t *testing.T) {
+	mock := &mockSSH{exitCode: "0\n", windowID: "@7\n"}
+	re := NewRemoteExecutor("aivcs@test", "onion", "/tmp/project", 30*time.Second, false)
+	re.ssh = mock
+	re.Tmux = true
+
+	result := re.ExecuteStage(Stage{Name: "test[features=serde]", Cmd: []string{"cargo", "test"}, Timeout: 5})
+	if result.Status != "pass" {
+		t.Fatalf("expected pass, got %s (%v)", result.Status, result.Error)
+	}
+	var newWindow, sendKeys string
+	for _, call := range mock.calls {
+		if strings.Contains(call, "new-window") {
+			newWindow = call
+		}
+		if strings.Contains(call, "send-keys") {
+			sendKeys = call
+		}
+	}
+	if !strings.Contains(newWindow, "kill-window -t onion:=test-features-serde-") || !strings.Contains(newWindow, "-n test-features-serde-") {
+		t.Errorf("unexpected window setup: %q", newWindow)
+	}
+	if !strings.HasPrefix(sendKeys, "tmux send-keys -t @7 ") {
+		t.Errorf("command should be typed into the stage's window: %q", sendKeys)
+	}
+}
+
+func TestSplitHostList(t *testing.T) {
+	got := splitHostList(" uranus, sparky,,uranus ")
+	if strings.Join(got, "|") != "uranus|sparky" {
+		t.Errorf("splitHostList = %q", got)
+	}
+}
+
+func TestRemotePoolRecordsCachePerHost(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	re := NewRemoteExecutor("ci@"+server.addr, "onion", t.TempDir(), 5*time.Second, false)
+	re.CacheTarget = remoteCacheTarget("sparky", remotePlatformLinuxSpark)
+	pool := newRemotePool([]*RemoteExecutor{re}, 1)
+	defer pool.Close()
+
+	stage := Stage{Name: "test", Cmd: []string{"true"}, Timeout: 10}
+	cache := map[string]string{}
+	run := func() Result {
+		runner := &ParallelRunner{
+			Stages:       []Stage{stage},
+			Concurrency:  pool.Concurrency(),
+			Cache:        cache,
+			SourceHash:   "hash",
+			Execute:      pool.Execute,
+			CacheTargets: remoteCacheTargets([]*RemoteExecutor{re}),
+		}
+		return runner.Run()[0]
+	}
+
+	if r := run(); r.Status != "pass" || r.CacheHit {
+		t.Fatalf("first run should execute, got %+v", r)
+	}
+	if _, ok := cache["test@sparky/linux_spark"]; !ok {
+		t.Fatalf("pass should be recorded under the host's target, cache = %v", cache)
+	}
+	if _, ok := cache["test"]; ok {
+		t.Error("a remote pass must not write the local entry")
+	}
+	if r := run(); !r.CacheHit {
+		t.Error("second run on the same host should hit the cache")
+	}
+}
+
+func TestRemotePoolCacheTargetsShareHost(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	// Two presets on one machine, checked out in different directories.
+	a := NewRemoteExecutor("ci@"+server.addr, "onion", t.TempDir(), 5*time.Second, false)
+	a.CacheTarget = remoteCacheTarget("stable", remotePlatformLinuxSpark)
+	b := NewRemoteExecutor("ci@"+server.addr, "onion", t.TempDir(), 5*time.Second, false)
+	b.CacheTarget = remoteCacheTarget("nightly", remotePlatformLinuxSpark)
+	pool := newRemotePool([]*RemoteExecutor{a, b}, 1)
+	defer pool.Close()
+
+	sleep := []string{"sh", "-c", "sleep 0.2"}
+	cache := map[string]string{}
+	runner := &ParallelRunner{
+		Stages:       []Stage{{Name: "x", Cmd: sleep, Timeout: 10}, {Name: "y", Cmd: sleep, Timeout: 10}},
+		Concurrency:  pool.Concurrency(),
+		Cache:        cache,
+		SourceHash:   "hash",
+		Execute:      pool.Execute,
+		CacheTargets: remoteCacheTargets([]*RemoteExecutor{a, b}),
+	}
+	results := runner.Run()
+	if results[0].CacheTarget == results[1].CacheTarget {
+		t.Fatalf("stages should run on both presets, both ran on %q", results[0].CacheTarget)
+	}
+	for _, r := range results {
+		if _, ok := cache[cacheEntryName(r.Name, r.CacheTarget)]; !ok {
+			t.Errorf("%s should be recorded under %s, cache = %v", r.Name, r.CacheTarget, cache)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -32,6 +33,23 @@ func TestBenignSSHFailure(t *testing.T) {
 	}
 }
 
+func TestRemoteStageEnvAndDir(t *testing.T) {
+	stage := Stage{Cmd: []string{"cargo", "test"}, Env: map[string]string{"B": "2", "A": "1"}, Dir: "crates/core"}
+	if got := remoteStageDir("/tmp/proj", stage); got != "/tmp/proj/crates/core" {
+		t.Errorf("remoteStageDir = %q", got)
+	}
+	if got := joinShellCommand(remoteStageCmd(stage)); got != "env A=1 B=2 cargo test" {
+		t.Errorf("remoteStageCmd = %q", got)
+	}
+	plain := Stage{Cmd: []string{"make"}}
+	if got := remoteStageDir("/tmp/proj", plain); got != "/tmp/proj" {
+		t.Errorf("remoteStageDir without dir = %q", got)
+	}
+	if got := remoteStageCmd(plain); len(got) != 1 || got[0] != "make" {
+		t.Errorf("remoteStageCmd without env = %q", got)
+	}
+}
+
 func TestRemoteExecutorCreation(t *testing.T) {
 	re := NewRemoteExecutor("aivcs@100.90.209.9", "onion", "/tmp/project", 30*time.Second, false)
 	if re.Host != "aivcs@100.90.209.9" {
@@ -74,22 +92,26 @@ func TestJoinShellCommand(t *testing.T) {
 }
 
 func TestBuildRemoteStageCommand(t *testing.T) {
-	cmd := buildRemoteStageCommand("/data/builds/local-ci", []string{"cargo", "test", "--workspace"}, "/tmp/kc_exit_test")
+	cmd := buildRemoteStageCommand("/data/builds/local-ci", []string{"cargo", "test", "--workspace"}, "/tmp/kc_exit_test", "/tmp/local-ci-onion/test.log")
 	if !strings.Contains(cmd, "cd /data/builds/local-ci") {
 		t.Fatalf("missing cd: %q", cmd)
 	}
 	if !strings.Contains(cmd, "cargo test --workspace") {
 		t.Fatalf("missing command: %q", cmd)
 	}
-	if !strings.Contains(cmd, "echo $? > /tmp/kc_exit_test") {
+	if !strings.Contains(cmd, "echo $? > /tmp/kc_exit_test.tmp") {
 		t.Fatalf("missing sentinel: %q", cmd)
 	}
+	if !strings.Contains(cmd, "| tee /tmp/local-ci-onion/test.log; mv -f /tmp/kc_exit_test.tmp /tmp/kc_exit_test") {
+		t.Fatalf("sentinel should be published after the log is complete: %q", cmd)
+	}
 }
 
 type mockSSH struct {
 	calls         []string
 	exitCode      string
 	captureOutput string
+	windowID      string
 	failOn        string
 }
 
@@ -98,7 +120,10 @@ func (m *mockSSH) execWithOutput(_ context.Context, cmd string) (string, error)
 	if m.failOn != "" && strings.Contains(cmd, m.failOn) {
 		return "", context.DeadlineExceeded
 	}
-	if strings.Contains(cmd, "capture-pane") {
+	if strings.Contains(cmd, "new-window") {
+		return m.windowID, nil
+	}
+	if strings.HasPrefix(cmd, "cat ") && strings.HasSuffix(cmd, ".log") {
 		if m.captureOutput != "" {
 			return m.captureOutput, nil
 		}
@@ -179,6 +204,72 @@ func TestRemoteExecutorSSHFailure(t *testing.T) {
 	}
 }
 
+func TestRemoteExecutorDirectExec(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+	workDir := t.TempDir()
+	os.MkdirAll(filepath.Join(workDir, "sub"), 0o755)
+
+	re := NewRemoteExecutor("ci@"+server.addr, "onion", workDir, 5*time.Second, false)
+	defer re.Close()
+
+	// Far more output than a tmux pane holds, split across stdout/stderr.
+	stage := Stage{
+		Name:    "long",
+		Cmd:     []string{"sh", "-c", "pwd; echo $GREETING; seq 1 5000; echo oops >&2; exit 7"},
+		Dir:     "sub",
+		Env:     map[string]string{"GREETING": "hi there"},
+		Timeout: 10,
+	}
+	var live strings.Builder
+	result := re.RunStage(context.Background(), stage, &live)
+	if result.Status != "fail" || result.Error == nil || result.Error.Error() != "exit code 7" {
+		t.Fatalf("expected real exit code 7, got %s (%v)", result.Status, result.Error)
+	}
+	lines := strings.Split(strings.TrimSpace(result.Output), "\n")
+	if len(lines) != 5003 || lines[0] != filepath.Join(workDir, "sub") || lines[1] != "hi there" || lines[2] != "1" || lines[5001] != "5000" || lines[5002] != "oops" {
+		t.Fatalf("expected the full log, got %d lines starting %q", len(lines), lines[:2])
+	}
+	if live.String() != result.Output {
+		t.Error("live stream should carry the same output as the result")
+	}
+
+	result = re.RunStage(context.Background(), Stage{Name: "slow", Cmd: []string{"sleep", "10"}, Timeout: 1}, nil)
+	if result.Status != "fail" || result.Error == nil || !strings.Contains(result.Error.Error(), "timed out") {
+		t.Fatalf("expected timeout, got %s (%v)", result.Status, result.Error)
+	}
+}
+
+func TestRemoteExecutorTmuxLogFollow(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	// Stand in for tmux: run whatever send-keys would type in the background.
+	bin := t.TempDir()
+	fakeTmux := "#!/bin/sh\nif [ \"$1\" = send-keys ]; then sh -c \"$4\" >/dev/null 2>&1 & fi\n"
+	os.WriteFile(filepath.Join(bin, "tmux"), []byte(fakeTmux), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	re := NewRemoteExecutor("ci@"+server.addr, "lci-test-"+filepath.Base(t.TempDir()), t.TempDir(), 5*time.Second, false)
+	re.Tmux = true
+	defer re.Close()
+	defer os.RemoveAll(re.LogDir())
+
+	var live strings.Builder
+	result := re.RunStage(context.Background(), Stage{Name: "t", Cmd: []string{"sh", "-c", "echo one; echo two >&2; exit 3"}, Timeout: 10}, &live)
+	if result.Error == nil || result.Error.Error() != "exit code 3" {
+		t.Fatalf("expected exit code 3, got %v", result.Error)
+	}
+	if result.Output != "one\ntwo\n" || !strings.Contains(live.String(), "one\ntwo\n") {
+		t.Errorf("output = %q, live = %q", result.Output, live.String())
+	}
+	if data, _ := os.ReadFile(re.stageLogPath("t")); string(data) != "one\ntwo\n" {
+		t.Errorf("stage log should be kept for later inspection, got %q", data)
+	}
+}
+
 func TestRemoteExecutorCaptureAfterCompletion(t *testing.T) {
 	mock := &mockSSH{exitCode: "0\n", captureOutput: "final output line\n"}
 	re := NewRemoteExecutor("aivcs@test", "onion", "/tmp/project", 30*time.Second, false)
@@ -196,21 +287,24 @@ func TestRemoteExecutorCaptureAfterCompletion(t *testing.T) {
 		t.Fatalf("expected pass, got %s (%v)", result.Status, result.Error)
 	}
 	if !strings.Contains(result.Output, "final output line") {
-		t.Errorf("expected post-completion capture, got %q", result.Output)
+		t.Errorf("expected post-completion log, got %q", result.Output)
 	}
 
-	captureIdx := -1
+	logIdx := -1
 	catIdx := -1
 	for i, call := range mock.calls {
-		if strings.Contains(call, "capture-pane") {
-			captureIdx = i
+		if call == "cat /tmp/local-ci-onion/fmt.log" {
+			logIdx = i
 		}
 		if strings.Contains(call, "cat /tmp/kc_exit_") {
 			catIdx = i
 		}
+		if strings.Contains(call, "capture-pane") {
+			t.Errorf("the visible pane should no longer be scraped: %q", call)
+		}
 	}
-	if captureIdx == -1 || catIdx == -1 || captureIdx <= catIdx {
-		t.Fatalf("capture-pane should run after sentinel read; calls=%v", mock.calls)
+	if logIdx == -1 || catIdx == -1 || logIdx <= catIdx {
+		t.Fatalf("stage log should be read after sentinel; calls=%v", mock.calls)
 	}
 }
 
@@ -237,6 +331,33 @@ func TestEscapeShellArg(t *testing.T) {
 	}
 }
 
+func TestEscapeShellArgTildeRemoteDir(t *testing.T) {
+	home := t.TempDir()
+	for dir, want := range map[string]string{
+		"~/src/foo":       filepath.Join(home, "src/foo"),
+		"~/my src":        filepath.Join(home, "my src"),
+		"~":               home,
+		"/opt/~cache/foo": "/opt/~cache/foo",
+	} {
+		// The same mkdir/cd pair remote runs use for remote_dir.
+		script := "mkdir -p " + escapeShellArg(dir) + " 2>/dev/null; cd " + escapeShellArg(dir) + " 2>/dev/null; echo " + escapeShellArg(dir) + "; pwd"
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Dir = t.TempDir()
+		cmd.Env = []string{"HOME=" + home, "PATH=" + os.Getenv("PATH")}
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %v", dir, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if lines[0] != want {
+			t.Errorf("escapeShellArg(%q) expands to %q, want %q", dir, lines[0], want)
+		}
+		if strings.HasPrefix(dir, "~") && lines[1] != want {
+			t.Errorf("cd %s landed in %q, want %q", escapeShellArg(dir), lines[1], want)
+		}
+	}
+}
+
 func TestEscapeForTmux(t *testing.T) {
 	tests := []string{
 		"simple command",
@@ -288,7 +409,7 @@ func TestRemoteStageExecution(t *testing.T) {
 	}
 
 	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	_ = re.KillRemoteSession(ctx)
+	re.KillRemoteSession(ctx)
 	cancel()
 }
 
@@ -317,7 +438,7 @@ func TestRemoteStageExecutionFailure(t *testing.T) {
 	}
 
 	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	_ = re.KillRemoteSession(ctx)
+	re.KillRemoteSession(ctx)
 	cancel()
 }
 
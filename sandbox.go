@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxReportedWrites caps how many undeclared writes an error message lists.
+const maxReportedWrites = 10
+
+// sandboxOutputPath resolves a declared output: relative paths are inside
+// the project, "~/" paths under the home directory, absolute paths as is.
+func sandboxOutputPath(root, output string) (string, error) {
+	switch {
+	case strings.HasPrefix(output, "~/"):
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, output[2:]), nil
+	case filepath.IsAbs(output):
+		return filepath.Clean(output), nil
+	case filepath.IsLocal(output):
+		return filepath.Join(root, output), nil
+	default:
+		return "", fmt.Errorf("output %q escapes the project; use an absolute or ~/ path", output)
+	}
+}
+
+// sandboxArgs builds the bwrap arguments that confine a stage: the whole
+// filesystem read-only, a private /tmp, and no network unless the stage
+// allows it. The project is an overlay whose writes land in upper (so they
+// can be reported and discarded) when upper is set, and read-only
+// otherwise. Outputs are bound writable on top.
+func sandboxArgs(stage Stage, root, dir, upper, work string) ([]string, error) {
+	args := []string{
+		"--die-with-parent", "--unshare-pid", "--unshare-ipc", "--unshare-uts",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	if stage.NoNetwork {
+		args = append(args, "--unshare-net")
+	}
+	// /tmp is private, so the project is mounted again in case it lives there.
+	if upper != "" {
+		args = append(args, "--overlay-src", root, "--overlay", upper, work, root)
+	} else {
+		args = append(args, "--ro-bind", root, root)
+	}
+	for _, output := range stage.Outputs {
+		p, err := sandboxOutputPath(root, output)
+		if err != nil {
+			return nil, err
+		}
+		if err := createSandboxOutput(p, strings.HasSuffix(output, "/")); err != nil {
+			return nil, fmt.Errorf("output %q: %w", output, err)
+		}
+		args = append(args, "--bind", p, p)
+	}
+	return append(args, "--setenv", "TMPDIR", "/tmp", "--chdir", dir), nil
+}
+
+// createSandboxOutput makes a missing output exist so it can be bound: a
+// directory when it was declared with a trailing slash, otherwise an empty
+// file in a created parent. Existing outputs are bound as they are.
+func createSandboxOutput(p string, isDir bool) error {
+	if _, err := os.Lstat(p); err == nil {
+		return nil
+	}
+	if isDir {
+		return os.MkdirAll(p, 0o755)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+var (
+	overlayOnce      sync.Once
+	overlaySupported bool
+)
+
+// sandboxOverlay reports whether bwrap can mount an overlay here. It needs
+// bubblewrap 0.8+ and a kernel that allows overlayfs in user namespaces.
+func sandboxOverlay() bool {
+	overlayOnce.Do(func() {
+		tmp, err := os.MkdirTemp("", "local-ci-overlay-probe-")
+		if err != nil {
+			return
+		}
+		defer os.RemoveAll(tmp)
+		lower, upper, work := filepath.Join(tmp, "lower"), filepath.Join(tmp, "upper"), filepath.Join(tmp, "work")
+		for _, d := range []string{lower, upper, work} {
+			os.Mkdir(d, 0o755)
+		}
+		overlaySupported = exec.Command("bwrap", "--ro-bind", "/", "/", "--overlay-src", lower, "--overlay", upper, work, lower, "true").Run() == nil
+	})
+	return overlaySupported
+}
+
+// sandboxCommand wraps cmd in bubblewrap. The returned check runs after the
+// command exits: it lists files the stage wrote outside its outputs and
+// cleans up the overlay.
+func sandboxCommand(ctx context.Context, cmd *exec.Cmd, stage Stage, root string) (*exec.Cmd, func() []string, error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil, fmt.Errorf("sandbox = true needs Linux with bubblewrap (bwrap); this is %s", runtime.GOOS)
+	}
+	bwrap, err := exec.LookPath("bwrap")
+	if err != nil {
+		return nil, nil, fmt.Errorf("sandbox = true needs bubblewrap (bwrap) in PATH")
+	}
+	if cmd.Err != nil {
+		return nil, nil, cmd.Err
+	}
+	dir := cmd.Dir
+	if dir == "" {
+		dir = root
+	}
+
+	var tmp, upper, work string
+	if sandboxOverlay() {
+		if tmp, err = os.MkdirTemp("", "local-ci-sandbox-"); err != nil {
+			return nil, nil, err
+		}
+		upper, work = filepath.Join(tmp, "upper"), filepath.Join(tmp, "work")
+		os.Mkdir(upper, 0o755)
+		os.Mkdir(work, 0o755)
+	}
+	args, err := sandboxArgs(stage, root, dir, upper, work)
+	if err != nil {
+		if tmp != "" {
+			os.RemoveAll(tmp)
+		}
+		return nil, nil, err
+	}
+	args = append(append(args, "--", cmd.Path), cmd.Args[1:]...)
+
+	wrapped := exec.CommandContext(ctx, bwrap, args...)
+	wrapped.Dir = dir
+	wrapped.Env = cmd.Env
+	check := func() []string {
+		if tmp == "" {
+			return nil
+		}
+		defer os.RemoveAll(tmp)
+		return overlayWrites(upper)
+	}
+	return wrapped, check, nil
+}
+
+// overlayWrites lists what an overlay upper directory holds: files the
+// stage created or changed, and "-path" for files it deleted (whiteouts).
+func overlayWrites(upper string) []string {
+	var writes []string
+	filepath.WalkDir(upper, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == upper || d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(upper, p)
+		rel = filepath.ToSlash(rel)
+		if d.Type()&fs.ModeCharDevice != 0 {
+			rel = "-" + rel
+		}
+		writes = append(writes, rel)
+		return nil
+	})
+	sort.Strings(writes)
+	return writes
+}
+
+// undeclaredWritesError summarizes writes outside a stage's outputs.
+func undeclaredWritesError(writes []string) error {
+	shown := writes
+	if len(shown) > maxReportedWrites {
+		shown = shown[:maxReportedWrites]
+	}
+	msg := strings.Join(shown, ", ")
+	if len(writes) > len(shown) {
+		msg += fmt.Sprintf(" and %d more", len(writes)-len(shown))
+	}
+	return fmt.Errorf("undeclared writes (discarded; add them to outputs): %s", msg)
+}
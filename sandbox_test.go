@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSandboxArgs(t *testing.T) {
+	root := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	stage := Stage{Name: "test", Outputs: []string{"target/", "~/.cargo/registry/"}, NoNetwork: true}
+
+	args, err := sandboxArgs(stage, root, filepath.Join(root, "crates"), "/u", "/w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"--ro-bind / /",
+		"--tmpfs /tmp",
+		"--unshare-net",
+		"--overlay-src " + root + " --overlay /u /w " + root,
+		"--bind " + filepath.Join(root, "target") + " " + filepath.Join(root, "target"),
+		"--bind " + filepath.Join(home, ".cargo/registry"),
+		"--chdir " + filepath.Join(root, "crates"),
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(root, "target")); err != nil || !fi.IsDir() {
+		t.Errorf("directory outputs should be created so they can be bound: %v", err)
+	}
+
+	stage.NoNetwork = false
+	args, _ = sandboxArgs(stage, root, root, "", "")
+	joined = strings.Join(args, " ")
+	if strings.Contains(joined, "--unshare-net") || !strings.Contains(joined, "--ro-bind "+root+" "+root) {
+		t.Errorf("without an overlay the project is read-only, and network stays on:\n%s", joined)
+	}
+
+	if _, err := sandboxArgs(Stage{Outputs: []string{"../elsewhere/"}}, root, root, "", ""); err == nil {
+		t.Error("an output escaping the project should be rejected")
+	}
+}
+
+func TestSandboxFileOutputs(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "dist"), 0o755)
+	os.WriteFile(filepath.Join(root, "lcov.info"), []byte("TN:\n"), 0o644)
+	stage := Stage{Outputs: []string{"reports/junit.xml", "lcov.info", "dist"}}
+
+	args, err := sandboxArgs(stage, root, root, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(args, " ")
+	for _, output := range stage.Outputs {
+		p := filepath.Join(root, output)
+		if !strings.Contains(joined, "--bind "+p+" "+p) {
+			t.Errorf("args missing bind of %s:\n%s", output, joined)
+		}
+	}
+	// A missing file output is bound as an empty file in a created parent,
+	// not turned into a directory.
+	if fi, err := os.Stat(filepath.Join(root, "reports", "junit.xml")); err != nil || !fi.Mode().IsRegular() || fi.Size() != 0 {
+		t.Errorf("reports/junit.xml = %v, %v", fi, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "lcov.info")); string(data) != "TN:\n" {
+		t.Errorf("existing file output was changed: %q", data)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "dist")); err != nil || !fi.IsDir() {
+		t.Errorf("existing directory output = %v, %v", fi, err)
+	}
+}
+
+// fakeBwrap installs a bwrap stand-in that skips its mount options, honors
+// --chdir, and writes $FAKE_BWRAP_WRITE into the overlay's upper directory
+// as if the stage had written it.
+func fakeBwrap(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox is Linux-only")
+	}
+	bin := t.TempDir()
+	script := `#!/bin/sh
+upper=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--) shift; break ;;
+	--overlay) upper=$2; shift 4 ;;
+	--chdir) cd "$2"; shift 2 ;;
+	--ro-bind|--bind|--setenv) shift 3 ;;
+	--overlay-src|--dev|--proc|--tmpfs) shift 2 ;;
+	--*) shift ;;
+	*) break ;;
+	esac
+done
+if [ -n "$upper" ] && [ -n "$FAKE_BWRAP_WRITE" ]; then
+	mkdir -p "$upper/$(dirname "$FAKE_BWRAP_WRITE")"
+	echo x > "$upper/$FAKE_BWRAP_WRITE"
+fi
+exec "$@"
+`
+	os.WriteFile(filepath.Join(bin, "bwrap"), []byte(script), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	overlayOnce = sync.Once{}
+	t.Cleanup(func() { overlayOnce = sync.Once{} })
+}
+
+func TestSandboxedStageReportsUndeclaredWrites(t *testing.T) {
+	fakeBwrap(t)
+	root := t.TempDir()
+	stage := Stage{Name: "build", Cmd: []string{"sh", "-c", "echo built"}, Timeout: 10, Sandbox: true, Outputs: []string{"dist/"}}
+
+	result := runLocalStage(context.Background(), stage, root, nil)
+	if result.Status != "pass" || result.Output != "built\n" {
+		t.Fatalf("clean run = %s %v %q", result.Status, result.Error, result.Output)
+	}
+
+	t.Setenv("FAKE_BWRAP_WRITE", "src/generated.rs")
+	result = runLocalStage(context.Background(), stage, root, nil)
+	if result.Status != "fail" || len(result.UndeclaredWrites) != 1 || result.UndeclaredWrites[0] != "src/generated.rs" {
+		t.Fatalf("expected an undeclared write, got %s %v %v", result.Status, result.Error, result.UndeclaredWrites)
+	}
+	if !strings.Contains(result.Error.Error(), "add them to outputs") {
+		t.Errorf("error = %v", result.Error)
+	}
+	if rj := toJSONResults([]Result{result})[0]; len(rj.UndeclaredWrites) != 1 {
+		t.Errorf("JSON should carry undeclared writes: %+v", rj)
+	}
+}
+
+func TestOverlayWritesAndError(t *testing.T) {
+	upper := t.TempDir()
+	os.MkdirAll(filepath.Join(upper, "a", "b"), 0o755)
+	os.WriteFile(filepath.Join(upper, "a", "b", "c.txt"), nil, 0o644)
+	os.WriteFile(filepath.Join(upper, "top.log"), nil, 0o644)
+	if got := strings.Join(overlayWrites(upper), ","); got != "a/b/c.txt,top.log" {
+		t.Errorf("writes = %s", got)
+	}
+
+	var many []string
+	for i := 0; i < maxReportedWrites+3; i++ {
+		many = append(many, "f")
+	}
+	if msg := undeclaredWritesError(many).Error(); !strings.HasSuffix(msg, "and 3 more") {
+		t.Errorf("message = %s", msg)
+	}
+}
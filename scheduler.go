@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// resourceBudget is what the parallel scheduler may hand out at once. Zero
+// fields are unlimited.
+type resourceBudget struct {
+	CPUs   int   `json:"cpus"`
+	Memory int64 `json:"memory_bytes"`
+}
+
+// localResourceBudget is this machine's cores and currently available
+// memory. Memory is only known on Linux; elsewhere it is unlimited.
+func localResourceBudget() resourceBudget {
+	return resourceBudget{CPUs: runtime.NumCPU(), Memory: memAvailable("/proc/meminfo")}
+}
+
+// memAvailable reads MemAvailable from a meminfo file, in bytes, or 0.
+func memAvailable(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemAvailable:   12345678 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// parseMemorySize parses a stage's `memory`: "512M", "4G", "1.5GiB" (binary
+// units), or a bare number of megabytes.
+func parseMemorySize(value string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1 << 20)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult, s = 1<<10, s[:n-1]
+		case 'M':
+			mult, s = 1<<20, s[:n-1]
+		case 'G':
+			mult, s = 1<<30, s[:n-1]
+		case 'T':
+			mult, s = 1<<40, s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid memory size %q (want e.g. 512M or 4G)", value)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// stageCPUs is the cores a stage is assumed to use: its `cpus` hint, or one.
+func stageCPUs(s Stage) int {
+	if s.CPUs > 0 {
+		return s.CPUs
+	}
+	return 1
+}
+
+// resourcePool admits stages while they fit: a free slot (--parallel), the
+// cores and memory they declare, and their `lock` group. A stage asking for
+// more than the whole budget runs once nothing else holds that resource,
+// instead of never.
+type resourcePool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	slots  int
+	budget resourceBudget
+
+	running int
+	cpus    int
+	memory  int64
+	locks   map[string]bool
+}
+
+func newResourcePool(slots int, budget resourceBudget) *resourcePool {
+	p := &resourcePool{slots: slots, budget: budget, locks: make(map[string]bool)}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// fits reports whether s can start now. Callers hold p.mu.
+func (p *resourcePool) fits(s Stage) bool {
+	if p.slots > 0 && p.running >= p.slots {
+		return false
+	}
+	if s.Lock != "" && p.locks[s.Lock] {
+		return false
+	}
+	if p.budget.CPUs > 0 && p.cpus > 0 && p.cpus+stageCPUs(s) > p.budget.CPUs {
+		return false
+	}
+	if p.budget.Memory > 0 && p.memory > 0 && p.memory+s.Memory > p.budget.Memory {
+		return false
+	}
+	return true
+}
+
+func (p *resourcePool) take(s Stage) {
+	p.running++
+	p.cpus += stageCPUs(s)
+	p.memory += s.Memory
+	if s.Lock != "" {
+		p.locks[s.Lock] = true
+	}
+}
+
+func (p *resourcePool) give(s Stage) {
+	p.running--
+	p.cpus -= stageCPUs(s)
+	p.memory -= s.Memory
+	if s.Lock != "" {
+		delete(p.locks, s.Lock)
+	}
+}
+
+// acquire blocks until s fits and returns the function that releases it.
+func (p *resourcePool) acquire(s Stage) func() {
+	p.mu.Lock()
+	for !p.fits(s) {
+		p.cond.Wait()
+	}
+	p.take(s)
+	p.mu.Unlock()
+	return func() {
+		p.mu.Lock()
+		p.give(s)
+		p.mu.Unlock()
+		p.cond.Broadcast()
+	}
+}
+
+// planSchedule predicts how the scheduler batches stages, assuming they all
+// take equally long: each wave starts the stages whose dependencies ran in
+// earlier waves, in order, while they fit. Dependencies outside stages are
+// treated as satisfied. Used by --dry-run.
+func planSchedule(stages []Stage, slots int, budget resourceBudget) [][]string {
+	in := make(map[string]bool, len(stages))
+	for _, s := range stages {
+		in[s.Name] = true
+	}
+	done := make(map[string]bool, len(stages))
+	remaining := append([]Stage(nil), stages...)
+	var waves [][]string
+	for len(remaining) > 0 {
+		pool := newResourcePool(slots, budget)
+		var wave []string
+		var next []Stage
+		for _, s := range remaining {
+			ready := true
+			for _, dep := range s.DependsOn {
+				if in[dep] && !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready && pool.fits(s) {
+				pool.take(s)
+				wave = append(wave, s.Name)
+			} else {
+				next = append(next, s)
+			}
+		}
+		if len(wave) == 0 {
+			// A dependency cycle: the runner would wait forever too.
+			for _, s := range next {
+				wave = append(wave, s.Name+" (blocked)")
+			}
+			next = nil
+		}
+		for _, name := range wave {
+			done[name] = true
+		}
+		waves = append(waves, wave)
+		remaining = next
+	}
+	return waves
+}
+
+// formatStageResources renders a stage's hints for --dry-run, e.g.
+// "cpus=4 memory=4.0 GB lock=cargo-target", or "" without any.
+func formatStageResources(s Stage) string {
+	var parts []string
+	if s.CPUs > 0 {
+		parts = append(parts, fmt.Sprintf("cpus=%d", s.CPUs))
+	}
+	if s.Memory > 0 {
+		parts = append(parts, "memory="+formatSize(s.Memory))
+	}
+	if s.Lock != "" {
+		parts = append(parts, "lock="+s.Lock)
+	}
+	return strings.Join(parts, " ")
+}
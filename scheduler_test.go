@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseMemorySize(t *testing.T) {
+	tests := map[string]int64{
+		"512M":   512 << 20,
+		"4G":     4 << 30,
+		"4gb":    4 << 30,
+		"1.5GiB": 3 << 29,
+		"256":    256 << 20,
+		"64K":    64 << 10,
+	}
+	for in, want := range tests {
+		got, err := parseMemorySize(in)
+		if err != nil || got != want {
+			t.Errorf("parseMemorySize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "lots", "-1G"} {
+		if _, err := parseMemorySize(bad); err == nil {
+			t.Errorf("parseMemorySize(%q) should fail", bad)
+		}
+	}
+}
+
+func TestMemAvailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	os.WriteFile(path, []byte("MemTotal:       16384000 kB\nMemFree:         1024000 kB\nMemAvailable:    8192000 kB\n"), 0o644)
+	if got := memAvailable(path); got != 8192000*1024 {
+		t.Errorf("memAvailable = %d", got)
+	}
+	if got := memAvailable(filepath.Join(t.TempDir(), "missing")); got != 0 {
+		t.Errorf("missing meminfo should be unlimited, got %d", got)
+	}
+}
+
+func TestStageResourcesFromConfig(t *testing.T) {
+	root := t.TempDir()
+	config := `[stages.build]
+command = ["cargo", "build"]
+cpus = 4
+memory = "3G"
+lock = "cargo-target"
+
+[stages.lint]
+command = ["cargo", "clippy"]
+memory = 512
+`
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(config), 0o644)
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, lint := cfg.Stages["build"], cfg.Stages["lint"]
+	if build.CPUs != 4 || build.Memory != 3<<30 || build.Lock != "cargo-target" {
+		t.Errorf("build = cpus %d memory %d lock %q", build.CPUs, build.Memory, build.Lock)
+	}
+	if lint.Memory != 512<<20 {
+		t.Errorf("a bare memory number is megabytes, got %d", lint.Memory)
+	}
+	if got := formatStageResources(build); got != "cpus=4 memory=3.0 GB lock=cargo-target" {
+		t.Errorf("formatStageResources = %q", got)
+	}
+
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte("[stages.x]\ncommand = [\"true\"]\nmemory = \"lots\"\n"), 0o644)
+	if _, err := LoadConfig(root, false); err == nil || !strings.Contains(err.Error(), "invalid memory size") {
+		t.Errorf("expected an invalid memory error, got %v", err)
+	}
+}
+
+// TestParallelRunnerHonorsLocksAndBudget runs stages that record how many
+// of them overlap, and checks locks and the memory budget serialize them
+// even with free slots.
+func TestParallelRunnerHonorsLocksAndBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []Stage
+		budget resourceBudget
+		want   int
+	}{
+		{
+			name:   "lock",
+			stages: []Stage{{Name: "build", Lock: "target"}, {Name: "test", Lock: "target"}, {Name: "doc", Lock: "target"}},
+			want:   1,
+		},
+		{
+			name:   "memory",
+			stages: []Stage{{Name: "a", Memory: 3 << 30}, {Name: "b", Memory: 3 << 30}, {Name: "c", Memory: 1 << 30}},
+			budget: resourceBudget{Memory: 4 << 30},
+			want:   2,
+		},
+		{
+			name:   "cpus",
+			stages: []Stage{{Name: "a", CPUs: 4}, {Name: "b", CPUs: 4}, {Name: "c", CPUs: 8}},
+			budget: resourceBudget{CPUs: 4},
+			want:   1,
+		},
+		{
+			name:   "unconstrained",
+			stages: []Stage{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			want:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			running, peak := 0, 0
+			runner := &ParallelRunner{
+				Stages:      tt.stages,
+				Concurrency: 3,
+				NoCache:     true,
+				Cache:       map[string]string{},
+				Budget:      tt.budget,
+				Execute: func(s Stage) Result {
+					mu.Lock()
+					running++
+					peak = max(peak, running)
+					mu.Unlock()
+					time.Sleep(30 * time.Millisecond)
+					mu.Lock()
+					running--
+					mu.Unlock()
+					return Result{Name: s.Name, Status: "pass"}
+				},
+			}
+			for _, r := range runner.Run() {
+				if r.Status != "pass" {
+					t.Errorf("%s = %s", r.Name, r.Status)
+				}
+			}
+			if peak > tt.want || (tt.want == 3 && peak != 3) {
+				t.Errorf("peak concurrency = %d, want %d", peak, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSchedule(t *testing.T) {
+	stages := []Stage{
+		{Name: "fmt"},
+		{Name: "build", CPUs: 4, Lock: "cargo-target"},
+		{Name: "clippy", CPUs: 2, Lock: "cargo-target"},
+		{Name: "test", CPUs: 4, Lock: "cargo-target", DependsOn: []string{"build"}},
+		{Name: "docs", DependsOn: []string{"fmt"}},
+	}
+	waves := planSchedule(stages, 4, resourceBudget{CPUs: 6})
+	var got []string
+	for _, w := range waves {
+		got = append(got, strings.Join(w, ","))
+	}
+	want := "fmt,build | clippy,docs | test"
+	if strings.Join(got, " | ") != want {
+		t.Errorf("plan = %s, want %s", strings.Join(got, " | "), want)
+	}
+
+	cycle := planSchedule([]Stage{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}, 2, resourceBudget{})
+	if len(cycle) != 1 || cycle[0][0] != "a (blocked)" {
+		t.Errorf("cycle plan = %v", cycle)
+	}
+}
+
+func TestDryRunSchedule(t *testing.T) {
+	stages := []Stage{
+		{Name: "build", Cmd: []string{"cargo", "build"}, Enabled: true, Lock: "target"},
+		{Name: "test", Cmd: []string{"cargo", "test"}, Enabled: true, Lock: "target"},
+		{Name: "off", Cmd: []string{"true"}},
+	}
+	report := BuildDryRunReport(stages, map[string]string{}, nil, "h", false, nil, nil)
+	report.AddSchedule(stages, 4, resourceBudget{CPUs: 8})
+	if report.Schedule == nil || len(report.Schedule.Waves) != 2 || report.Schedule.Waves[1][0] != "test" {
+		t.Fatalf("schedule = %+v", report.Schedule)
+	}
+	if report.Stages[0].Lock != "target" {
+		t.Errorf("stage resources missing: %+v", report.Stages[0])
+	}
+}
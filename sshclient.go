@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// nativeSSH runs remote commands over a single SSH connection that is dialed
+// on first use and shared by every command in the run. Each command gets its
+// own channel, so concurrent stages multiplex over the one connection instead
+// of paying a handshake per poll.
+type nativeSSH struct {
+	target     string
+	timeout    time.Duration
+	configPath string // defaults to ~/.ssh/config
+
+	mu     sync.Mutex
+	client *ssh.Client
+	hops   []*ssh.Client // ProxyJump connections, closest first
+	agent  net.Conn
+	done   chan struct{} // stops the keepalive loop
+}
+
+func newNativeSSH(target string, timeout time.Duration) *nativeSSH {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &nativeSSH{target: target, timeout: timeout}
+}
+
+func (n *nativeSSH) execWithOutput(ctx context.Context, cmd string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := n.run(ctx, cmd, &stdout, &stderr); err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) && benignSSHFailure(cmd, stdout.String(), stderr.String()) {
+			return "", nil
+		}
+		return stdout.String(), fmt.Errorf("SSH command failed: %w (stderr: %s)", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// run executes cmd on a new channel, returning *ssh.ExitError when the
+// command exits non-zero. Cancelling ctx signals and closes the channel.
+func (n *nativeSSH) run(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	return n.runWithInput(ctx, cmd, nil, stdout, stderr)
+}
+
+// runWithInput is run with stdin fed to the remote command.
+func (n *nativeSSH) runWithInput(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	client, err := n.connect(ctx)
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		// The connection may have dropped since the last command.
+		n.reset(client)
+		if client, err = n.connect(ctx); err != nil {
+			return err
+		}
+		if session, err = client.NewSession(); err != nil {
+			return fmt.Errorf("failed to open SSH session: %w", err)
+		}
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		// Wait for the session's output copies to finish so the caller can
+		// read stdout/stderr. A host that stopped answering never closes
+		// the channel, so drop the connection to unblock them.
+		select {
+		case <-done:
+		case <-time.After(n.timeout):
+			n.reset(client)
+			<-done
+		}
+		return ctx.Err()
+	}
+}
+
+// Close tears down the shared connection and any ProxyJump hops.
+func (n *nativeSSH) Close() error {
+	n.mu.Lock()
+	client := n.client
+	n.mu.Unlock()
+	if client != nil {
+		n.reset(client)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.agent != nil {
+		n.agent.Close()
+		n.agent = nil
+	}
+	return nil
+}
+
+// reset drops client if it is still the current connection.
+func (n *nativeSSH) reset(client *ssh.Client) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.client != client {
+		return
+	}
+	close(n.done)
+	n.client.Close()
+	for i := len(n.hops) - 1; i >= 0; i-- {
+		n.hops[i].Close()
+	}
+	n.client, n.hops, n.done = nil, nil, nil
+}
+
+func (n *nativeSSH) connect(ctx context.Context) (*ssh.Client, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.client != nil {
+		return n.client, nil
+	}
+
+	configPath := n.configPath
+	if configPath == "" {
+		configPath = filepath.Join(homeDir(), ".ssh", "config")
+	}
+	cfg, err := loadSSHConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	target := resolveSSHTarget(n.target, cfg)
+
+	dial := func(network, addr string) (net.Conn, error) {
+		d := net.Dialer{Timeout: n.timeout}
+		return d.DialContext(ctx, network, addr)
+	}
+	var hops []*ssh.Client
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			hops[i].Close()
+		}
+	}
+	for _, hop := range target.ProxyJump {
+		hc := resolveSSHTarget(hop, cfg)
+		c, err := n.handshake(dial, hc)
+		if err != nil {
+			closeHops()
+			return nil, fmt.Errorf("ProxyJump %s: %w", hop, err)
+		}
+		hops = append(hops, c)
+		dial = c.Dial
+	}
+	client, err := n.handshake(dial, target)
+	if err != nil {
+		closeHops()
+		return nil, err
+	}
+
+	n.client, n.hops, n.done = client, hops, make(chan struct{})
+	if target.ServerAliveInterval > 0 {
+		go n.keepalive(client, target.ServerAliveInterval, n.done)
+	}
+	return client, nil
+}
+
+func (n *nativeSSH) handshake(dial func(network, addr string) (net.Conn, error), hc sshHostConfig) (*ssh.Client, error) {
+	hostKeys, err := sshHostKeyCallback(hc)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            hc.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(n.signers(hc))},
+		HostKeyCallback: hostKeys,
+		Timeout:         n.timeout,
+	}
+
+	conn, err := dial("tcp", hc.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", hc.Addr(), err)
+	}
+	config.HostKeyAlgorithms = knownHostKeyAlgorithms(hc, hc.Addr(), conn.RemoteAddr())
+	// Channels opened through a jump host don't support deadlines; the
+	// outer connection's keepalive covers them instead.
+	conn.SetDeadline(time.Now().Add(n.timeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, hc.Addr(), config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with %s failed: %w", hc.Addr(), err)
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// signers offers ssh-agent keys first, then the host's identity files.
+// Both go through one callback because the client tries each auth method
+// type only once.
+func (n *nativeSSH) signers(hc sshHostConfig) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if n.agent == nil {
+				n.agent, _ = net.Dial("unix", sock)
+			}
+			if n.agent != nil {
+				if keys, err := agent.NewClient(n.agent).Signers(); err == nil {
+					signers = append(signers, keys...)
+				}
+			}
+		}
+		for _, path := range hc.IdentityFiles {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			// Passphrase-protected keys are only usable through the agent.
+			signer, err := ssh.ParsePrivateKey(data)
+			if err != nil {
+				continue
+			}
+			signers = append(signers, signer)
+		}
+		return signers, nil
+	}
+}
+
+// sshHostKeyCallback verifies host keys against known_hosts. Unknown hosts
+// are rejected unless StrictHostKeyChecking is accept-new or no, which
+// record the key; a changed key is always rejected.
+func sshHostKeyCallback(hc sshHostConfig) (ssh.HostKeyCallback, error) {
+	acceptNew := false
+	switch hc.StrictHostKeyChecking {
+	case "accept-new", "no", "off":
+		acceptNew = true
+	}
+	var files []string
+	for _, f := range hc.KnownHostsFiles {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	var known ssh.HostKeyCallback
+	if len(files) > 0 {
+		cb, err := knownhosts.New(files...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+		}
+		known = cb
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if known != nil {
+			err := known(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+				return fmt.Errorf("host key verification failed for %s: %w", hostname, err)
+			}
+		}
+		if acceptNew {
+			if len(hc.KnownHostsFiles) == 0 {
+				return nil
+			}
+			line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+			return appendKnownHost(hc.KnownHostsFiles[0], line)
+		}
+		return fmt.Errorf("host key for %s is not in known_hosts; connect once with `ssh %s` to verify it, or set StrictHostKeyChecking accept-new", hostname, hc.Alias)
+	}, nil
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms of the keys
+// known_hosts records for hostname, so the server is asked for a key that
+// can be verified rather than whichever type it prefers. Nil (the library
+// default) when nothing is recorded.
+func knownHostKeyAlgorithms(hc sshHostConfig, hostname string, remote net.Addr) []string {
+	var files []string
+	for _, f := range hc.KnownHostsFiles {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	known, err := knownhosts.New(files...)
+	if err != nil {
+		return nil
+	}
+	// Checking a key that can't be recorded yields the ones that are.
+	_, probe, _ := ed25519.GenerateKey(rand.Reader)
+	probeKey, err := ssh.NewSignerFromKey(probe)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(known(hostname, remote, probeKey.PublicKey()), &keyErr) {
+		return nil
+	}
+	var algos []string
+	for _, k := range keyErr.Want {
+		if k.Key.Type() == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, k.Key.Type())
+	}
+	return dedupeStrings(algos)
+}
+
+func appendKnownHost(path, line string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+// keepalive pings the server every interval and drops the connection when
+// a ping goes unanswered, so the next command reconnects instead of hanging.
+func (n *nativeSSH) keepalive(client *ssh.Client, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case <-done:
+			return
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+		case <-time.After(interval):
+		}
+		n.reset(client)
+		return
+	}
+}
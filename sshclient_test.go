@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is a minimal in-process SSH server that runs exec requests
+// with sh -c and forwards direct-tcpip channels (for ProxyJump).
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+	conns   atomic.Int32
+}
+
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey, extraHostKeys ...ssh.Signer) *testSSHServer {
+	t.Helper()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized key")
+		},
+	}
+	config.AddHostKey(hostKey)
+	for _, k := range extraHostKeys {
+		config.AddHostKey(k)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &testSSHServer{addr: ln.Addr().String(), hostKey: hostKey}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) port() string {
+	_, port, _ := net.SplitHostPort(s.addr)
+	return port
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.conns.Add(1)
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go serveTestSession(ch, reqs)
+		case "direct-tcpip":
+			var target struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			ssh.Unmarshal(nc.ExtraData(), &target)
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+			if err != nil {
+				nc.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				io.Copy(ch, upstream)
+				ch.CloseWrite()
+			}()
+			go func() {
+				io.Copy(upstream, ch)
+				upstream.Close()
+			}()
+		default:
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func serveTestSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		n := binary.BigEndian.Uint32(req.Payload)
+		cmd := exec.Command("sh", "-c", string(req.Payload[4:4+n]))
+		cmd.Stdin = ch
+		cmd.Stdout = ch
+		cmd.Stderr = ch.Stderr()
+		code := 0
+		if err := cmd.Run(); err != nil {
+			code = 255
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.ExitCode()
+			}
+		}
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Code uint32 }{uint32(code)}))
+		return
+	}
+}
+
+// sshTestHome sets up $HOME/.ssh with a client key and returns the home
+// directory and the key's public half.
+func sshTestHome(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USER", "ci")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0o700)
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0o600)
+	sshPub, _ := ssh.NewPublicKey(pub)
+	return home, sshPub
+}
+
+func trustHost(t *testing.T, home string, s *testSSHServer) {
+	t.Helper()
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey.PublicKey())
+	if err := appendKnownHost(filepath.Join(home, ".ssh", "known_hosts"), line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNativeSSHExecSharesConnection(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	client := newNativeSSH("ci@"+server.addr, 5*time.Second)
+	defer client.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		out, err := client.execWithOutput(ctx, "echo hello")
+		if err != nil || out != "hello\n" {
+			t.Fatalf("exec %d = %q, %v", i, out, err)
+		}
+	}
+	if n := server.conns.Load(); n != 1 {
+		t.Errorf("expected one shared connection, server saw %d", n)
+	}
+
+	_, err := client.execWithOutput(ctx, "echo boom >&2; exit 3")
+	if err == nil || !strings.Contains(err.Error(), "boom") || !strings.Contains(err.Error(), "status 3") {
+		t.Errorf("expected exit status and stderr in error, got %v", err)
+	}
+
+	// Polling a missing sentinel is not an error.
+	if out, err := client.execWithOutput(ctx, "cat /nonexistent/sentinel 2>/dev/null"); err != nil || out != "" {
+		t.Errorf("missing sentinel = %q, %v", out, err)
+	}
+
+	// A dropped connection is re-dialed on the next command.
+	client.Close()
+	if out, err := client.execWithOutput(ctx, "echo again"); err != nil || out != "again\n" {
+		t.Errorf("exec after reconnect = %q, %v", out, err)
+	}
+}
+
+func TestNativeSSHCancel(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	client := newNativeSSH("ci@"+server.addr, 5*time.Second)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := client.execWithOutput(ctx, "sleep 10"); err == nil {
+		t.Fatal("expected cancellation error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("cancellation took %s", time.Since(start))
+	}
+}
+
+func TestNativeSSHHostKeyVerification(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	ctx := context.Background()
+
+	client := newNativeSSH("ci@"+server.addr, 5*time.Second)
+	_, err := client.execWithOutput(ctx, "true")
+	if err == nil || !strings.Contains(err.Error(), "not in known_hosts") {
+		t.Fatalf("unknown host should be rejected, got %v", err)
+	}
+
+	// A known_hosts entry with a different key is a hard failure.
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewSignerFromKey(other)
+	knownHosts := filepath.Join(home, ".ssh", "known_hosts")
+	appendKnownHost(knownHosts, knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, otherKey.PublicKey()))
+	if _, err := client.execWithOutput(ctx, "true"); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Fatalf("changed host key should be rejected, got %v", err)
+	}
+
+	// accept-new records an unknown host and connects.
+	os.Remove(knownHosts)
+	os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte("StrictHostKeyChecking accept-new\n"), 0o600)
+	if _, err := client.execWithOutput(ctx, "true"); err != nil {
+		t.Fatalf("accept-new should connect: %v", err)
+	}
+	client.Close()
+	data, _ := os.ReadFile(knownHosts)
+	if !strings.Contains(string(data), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(server.hostKey.PublicKey())))) {
+		t.Errorf("accept-new should record the host key, known_hosts:\n%s", data)
+	}
+
+	// StrictHostKeyChecking no still refuses a key that conflicts with
+	// known_hosts.
+	os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, otherKey.PublicKey())+"\n"), 0o600)
+	os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte("StrictHostKeyChecking no\n"), 0o600)
+	if _, err := client.execWithOutput(ctx, "true"); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Fatalf("StrictHostKeyChecking no should reject a changed host key, got %v", err)
+	}
+}
+
+func TestNativeSSHHostKeyAlgorithmFromKnownHosts(t *testing.T) {
+	home, pub := sshTestHome(t)
+	// The server also offers ECDSA, which the client would otherwise prefer
+	// over the recorded ed25519 key.
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecSigner, _ := ssh.NewSignerFromKey(ecKey)
+	server := startTestSSHServer(t, pub, ecSigner)
+	trustHost(t, home, server)
+
+	client := newNativeSSH("ci@"+server.addr, 5*time.Second)
+	defer client.Close()
+	if _, err := client.execWithOutput(context.Background(), "true"); err != nil {
+		t.Fatalf("recorded ed25519 key should verify: %v", err)
+	}
+}
+
+func TestNativeSSHConfigAliasAndProxyJump(t *testing.T) {
+	home, pub := sshTestHome(t)
+	jump := startTestSSHServer(t, pub)
+	target := startTestSSHServer(t, pub)
+	trustHost(t, home, jump)
+	trustHost(t, home, target)
+
+	// The key lives somewhere only IdentityFile points to.
+	keyPath := filepath.Join(home, "keys", "ci")
+	os.MkdirAll(filepath.Dir(keyPath), 0o700)
+	os.Rename(filepath.Join(home, ".ssh", "id_ed25519"), keyPath)
+
+	config := fmt.Sprintf(`Host bastion
+  HostName 127.0.0.1
+  Port %s
+
+Host builder
+  HostName 127.0.0.1
+  Port %s
+  ProxyJump bastion
+
+Host *
+  User ci
+  IdentityFile ~/keys/ci
+`, jump.port(), target.port())
+	os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(config), 0o600)
+
+	client := newNativeSSH("builder", 5*time.Second)
+	defer client.Close()
+	out, err := client.execWithOutput(context.Background(), "echo via-jump")
+	if err != nil || out != "via-jump\n" {
+		t.Fatalf("exec through ProxyJump = %q, %v", out, err)
+	}
+	if jump.conns.Load() != 1 || target.conns.Load() != 1 {
+		t.Errorf("expected one connection to each host, got jump=%d target=%d", jump.conns.Load(), target.conns.Load())
+	}
+}
+
+func TestRemoteExecutorReusesNativeClient(t *testing.T) {
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+
+	re := NewRemoteExecutor("ci@"+server.addr, "onion", "/tmp", 5*time.Second, false)
+	defer re.Close()
+	if re.sshClient() != re.sshClient() {
+		t.Fatal("sshClient should return the same client for the whole run")
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := re.TestSSHConnection(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.conns.Load(); n != 1 {
+		t.Errorf("expected one connection, server saw %d", n)
+	}
+
+	re2 := NewRemoteExecutor("ci@"+server.addr, "onion", "/tmp", 5*time.Second, false)
+	re2.OpenSSH = true
+	if _, ok := re2.sshClient().(execSSH); !ok {
+		t.Errorf("OpenSSH should select the ssh binary, got %T", re2.sshClient())
+	}
+}
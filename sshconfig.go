@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSSHKeepalive is used when ~/.ssh/config doesn't set
+// ServerAliveInterval. OpenSSH defaults to no keepalives, but long stages
+// behind NAT or Tailscale relays otherwise lose idle connections.
+const defaultSSHKeepalive = 30 * time.Second
+
+// sshHostConfig is the subset of ssh_config(5) local-ci honors for a host.
+type sshHostConfig struct {
+	Alias                 string // name as given on the command line
+	HostName              string
+	User                  string
+	Port                  string
+	IdentityFiles         []string
+	ProxyJump             []string // hops in order, each [user@]host[:port]
+	KnownHostsFiles       []string
+	StrictHostKeyChecking string // yes | no | accept-new | ask
+	ServerAliveInterval   time.Duration
+}
+
+// Addr is the host:port to dial.
+func (h sshHostConfig) Addr() string {
+	return h.HostName + ":" + h.Port
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string // lowercased keyword, value
+}
+
+// sshConfigFile is a parsed ssh_config. Only Host blocks are supported;
+// Match blocks never match.
+type sshConfigFile struct {
+	blocks []sshConfigBlock
+}
+
+// loadSSHConfig parses path (following Include). A missing file yields an
+// empty config.
+func loadSSHConfig(path string) (*sshConfigFile, error) {
+	cfg := &sshConfigFile{blocks: []sshConfigBlock{{patterns: []string{"*"}}}}
+	if err := cfg.parse(path, 0); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *sshConfigFile) parse(file string, depth int) error {
+	if depth > 16 {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value := splitSSHConfigLine(scanner.Text())
+		switch key {
+		case "":
+			continue
+		case "host":
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: strings.Fields(value)})
+		case "match":
+			// Unsupported: a block with no patterns never matches.
+			c.blocks = append(c.blocks, sshConfigBlock{})
+		case "include":
+			for _, pattern := range strings.Fields(value) {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(homeDir(), ".ssh", pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, m := range matches {
+					c.parse(m, depth+1)
+				}
+			}
+		default:
+			last := &c.blocks[len(c.blocks)-1]
+			last.options = append(last.options, [2]string{key, value})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitSSHConfigLine returns the lowercased keyword and its value, with
+// comments and surrounding quotes removed.
+func splitSSHConfigLine(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	key := strings.ToLower(line[:i])
+	value := strings.TrimLeft(line[i:], " \t=")
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return key, value
+}
+
+func (b sshConfigBlock) matches(host string) bool {
+	matched := false
+	for _, p := range b.patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		ok, _ := path.Match(p, host)
+		if ok && negate {
+			return false
+		}
+		if ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// lookup collects the options that apply to host. As in OpenSSH, the first
+// value obtained for a keyword wins, except IdentityFile which accumulates.
+func (c *sshConfigFile) lookup(host string) map[string][]string {
+	opts := make(map[string][]string)
+	for _, b := range c.blocks {
+		if !b.matches(host) {
+			continue
+		}
+		for _, o := range b.options {
+			if _, seen := opts[o[0]]; seen && o[0] != "identityfile" {
+				continue
+			}
+			opts[o[0]] = append(opts[o[0]], o[1])
+		}
+	}
+	return opts
+}
+
+// resolveSSHTarget applies ssh_config to a [user@]host[:port] target. An
+// explicit user or port in target wins over the config file.
+func resolveSSHTarget(target string, cfg *sshConfigFile) sshHostConfig {
+	var explicitUser, explicitPort string
+	alias := target
+	if i := strings.LastIndex(alias, "@"); i >= 0 {
+		explicitUser, alias = alias[:i], alias[i+1:]
+	}
+	if i := strings.LastIndex(alias, ":"); i >= 0 && !strings.Contains(alias[:i], ":") {
+		if _, err := strconv.Atoi(alias[i+1:]); err == nil {
+			alias, explicitPort = alias[:i], alias[i+1:]
+		}
+	}
+
+	opts := cfg.lookup(alias)
+	first := func(key string) string {
+		if v := opts[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	h := sshHostConfig{Alias: alias, HostName: alias, Port: "22"}
+	if v := first("hostname"); v != "" {
+		h.HostName = strings.ReplaceAll(v, "%h", alias)
+	}
+	h.User = explicitUser
+	if h.User == "" {
+		h.User = first("user")
+	}
+	if h.User == "" {
+		h.User = localUsername()
+	}
+	if explicitPort != "" {
+		h.Port = explicitPort
+	} else if v := first("port"); v != "" {
+		h.Port = v
+	}
+
+	for _, f := range opts["identityfile"] {
+		h.IdentityFiles = append(h.IdentityFiles, expandSSHTokens(f, h))
+	}
+	if len(h.IdentityFiles) == 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			h.IdentityFiles = append(h.IdentityFiles, filepath.Join(homeDir(), ".ssh", name))
+		}
+	}
+
+	if v := first("proxyjump"); v != "" && !strings.EqualFold(v, "none") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				h.ProxyJump = append(h.ProxyJump, strings.TrimPrefix(hop, "ssh://"))
+			}
+		}
+	}
+
+	if v := first("userknownhostsfile"); v != "" {
+		for _, f := range strings.Fields(v) {
+			h.KnownHostsFiles = append(h.KnownHostsFiles, expandSSHTokens(f, h))
+		}
+	} else {
+		h.KnownHostsFiles = []string{filepath.Join(homeDir(), ".ssh", "known_hosts")}
+	}
+
+	h.StrictHostKeyChecking = strings.ToLower(first("stricthostkeychecking"))
+	if h.StrictHostKeyChecking == "" {
+		h.StrictHostKeyChecking = "ask"
+	}
+
+	h.ServerAliveInterval = defaultSSHKeepalive
+	if v := first("serveraliveinterval"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			h.ServerAliveInterval = time.Duration(n) * time.Second
+		}
+	}
+	return h
+}
+
+// expandSSHTokens expands ~ and the common % tokens in path-like options.
+func expandSSHTokens(s string, h sshHostConfig) string {
+	s = expandHome(s)
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", homeDir(),
+		"%h", h.HostName,
+		"%r", h.User,
+		"%u", localUsername(),
+	).Replace(s)
+}
+
+func expandHome(p string) string {
+	if p == "~" {
+		return homeDir()
+	}
+	if strings.HasPrefix(p, "~/") {
+		return filepath.Join(homeDir(), p[2:])
+	}
+	return p
+}
+
+func homeDir() string {
+	if h, err := os.UserHomeDir(); err == nil {
+		return h
+	}
+	return "."
+}
+
+func localUsername() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "root"
+}
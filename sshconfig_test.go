@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveSSHTarget(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USER", "me")
+	sshDir := filepath.Join(home, ".ssh")
+	os.MkdirAll(sshDir, 0o700)
+	os.WriteFile(filepath.Join(sshDir, "extra.conf"), []byte("Host builder\n  ServerAliveInterval 5\n"), 0o600)
+	config := `# global
+Include extra.conf
+
+Host builder mac-*
+  HostName 10.0.0.%h
+  User ci
+  Port=2222
+  IdentityFile ~/.ssh/builder_key
+  ProxyJump bastion,ci@gw:2200
+
+Host !mac-mini *
+  IdentityFile "%d/.ssh/fallback"
+  User ignored
+  StrictHostKeyChecking accept-new
+
+Match host builder
+  User never
+`
+	path := filepath.Join(sshDir, "config")
+	os.WriteFile(path, []byte(config), 0o600)
+
+	cfg, err := loadSSHConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := resolveSSHTarget("builder", cfg)
+	want := sshHostConfig{
+		Alias:                 "builder",
+		HostName:              "10.0.0.builder",
+		User:                  "ci",
+		Port:                  "2222",
+		IdentityFiles:         []string{filepath.Join(sshDir, "builder_key"), filepath.Join(sshDir, "fallback")},
+		ProxyJump:             []string{"bastion", "ci@gw:2200"},
+		KnownHostsFiles:       []string{filepath.Join(sshDir, "known_hosts")},
+		StrictHostKeyChecking: "accept-new",
+		ServerAliveInterval:   5 * time.Second,
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("resolveSSHTarget(builder) =\n%+v\nwant\n%+v", h, want)
+	}
+
+	// Explicit user and port win; the negated pattern excludes mac-mini
+	// from the catch-all block.
+	h = resolveSSHTarget("root@mac-mini:22", cfg)
+	if h.User != "root" || h.Port != "22" || h.HostName != "10.0.0.mac-mini" || h.StrictHostKeyChecking != "ask" {
+		t.Errorf("unexpected mac-mini config: %+v", h)
+	}
+	if len(h.IdentityFiles) != 1 {
+		t.Errorf("negated host should not get the fallback identity: %v", h.IdentityFiles)
+	}
+
+	h = resolveSSHTarget("plain.example.com", cfg)
+	if h.HostName != "plain.example.com" || h.User != "ignored" || h.Port != "22" || len(h.ProxyJump) != 0 || h.ServerAliveInterval != defaultSSHKeepalive {
+		t.Errorf("unexpected default config: %+v", h)
+	}
+}
+
+func TestLoadSSHConfigMissingFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USER", "me")
+	cfg, err := loadSSHConfig(filepath.Join(t.TempDir(), "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := resolveSSHTarget("host", cfg)
+	if h.User != "me" || h.Addr() != "host:22" || len(h.IdentityFiles) != 3 {
+		t.Errorf("unexpected defaults: %+v", h)
+	}
+}
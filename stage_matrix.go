@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stageMatrix is a stage's `matrix` table. Every combination of the axes'
+// values becomes its own stage instance, minus `exclude` and plus `include`
+// entries, as in GitHub Actions.
+type stageMatrix struct {
+	Axes    map[string][]string
+	Exclude []map[string]string
+	Include []map[string]string
+}
+
+// matrixCombo is one instance's values. nameKeys are the keys shown in the
+// instance name: the axes, or every key of an added `include` entry.
+type matrixCombo struct {
+	values   map[string]string
+	nameKeys []string
+}
+
+var matrixPlaceholder = regexp.MustCompile(`\{matrix\.([A-Za-z0-9_-]+)\}`)
+
+// parseStageMatrix reads a `matrix` table. Axis values may be strings,
+// numbers or booleans; quote versions such as "3.10" so they survive.
+func parseStageMatrix(raw map[string]interface{}) (*stageMatrix, error) {
+	m := &stageMatrix{Axes: make(map[string][]string)}
+	for key, val := range raw {
+		switch key {
+		case "exclude", "include":
+			entries, err := matrixEntries(val)
+			if err != nil {
+				return nil, fmt.Errorf("matrix.%s: %w", key, err)
+			}
+			if key == "exclude" {
+				m.Exclude = entries
+			} else {
+				m.Include = entries
+			}
+		default:
+			list, ok := val.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("matrix.%s must be a non-empty list", key)
+			}
+			for _, item := range list {
+				s, err := matrixValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("matrix.%s: %w", key, err)
+				}
+				m.Axes[key] = append(m.Axes[key], s)
+			}
+		}
+	}
+	if len(m.Axes) == 0 && len(m.Include) == 0 {
+		return nil, fmt.Errorf("matrix has no values")
+	}
+	return m, nil
+}
+
+// matrixEntries reads an exclude or include list of tables.
+func matrixEntries(val interface{}) ([]map[string]string, error) {
+	var tables []map[string]interface{}
+	switch v := val.(type) {
+	case []map[string]interface{}:
+		tables = v
+	case []interface{}:
+		for _, item := range v {
+			t, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("entries must be tables, e.g. { features = \"full\" }")
+			}
+			tables = append(tables, t)
+		}
+	default:
+		return nil, fmt.Errorf("must be a list of tables")
+	}
+	var entries []map[string]string
+	for _, t := range tables {
+		entry := make(map[string]string, len(t))
+		for k, item := range t {
+			s, err := matrixValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			entry[k] = s
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func matrixValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// combinations expands the matrix in a stable order: axes sorted by name,
+// the first varying slowest, and values in the order they were written.
+func (m *stageMatrix) combinations() []matrixCombo {
+	axes := make([]string, 0, len(m.Axes))
+	for k := range m.Axes {
+		axes = append(axes, k)
+	}
+	sort.Strings(axes)
+
+	var combos []matrixCombo
+	if len(axes) > 0 {
+		combos = []matrixCombo{{values: map[string]string{}, nameKeys: axes}}
+		for _, axis := range axes {
+			var next []matrixCombo
+			for _, c := range combos {
+				for _, v := range m.Axes[axis] {
+					values := make(map[string]string, len(c.values)+1)
+					for k, cv := range c.values {
+						values[k] = cv
+					}
+					values[axis] = v
+					next = append(next, matrixCombo{values: values, nameKeys: axes})
+				}
+			}
+			combos = next
+		}
+	}
+
+	kept := combos[:0]
+	for _, c := range combos {
+		excluded := false
+		for _, ex := range m.Exclude {
+			if matrixMatches(c.values, ex) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, c)
+		}
+	}
+	combos = kept
+
+	// An include entry whose axis values match existing combinations adds
+	// its other keys to them; otherwise it is a combination of its own.
+	for _, inc := range m.Include {
+		onAxes := make(map[string]string)
+		extended := false
+		for k, v := range inc {
+			if _, ok := m.Axes[k]; ok {
+				onAxes[k] = v
+			}
+		}
+		if len(onAxes) > 0 {
+			for _, c := range combos {
+				if matrixMatches(c.values, onAxes) {
+					for k, v := range inc {
+						c.values[k] = v
+					}
+					extended = true
+				}
+			}
+		}
+		if !extended {
+			values := make(map[string]string, len(inc))
+			keys := make([]string, 0, len(inc))
+			for k, v := range inc {
+				values[k] = v
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			combos = append(combos, matrixCombo{values: values, nameKeys: keys})
+		}
+	}
+
+	// Keys only some combinations have are empty in the others.
+	for _, c := range combos {
+		for _, other := range combos {
+			for k := range other.values {
+				if _, ok := c.values[k]; !ok {
+					c.values[k] = ""
+				}
+			}
+		}
+	}
+	return combos
+}
+
+// matrixMatches reports whether values has every key/value in want.
+func matrixMatches(values, want map[string]string) bool {
+	for k, v := range want {
+		if values[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// matrixInstanceName renders e.g. "test[features=serde,os=linux]".
+func matrixInstanceName(base string, c matrixCombo) string {
+	parts := make([]string, len(c.nameKeys))
+	for i, k := range c.nameKeys {
+		parts[i] = k + "=" + c.values[k]
+	}
+	return base + "[" + strings.Join(parts, ",") + "]"
+}
+
+// matrixExpand substitutes {matrix.<key>} placeholders in s.
+func matrixExpand(s string, values map[string]string) (string, error) {
+	var err error
+	out := matrixPlaceholder.ReplaceAllStringFunc(s, func(ph string) string {
+		key := matrixPlaceholder.FindStringSubmatch(ph)[1]
+		v, ok := values[key]
+		if !ok && err == nil {
+			err = fmt.Errorf("%s has no matrix value", ph)
+		}
+		return v
+	})
+	return out, err
+}
+
+// matrixExpandArgs substitutes placeholders in a command. An argument that
+// is only a placeholder and expands to "" is dropped, so an empty value can
+// mean "no flag".
+func matrixExpandArgs(args []string, values map[string]string) ([]string, error) {
+	if args == nil {
+		return nil, nil
+	}
+	out := make([]string, 0, len(args))
+	for _, arg := range args {
+		expanded, err := matrixExpand(arg, values)
+		if err != nil {
+			return nil, err
+		}
+		if expanded == "" && matrixPlaceholder.MatchString(arg) && matrixPlaceholder.ReplaceAllString(arg, "") == "" {
+			continue
+		}
+		out = append(out, expanded)
+	}
+	return out, nil
+}
+
+// matrixInstance builds one instance of a matrix stage.
+func matrixInstance(base Stage, index int, c matrixCombo) (Stage, error) {
+	s := base
+	s.Matrix = nil
+	s.Name = matrixInstanceName(base.Name, c)
+	s.MatrixOf = base.Name
+	s.MatrixValues = c.values
+	s.matrixIndex = index
+	var err error
+	if s.Cmd, err = matrixExpandArgs(base.Cmd, c.values); err != nil {
+		return s, err
+	}
+	if s.FixCmd, err = matrixExpandArgs(base.FixCmd, c.values); err != nil {
+		return s, err
+	}
+	if s.Dir, err = matrixExpand(base.Dir, c.values); err != nil {
+		return s, err
+	}
+	if base.Env != nil {
+		s.Env = make(map[string]string, len(base.Env))
+		for k, v := range base.Env {
+			if s.Env[k], err = matrixExpand(v, c.values); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// expandStageMatrices replaces every stage that has a matrix with its
+// instances, and points depends_on entries naming such a stage at all of
+// its instances.
+func expandStageMatrices(stages map[string]Stage) error {
+	bases := make(map[string][]string)
+	names := make([]string, 0, len(stages))
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stage := stages[name]
+		if stage.Matrix == nil {
+			continue
+		}
+		combos := stage.Matrix.combinations()
+		if len(combos) == 0 {
+			return fmt.Errorf("stage %s: matrix has no combinations left after exclude", name)
+		}
+		delete(stages, name)
+		for i, c := range combos {
+			inst, err := matrixInstance(stage, i, c)
+			if err != nil {
+				return fmt.Errorf("stage %s: %w", inst.Name, err)
+			}
+			if _, exists := stages[inst.Name]; exists {
+				return fmt.Errorf("stage %s: matrix instance duplicates an existing stage", inst.Name)
+			}
+			stages[inst.Name] = inst
+			bases[name] = append(bases[name], inst.Name)
+		}
+	}
+	if len(bases) == 0 {
+		return nil
+	}
+	for name, stage := range stages {
+		var deps []string
+		for _, dep := range stage.DependsOn {
+			if instances, ok := bases[dep]; ok {
+				deps = append(deps, instances...)
+			} else {
+				deps = append(deps, dep)
+			}
+		}
+		stage.DependsOn = deps
+		stages[name] = stage
+	}
+	return nil
+}
+
+// parseMatrixSelector splits "test[features=full]" into the base name and
+// its key/value filter. A name without brackets has no filter.
+func parseMatrixSelector(name string) (string, map[string]string, bool) {
+	base, rest, found := strings.Cut(name, "[")
+	if !found {
+		return name, nil, true
+	}
+	inner, ok := strings.CutSuffix(rest, "]")
+	if !ok {
+		return "", nil, false
+	}
+	filter := make(map[string]string)
+	for _, pair := range strings.Split(inner, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return "", nil, false
+		}
+		filter[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return base, filter, true
+}
+
+// StagesNamed resolves a stage argument: an exact stage name, a matrix
+// stage's base name (all of its instances), or a selector such as
+// "test[features=full]" or "test[os=linux]" (the instances that match).
+func (c *Config) StagesNamed(name string) []string {
+	if _, ok := c.Stages[name]; ok {
+		return []string{name}
+	}
+	base, filter, ok := parseMatrixSelector(name)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, n := range c.GetAllStages() {
+		s := c.Stages[n]
+		if s.MatrixOf == base && matrixMatches(s.MatrixValues, filter) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
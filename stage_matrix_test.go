@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadMatrixConfig(t *testing.T, config string) *Config {
+	t.Helper()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(config), 0o644)
+	cfg, err := LoadConfig(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestStageMatrixExpansion(t *testing.T) {
+	cfg := loadMatrixConfig(t, `[stages.test]
+command = ["cargo", "test", "{matrix.flags}", "--features={matrix.features}"]
+env = { TOOLCHAIN = "{matrix.toolchain}" }
+matrix = { features = ["", "serde", "full"], toolchain = ["stable", "nightly"], exclude = [{ features = "full", toolchain = "nightly" }], include = [{ features = "full", flags = "--release" }] }
+enabled = true
+
+[stages.report]
+command = ["true"]
+depends_on = ["test"]
+enabled = true
+`)
+	var names []string
+	for _, name := range cfg.GetEnabledStages() {
+		names = append(names, name)
+	}
+	want := []string{
+		"test[features=,toolchain=stable]",
+		"test[features=,toolchain=nightly]",
+		"test[features=serde,toolchain=stable]",
+		"test[features=serde,toolchain=nightly]",
+		"test[features=full,toolchain=stable]",
+		"report",
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("stages =\n%s\nwant\n%s", strings.Join(names, "\n"), strings.Join(want, "\n"))
+	}
+	if _, ok := cfg.Stages["test"]; ok {
+		t.Error("the matrix stage itself should be replaced by its instances")
+	}
+
+	full := cfg.Stages["test[features=full,toolchain=stable]"]
+	if got := strings.Join(full.Cmd, " "); got != "cargo test --release --features=full" {
+		t.Errorf("full cmd = %q", got)
+	}
+	if full.MatrixOf != "test" || full.MatrixValues["flags"] != "--release" {
+		t.Errorf("full = %+v", full)
+	}
+	serde := cfg.Stages["test[features=serde,toolchain=nightly]"]
+	if got := strings.Join(serde.Cmd, " "); got != "cargo test --features=serde" {
+		t.Errorf("an empty placeholder-only arg should be dropped, cmd = %q", got)
+	}
+	if serde.Env["TOOLCHAIN"] != "nightly" {
+		t.Errorf("env = %v", serde.Env)
+	}
+	if deps := cfg.Stages["report"].DependsOn; len(deps) != 5 || deps[0] != "test[features=,toolchain=stable]" {
+		t.Errorf("depending on a matrix stage should wait for every instance: %v", deps)
+	}
+}
+
+func TestStageMatrixSelection(t *testing.T) {
+	cfg := loadMatrixConfig(t, `[stages.test]
+command = ["echo", "{matrix.python}", "{matrix.os}"]
+matrix = { python = ["3.11", "3.12"], os = ["linux", "macos"] }
+`)
+	count := func(name string) int { return len(cfg.SelectStages([]string{name})) }
+	if n := count("test"); n != 4 {
+		t.Errorf("test selects %d instances, want 4", n)
+	}
+	if n := count("test[os=linux,python=3.12]"); n != 1 {
+		t.Errorf("an exact instance selects %d", n)
+	}
+	if n := count("test[ python=3.12 ]"); n != 2 {
+		t.Errorf("a partial selector selects %d, want 2", n)
+	}
+	if got := cfg.StagesNamed("test[python=2.7]"); len(got) != 0 {
+		t.Errorf("an unmatched selector selects %v", got)
+	}
+	if got := cfg.StagesNamed("test[python"); len(got) != 0 {
+		t.Errorf("a malformed selector selects %v", got)
+	}
+}
+
+func TestStageMatrixCachesInstancesSeparately(t *testing.T) {
+	cfg := loadMatrixConfig(t, `[stages.greet]
+command = ["echo", "hi {matrix.who}"]
+matrix = { who = ["a", "b"] }
+`)
+	stages := cfg.SelectStages([]string{"greet"})
+	cache := map[string]string{}
+	runner := &ParallelRunner{Stages: stages, Concurrency: 2, Cwd: t.TempDir(), Cache: cache, SourceHash: "h"}
+	for _, r := range runner.Run() {
+		if r.Status != "pass" {
+			t.Fatalf("%s = %s %v", r.Name, r.Status, r.Error)
+		}
+	}
+	if len(cache) != 2 {
+		t.Fatalf("cache = %v", cache)
+	}
+	if cacheHit(cache, Stage{Name: "greet[who=a]", Cmd: []string{"echo", "hi b"}}, "h") {
+		t.Error("an instance must not hit another instance's cache entry")
+	}
+	if r := runLocalStage(context.Background(), cfg.Stages["greet[who=b]"], t.TempDir(), nil); r.Output != "hi b\n" {
+		t.Errorf("output = %q", r.Output)
+	}
+}
+
+func TestStageMatrixErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown placeholder": `[stages.t]
+command = ["echo", "{matrix.nope}"]
+matrix = { a = ["1"] }
+`,
+		"empty axis": `[stages.t]
+command = ["true"]
+matrix = { a = [] }
+`,
+		"all excluded": `[stages.t]
+command = ["true"]
+matrix = { a = ["1"], exclude = [{ a = "1" }] }
+`,
+	}
+	for name, config := range tests {
+		root := t.TempDir()
+		os.WriteFile(filepath.Join(root, ".local-ci.toml"), []byte(config), 0o644)
+		if _, err := LoadConfig(root, false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// SyncConfig controls how the workspace is pushed to remote hosts
+// (`[sync]` in .local-ci.toml or .local-ci-remote.toml).
+type SyncConfig struct {
+	Timeout int    `toml:"timeout"` // seconds; default 120
+	Method  string `toml:"method"`  // auto (rsync when both ends have it, else tar) | rsync | tar
+	Git     string `toml:"git"`     // none (default) | shallow | bundle
+}
+
+const (
+	defaultSyncTimeout = 2 * time.Minute
+	// syncManifestName is written to the remote workspace after each sync.
+	// Its mtime doubles as the reference for spotting files changed remotely.
+	syncManifestName = ".local-ci-manifest"
+	syncBundleName   = ".local-ci.bundle"
+)
+
+// TimeoutDuration is the configured sync timeout, or the default.
+func (c SyncConfig) TimeoutDuration() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Second
+	}
+	return defaultSyncTimeout
+}
+
+// Validate rejects unknown method and git values.
+func (c SyncConfig) Validate() error {
+	switch c.Method {
+	case "", "auto", "rsync", "tar":
+	default:
+		return fmt.Errorf("invalid [sync] method %q (want auto, rsync or tar)", c.Method)
+	}
+	switch c.Git {
+	case "", "none", "shallow", "bundle":
+	default:
+		return fmt.Errorf("invalid [sync] git %q (want none, shallow or bundle)", c.Git)
+	}
+	return nil
+}
+
+// SyncOptions configures RemoteExecutor.SyncWorkspace.
+type SyncOptions struct {
+	SkipDirs []string
+	Method   string
+	Git      string
+	Index    *HashIndex // shared digests, so syncing several hosts hashes once
+	Progress io.Writer  // progress and summary lines; nil for silent
+}
+
+// syncOptions builds SyncOptions from the loaded config, reporting progress
+// on the human output stream.
+func (c *Config) syncOptions(ix *HashIndex) SyncOptions {
+	progress, _ := humanStream()
+	return SyncOptions{
+		SkipDirs: c.Cache.SkipDirs,
+		Method:   c.Sync.Method,
+		Git:      c.Sync.Git,
+		Index:    ix,
+		Progress: progress,
+	}
+}
+
+type manifestEntry struct {
+	Sum  string      `json:"sum"`
+	Mode fs.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+}
+
+// syncManifest records what the last sync put on a host.
+type syncManifest struct {
+	Files map[string]manifestEntry `json:"files"` // slash-separated relative paths
+	Git   string                   `json:"git,omitempty"`
+}
+
+// syncIgnored reports root-level files that are local-ci bookkeeping and
+// never synced in either direction.
+func syncIgnored(rel string) bool {
+	switch rel {
+	case ".local-ci-cache", syncManifestName, syncBundleName:
+		return true
+	}
+	return false
+}
+
+// buildSyncManifest hashes every regular file and symlink under root,
+// skipping skipDirs (by name), .git and .local-ci.
+func buildSyncManifest(root string, skipDirs []string, ix *HashIndex) (*syncManifest, error) {
+	skip := skipDirSet(skipDirs)
+	skip[".git"] = true
+	skip[".local-ci"] = true
+	m := &syncManifest{Files: make(map[string]manifestEntry)}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && skip[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if syncIgnored(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return nil
+			}
+			m.Files[rel] = manifestEntry{Sum: "link:" + target, Mode: fs.ModeSymlink}
+		case info.Mode().IsRegular():
+			sum, err := ix.fileSum(p, d)
+			if err != nil {
+				return nil
+			}
+			m.Files[rel] = manifestEntry{Sum: hex.EncodeToString(sum[:]), Mode: info.Mode().Perm(), Size: info.Size()}
+		}
+		return nil
+	})
+	return m, err
+}
+
+// remoteSyncState is what the remote host reports before a sync.
+type remoteSyncState struct {
+	Manifest *syncManifest   // nil on a first sync or an unreadable manifest
+	Changed  map[string]bool // files modified on the host since the last sync
+	Files    map[string]bool // every file currently in the remote workspace
+	Rsync    bool
+}
+
+// remoteStateCommand creates workDir and lists its state: whether rsync is
+// installed, the last manifest, files newer than it, and all files.
+func remoteStateCommand(workDir string, skipDirs []string) string {
+	names := append([]string{".git", ".local-ci"}, skipDirs...)
+	var prune []string
+	for _, n := range dedupeStrings(names) {
+		prune = append(prune, "-name "+escapeShellArg(n))
+	}
+	find := fmt.Sprintf(`find . \( %s \) -prune -o \( -type f -o -type l \)`, strings.Join(prune, " -o "))
+	return fmt.Sprintf(
+		"mkdir -p %[1]s && cd %[1]s || exit 1; "+
+			"if command -v rsync >/dev/null 2>&1; then echo rsync=yes; else echo rsync=no; fi; "+
+			"echo '--- manifest'; cat %[2]s 2>/dev/null; echo; "+
+			"echo '--- changed'; if [ -f %[2]s ]; then %[3]s -newer %[2]s -print; fi; "+
+			"echo '--- files'; %[3]s -print",
+		escapeShellArg(workDir), syncManifestName, find,
+	)
+}
+
+func parseRemoteSyncState(out string) remoteSyncState {
+	state := remoteSyncState{Changed: map[string]bool{}, Files: map[string]bool{}}
+	var section string
+	var manifest strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch line {
+		case "rsync=yes":
+			state.Rsync = true
+			continue
+		case "--- manifest", "--- changed", "--- files":
+			section = line
+			continue
+		}
+		rel := strings.TrimPrefix(line, "./")
+		switch section {
+		case "--- manifest":
+			manifest.WriteString(line)
+		case "--- changed":
+			if rel != "" && !syncIgnored(rel) {
+				state.Changed[rel] = true
+			}
+		case "--- files":
+			if rel != "" && !syncIgnored(rel) {
+				state.Files[rel] = true
+			}
+		}
+	}
+	if manifest.Len() > 0 {
+		var m syncManifest
+		if json.Unmarshal([]byte(manifest.String()), &m) == nil && m.Files != nil {
+			state.Manifest = &m
+		}
+	}
+	return state
+}
+
+// syncPlan lists what a sync has to transfer and delete.
+type syncPlan struct {
+	Upload    []string
+	Delete    []string
+	Unchanged int
+	Bytes     int64
+}
+
+// planSync uploads files that are new or differ from the last manifest,
+// were modified on the host, or went missing there, and deletes remote
+// files that no longer exist locally.
+func planSync(local *syncManifest, remote remoteSyncState) syncPlan {
+	var plan syncPlan
+	for rel, entry := range local.Files {
+		var last manifestEntry
+		var known bool
+		if remote.Manifest != nil {
+			last, known = remote.Manifest.Files[rel]
+		}
+		if known && last == entry && remote.Files[rel] && !remote.Changed[rel] {
+			plan.Unchanged++
+			continue
+		}
+		plan.Upload = append(plan.Upload, rel)
+		plan.Bytes += entry.Size
+	}
+	for rel := range remote.Files {
+		if _, ok := local.Files[rel]; !ok {
+			plan.Delete = append(plan.Delete, rel)
+		}
+	}
+	sort.Strings(plan.Upload)
+	sort.Strings(plan.Delete)
+	return plan
+}
+
+// SyncWorkspace brings WorkDir on the remote host in line with localDir,
+// transferring only files whose content changed since the last sync (or
+// that were modified on the host), via rsync when both ends have it and
+// tar over SSH otherwise.
+func (re *RemoteExecutor) SyncWorkspace(ctx context.Context, localDir string, opts SyncOptions) error {
+	start := time.Now()
+	uploader, ok := re.sshClient().(remoteUploader)
+	if !ok {
+		return fmt.Errorf("SSH client cannot stream uploads")
+	}
+	if opts.Index == nil {
+		opts.Index = NewHashIndex()
+	}
+
+	stateOut, err := re.sshExecWithOutput(ctx, remoteStateCommand(re.WorkDir, opts.SkipDirs))
+	if err != nil {
+		return fmt.Errorf("failed to read remote workspace state: %w", err)
+	}
+	state := parseRemoteSyncState(stateOut)
+
+	local, err := buildSyncManifest(localDir, opts.SkipDirs, opts.Index)
+	if err != nil {
+		return fmt.Errorf("failed to scan workspace: %w", err)
+	}
+	plan := planSync(local, state)
+
+	method := opts.Method
+	if method == "" || method == "auto" {
+		method = "tar"
+		if _, err := exec.LookPath("rsync"); err == nil && state.Rsync {
+			method = "rsync"
+		}
+	}
+	if re.Verbose {
+		printf("Sync plan for %s: %d to upload (%s), %d to delete, %d unchanged, via %s\n",
+			re.Host, len(plan.Upload), formatSize(plan.Bytes), len(plan.Delete), plan.Unchanged, method)
+	}
+
+	if len(plan.Upload) > 0 {
+		progress := newSyncProgress(opts.Progress, re.Host, len(plan.Upload), plan.Bytes)
+		switch method {
+		case "rsync":
+			err = re.rsyncFiles(ctx, localDir, plan.Upload, progress)
+		case "tar":
+			err = re.tarFiles(ctx, uploader, localDir, plan.Upload, local, progress)
+		default:
+			err = fmt.Errorf("unknown sync method %q", method)
+		}
+		progress.finish()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Delete) > 0 {
+		list := strings.Join(plan.Delete, "\x00") + "\x00"
+		cmd := fmt.Sprintf("cd %s && xargs -0 rm -f --", escapeShellArg(re.WorkDir))
+		var stderr bytes.Buffer
+		if err := uploader.runWithInput(ctx, cmd, strings.NewReader(list), io.Discard, &stderr); err != nil {
+			return fmt.Errorf("failed to delete stale remote files: %w (stderr: %s)", err, stderr.String())
+		}
+	}
+
+	if opts.Git != "" && opts.Git != "none" {
+		local.Git, err = re.syncGit(ctx, uploader, localDir, opts.Git, state.Manifest)
+		if err != nil {
+			return fmt.Errorf("git sync failed: %w", err)
+		}
+	}
+
+	data, _ := json.Marshal(local)
+	cmd := fmt.Sprintf("cat > %s", escapeShellArg(path.Join(re.WorkDir, syncManifestName)))
+	var stderr bytes.Buffer
+	if err := uploader.runWithInput(ctx, cmd, bytes.NewReader(data), io.Discard, &stderr); err != nil {
+		return fmt.Errorf("failed to write sync manifest: %w (stderr: %s)", err, stderr.String())
+	}
+
+	if opts.Progress != nil {
+		fmt.Fprintf(opts.Progress, "  %s: %d file(s) sent (%s), %d deleted, %d unchanged via %s in %dms\n",
+			re.Host, len(plan.Upload), formatSize(plan.Bytes), len(plan.Delete), plan.Unchanged, method, time.Since(start).Milliseconds())
+	}
+	return nil
+}
+
+// rsyncFiles transfers exactly files with rsync over the system ssh. With
+// progress, rsync names each file as it finishes (`--out-format`), which
+// drives the same progress line as the tar path.
+func (re *RemoteExecutor) rsyncFiles(ctx context.Context, localDir string, files []string, progress *syncProgress) error {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return fmt.Errorf("rsync is not installed locally; set [sync] method = \"tar\"")
+	}
+	src := strings.TrimSuffix(localDir, "/") + "/"
+	// rsync reads "host:port:dir" as a path on host, so the port goes to ssh.
+	host, port := systemSSHTarget(re.Host)
+	if user, h, ok := strings.Cut(host, "@"); ok && strings.Contains(h, ":") {
+		host = user + "@[" + h + "]"
+	} else if !ok && strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	dest := host + ":" + re.WorkDir
+	// Like tar -m, leave mtimes to the host (no -t) so changed files look new.
+	args := []string{"-rlpz", "--from0", "--files-from=-"}
+	if port != "" {
+		args = append(args, "-e", "ssh -p "+port)
+	}
+	if progress != nil {
+		args = append(args, "--out-format=%l %n")
+	}
+	cmd := exec.CommandContext(ctx, "rsync", append(args, src, dest)...)
+	cmd.Stdin = strings.NewReader(strings.Join(files, "\x00") + "\x00")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if progress != nil {
+		lines := &lineWriter{emit: progress.rsyncLine}
+		cmd.Stdout = lines
+		defer lines.Flush()
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsync failed: %w (stderr: %s)", err, stderr.String())
+	}
+	return nil
+}
+
+// tarFiles streams files as a tar archive into `tar -x` on the host.
+func (re *RemoteExecutor) tarFiles(ctx context.Context, uploader remoteUploader, localDir string, files []string, local *syncManifest, progress *syncProgress) error {
+	// -m stamps extracted files with the host's current time, so build tools
+	// see them as changed and they predate the manifest written afterwards.
+	cmd := fmt.Sprintf("cd %s && tar -xmf -", escapeShellArg(re.WorkDir))
+	return streamTar(ctx, uploader, cmd, func(tw *tar.Writer) error {
+		for _, rel := range files {
+			if err := addTarFile(tw, filepath.Join(localDir, filepath.FromSlash(rel)), rel, progress); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// streamTar runs cmd on the host with a tar archive produced by fill as stdin.
+func streamTar(ctx context.Context, uploader remoteUploader, cmd string, fill func(tw *tar.Writer) error) error {
+	pr, pw := io.Pipe()
+	var writeErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tw := tar.NewWriter(pw)
+		writeErr = fill(tw)
+		if writeErr == nil {
+			writeErr = tw.Close()
+		}
+		pw.CloseWithError(writeErr)
+	}()
+
+	var stderr bytes.Buffer
+	err := uploader.runWithInput(ctx, cmd, pr, io.Discard, &stderr)
+	pr.CloseWithError(io.ErrClosedPipe)
+	wg.Wait()
+	if writeErr != nil && writeErr != io.ErrClosedPipe {
+		return fmt.Errorf("failed to archive workspace: %w", writeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("remote tar failed: %w (stderr: %s)", err, stderr.String())
+	}
+	return nil
+}
+
+// addTarFile writes one file or symlink to tw under name. Files that
+// vanished since the scan are skipped.
+func addTarFile(tw *tar.Writer, file, name string, progress *syncProgress) error {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return nil
+	}
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(file); err != nil {
+			return nil
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.ModTime = info.ModTime().Truncate(time.Second)
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var dst io.Writer = tw
+		if progress != nil {
+			dst = io.MultiWriter(tw, progress)
+		}
+		// CopyN keeps the entry at its header size if the file grows.
+		if _, err := io.CopyN(dst, f, hdr.Size); err != nil {
+			return err
+		}
+	}
+	progress.fileDone()
+	return nil
+}
+
+// syncGit gives the remote workspace git metadata for the local HEAD: a
+// shallow clone's .git, or a bundle of HEAD's history. It is skipped when
+// the host already has the same HEAD in the same mode.
+func (re *RemoteExecutor) syncGit(ctx context.Context, uploader remoteUploader, localDir, mode string, last *syncManifest) (string, error) {
+	git := func(args ...string) (string, error) {
+		out, err := exec.CommandContext(ctx, "git", append([]string{"-C", localDir}, args...)...).Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	head, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git checkout with commits", localDir)
+	}
+	key := mode + ":" + head
+	if last != nil && last.Git == key {
+		return key, nil
+	}
+	branch, _ := git("symbolic-ref", "-q", "--short", "HEAD")
+	origin, _ := git("remote", "get-url", "origin")
+
+	tmp, err := os.MkdirTemp("", "local-ci-git-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	workDir := escapeShellArg(re.WorkDir)
+	// Keep the sync manifest out of `git status` on the host.
+	exclude := fmt.Sprintf("mkdir -p .git/info && echo %s >> .git/info/exclude", syncManifestName)
+	switch mode {
+	case "shallow":
+		clone := filepath.Join(tmp, "repo")
+		args := []string{"clone", "-q", "--depth", "1", "--no-checkout"}
+		if branch != "" {
+			args = append(args, "--branch", branch)
+		}
+		args = append(args, "file://"+localDir, clone)
+		if out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("shallow clone failed: %v: %s", err, out)
+		}
+		if origin != "" {
+			exec.CommandContext(ctx, "git", "-C", clone, "remote", "set-url", "origin", origin).Run()
+		}
+		gitDir := filepath.Join(clone, ".git")
+		cmd := fmt.Sprintf("cd %s && rm -rf .git && tar -xf - && %s && (git reset -q 2>/dev/null || true)", workDir, exclude)
+		return key, streamTar(ctx, uploader, cmd, func(tw *tar.Writer) error {
+			return filepath.WalkDir(gitDir, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, _ := filepath.Rel(clone, p)
+				return addTarFile(tw, p, filepath.ToSlash(rel), nil)
+			})
+		})
+
+	case "bundle":
+		bundle := filepath.Join(tmp, "repo.bundle")
+		if _, err := git("bundle", "create", bundle, "HEAD"); err != nil {
+			return "", fmt.Errorf("git bundle failed: %w", err)
+		}
+		f, err := os.Open(bundle)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		var stderr bytes.Buffer
+		if err := uploader.runWithInput(ctx, fmt.Sprintf("cat > %s/%s", workDir, syncBundleName), f, io.Discard, &stderr); err != nil {
+			return "", fmt.Errorf("failed to upload bundle: %w (stderr: %s)", err, stderr.String())
+		}
+		setHead := "git update-ref --no-deref HEAD FETCH_HEAD"
+		if branch != "" {
+			b := escapeShellArg(branch)
+			setHead = fmt.Sprintf("git branch -f %s FETCH_HEAD && git symbolic-ref HEAD refs/heads/%s", b, b)
+		}
+		script := fmt.Sprintf("cd %s && rm -rf .git && git init -q && %s && git fetch -q %s HEAD && %s && git reset -q", workDir, exclude, syncBundleName, setHead)
+		if origin != "" {
+			script += " && git remote add origin " + escapeShellArg(origin)
+		}
+		script += "; status=$?; rm -f " + syncBundleName + "; exit $status"
+		stderr.Reset()
+		if err := uploader.runWithInput(ctx, script, nil, io.Discard, &stderr); err != nil {
+			return "", fmt.Errorf("failed to unpack bundle: %w (stderr: %s)", err, stderr.String())
+		}
+		return key, nil
+	}
+	return "", fmt.Errorf("unknown git sync mode %q", mode)
+}
+
+// syncProgress renders a single updating progress line on a terminal.
+type syncProgress struct {
+	w          io.Writer
+	host       string
+	files      int
+	totalFiles int
+	bytes      int64
+	totalBytes int64
+	last       time.Time
+	mu         sync.Mutex
+}
+
+// newSyncProgress returns nil (a no-op) unless w is a terminal.
+func newSyncProgress(w io.Writer, host string, files int, bytes int64) *syncProgress {
+	f, ok := w.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	return &syncProgress{w: w, host: host, totalFiles: files, totalBytes: bytes}
+}
+
+func (p *syncProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.bytes += int64(len(b))
+	p.mu.Unlock()
+	p.render(false)
+	return len(b), nil
+}
+
+func (p *syncProgress) fileDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.files++
+	p.mu.Unlock()
+	p.render(false)
+}
+
+// rsyncLine counts a "<size> <name>" line from rsync's --out-format.
+// Directories rsync creates along the way are listed too; they end in "/".
+func (p *syncProgress) rsyncLine(line string) {
+	size, name, ok := strings.Cut(line, " ")
+	n, err := strconv.ParseInt(size, 10, 64)
+	if !ok || err != nil || strings.HasSuffix(name, "/") {
+		return
+	}
+	p.mu.Lock()
+	p.bytes += n
+	p.files++
+	p.mu.Unlock()
+	p.render(false)
+}
+
+func (p *syncProgress) render(force bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !force && time.Since(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = time.Now()
+	fmt.Fprintf(p.w, "\r\033[K  %s: %d/%d files, %s/%s", p.host, p.files, p.totalFiles, formatSize(p.bytes), formatSize(p.totalBytes))
+}
+
+func (p *syncProgress) finish() {
+	if p == nil {
+		return
+	}
+	p.render(true)
+	fmt.Fprint(p.w, "\r\033[K")
+}
+
+// formatSize renders a byte count as B, KB or MB.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func syncTestExecutor(t *testing.T) (*RemoteExecutor, string) {
+	t.Helper()
+	home, pub := sshTestHome(t)
+	server := startTestSSHServer(t, pub)
+	trustHost(t, home, server)
+	remoteDir := filepath.Join(t.TempDir(), "remote")
+	re := NewRemoteExecutor("ci@"+server.addr, "onion", remoteDir, 5*time.Second, false)
+	t.Cleanup(func() { re.Close() })
+	return re, remoteDir
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func syncAndReport(t *testing.T, re *RemoteExecutor, local string, opts SyncOptions) string {
+	t.Helper()
+	var progress strings.Builder
+	opts.Progress = &progress
+	if opts.Method == "" {
+		opts.Method = "tar"
+	}
+	if err := re.SyncWorkspace(context.Background(), local, opts); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	return progress.String()
+}
+
+func readRemote(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestSyncWorkspaceIncremental(t *testing.T) {
+	re, remote := syncTestExecutor(t)
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{
+		"src/main.rs":     "fn main() {}",
+		"src/lib.rs":      "pub fn lib() {}",
+		"Cargo.toml":      "[package]",
+		"README.md":       "docs",
+		"target/debug/x":  "build output",
+		".local-ci-cache": "fmt:abc",
+	})
+	os.Symlink("src/main.rs", filepath.Join(local, "main-link.rs"))
+	opts := SyncOptions{SkipDirs: []string{"target"}}
+
+	out := syncAndReport(t, re, local, opts)
+	if !strings.Contains(out, "5 file(s) sent") {
+		t.Errorf("first sync should send everything: %q", out)
+	}
+	if readRemote(t, remote, "src/lib.rs") != "pub fn lib() {}" || readRemote(t, remote, "target/debug/x") != "<missing>" || readRemote(t, remote, ".local-ci-cache") != "<missing>" {
+		t.Fatal("unexpected remote tree after first sync")
+	}
+	if target, err := os.Readlink(filepath.Join(remote, "main-link.rs")); err != nil || target != "src/main.rs" {
+		t.Errorf("symlink not preserved: %q, %v", target, err)
+	}
+
+	if out := syncAndReport(t, re, local, opts); !strings.Contains(out, "0 file(s) sent (0 B), 0 deleted, 5 unchanged") {
+		t.Errorf("no-op sync should send nothing: %q", out)
+	}
+
+	// Local edit + delete, plus drift on the host: an edited file and a stray one.
+	writeTree(t, local, map[string]string{"src/main.rs": "fn main() { run() }"})
+	os.Remove(filepath.Join(local, "README.md"))
+	writeTree(t, remote, map[string]string{"Cargo.toml": "[tampered]", "stray.txt": "x", "target/debug/y": "kept"})
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(remote, "Cargo.toml"), future, future)
+
+	out = syncAndReport(t, re, local, opts)
+	if !strings.Contains(out, "2 file(s) sent") || !strings.Contains(out, "2 deleted") {
+		t.Errorf("expected 2 sent (edit + remote drift) and 2 deleted: %q", out)
+	}
+	if readRemote(t, remote, "src/main.rs") != "fn main() { run() }" || readRemote(t, remote, "Cargo.toml") != "[package]" {
+		t.Error("changed files were not re-synced")
+	}
+	if readRemote(t, remote, "README.md") != "<missing>" || readRemote(t, remote, "stray.txt") != "<missing>" {
+		t.Error("deleted and stray files should be removed")
+	}
+	if readRemote(t, remote, "target/debug/y") != "kept" {
+		t.Error("skip dirs on the host must be left alone")
+	}
+}
+
+func TestSyncWorkspaceGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	for _, mode := range []string{"bundle", "shallow"} {
+		t.Run(mode, func(t *testing.T) {
+			re, remote := syncTestExecutor(t)
+			local := t.TempDir()
+			writeTree(t, local, map[string]string{"a.txt": "one"})
+			git := func(dir string, args ...string) string {
+				cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+				cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@t", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@t")
+				out, err := cmd.CombinedOutput()
+				if err != nil {
+					t.Fatalf("git %v: %v\n%s", args, err, out)
+				}
+				return strings.TrimSpace(string(out))
+			}
+			git(local, "init", "-q", "-b", "work")
+			git(local, "add", ".")
+			git(local, "commit", "-q", "-m", "first")
+			writeTree(t, local, map[string]string{"a.txt": "two"})
+			git(local, "commit", "-q", "-am", "second")
+
+			syncAndReport(t, re, local, SyncOptions{Git: mode})
+			if got, want := git(remote, "rev-parse", "HEAD"), git(local, "rev-parse", "HEAD"); got != want {
+				t.Fatalf("remote HEAD %s, want %s", got, want)
+			}
+			if branch := git(remote, "symbolic-ref", "--short", "HEAD"); branch != "work" {
+				t.Errorf("remote branch = %q", branch)
+			}
+			if status := git(remote, "status", "--porcelain"); status != "" {
+				t.Errorf("remote checkout should be clean, got:\n%s", status)
+			}
+			if mode == "shallow" && git(remote, "rev-list", "--count", "HEAD") != "1" {
+				t.Error("shallow sync should carry a single commit")
+			}
+		})
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	local := &syncManifest{Files: map[string]manifestEntry{
+		"same":    {Sum: "1", Size: 1},
+		"edited":  {Sum: "2", Size: 2},
+		"new":     {Sum: "3", Size: 3},
+		"drifted": {Sum: "4", Size: 4},
+		"gone":    {Sum: "5", Size: 5},
+	}}
+	remote := parseRemoteSyncState(strings.Join([]string{
+		"rsync=yes",
+		"--- manifest",
+		`{"files":{"same":{"sum":"1","mode":0,"size":1},"edited":{"sum":"x","mode":0,"size":2},"drifted":{"sum":"4","mode":0,"size":4},"gone":{"sum":"5","mode":0,"size":5},"old":{"sum":"6","mode":0,"size":6}}}`,
+		"",
+		"--- changed",
+		"./drifted",
+		"--- files",
+		"./same", "./edited", "./drifted", "./old", "./.local-ci-manifest",
+	}, "\n"))
+	if !remote.Rsync || remote.Manifest == nil {
+		t.Fatalf("unexpected remote state: %+v", remote)
+	}
+	plan := planSync(local, remote)
+	if strings.Join(plan.Upload, ",") != "drifted,edited,gone,new" || strings.Join(plan.Delete, ",") != "old" || plan.Unchanged != 1 || plan.Bytes != 14 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestRsyncProgress(t *testing.T) {
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	os.WriteFile(filepath.Join(bin, "rsync"), []byte(`#!/bin/sh
+echo "$@" > `+args+`
+cat > /dev/null
+printf '0 src/\n12 src/main.go\n30 README.md\n'
+`), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var out strings.Builder
+	progress := &syncProgress{w: &out, host: "sparky", totalFiles: 2, totalBytes: 42}
+	re := NewRemoteExecutor("ci@sparky", "onion", "/work", time.Second, false)
+	if err := re.rsyncFiles(context.Background(), t.TempDir(), []string{"src/main.go", "README.md"}, progress); err != nil {
+		t.Fatal(err)
+	}
+	progress.finish()
+	if data, _ := os.ReadFile(args); !strings.Contains(string(data), "--out-format=%l %n") {
+		t.Errorf("rsync args = %s", data)
+	}
+	if !strings.Contains(out.String(), "sparky: 2/2 files, 42 B/42 B") {
+		t.Errorf("progress = %q", out.String())
+	}
+}
+
+func TestRsyncHostPort(t *testing.T) {
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	os.WriteFile(filepath.Join(bin, "rsync"), []byte(`#!/bin/sh
+printf '%s\n' "$@" > `+args+`
+cat > /dev/null
+`), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for host, want := range map[string]struct{ dest, ssh string }{
+		"ci@10.0.0.5:2222": {"ci@10.0.0.5:/work", "ssh -p 2222"},
+		"ci@[fd00::5]:22":  {"ci@[fd00::5]:/work", "ssh -p 22"},
+		"sparky":           {"sparky:/work", ""},
+	} {
+		re := NewRemoteExecutor(host, "onion", "/work", time.Second, false)
+		if err := re.rsyncFiles(context.Background(), t.TempDir(), []string{"README.md"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(args)
+		got := strings.Split(strings.TrimSpace(string(data)), "\n")
+		ssh := ""
+		for i, arg := range got[:len(got)-1] {
+			if arg == "-e" {
+				ssh = got[i+1]
+			}
+		}
+		if got[len(got)-1] != want.dest || ssh != want.ssh {
+			t.Errorf("%s: rsync args = %q, want dest %q and -e %q", host, got, want.dest, want.ssh)
+		}
+	}
+}
@@ -14,6 +14,15 @@ type Tool struct {
 	InstallCmd string   // How to install
 	ToolType   string   // "cargo", "system", "binary"
 	Optional   bool
+	NixPackage string // nixpkgs attribute, when it isn't Command
+}
+
+// nixPackage is the nixpkgs attribute that provides the tool.
+func (t Tool) nixPackage() string {
+	if t.NixPackage != "" {
+		return t.NixPackage
+	}
+	return t.Command
 }
 
 var cargoTools = []Tool{
@@ -24,6 +33,7 @@ var cargoTools = []Tool{
 		InstallCmd: "cargo install cargo-nextest",
 		ToolType:   "cargo",
 		Optional:   true,
+		NixPackage: "cargo-nextest",
 	},
 	{
 		Name:       "cargo-deny",
@@ -32,6 +42,7 @@ var cargoTools = []Tool{
 		InstallCmd: "cargo install cargo-deny",
 		ToolType:   "cargo",
 		Optional:   true,
+		NixPackage: "cargo-deny",
 	},
 	{
 		Name:       "cargo-audit",
@@ -40,6 +51,7 @@ var cargoTools = []Tool{
 		InstallCmd: "cargo install cargo-audit",
 		ToolType:   "cargo",
 		Optional:   true,
+		NixPackage: "cargo-audit",
 	},
 	{
 		Name:       "cargo-machete",
@@ -48,6 +60,7 @@ var cargoTools = []Tool{
 		InstallCmd: "cargo install cargo-machete",
 		ToolType:   "cargo",
 		Optional:   true,
+		NixPackage: "cargo-machete",
 	},
 	{
 		Name:       "taplo",
@@ -78,6 +91,7 @@ var systemTools = []Tool{
 		InstallCmd: "brew install protobuf  # macOS\nsudo apt install protobuf-compiler  # Ubuntu",
 		ToolType:   "system",
 		Optional:   true,
+		NixPackage: "protobuf",
 	},
 	{
 		Name:       "clang",
@@ -187,9 +201,9 @@ func GetMissingToolsWithHints(kind ProjectKind) map[string]string {
 		if tool.Optional && !CheckToolInstalled(&tool).Found {
 			installCmd := tool.InstallCmd
 			if platform.IsNixOS() {
-				installCmd = fmt.Sprintf("nix profile install nixpkgs#%s", tool.Command)
+				installCmd = fmt.Sprintf("nix profile install nixpkgs#%s", tool.nixPackage())
 				if tool.ToolType == "cargo" {
-					installCmd = fmt.Sprintf("nix profile install nixpkgs#%s\n# Or add to your flake.nix devShell", tool.Command)
+					installCmd += "\n# Or add to your flake.nix devShell"
 				}
 			}
 			hints[tool.Name] = installCmd
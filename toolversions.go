@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// versionTools are tools whose versions stage results depend on but that
+// local-ci never installs, so they aren't in the tables in toolcheck.go.
+var versionTools = []Tool{
+	{Name: "rustc", Command: "rustc", CheckArgs: []string{"--version"}},
+	{Name: "cargo", Command: "cargo", CheckArgs: []string{"--version"}},
+	{Name: "clippy", Command: "cargo", CheckArgs: []string{"clippy", "--version"}},
+	{Name: "rustfmt", Command: "rustfmt", CheckArgs: []string{"--version"}},
+	{Name: "node", Command: "node", CheckArgs: []string{"--version"}},
+	{Name: "python", Command: "python3", CheckArgs: []string{"--version"}},
+	{Name: "ruff", Command: "ruff", CheckArgs: []string{"--version"}},
+	{Name: "go", Command: "go", CheckArgs: []string{"version"}},
+	{Name: "golangci-lint", Command: "golangci-lint", CheckArgs: []string{"--version"}},
+}
+
+// programTools maps the programs a stage runs to the tools whose versions
+// its result depends on, where they differ from the program itself.
+var programTools = map[string][]string{
+	"cargo":        {"cargo", "rustc"},
+	"cargo-clippy": {"clippy", "rustc"},
+	"cargo-fmt":    {"rustfmt"},
+	"bunx":         {"bun"},
+	"npm":          {"node"},
+	"npx":          {"node"},
+	"python3":      {"python"},
+}
+
+// asdfTools maps .tool-versions plugin names to tool names.
+var asdfTools = map[string]string{
+	"nodejs": "node",
+	"rust":   "rustc",
+	"golang": "go",
+}
+
+// versionNumber is the numeric part of a version, e.g. "1.80.0" in
+// "1.80.0-nightly".
+var versionNumber = regexp.MustCompile(`^\d+(\.\d+)*`)
+
+// versionProbes caches tool name -> version ("" when it can't be probed)
+// for one run. Each run starts with an empty one and probes again, so a
+// long-lived daemon sees a tool upgraded between runs.
+type versionProbes map[string]string
+
+// versionTool finds the tool named name: versionTools first, then the
+// tools local-ci knows how to install.
+func versionTool(name string) *Tool {
+	for _, list := range [][]Tool{versionTools, cargoTools, systemTools, bunTools} {
+		for _, tool := range list {
+			if tool.Name == name {
+				return &tool
+			}
+		}
+	}
+	return nil
+}
+
+// toolVersion probes name's version with its CheckArgs, asking for
+// --version instead where those only print help. Unknown tools are only
+// probed (with --version) when guess is set, since a stage's program may
+// be anything. Empty when the tool is missing or prints no version.
+func (p versionProbes) toolVersion(name string, guess bool) string {
+	if v, ok := p[name]; ok {
+		return v
+	}
+	tool := versionTool(name)
+	if tool == nil && guess {
+		tool = &Tool{Name: name, Command: name, CheckArgs: []string{"--version"}}
+	}
+	version := ""
+	if tool != nil {
+		args := append([]string(nil), tool.CheckArgs...)
+		if n := len(args); n > 0 && (args[n-1] == "help" || args[n-1] == "--help") {
+			args[n-1] = "--version"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		out, err := exec.CommandContext(ctx, tool.Command, args...).Output()
+		cancel()
+		if err == nil {
+			version = versionPattern.FindString(string(out))
+		}
+	}
+	if tool != nil {
+		p[name] = version
+	}
+	return version
+}
+
+// stageTools returns the tools a stage's result depends on, sorted.
+func stageTools(s Stage) []string {
+	var tools []string
+	for _, program := range stagePrograms(s) {
+		if strings.ContainsRune(program, '/') {
+			continue
+		}
+		if mapped, ok := programTools[program]; ok {
+			tools = append(tools, mapped...)
+		} else {
+			tools = append(tools, program)
+		}
+	}
+	tools = dedupeStrings(tools)
+	sort.Strings(tools)
+	return tools
+}
+
+// resolveStageToolVersions records the versions of each stage's known
+// tools, which become part of its cache key: upgrading clippy re-runs
+// clippy. Stages in a container or Nix shell get their tools from there,
+// which the image ID or devShell hash already covers.
+func resolveStageToolVersions(stages []Stage, probes versionProbes) {
+	for i := range stages {
+		if stages[i].Container != "" || stages[i].NixShell != "" {
+			continue
+		}
+		var parts []string
+		for _, name := range stageTools(stages[i]) {
+			if v := probes.toolVersion(name, false); v != "" {
+				parts = append(parts, name+"@"+v)
+			}
+		}
+		stages[i].ToolVersions = strings.Join(parts, ",")
+	}
+}
+
+// toolPin is a version constraint on a tool, from [tools] or a version
+// file such as rust-toolchain.toml.
+type toolPin struct {
+	Tool       string
+	Constraint string // "1.80", ">=1.1", ">=1.1, <2"
+	Source     string // "[tools]" or the file it came from
+}
+
+// enforced reports whether a mismatch fails the run. Version files belong
+// to rustup, nvm and asdf, which usually switch versions themselves, so
+// mismatches against them only warn.
+func (p toolPin) enforced() bool {
+	return p.Source == "[tools]"
+}
+
+// loadToolPins reads the version files in root, then [tools], which wins
+// where both pin the same tool.
+func loadToolPins(root string, tools map[string]string) ([]toolPin, error) {
+	pins := make(map[string]toolPin)
+	add := func(tool, constraint, source string) {
+		pins[tool] = toolPin{Tool: tool, Constraint: constraint, Source: source}
+	}
+
+	if channel, source := rustToolchainChannel(root); channel != "" {
+		add("rustc", channel, source)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, ".nvmrc")); err == nil {
+		if v := strings.TrimPrefix(strings.TrimSpace(string(data)), "v"); isVersion(v) {
+			add("node", v, ".nvmrc")
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(root, ".tool-versions")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			fields := strings.Fields(line)
+			// Only the first of several versions is the active one.
+			if len(fields) < 2 || !isVersion(fields[1]) {
+				continue
+			}
+			name := fields[0]
+			if mapped, ok := asdfTools[name]; ok {
+				name = mapped
+			}
+			add(name, fields[1], ".tool-versions")
+		}
+	}
+
+	for tool, constraint := range tools {
+		if _, err := parseVersionConstraint(constraint); err != nil {
+			return nil, fmt.Errorf("[tools] %s: %w", tool, err)
+		}
+		add(tool, constraint, "[tools]")
+	}
+
+	out := make([]toolPin, 0, len(pins))
+	for _, pin := range pins {
+		out = append(out, pin)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tool < out[j].Tool })
+	return out, nil
+}
+
+// rustToolchainChannel returns the numeric channel from rust-toolchain.toml
+// or the legacy rust-toolchain file, and which file it came from. Named
+// channels such as stable or nightly-2024-05-01 pin nothing checkable.
+func rustToolchainChannel(root string) (channel, source string) {
+	source = "rust-toolchain.toml"
+	if data, err := os.ReadFile(filepath.Join(root, source)); err == nil {
+		var file struct {
+			Toolchain struct {
+				Channel string `toml:"channel"`
+			} `toml:"toolchain"`
+		}
+		if toml.Unmarshal(data, &file) == nil {
+			channel = file.Toolchain.Channel
+		}
+	} else if data, err := os.ReadFile(filepath.Join(root, "rust-toolchain")); err == nil {
+		channel, source = strings.TrimSpace(string(data)), "rust-toolchain"
+	}
+	if !isVersion(channel) {
+		return "", ""
+	}
+	return channel, source
+}
+
+// toolMismatch is a pinned tool whose installed version doesn't satisfy
+// its pin. Found is empty when the version couldn't be probed.
+type toolMismatch struct {
+	Pin    toolPin
+	Found  string
+	Stages []string
+}
+
+// checkToolPins probes the pinned tools that stages use and returns those
+// whose versions don't satisfy their pins. Stages in a container or Nix
+// shell, and skipped stages, are left out like in preflightStages.
+func checkToolPins(pins []toolPin, stages []Stage, probes versionProbes) []toolMismatch {
+	users := make(map[string][]string)
+	for _, s := range stages {
+		if s.Container != "" || s.NixShell != "" || s.SkipReason != "" {
+			continue
+		}
+		for _, tool := range stageTools(s) {
+			users[tool] = append(users[tool], s.Name)
+		}
+	}
+	var mismatches []toolMismatch
+	for _, pin := range pins {
+		if len(users[pin.Tool]) == 0 {
+			continue
+		}
+		found := probes.toolVersion(pin.Tool, true)
+		// Constraints were validated when the pins were loaded.
+		c, _ := parseVersionConstraint(pin.Constraint)
+		if found == "" || !c.matches(found) {
+			mismatches = append(mismatches, toolMismatch{Pin: pin, Found: found, Stages: users[pin.Tool]})
+		}
+	}
+	return mismatches
+}
+
+// formatToolMismatches renders the version check table.
+func formatToolMismatches(mismatches []toolMismatch) string {
+	rows := [][]string{{"TOOL", "WANT", "FOUND", "FROM", "NEEDED BY"}}
+	for _, m := range mismatches {
+		found := m.Found
+		if found == "" {
+			found = "(unknown)"
+		}
+		rows = append(rows, []string{m.Pin.Tool, m.Pin.Constraint, found, m.Pin.Source, strings.Join(m.Stages, ", ")})
+	}
+	return formatTable(rows)
+}
+
+// versionConstraint is a list of clauses that must all hold.
+type versionConstraint []versionClause
+
+type versionClause struct {
+	op      string // "=", ">=", ">", "<=" or "<"
+	version []int
+}
+
+// parseVersionConstraint parses comma-separated clauses such as "1.80",
+// "=1.80.1", ">=1.1" or ">=1.1, <2". A bare or "=" version matches every
+// release it prefixes: "1.80" matches 1.80.0 and 1.80.1.
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, prefix := range []string{">=", "<=", "==", ">", "<", "="} {
+			if rest, ok := strings.CutPrefix(part, prefix); ok {
+				op, part = prefix, strings.TrimSpace(rest)
+				if op == "==" {
+					op = "="
+				}
+				break
+			}
+		}
+		version, ok := parseVersion(strings.TrimPrefix(part, "v"))
+		if !ok {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		c = append(c, versionClause{op: op, version: version})
+	}
+	return c, nil
+}
+
+func (c versionConstraint) matches(version string) bool {
+	v, ok := parseVersion(versionNumber.FindString(version))
+	if !ok {
+		return false
+	}
+	for _, clause := range c {
+		cmp := compareVersions(v, clause.version)
+		var ok bool
+		switch clause.op {
+		case "=":
+			ok = len(v) >= len(clause.version) && compareVersions(v[:len(clause.version)], clause.version) == 0
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseVersion splits "1.80.1" into its numeric components.
+func parseVersion(s string) ([]int, bool) {
+	if s == "" {
+		return nil, false
+	}
+	var version []int
+	for _, field := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		version = append(version, n)
+	}
+	return version, true
+}
+
+func isVersion(s string) bool {
+	_, ok := parseVersion(s)
+	return ok
+}
+
+// compareVersions orders versions component by component; missing
+// components count as 0, so 1.80 and 1.80.0 are equal.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
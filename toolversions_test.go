@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{"1.80", "1.80.0", true},
+		{"1.80", "1.80.1", true},
+		{"1.80", "1.8.0", false},
+		{"1.80", "1.81.0", false},
+		{"=1.80.1", "1.80.1", true},
+		{"==1.80.1", "1.80.0", false},
+		{">=1.1", "1.1.8", true},
+		{">=1.1", "1.0.30", false},
+		{">=1.1, <2", "2.0.0", false},
+		{">=1.1, <2", "1.9.9", true},
+		{">1.80", "1.80.0", false},
+		{"<=0.5", "0.5.0", true},
+		{"1.80", "1.80.0-nightly", true},
+		{"v20", "20.11.0", true},
+	}
+	for _, tt := range tests {
+		c, err := parseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("parseVersionConstraint(%q): %v", tt.constraint, err)
+		}
+		if got := c.matches(tt.version); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "latest", ">=", "1.x", ">=1.1,"} {
+		if _, err := parseVersionConstraint(bad); err == nil {
+			t.Errorf("parseVersionConstraint(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLoadToolPins(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "rust-toolchain.toml"), []byte("[toolchain]\nchannel = \"1.79.0\"\ncomponents = [\"clippy\"]\n"), 0o644)
+	os.WriteFile(filepath.Join(root, ".nvmrc"), []byte("v20.11.0\n"), 0o644)
+	os.WriteFile(filepath.Join(root, ".tool-versions"), []byte("nodejs 22.1.0\npython 3.12.1 3.11.9\nruby system # not a version\n"), 0o644)
+
+	pins, err := loadToolPins(root, map[string]string{"rustc": "1.80", "bun": ">=1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range pins {
+		got = append(got, p.Tool+" "+p.Constraint+" "+p.Source)
+	}
+	want := "bun >=1.1 [tools]|node 22.1.0 .tool-versions|python 3.12.1 .tool-versions|rustc 1.80 [tools]"
+	if strings.Join(got, "|") != want {
+		t.Errorf("pins = %s\nwant   %s", strings.Join(got, "|"), want)
+	}
+
+	if _, err := loadToolPins(root, map[string]string{"bun": "newest"}); err == nil || !strings.Contains(err.Error(), "[tools] bun") {
+		t.Errorf("invalid [tools] pin error = %v", err)
+	}
+
+	named := t.TempDir()
+	os.WriteFile(filepath.Join(named, "rust-toolchain"), []byte("nightly-2024-05-01\n"), 0o644)
+	if pins, _ := loadToolPins(named, nil); len(pins) != 0 {
+		t.Errorf("named channel pinned %+v", pins)
+	}
+}
+
+func TestStageTools(t *testing.T) {
+	tests := map[string]string{
+		"cargo clippy --all-targets": "cargo clippy rustc",
+		"cargo fmt --check":          "cargo rustc rustfmt",
+		"cargo deny check":           "cargo cargo-deny rustc",
+		"npx prettier --check .":     "node",
+		"ruff check .":               "ruff",
+		"./scripts/lint.sh":          "",
+	}
+	for cmd, want := range tests {
+		got := strings.Join(stageTools(Stage{Cmd: strings.Fields(cmd)}), " ")
+		if got != want {
+			t.Errorf("stageTools(%q) = %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestToolVersionsInCacheKey(t *testing.T) {
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "ruff"), []byte("#!/bin/sh\necho ruff 0.4.1\n"), 0o755)
+	os.WriteFile(filepath.Join(bin, "fake-fmt"), []byte("#!/bin/sh\necho fake-fmt version 2.3\n"), 0o755)
+	t.Setenv("PATH", bin)
+
+	stages := []Stage{
+		{Name: "lint", Cmd: []string{"ruff", "check", "."}},
+		{Name: "fmt", Cmd: []string{"fake-fmt"}},
+		{Name: "boxed", Cmd: []string{"ruff", "check", "."}, Container: "python:3.12"},
+	}
+	probes := versionProbes{}
+	resolveStageToolVersions(stages, probes)
+	if stages[0].ToolVersions != "ruff@0.4.1" {
+		t.Errorf("lint tools = %q", stages[0].ToolVersions)
+	}
+	// Unknown programs aren't run just to ask for a version.
+	if stages[1].ToolVersions != "" || stages[2].ToolVersions != "" {
+		t.Errorf("tools = %q, %q", stages[1].ToolVersions, stages[2].ToolVersions)
+	}
+
+	before := cacheKeyForStage(Stage{Name: "lint", Cmd: stages[0].Cmd}, "abc")
+	after := cacheKeyForStage(stages[0], "abc")
+	if after != "abc|ruff check .|tools:ruff@0.4.1" || before == after {
+		t.Errorf("cache key = %q", after)
+	}
+
+	pins := []toolPin{
+		{Tool: "ruff", Constraint: ">=0.5", Source: "[tools]"},
+		{Tool: "fake-fmt", Constraint: "2.3", Source: ".tool-versions"},
+		{Tool: "bun", Constraint: "1.1", Source: "[tools]"},
+	}
+	mismatches := checkToolPins(pins, stages, probes)
+	if len(mismatches) != 1 || mismatches[0].Pin.Tool != "ruff" || mismatches[0].Found != "0.4.1" || strings.Join(mismatches[0].Stages, ",") != "lint" {
+		t.Fatalf("mismatches = %+v", mismatches)
+	}
+	table := formatToolMismatches(mismatches)
+	for _, cell := range []string{"ruff", ">=0.5", "0.4.1", "[tools]", "lint"} {
+		if !strings.Contains(table, cell) {
+			t.Errorf("table missing %q:\n%s", cell, table)
+		}
+	}
+}
+
+func TestToolVersionsProbedOncePerRun(t *testing.T) {
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls")
+	os.WriteFile(filepath.Join(bin, "ruff"), []byte("#!/bin/sh\necho probe >> "+calls+"\necho ruff 0.4.1\n"), 0o755)
+	t.Setenv("PATH", bin)
+
+	root := t.TempDir()
+	stages := []Stage{
+		{Name: "lint", Cmd: []string{"ruff", "check", "."}},
+		{Name: "format", Cmd: []string{"ruff", "format", "--check", "."}},
+	}
+	probes := versionProbes{}
+	if err := resolveStageBackends(stages, root, false, probes); err != nil {
+		t.Fatal(err)
+	}
+	check, err := checkStageTools(stages, root, map[string]string{"ruff": "0.4"}, false, probes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(check.Enforced) != 0 {
+		t.Errorf("ruff 0.4.1 should satisfy its pin: %+v", check.Enforced)
+	}
+	if data, _ := os.ReadFile(calls); strings.Count(string(data), "probe") != 1 {
+		t.Errorf("ruff should be probed once per run, got:\n%s", data)
+	}
+}
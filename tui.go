@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// tuiSupported reports whether both stdin and stdout are terminals, which
+// --tui needs for raw key input and full-screen drawing.
+func tuiSupported() bool {
+	return term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+var tuiSpinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// tuiRow is the live state of one stage in the UI.
+type tuiRow struct {
+	stage    Stage
+	status   string // pending | running | pass | fail | cached | skip | cancelled
+	cacheHit bool   // cache state when the pipeline started
+	start    time.Time
+	duration time.Duration
+	lines    []string
+	cancel   context.CancelFunc
+	result   Result
+	ran      bool
+}
+
+type tuiKey int
+
+const (
+	keyNone tuiKey = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyCancel
+	keyRerun
+	keyFix
+	keyQuit
+)
+
+// tuiApp runs a pipeline under an interactive full-screen view: one row per
+// stage with status, elapsed time and cache state, plus a log pane for the
+// selected stage.
+type tuiApp struct {
+	Cwd        string
+	Cache      map[string]string
+	SourceHash string
+	Hashes     map[string]string
+	NoCache    bool
+
+	mu       sync.Mutex
+	rows     []*tuiRow
+	byName   map[string]*tuiRow
+	selected int
+	scroll   int // log lines scrolled up from the bottom
+	fix      bool
+	done     bool // initial pipeline finished
+	quitting bool
+	message  string
+	frame    int
+	reruns   sync.WaitGroup
+}
+
+func newTUIApp(stages []Stage, cwd string, cache map[string]string, sourceHash string, hashes map[string]string, noCache, fix bool) *tuiApp {
+	a := &tuiApp{
+		Cwd:        cwd,
+		Cache:      cache,
+		SourceHash: sourceHash,
+		Hashes:     hashes,
+		NoCache:    noCache,
+		fix:        fix,
+		byName:     make(map[string]*tuiRow, len(stages)),
+	}
+	for _, s := range stages {
+		row := &tuiRow{stage: s, status: "pending"}
+		row.cacheHit = !noCache && cacheHit(cache, s, a.hash(s))
+		a.rows = append(a.rows, row)
+		a.byName[s.Name] = row
+	}
+	return a
+}
+
+func (a *tuiApp) hash(s Stage) string {
+	if h, ok := a.Hashes[s.Name]; ok {
+		return h
+	}
+	return a.SourceHash
+}
+
+// Run takes over the terminal, runs the pipeline and keeps the UI up until
+// the user quits, so failed stages can be inspected and rerun.
+func (a *tuiApp) Run(concurrency int, failFast bool) ([]Result, error) {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enter raw mode: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan tuiKey)
+	go readTUIKeys(os.Stdin, keys)
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	stages := make([]Stage, len(a.rows))
+	for i, row := range a.rows {
+		stages[i] = row.stage
+	}
+	runner := &ParallelRunner{
+		Stages:      stages,
+		Concurrency: concurrency,
+		Cwd:         a.Cwd,
+		NoCache:     a.NoCache,
+		Cache:       a.Cache,
+		SourceHash:  a.SourceHash,
+		StageHashes: a.Hashes,
+		FailFast:    failFast,
+		Execute:     a.execute,
+		Budget:      localResourceBudget(),
+	}
+	pipelineDone := make(chan []Result, 1)
+	go func() { pipelineDone <- runner.Run() }()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		a.render(os.Stdout)
+		select {
+		case <-ticker.C:
+		case results := <-pipelineDone:
+			a.finishPipeline(results)
+			pipelineDone = nil
+		case k := <-keys:
+			if a.handleKey(k) {
+				a.quit()
+				if pipelineDone != nil {
+					a.finishPipeline(<-pipelineDone)
+				}
+				a.reruns.Wait()
+				return a.results(), nil
+			}
+		}
+	}
+}
+
+// execute runs one stage for the pipeline or a rerun, recording its output
+// into the row as it arrives.
+func (a *tuiApp) execute(stage Stage) Result {
+	a.mu.Lock()
+	row := a.byName[stage.Name]
+	if a.quitting {
+		a.mu.Unlock()
+		return Result{Name: stage.Name, Status: "skip"}
+	}
+	if a.fix && len(stage.FixCmd) > 0 {
+		stage.Cmd = stage.FixCmd
+		stage.Check = false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	row.status = "running"
+	row.start = time.Now()
+	row.lines = nil
+	row.cancel = cancel
+	row.ran = true
+	a.mu.Unlock()
+
+	w := &lineWriter{emit: func(line string) {
+		a.mu.Lock()
+		row.lines = append(row.lines, line)
+		a.mu.Unlock()
+	}}
+	result := runLocalStage(ctx, stage, a.Cwd, w)
+	w.Flush()
+	cancelled := ctx.Err() != nil
+	cancel()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	row.cancel = nil
+	row.duration = result.Duration
+	row.status = result.Status
+	if cancelled {
+		row.status = "cancelled"
+		result.Status = "fail"
+		result.Error = fmt.Errorf("cancelled")
+	}
+	row.result = result
+	return result
+}
+
+func (a *tuiApp) finishPipeline(results []Result) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range results {
+		row, ok := a.byName[r.Name]
+		if !ok || row.ran {
+			continue
+		}
+		row.result = r
+		switch {
+		case r.CacheHit:
+			row.status = "cached"
+		default:
+			row.status = r.Status
+		}
+	}
+	a.done = true
+	a.message = "Pipeline finished — r reruns the selected stage, q quits"
+}
+
+// handleKey applies a key press and reports whether the UI should exit.
+func (a *tuiApp) handleKey(k tuiKey) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch k {
+	case keyUp:
+		if a.selected > 0 {
+			a.selected--
+			a.scroll = 0
+		}
+	case keyDown:
+		if a.selected < len(a.rows)-1 {
+			a.selected++
+			a.scroll = 0
+		}
+	case keyPageUp:
+		a.scroll += 10
+	case keyPageDown:
+		a.scroll -= 10
+		if a.scroll < 0 {
+			a.scroll = 0
+		}
+	case keyCancel:
+		row := a.rows[a.selected]
+		if row.cancel != nil {
+			row.cancel()
+			a.message = fmt.Sprintf("Cancelled %s", row.stage.Name)
+		}
+	case keyRerun:
+		row := a.rows[a.selected]
+		switch {
+		case !a.done:
+			a.message = "Reruns are available once the pipeline finishes"
+		case row.status == "running":
+			a.message = fmt.Sprintf("%s is already running", row.stage.Name)
+		case row.status == "fail" || row.status == "cancelled" || row.status == "skip":
+			a.message = fmt.Sprintf("Rerunning %s", row.stage.Name)
+			row.status = "running"
+			row.start = time.Now()
+			a.reruns.Add(1)
+			go a.rerun(row.stage)
+		default:
+			a.message = fmt.Sprintf("%s already passed", row.stage.Name)
+		}
+	case keyFix:
+		a.fix = !a.fix
+		if a.fix {
+			a.message = "Fix mode on — stages with a fix command will apply fixes"
+		} else {
+			a.message = "Fix mode off"
+		}
+	case keyQuit:
+		return true
+	}
+	return false
+}
+
+func (a *tuiApp) rerun(stage Stage) {
+	defer a.reruns.Done()
+	result := a.execute(stage)
+	if result.Status == "pass" && !a.NoCache {
+		a.mu.Lock()
+		a.Cache[stage.Name] = cacheKeyForStage(stage, a.hash(stage))
+		a.mu.Unlock()
+	}
+}
+
+func (a *tuiApp) quit() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.quitting = true
+	for _, row := range a.rows {
+		if row.cancel != nil {
+			row.cancel()
+		}
+	}
+}
+
+// results returns the final result of every stage in pipeline order.
+func (a *tuiApp) results() []Result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]Result, 0, len(a.rows))
+	for _, row := range a.rows {
+		r := row.result
+		if r.Name == "" {
+			r = Result{Name: row.stage.Name, Status: "skip"}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (a *tuiApp) render(w io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 100, 30
+	}
+	a.draw(w, width, height)
+}
+
+// draw writes one full frame sized to width×height.
+func (a *tuiApp) draw(w io.Writer, width, height int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.frame++
+
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(truncateRunes(s, width))
+		b.WriteString("\x1b[K\r\n")
+	}
+
+	b.WriteString("\x1b[H")
+	fix := "off"
+	if a.fix {
+		fix = "on"
+	}
+	line(fmt.Sprintf("local-ci — %d stage(s)   fix: %s", len(a.rows), fix))
+	line("")
+
+	for i, row := range a.rows {
+		cursor := "  "
+		if i == a.selected {
+			cursor = "▸ "
+		}
+		elapsed := ""
+		switch row.status {
+		case "running":
+			elapsed = fmt.Sprintf("%.1fs", time.Since(row.start).Seconds())
+		case "pass", "fail", "cancelled":
+			elapsed = fmt.Sprintf("%.1fs", row.duration.Seconds())
+		}
+		cache := "miss"
+		if row.cacheHit {
+			cache = "hit"
+		}
+		line(fmt.Sprintf("%s%s %-20s %-10s %8s   cache: %s", cursor, a.statusGlyph(row.status), row.stage.Name, row.status, elapsed, cache))
+	}
+
+	line(strings.Repeat("─", width))
+	logHeight := height - len(a.rows) - 6
+	if logHeight < 1 {
+		logHeight = 1
+	}
+	var logLines []string
+	if len(a.rows) > 0 {
+		logLines = a.rows[a.selected].lines
+	}
+	end := len(logLines) - a.scroll
+	if end < 0 {
+		end = 0
+	}
+	start := end - logHeight
+	if start < 0 {
+		start = 0
+	}
+	shown := 0
+	for _, l := range logLines[start:end] {
+		line(l)
+		shown++
+	}
+	for ; shown < logHeight; shown++ {
+		line("")
+	}
+	line(strings.Repeat("─", width))
+	footer := "↑/↓ select  PgUp/PgDn scroll  c cancel  r rerun  f fix  q quit"
+	if a.message != "" {
+		footer += "   " + a.message
+	}
+	b.WriteString(truncateRunes(footer, width))
+	b.WriteString("\x1b[K\x1b[J")
+
+	io.WriteString(w, decorate(b.String(), "", false))
+}
+
+func (a *tuiApp) statusGlyph(status string) string {
+	switch status {
+	case "running":
+		glyph := tuiSpinner[a.frame%len(tuiSpinner)]
+		if !output.unicode {
+			glyph = string(`|/-\`[a.frame%4])
+		}
+		return decorate(glyph, "33", output.colorStdout)
+	case "pass", "cached":
+		return decorate("✓", "32", output.colorStdout)
+	case "fail":
+		return decorate("✗", "31", output.colorStdout)
+	case "cancelled":
+		return decorate("⏹", "31", output.colorStdout)
+	case "skip":
+		return "-"
+	default:
+		return "·"
+	}
+}
+
+func truncateRunes(s string, width int) string {
+	r := []rune(s)
+	if width > 0 && len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
+
+// readTUIKeys decodes raw terminal input into key presses.
+func readTUIKeys(r io.Reader, keys chan<- tuiKey) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			keys <- keyQuit
+			return
+		}
+		if k := parseTUIKey(buf[:n]); k != keyNone {
+			keys <- k
+		}
+	}
+}
+
+func parseTUIKey(b []byte) tuiKey {
+	switch string(b) {
+	case "\x1b[A", "k":
+		return keyUp
+	case "\x1b[B", "j":
+		return keyDown
+	case "\x1b[5~":
+		return keyPageUp
+	case "\x1b[6~":
+		return keyPageDown
+	case "c":
+		return keyCancel
+	case "r":
+		return keyRerun
+	case "f":
+		return keyFix
+	case "q", "\x03":
+		return keyQuit
+	}
+	return keyNone
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTUIKey(t *testing.T) {
+	cases := map[string]tuiKey{
+		"\x1b[A":  keyUp,
+		"k":       keyUp,
+		"\x1b[B":  keyDown,
+		"j":       keyDown,
+		"\x1b[5~": keyPageUp,
+		"\x1b[6~": keyPageDown,
+		"c":       keyCancel,
+		"r":       keyRerun,
+		"f":       keyFix,
+		"q":       keyQuit,
+		"\x03":    keyQuit,
+		"x":       keyNone,
+	}
+	for in, want := range cases {
+		if got := parseTUIKey([]byte(in)); got != want {
+			t.Errorf("parseTUIKey(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestTUIExecuteRecordsOutput(t *testing.T) {
+	stage := Stage{Name: "echo", Cmd: []string{"sh", "-c", "echo one; echo two"}, Timeout: 10}
+	a := newTUIApp([]Stage{stage}, t.TempDir(), map[string]string{}, "h", nil, true, false)
+
+	result := a.execute(stage)
+	if result.Status != "pass" {
+		t.Fatalf("expected pass, got %s (%v)", result.Status, result.Error)
+	}
+	row := a.byName["echo"]
+	if row.status != "pass" {
+		t.Errorf("row status = %s, want pass", row.status)
+	}
+	if strings.Join(row.lines, ",") != "one,two" {
+		t.Errorf("unexpected log lines: %q", row.lines)
+	}
+}
+
+func TestTUICancelSelectedStage(t *testing.T) {
+	stage := Stage{Name: "slow", Cmd: []string{"sleep", "10"}, Timeout: 30}
+	a := newTUIApp([]Stage{stage}, t.TempDir(), map[string]string{}, "h", nil, true, false)
+
+	done := make(chan Result, 1)
+	go func() { done <- a.execute(stage) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		running := a.rows[0].cancel != nil
+		a.mu.Unlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stage never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	a.handleKey(keyCancel)
+	select {
+	case result := <-done:
+		if result.Status != "fail" {
+			t.Errorf("cancelled stage should report fail, got %s", result.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel did not stop the stage")
+	}
+	if a.rows[0].status != "cancelled" {
+		t.Errorf("row status = %s, want cancelled", a.rows[0].status)
+	}
+}
+
+func TestTUIFixModeUsesFixCommand(t *testing.T) {
+	stage := Stage{
+		Name:    "fmt",
+		Cmd:     []string{"sh", "-c", "echo check"},
+		FixCmd:  []string{"sh", "-c", "echo fix"},
+		Timeout: 10,
+	}
+	a := newTUIApp([]Stage{stage}, t.TempDir(), map[string]string{}, "h", nil, true, false)
+
+	a.handleKey(keyFix)
+	a.execute(stage)
+	if got := strings.Join(a.rows[0].lines, ","); got != "fix" {
+		t.Errorf("fix mode should run the fix command, got output %q", got)
+	}
+}
+
+func TestTUIRerunAfterPipeline(t *testing.T) {
+	dir := t.TempDir()
+	stage := Stage{Name: "flaky", Cmd: []string{"sh", "-c", "test -f ok || { touch ok; exit 1; }"}, Timeout: 10}
+	cache := map[string]string{}
+	a := newTUIApp([]Stage{stage}, dir, cache, "h", nil, false, false)
+
+	a.handleKey(keyRerun)
+	if !strings.Contains(a.message, "once the pipeline finishes") {
+		t.Errorf("rerun before the pipeline finished should be refused, message %q", a.message)
+	}
+
+	a.finishPipeline([]Result{a.execute(stage)})
+	if a.rows[0].status != "fail" {
+		t.Fatalf("first run should fail, got %s", a.rows[0].status)
+	}
+
+	a.handleKey(keyRerun)
+	a.reruns.Wait()
+	if a.rows[0].status != "pass" {
+		t.Errorf("rerun should pass, got %s", a.rows[0].status)
+	}
+	if !cacheHit(cache, stage, "h") {
+		t.Error("a passing rerun should be recorded in the cache")
+	}
+	results := a.results()
+	if len(results) != 1 || results[0].Status != "pass" {
+		t.Errorf("final results should reflect the rerun: %+v", results)
+	}
+}
+
+func TestTUIDrawShowsStagesAndLog(t *testing.T) {
+	stages := []Stage{
+		{Name: "fmt", Cmd: []string{"true"}},
+		{Name: "test", Cmd: []string{"true"}},
+	}
+	a := newTUIApp(stages, t.TempDir(), map[string]string{}, "h", nil, true, false)
+	a.rows[0].status = "pass"
+	a.rows[1].status = "running"
+	a.rows[1].start = time.Now()
+	a.rows[1].lines = []string{"compiling foo", "running 3 tests"}
+	a.handleKey(keyDown)
+
+	var buf bytes.Buffer
+	a.draw(&buf, 80, 20)
+	out := buf.String()
+	for _, want := range []string{"fmt", "test", "running", "running 3 tests", "q quit", "cache: miss"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("frame missing %q:\n%s", want, out)
+		}
+	}
+	for _, l := range strings.Split(out, "\r\n") {
+		// Strip the erase-line escape before measuring.
+		l = strings.ReplaceAll(l, "\x1b[K", "")
+		if len([]rune(l)) > 80+20 { // allow for color escapes
+			t.Errorf("line exceeds width: %q", l)
+		}
+	}
+}
+
+func TestTUIResultsSkipsStagesThatNeverRan(t *testing.T) {
+	a := newTUIApp([]Stage{{Name: "a"}, {Name: "b"}}, t.TempDir(), map[string]string{}, "h", nil, true, false)
+	a.quit()
+	if r := a.execute(Stage{Name: "a", Cmd: []string{"true"}}); r.Status != "skip" {
+		t.Errorf("stages started after quit should be skipped, got %s", r.Status)
+	}
+	for _, r := range a.results() {
+		if r.Status != "skip" {
+			t.Errorf("%s: status %s, want skip", r.Name, r.Status)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultWatchDebounce is how long the tree must stay quiet after a change
+// before watch mode starts a new run. Editors often write a file several
+// times per save (temp file, rename, chmod), so rerunning on the first event
+// would waste a run.
+const defaultWatchDebounce = 300 * time.Millisecond
+
+// fsWatcher reports paths under a root that were created, modified, removed
+// or renamed. Implementations are platform-specific (inotify on Linux, a
+// polling fallback elsewhere).
+type fsWatcher interface {
+	Events() <-chan string
+	Close() error
+}
+
+func skipDirSet(dirs []string) map[string]bool {
+	set := make(map[string]bool, len(dirs))
+	for _, d := range dirs {
+		set[d] = true
+	}
+	return set
+}
+
+// watchSession keeps re-running the selected stages as the workspace
+// changes. Only stages whose per-stage hash moved since their last run are
+// executed; the shared cache keeps unchanged stages green across restarts.
+type watchSession struct {
+	Root     string
+	Config   *Config
+	Ws       *Workspace
+	Stages   []Stage
+	Cache    map[string]string
+	NoCache  bool
+	Verbose  bool
+	Debounce time.Duration
+
+	lastHash map[string]string // stage → hash of its last completed run
+	patterns []string
+}
+
+// Run watches the workspace until ctx is cancelled. The first run happens
+// immediately; afterwards every burst of relevant changes cancels the
+// in-flight run (if any) and starts a new one once the burst settles.
+func (w *watchSession) Run(ctx context.Context) error {
+	watcher, err := newFSWatcher(w.Root, w.Config.Cache.SkipDirs)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", w.Root, err)
+	}
+	defer watcher.Close()
+
+	if w.Debounce <= 0 {
+		w.Debounce = defaultWatchDebounce
+	}
+	w.lastHash = make(map[string]string)
+	w.patterns = w.watchPatterns()
+
+	printf("👀 Watching %s for changes (Ctrl-C to stop)\n\n", w.Root)
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	runDone := w.start(runCtx)
+
+	changed := make(map[string]bool)
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			cancelRun()
+			<-runDone
+			return nil
+		case path, ok := <-watcher.Events():
+			if !ok {
+				cancelRun()
+				<-runDone
+				return fmt.Errorf("file watcher stopped unexpectedly")
+			}
+			if !w.relevant(path) {
+				continue
+			}
+			// New changes make the in-flight run stale; stop it now rather
+			// than letting it finish against outdated sources.
+			cancelRun()
+			changed[path] = true
+			settle = time.After(w.Debounce)
+		case <-settle:
+			settle = nil
+			<-runDone
+			printf("\n🔄 %d file(s) changed\n", len(changed))
+			if w.Verbose {
+				for path := range changed {
+					rel, _ := filepath.Rel(w.Root, path)
+					printf("   %s\n", rel)
+				}
+			}
+			changed = make(map[string]bool)
+			runCtx, cancelRun = context.WithCancel(ctx)
+			runDone = w.start(runCtx)
+		}
+	}
+}
+
+func (w *watchSession) start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.cycle(ctx)
+	}()
+	return done
+}
+
+// cycle runs every stage whose hash changed since its last completed run.
+func (w *watchSession) cycle(ctx context.Context) {
+	hashes, err := computeStageHashes(w.Root, w.Config, w.Ws, w.Stages)
+	if err != nil {
+		warnf("Warning: hash computation failed: %v\n", err)
+		hashes = map[string]string{}
+	}
+
+	// Conditions are checked every cycle: a change can satisfy if_changed.
+	conditions := newConditionEnv(w.Root)
+	ran, failed := 0, 0
+	for _, stage := range w.Stages {
+		if ctx.Err() != nil {
+			printf("⏹ Run cancelled\n")
+			return
+		}
+
+		hash := hashes[stage.Name]
+		if hash != "" && w.lastHash[stage.Name] == hash {
+			continue
+		}
+		if reason := conditions.check(stage, false); reason != "" {
+			if w.Verbose {
+				printf("- %s (skipped: %s)\n", stage.Name, reason)
+			}
+			continue
+		}
+		if !w.NoCache && cacheHit(w.Cache, stage, hash) {
+			w.lastHash[stage.Name] = hash
+			if w.Verbose {
+				printf("✓ %s (cached)\n", stage.Name)
+			}
+			continue
+		}
+
+		printf("▶ %s\n", stage.Name)
+		result := runLocalStage(ctx, stage, w.Root, nil)
+		if ctx.Err() != nil {
+			printf("⏹ %s cancelled\n", stage.Name)
+			return
+		}
+		w.lastHash[stage.Name] = hash
+		ran++
+
+		if result.Status == "pass" {
+			if w.Verbose {
+				printf("%s\n", result.Output)
+			}
+			printf("✓ %s (%dms)\n", stage.Name, result.Duration.Milliseconds())
+			if !w.NoCache {
+				w.Cache[stage.Name] = cacheKeyForStage(stage, hash)
+			}
+		} else {
+			failed++
+			if result.Output != "" {
+				printf("%s\n", result.Output)
+			} else if result.Error != nil {
+				printf("Error: %v\n", result.Error)
+			}
+			printf("✗ %s (failed)\n", stage.Name)
+		}
+	}
+
+	if !w.NoCache {
+		saveCache(w.Cache, w.Root)
+	}
+
+	switch {
+	case ran == 0:
+		successf("✅ No affected stages\n")
+	case failed == 0:
+		successf("✅ %d stage(s) passed\n", ran)
+	default:
+		errorf("❌ %d/%d stages failed\n", failed, ran)
+	}
+}
+
+// watchPatterns is the union of the cache include patterns and every
+// selected stage's watch patterns.
+func (w *watchSession) watchPatterns() []string {
+	seen := make(map[string]bool)
+	var patterns []string
+	add := func(list []string) {
+		for _, p := range list {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	add(w.Config.Cache.IncludePatterns)
+	for _, s := range w.Stages {
+		add(s.Watch)
+	}
+	return patterns
+}
+
+// relevant reports whether a change to path can affect any selected stage.
+// local-ci's own state files are ignored so saving the cache doesn't
+// retrigger a run.
+func (w *watchSession) relevant(path string) bool {
+	rel, err := filepath.Rel(w.Root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	if rel == ".local-ci-cache" || rel == ".local-ci" || strings.HasPrefix(rel, ".local-ci"+string(filepath.Separator)) {
+		return false
+	}
+	if w.Ws != nil && !w.Ws.IsSingle && w.Ws.IsExcluded(rel) {
+		return false
+	}
+	return matchesPatterns(filepath.Base(path), w.patterns)
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher watches a directory tree with one inotify watch per
+// directory. New directories are picked up as they are created.
+type inotifyWatcher struct {
+	root     string
+	skipDirs map[string]bool
+	file     *os.File
+	fd       int
+	events   chan string
+
+	mu    sync.Mutex
+	paths map[int]string // watch descriptor → directory
+}
+
+func newFSWatcher(root string, skipDirs []string) (fsWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		root:     root,
+		skipDirs: skipDirSet(skipDirs),
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		fd:       fd,
+		events:   make(chan string, 256),
+		paths:    make(map[int]string),
+	}
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.readLoop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan string { return w.events }
+
+func (w *inotifyWatcher) Close() error { return w.file.Close() }
+
+// addTree registers every directory under dir that isn't in skipDirs.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories can vanish between the event and the walk.
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != w.root && w.skipDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return nil
+		}
+		w.mu.Lock()
+		w.paths[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) readLoop() {
+	defer close(w.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(ev.Len)]), "\x00")
+			offset = nameStart + int(ev.Len)
+
+			w.mu.Lock()
+			dir, ok := w.paths[int(ev.Wd)]
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.paths, int(ev.Wd))
+			}
+			w.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			if ev.Mask&syscall.IN_ISDIR != 0 {
+				if w.skipDirs[name] {
+					continue
+				}
+				if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					_ = w.addTree(path)
+				}
+			}
+			w.events <- path
+		}
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !linux
+
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// pollWatcher is the portable fallback for platforms without inotify. It
+// rescans the tree once per interval and reports files whose size or mtime
+// changed, appeared, or disappeared.
+type pollWatcher struct {
+	root     string
+	skipDirs map[string]bool
+	events   chan string
+	done     chan struct{}
+}
+
+type pollStat struct {
+	size    int64
+	modTime time.Time
+}
+
+func newFSWatcher(root string, skipDirs []string) (fsWatcher, error) {
+	w := &pollWatcher{
+		root:     root,
+		skipDirs: skipDirSet(skipDirs),
+		events:   make(chan string, 256),
+		done:     make(chan struct{}),
+	}
+	go w.loop(w.scan())
+	return w, nil
+}
+
+func (w *pollWatcher) Events() <-chan string { return w.events }
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollWatcher) scan() map[string]pollStat {
+	seen := make(map[string]pollStat)
+	_ = filepath.WalkDir(w.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != w.root && w.skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			seen[path] = pollStat{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return seen
+}
+
+func (w *pollWatcher) loop(prev map[string]pollStat) {
+	defer close(w.events)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		cur := w.scan()
+		for path, st := range cur {
+			if old, ok := prev[path]; !ok || old != st {
+				w.events <- path
+			}
+		}
+		for path := range prev {
+			if _, ok := cur[path]; !ok {
+				w.events <- path
+			}
+		}
+		prev = cur
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWatchTestSession(t *testing.T, root, logDir string) *watchSession {
+	t.Helper()
+	appendTo := func(name string) []string {
+		return []string{"sh", "-c", "echo run >> " + filepath.Join(logDir, name)}
+	}
+	return &watchSession{
+		Root: root,
+		Config: &Config{Cache: CacheConfig{
+			SkipDirs:        []string{"target"},
+			IncludePatterns: []string{"*.a", "*.b"},
+		}},
+		Stages: []Stage{
+			{Name: "a", Cmd: appendTo("a.log"), Watch: []string{"*.a"}, Timeout: 10},
+			{Name: "b", Cmd: appendTo("b.log"), Watch: []string{"*.b"}, Timeout: 10},
+		},
+		Cache:    map[string]string{},
+		lastHash: map[string]string{},
+		patterns: []string{"*.a", "*.b"},
+	}
+}
+
+func countRuns(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(string(data), "run")
+}
+
+func TestWatchCycleRerunsOnlyChangedStages(t *testing.T) {
+	root, logDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(root, "x.a"), []byte("1"), 0o644)
+	os.WriteFile(filepath.Join(root, "x.b"), []byte("1"), 0o644)
+
+	w := newWatchTestSession(t, root, logDir)
+	w.cycle(context.Background())
+	if countRuns(t, filepath.Join(logDir, "a.log")) != 1 || countRuns(t, filepath.Join(logDir, "b.log")) != 1 {
+		t.Fatal("first cycle should run every stage once")
+	}
+
+	os.WriteFile(filepath.Join(root, "x.a"), []byte("2"), 0o644)
+	w.cycle(context.Background())
+	if got := countRuns(t, filepath.Join(logDir, "a.log")); got != 2 {
+		t.Errorf("stage a should rerun after its input changed, ran %d times", got)
+	}
+	if got := countRuns(t, filepath.Join(logDir, "b.log")); got != 1 {
+		t.Errorf("stage b inputs unchanged, should not rerun; ran %d times", got)
+	}
+}
+
+func TestWatchCycleReusesCache(t *testing.T) {
+	root, logDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(root, "x.a"), []byte("1"), 0o644)
+
+	w := newWatchTestSession(t, root, logDir)
+	w.cycle(context.Background())
+
+	// A fresh session (e.g. after restarting watch) starts from the cache.
+	w2 := newWatchTestSession(t, root, logDir)
+	w2.Cache = w.Cache
+	w2.cycle(context.Background())
+	if got := countRuns(t, filepath.Join(logDir, "a.log")); got != 1 {
+		t.Errorf("cached stage should not rerun on restart, ran %d times", got)
+	}
+}
+
+func TestWatchCycleCancelledStageRunsAgain(t *testing.T) {
+	root, logDir := t.TempDir(), t.TempDir()
+	w := newWatchTestSession(t, root, logDir)
+	w.Stages = []Stage{{Name: "slow", Cmd: []string{"sleep", "5"}, Timeout: 10}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	w.cycle(ctx)
+	if _, ok := w.lastHash["slow"]; ok {
+		t.Fatal("cancelled stage must not be recorded as completed")
+	}
+}
+
+func TestWatchRelevant(t *testing.T) {
+	root := t.TempDir()
+	w := newWatchTestSession(t, root, t.TempDir())
+
+	cases := map[string]bool{
+		"src/x.a":                    true,
+		"x.b":                        true,
+		"notes.txt":                  false,
+		".local-ci-cache":            false,
+		".local-ci/daemon.sock":      false,
+		"../outside.a":               false,
+		"deep/nested/dir/changed.a":  true,
+		"deep/nested/dir/changed.rs": false,
+	}
+	for rel, want := range cases {
+		if got := w.relevant(filepath.Join(root, rel)); got != want {
+			t.Errorf("relevant(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestFSWatcherReportsChanges(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "target"), 0o755)
+
+	w, err := newFSWatcher(root, []string{"target"})
+	if err != nil {
+		t.Fatalf("newFSWatcher: %v", err)
+	}
+	defer w.Close()
+
+	os.WriteFile(filepath.Join(root, "target", "ignored.a"), []byte("x"), 0o644)
+	want := filepath.Join(root, "changed.a")
+	os.WriteFile(want, []byte("x"), 0o644)
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case path := <-w.Events():
+			if strings.Contains(path, "target") {
+				t.Fatalf("event from skipped dir: %s", path)
+			}
+			if path == want {
+				return
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for change event")
+		}
+	}
+}
+
+func TestWatchRunRerunsOnChange(t *testing.T) {
+	root, logDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(root, "x.a"), []byte("1"), 0o644)
+
+	w := newWatchTestSession(t, root, logDir)
+	w.Debounce = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for countRuns(t, filepath.Join(logDir, "a.log")) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("stage a did not reach %d runs", n)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	waitFor(1)
+	// Give the watcher time to settle before triggering a change.
+	time.Sleep(200 * time.Millisecond)
+	os.WriteFile(filepath.Join(root, "x.a"), []byte("2"), 0o644)
+	waitFor(2)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+}